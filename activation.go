@@ -0,0 +1,75 @@
+package powsrv
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFdsStart is the first file descriptor passed by systemd (SD_LISTEN_FDS_START)
+const listenFdsStart = 3
+
+// ActivatedListener is a listener that was passed by systemd socket activation
+type ActivatedListener struct {
+	Name string // FileDescriptorName of the .socket unit, or "fd <n>" if it is not set
+	net.Listener
+}
+
+// ActivationListeners returns the listeners of the systemd socket activation (LISTEN_FDS)
+// If the environment variables are absent or meant for another process, no listeners are returned.
+// The variables are unset, so child processes don't inherit the sockets.
+func ActivationListeners() ([]ActivatedListener, error) {
+	return activationListeners(listenFdsStart)
+}
+
+// activationListeners returns the listeners of the file descriptors starting with firstFd
+func activationListeners(firstFd int) ([]ActivatedListener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if (err != nil) || (pid != os.Getpid()) {
+		return nil, nil
+	}
+
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if (err != nil) || (fds <= 0) {
+		return nil, nil
+	}
+
+	var names []string
+	if fdNames := os.Getenv("LISTEN_FDNAMES"); fdNames != "" {
+		names = strings.Split(fdNames, ":")
+	}
+
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	var listeners []ActivatedListener
+	for i := 0; i < fds; i++ {
+		fd := firstFd + i
+
+		name := fmt.Sprintf("fd %d", fd)
+		if (i < len(names)) && (names[i] != "") && (names[i] != "unknown") {
+			name = names[i]
+		}
+
+		// FileListener duplicates the descriptor, the original one is closed afterwards
+		file := os.NewFile(uintptr(fd), name)
+		ln, err := net.FileListener(file)
+		file.Close()
+		if (err == nil) && (ln.Addr().Network() != "unix") && (ln.Addr().Network() != "tcp") {
+			ln.Close()
+			err = fmt.Errorf("Unsupported network %v, only unix and tcp stream sockets can be used", ln.Addr().Network())
+		}
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("Socket \"%v\" of systemd could not be used: %v", name, err)
+		}
+		listeners = append(listeners, ActivatedListener{Name: name, Listener: ln})
+	}
+
+	return listeners, nil
+}
@@ -0,0 +1,108 @@
+package powsrv
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+// passSockets puts the descriptors of the listeners to consecutive fds starting with firstFd, like systemd does
+func passSockets(t *testing.T, firstFd int, files ...*os.File) {
+	for i, file := range files {
+		err := syscall.Dup3(int(file.Fd()), firstFd+i, syscall.O_CLOEXEC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+	}
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", strconv.Itoa(len(files)))
+}
+
+func TestActivationListeners(t *testing.T) {
+	const firstFd = 200
+
+	// No socket activation for this process
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	listeners, err := activationListeners(firstFd)
+	if (err != nil) || (listeners != nil) {
+		t.Fatalf("Expected no listeners for another process, got: %v, %v", listeners, err)
+	}
+
+	socketPath := filepath.Join(t.TempDir(), "powSrv.sock")
+	unixLn, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unixLn.(*net.UnixListener).SetUnlinkOnClose(false)
+	unixFile, _ := unixLn.(*net.UnixListener).File()
+	unixLn.Close()
+
+	tcpLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcpFile, _ := tcpLn.(*net.TCPListener).File()
+	tcpLn.Close()
+
+	passSockets(t, firstFd, unixFile, tcpFile)
+	t.Setenv("LISTEN_FDNAMES", "local:unknown")
+
+	listeners, err = activationListeners(firstFd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listeners) != 2 {
+		t.Fatalf("Expected 2 listeners, got %d", len(listeners))
+	}
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Error("LISTEN_FDS was not unset")
+	}
+
+	expected := []struct{ name, network string }{{"local", "unix"}, {"fd " + strconv.Itoa(firstFd+1), "tcp"}}
+	for i, ln := range listeners {
+		if (ln.Name != expected[i].name) || (ln.Addr().Network() != expected[i].network) {
+			t.Errorf("Unexpected listener %d: %v (%v)", i, ln.Name, ln.Addr().Network())
+		}
+
+		// The inherited sockets accept connections
+		go func(ln net.Listener) {
+			c, err := ln.Accept()
+			if err == nil {
+				c.Close()
+			}
+		}(ln)
+		c, err := net.Dial(ln.Addr().Network(), ln.Addr().String())
+		if err != nil {
+			t.Errorf("Listener %v: %v", ln.Name, err)
+			continue
+		}
+		c.Close()
+	}
+
+	for _, ln := range listeners {
+		ln.Close()
+	}
+
+	// The socket file of systemd is not removed on close
+	if _, err := os.Stat(socketPath); err != nil {
+		t.Errorf("Socket file was removed: %v", err)
+	}
+
+	// Datagram sockets can't be used
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_DGRAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	syscall.Close(fds[1])
+	passSockets(t, firstFd, os.NewFile(uintptr(fds[0]), "socketpair"))
+	_, err = activationListeners(firstFd)
+	if err == nil {
+		t.Error("Expected an error for a datagram socket")
+	}
+}
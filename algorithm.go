@@ -0,0 +1,105 @@
+package powsrv
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/iotaledger/giota"
+
+	"github.com/muxxer/powsrv/ipc"
+)
+
+// MaxRawPowSize is the maximum size of the payload of PowRaw, every byte is encoded in 2 trytes of a transaction-sized buffer
+const MaxRawPowSize = (transactionTrytesSize - nonceTrytesSize) / 2
+
+// AlgorithmDriver is implemented by drivers that support other POW algorithms than ipc.AlgorithmCurlTransaction
+type AlgorithmDriver interface {
+	Algorithms() []byte // Supported ipc.Algorithm* IDs, including ipc.AlgorithmCurlTransaction
+}
+
+// supportsAlgorithm returns true if the device can do the POW of the algorithm
+func (d *PowDevice) supportsAlgorithm(algorithm byte) bool {
+	if d.Algorithms == nil {
+		return algorithm == ipc.AlgorithmCurlTransaction
+	}
+	for _, supported := range d.Algorithms {
+		if supported == algorithm {
+			return true
+		}
+	}
+	return false
+}
+
+// supportsAlgorithm returns true if at least one enabled device supports the algorithm
+func (d *powDispatcher) supportsAlgorithm(algorithm byte) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return d.hasAlgorithmDevice(anyDevice, algorithm)
+}
+
+// RawPowTrytes returns the trytes whose Curl-P-81 hash has to reach the MWM for ipc.AlgorithmCurlRaw
+// Every byte of the payload is encoded in 2 trytes, the trytes are padded with 9s to the size of a transaction.
+// The last 27 trytes are the nonce, they are 9s until the nonce of the POW is set.
+func RawPowTrytes(data []byte) (giota.Trytes, error) {
+	if (len(data) == 0) || (len(data) > MaxRawPowSize) {
+		return "", fmt.Errorf("Payload size out of range [1-%d]: %d", MaxRawPowSize, len(data))
+	}
+
+	var trytes strings.Builder
+	trytes.Grow(transactionTrytesSize)
+	for _, b := range data {
+		trytes.WriteByte(giota.TryteAlphabet[int(b)%27])
+		trytes.WriteByte(giota.TryteAlphabet[int(b)/27])
+	}
+	trytes.WriteString(strings.Repeat("9", transactionTrytesSize-trytes.Len()))
+	return giota.Trytes(trytes.String()), nil
+}
+
+// VerifyRawPow returns true if the nonce of PowRaw reaches the MWM for the payload
+func VerifyRawPow(data []byte, nonce giota.Trytes, mwm int) (bool, error) {
+	trytes, err := RawPowTrytes(data)
+	if err != nil {
+		return false, err
+	}
+
+	err = verifyNonce(trytes, nonce, mwm)
+	var invalidNonce *InvalidNonceError
+	if errors.As(err, &invalidNonce) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// parsePowFunc returns the trytes, the priority and the algorithm of an ipc.CmdPowFunc request without the MWM
+// Requests of the transaction POW start with the trytes, optionally followed by the priority. Requests with an
+// algorithm start with the priority, which can't be confused with a tryte.
+func parsePowFunc(data []byte) (trytes giota.Trytes, priority byte, algorithm byte, err error) {
+	priority = ipc.PriorityNormal
+	algorithm = ipc.AlgorithmCurlTransaction
+
+	if (len(data) >= 2) && (data[0] <= ipc.PriorityHigh) {
+		priority, algorithm, data = data[0], data[1], data[2:]
+	} else if len(data) == transactionTrytesSize+1 {
+		priority = data[transactionTrytesSize]
+		data = data[:transactionTrytesSize]
+	}
+
+	if priority > ipc.PriorityHigh {
+		return "", 0, 0, newServerError(ipc.ErrorInvalidRequest, "Invalid priority %d", priority)
+	}
+
+	switch algorithm {
+	case ipc.AlgorithmCurlTransaction:
+		trytes, err = parseTransaction(data)
+	case ipc.AlgorithmCurlRaw:
+		trytes, err = RawPowTrytes(data)
+		if err != nil {
+			err = newServerError(ipc.ErrorInvalidRequest, "%v", err)
+		}
+	default:
+		err = newServerError(ipc.ErrorUnsupportedAlgorithm, "%d", algorithm)
+	}
+	return trytes, priority, algorithm, err
+}
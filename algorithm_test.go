@@ -0,0 +1,112 @@
+package powsrv
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/iotaledger/giota"
+
+	"github.com/muxxer/powsrv/ipc"
+)
+
+func TestRawPowTrytes(t *testing.T) {
+	trytes, err := RawPowTrytes([]byte{0, 26, 27, 'A', 255})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (len(trytes) != transactionTrytesSize) || !strings.HasPrefix(string(trytes), "99Z99AKBLI") || (strings.Trim(string(trytes[10:]), "9") != "") {
+		t.Errorf("Wrong trytes: %v...", trytes[:20])
+	}
+
+	for _, size := range []int{0, MaxRawPowSize + 1} {
+		_, err = RawPowTrytes(make([]byte, size))
+		if err == nil {
+			t.Errorf("Payload of %d bytes accepted", size)
+		}
+	}
+	_, err = RawPowTrytes(make([]byte, MaxRawPowSize))
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParsePowFunc(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		data      []byte
+		priority  byte
+		algorithm byte
+		code      string
+	}{
+		{"transaction", []byte(transaction), ipc.PriorityNormal, ipc.AlgorithmCurlTransaction, ""},
+		{"transaction with priority", append([]byte(transaction), ipc.PriorityHigh), ipc.PriorityHigh, ipc.AlgorithmCurlTransaction, ""},
+		{"transaction with algorithm", append([]byte{ipc.PriorityHigh, ipc.AlgorithmCurlTransaction}, transaction...), ipc.PriorityHigh, ipc.AlgorithmCurlTransaction, ""},
+		{"raw", []byte{ipc.PriorityNormal, ipc.AlgorithmCurlRaw, 1, 2, 3}, ipc.PriorityNormal, ipc.AlgorithmCurlRaw, ""},
+		{"raw without payload", []byte{ipc.PriorityNormal, ipc.AlgorithmCurlRaw}, 0, 0, ipc.ErrorInvalidRequest},
+		{"unknown algorithm", []byte{ipc.PriorityNormal, 0x7F, 1, 2, 3}, 0, 0, ipc.ErrorUnsupportedAlgorithm},
+		{"invalid priority", append([]byte(transaction), 2), 0, 0, ipc.ErrorInvalidRequest},
+		{"short transaction", []byte(transaction[:100]), 0, 0, ipc.ErrorInvalidTrytes},
+	} {
+		_, priority, algorithm, err := parsePowFunc(test.data)
+		var serverErr *ServerError
+		switch {
+		case test.code == "" && err != nil:
+			t.Errorf("%v: unexpected error: %v", test.name, err)
+		case test.code != "" && (!errors.As(err, &serverErr) || (serverErr.Code != test.code)):
+			t.Errorf("%v: expected %v, got: %v", test.name, test.code, err)
+		case test.code == "" && ((priority != test.priority) || (algorithm != test.algorithm)):
+			t.Errorf("%v: expected priority %d and algorithm %d, got %d and %d", test.name, test.priority, test.algorithm, priority, algorithm)
+		}
+	}
+}
+
+func TestPowRaw(t *testing.T) {
+	path, cleanup := startTestServer(t, false)
+	defer cleanup()
+
+	// Only the software device supports the raw POW
+	var fpgaCalls int32
+	fpga := &PowDevice{Index: 0, PowType: "fpga", PowClass: DeviceClassFPGA, PowFunc: func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		atomic.AddInt32(&fpgaCalls, 1)
+		return fakeNonce, nil
+	}}
+	cpu := &PowDevice{Index: 1, PowType: "cpu", PowClass: DeviceClassCPU, Algorithms: []byte{ipc.AlgorithmCurlTransaction, ipc.AlgorithmCurlRaw}, PowFunc: func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return findNonce(t, trytes, mwm), nil
+	}}
+	SetPowDevices([]*PowDevice{fpga, cpu})
+
+	powClient := &PowClient{PowSrvPath: path, WriteTimeOutMs: 500, ReadTimeOutMs: 5000, VerifyResults: true}
+	err := powClient.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer powClient.Close()
+
+	payload := []byte("Hello powSrv")
+	for i := 0; i < 3; i++ {
+		nonce, err := powClient.PowRaw(payload, verifyMWM)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := VerifyRawPow(payload, nonce, verifyMWM); !ok || (err != nil) {
+			t.Errorf("Invalid nonce %v: %v", nonce, err)
+		}
+	}
+	if atomic.LoadInt32(&fpgaCalls) != 0 {
+		t.Error("Raw POW was started on a device without the algorithm")
+	}
+
+	limits, err := powClient.GetLimits()
+	if (err != nil) || !limits.HasFeature(FeatureRawPow) {
+		t.Errorf("Raw POW not reported in the features: %v, %v", limits.Features, err)
+	}
+
+	// Without a device for the algorithm, the request fails instead of waiting
+	SetPowDevices([]*PowDevice{fpga})
+	_, err = powClient.PowRaw(payload, verifyMWM)
+	if !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Errorf("Expected ErrUnsupportedAlgorithm, got: %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package powsrv
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"net"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/muxxer/powsrv/ipc"
+	"github.com/muxxer/powsrv/logs"
+)
+
+const (
+	authNonceSize = 32
+	authTimeout   = 5 * time.Second
+)
+
+// authHMAC returns the HMAC-SHA256 of the nonce with the token as key
+func authHMAC(token string, nonce []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write(nonce)
+	return mac.Sum(nil)
+}
+
+// authRequired returns true if the client of the connection has to authenticate with "server.authToken"
+// Local Unix socket and named pipe connections are exempted if "server.authRequiredForUnix" is false.
+func authRequired(c net.Conn, config *viper.Viper) bool {
+	if config.GetString("server.authToken") == "" {
+		return false
+	}
+
+	if network := c.LocalAddr().Network(); (network == "unix") || (network == "pipe") {
+		return !config.IsSet("server.authRequiredForUnix") || config.GetBool("server.authRequiredForUnix")
+	}
+	return true
+}
+
+// commandAllowed returns true if the command can be executed on the connection
+func (c *clientConnection) commandAllowed(command byte) bool {
+	if !c.authRequired || c.authenticated {
+		return true
+	}
+
+	switch command {
+	case ipc.CmdGetServerVersion, ipc.CmdGetFrameVersions, ipc.CmdGetLimits, ipc.CmdAuth:
+		return true
+	default:
+		return false
+	}
+}
+
+// handleAuth answers a CmdAuth request with a new nonce, or checks the HMAC of the last nonce
+func (c *clientConnection) handleAuth(reqID byte, data []byte, token string) {
+	if len(data) == 0 {
+		nonce := make([]byte, authNonceSize)
+		_, err := rand.Read(nonce)
+		if err != nil {
+			logs.Log.Debug(err.Error())
+			c.sendError(reqID, err, ipc.ErrorInternal)
+			return
+		}
+
+		c.authNonce = nonce
+		c.send(reqID, ipc.CmdResponse, nonce)
+		return
+	}
+
+	// Every nonce can only be used once
+	nonce := c.authNonce
+	c.authNonce = nil
+
+	if c.authRequired && ((nonce == nil) || !hmac.Equal(data, authHMAC(token, nonce))) {
+		logs.Log.Warningf("Authentication of %v failed", c.RemoteAddr())
+		c.sendError(reqID, &ServerError{Code: ipc.ErrorAuthFailed}, ipc.ErrorAuthFailed)
+		return
+	}
+
+	c.authenticated = true
+	c.send(reqID, ipc.CmdResponse, nil)
+}
+
+// authenticate proves the knowledge of AuthToken to the powSrv before the connection is used for requests
+// Only the HMAC of a nonce of the powSrv is sent, the token itself never leaves the client.
+func (p *PowClient) authenticate(c *serverConnection) error {
+	c.SetDeadline(time.Now().Add(authTimeout))
+	defer c.SetDeadline(time.Time{})
+
+	nonce, err := p.directRequest(c, ipc.CmdAuth, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = p.directRequest(c, ipc.CmdAuth, authHMAC(p.AuthToken, nonce))
+	return err
+}
+
+// directRequest sends a request during the connection setup and waits for the response
+// The receive loop is not running yet, so the response is read directly.
+func (p *PowClient) directRequest(c *serverConnection, command byte, data []byte) ([]byte, error) {
+	err := c.writer.WriteFrame(0, command, data)
+	if err != nil {
+		return nil, err
+	}
+	traceFrame(frameSent, c.address, 0, command, data, "")
+
+	for {
+		frame, err := c.reader.ReadFrame()
+		traceReceivedFrame(c.address, frame, err)
+		if err != nil {
+			return nil, err
+		}
+
+		if (frame.ReqID != 0) || (frame.Command == ipc.CmdNotification) {
+			continue
+		}
+
+		if frame.Command == ipc.CmdError {
+			return nil, parseServerError(frame.Data)
+		}
+		return frame.Data, nil
+	}
+}
@@ -0,0 +1,141 @@
+package powsrv
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/iotaledger/giota"
+	"github.com/spf13/viper"
+
+	"github.com/muxxer/powsrv/ipc"
+)
+
+// startAuthServer starts a server with "server.authToken" on the given network and returns the client for it
+func startAuthServer(t *testing.T, network string, config *viper.Viper) (client *PowClient, cleanup func()) {
+	dir, err := ioutil.TempDir("", "powsrv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	address := "127.0.0.1:0"
+	if network == "unix" {
+		address = filepath.Join(dir, "powSrv.sock")
+	}
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	config.Set("server.authToken", "secret")
+	if !config.IsSet("pow.maxMinWeightMagnitude") {
+		config.Set("pow.maxMinWeightMagnitude", 243)
+	}
+
+	SetPowDevices([]*PowDevice{{PowType: "test", PowFunc: func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return trytes, nil
+	}}})
+
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go HandleClientConnection(c, config)
+		}
+	}()
+
+	client = &PowClient{Network: network, Address: ln.Addr().String(), WriteTimeOutMs: 500, ReadTimeOutMs: 5000}
+	return client, func() {
+		ln.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	data, err := giota.ToTrytes(transaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		network string
+		forUnix bool
+		token   string
+		initErr error
+		powErr  error
+	}{
+		{"No token", "tcp", true, "", nil, ErrAuthRequired},
+		{"Wrong token", "tcp", true, "wrong", ErrAuthFailed, nil},
+		{"Right token", "tcp", true, "secret", nil, nil},
+		{"Unix socket", "unix", true, "", nil, ErrAuthRequired},
+		{"Unix socket exempted", "unix", false, "", nil, nil},
+	}
+
+	for _, test := range tests {
+		config := viper.New()
+		config.Set("server.authRequiredForUnix", test.forUnix)
+
+		powClient, cleanup := startAuthServer(t, test.network, config)
+		powClient.AuthToken = test.token
+
+		err := powClient.Init()
+		if !errors.Is(err, test.initErr) {
+			t.Errorf("%s: expected Init error %v, got: %v", test.name, test.initErr, err)
+		}
+		if err != nil {
+			cleanup()
+			continue
+		}
+
+		// The server version is available without authentication
+		_, err = powClient.sendIpcFrameToServer(context.Background(), ipc.CmdGetServerVersion, nil)
+		if err != nil {
+			t.Errorf("%s: GetServerVersion: %v", test.name, err)
+		}
+
+		_, err = powClient.PowFunc(data, MWM)
+		if !errors.Is(err, test.powErr) {
+			t.Errorf("%s: expected PowFunc error %v, got: %v", test.name, test.powErr, err)
+		}
+
+		powClient.Close()
+		cleanup()
+	}
+}
+
+func TestAuthNonceReuse(t *testing.T) {
+	powClient, cleanup := startAuthServer(t, "tcp", viper.New())
+	defer cleanup()
+
+	conn, err := powClient.dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newServerConnection(conn)
+	defer c.Close()
+
+	nonce, err := powClient.directRequest(c, ipc.CmdAuth, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac := authHMAC("secret", nonce)
+
+	_, err = powClient.directRequest(c, ipc.CmdAuth, mac)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A replayed HMAC is rejected, because the nonce was already used
+	_, err = powClient.directRequest(c, ipc.CmdAuth, mac)
+	if !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("Expected ErrAuthFailed, got: %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package powsrv
+
+import (
+	"github.com/iotaledger/giota"
+
+	"github.com/muxxer/powsrv/ipc"
+	"github.com/muxxer/powsrv/logs"
+)
+
+const (
+	transactionTrytesSize = 2673 // (8019 is the TransactionTrinarySize) / 3
+
+	// MaxPowBatchSize is the maximum number of transactions of an ipc.CmdPowFuncBatch request
+	// The request and the response must fit into a single IPC frame
+	MaxPowBatchSize = (ipc.MaxDataLengthV1 - 2) / transactionTrytesSize
+)
+
+// errInvalidTrytes is returned if a POW request doesn't contain the valid trytes of whole transactions
+var errInvalidTrytes = &ServerError{Code: ipc.ErrorInvalidTrytes}
+
+// parseTransaction returns the trytes of a single transaction of a POW request
+// The trytes are checked before they are handed to a device, so the device never works on garbage.
+func parseTransaction(data []byte) (giota.Trytes, error) {
+	if len(data) != transactionTrytesSize {
+		logs.Log.Debugf("Transaction length is not %d trytes: %d", transactionTrytesSize, len(data))
+		return "", errInvalidTrytes
+	}
+
+	trytes, err := giota.ToTrytes(string(data))
+	if err != nil {
+		logs.Log.Debug(err.Error())
+		return "", errInvalidTrytes
+	}
+	return trytes, nil
+}
+
+// parsePowBatch returns the transactions of an ipc.CmdPowFuncBatch request
+func parsePowBatch(data []byte) ([]giota.Trytes, error) {
+	if len(data) < 2 {
+		return nil, newServerError(ipc.ErrorInvalidRequest, "Batch request without MinWeightMagnitude and flags")
+	}
+
+	data = data[2:]
+	if (len(data) == 0) || (len(data)%transactionTrytesSize != 0) {
+		logs.Log.Debugf("Batch request length is not a multiple of %d trytes: %d", transactionTrytesSize, len(data))
+		return nil, errInvalidTrytes
+	}
+
+	var transactions []giota.Trytes
+	for i := 0; i < len(data); i += transactionTrytesSize {
+		trytes, err := parseTransaction(data[i:(i + transactionTrytesSize)])
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, trytes)
+	}
+
+	if len(transactions) > MaxPowBatchSize {
+		return nil, newServerError(ipc.ErrorInvalidRequest, "Too many transactions in batch request: %d, Allowed: %d", len(transactions), MaxPowBatchSize)
+	}
+
+	return transactions, nil
+}
+
+// submitBatch does the POW of the transactions of a bundle one after another
+// The trunkTransaction of every following transaction is set to the hash of the previous one.
+// done is called as soon as all POWs are finished or one of them failed, the result has the order of the transactions.
+func (c *clientConnection) submitBatch(reqID byte, transactions []giota.Trytes, mwm int, reversed bool, log *logs.Entry, done func(result []giota.Trytes, err error)) error {
+	order := make([]int, len(transactions))
+	for i := range order {
+		order[i] = i
+		if reversed {
+			order[i] = len(transactions) - 1 - i
+		}
+	}
+
+	result := make([]giota.Trytes, len(transactions))
+
+	var submitNext func(step int, previousHash giota.Trytes) error
+	submitNext = func(step int, previousHash giota.Trytes) error {
+		index := order[step]
+
+		tx, err := giota.NewTransaction(transactions[index])
+		if err != nil {
+			return err
+		}
+		if step > 0 {
+			tx.TrunkTransaction = previousHash
+		}
+
+		return c.submitJob(reqID, tx.Trytes(), mwm, ipc.PriorityNormal, ipc.AlgorithmCurlTransaction, log.WithFields(logs.Fields{"batchIndex": index}), func(nonce giota.Trytes, err error) {
+			if err != nil {
+				done(nil, err)
+				return
+			}
+
+			tx.Nonce = nonce
+			result[index] = tx.Trytes()
+
+			if step == len(order)-1 {
+				done(result, nil)
+				return
+			}
+
+			err = submitNext(step+1, tx.Hash())
+			if err != nil {
+				done(nil, err)
+			}
+		})
+	}
+
+	return submitNext(0, "")
+}
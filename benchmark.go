@@ -0,0 +1,134 @@
+package powsrv
+
+import (
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iotaledger/giota"
+)
+
+// BenchmarkResult contains the POW durations of a single device, or of all devices in parallel
+type BenchmarkResult struct {
+	Index        int     `json:"index"` // -1 for all devices
+	PowType      string  `json:"powType"`
+	Operations   int     `json:"operations"`
+	Errors       int     `json:"errors"`
+	MinMs        float64 `json:"minMs"`
+	AvgMs        float64 `json:"avgMs"`
+	P95Ms        float64 `json:"p95Ms"`
+	MaxMs        float64 `json:"maxMs"`
+	PowPerSecond float64 `json:"powPerSecond"`
+}
+
+// BenchmarkReport is the result of Benchmark
+type BenchmarkReport struct {
+	MWM       int               `json:"mwm"`
+	Devices   []BenchmarkResult `json:"devices"`
+	Aggregate BenchmarkResult   `json:"aggregate"`
+}
+
+const tryteAlphabet = "9ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// randomTransaction returns a transaction with a random message, address, bundle, approvees and tag and a value of zero
+func randomTransaction(r *rand.Rand) giota.Trytes {
+	transaction := []byte(strings.Repeat("9", transactionTrytesSize))
+	// Signature message fragment and address, bundle to tag
+	for _, field := range [][2]int{{0, 2268}, {2349, attachmentTimestampOffset}} {
+		for i := field[0]; i < field[1]; i++ {
+			transaction[i] = tryteAlphabet[r.Intn(len(tryteAlphabet))]
+		}
+	}
+	return setAttachmentTimestamp(giota.Trytes(transaction), time.Now())
+}
+
+// Benchmark does the given number of POW operations on random transactions on every enabled device,
+// and afterwards operations * number of devices on all devices in parallel
+// The POW is done by the dispatcher, like the requests of the clients.
+func Benchmark(devices []*PowDevice, operations int, mwm int) *BenchmarkReport {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	report := &BenchmarkReport{MWM: mwm}
+
+	var enabled []*PowDevice
+	for _, device := range devices {
+		if device.Disabled() {
+			continue
+		}
+		enabled = append(enabled, device)
+
+		d := newPowDispatcher([]*PowDevice{device})
+		result := runBenchmark(d, r, operations, mwm, false)
+		d.stop()
+
+		result.Index = device.Index
+		result.PowType = device.PowType
+		report.Devices = append(report.Devices, result)
+	}
+
+	d := newPowDispatcher(enabled)
+	report.Aggregate = runBenchmark(d, r, operations*len(enabled), mwm, true)
+	d.stop()
+	report.Aggregate.Index = -1
+	report.Aggregate.PowType = d.powTypes()
+
+	return report
+}
+
+// runBenchmark does the POW operations with the dispatcher, one after the other or all at once
+func runBenchmark(d *powDispatcher, r *rand.Rand, operations int, mwm int, parallel bool) BenchmarkResult {
+	result := BenchmarkResult{Operations: operations}
+	if operations == 0 {
+		return result
+	}
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	durations := make([]time.Duration, 0, operations)
+
+	run := func(trytes giota.Trytes) {
+		defer wg.Done()
+
+		ts := time.Now()
+		_, err := d.powFunc(trytes, mwm)
+		duration := time.Since(ts)
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		if err != nil {
+			result.Errors++
+			return
+		}
+		durations = append(durations, duration)
+	}
+
+	ts := time.Now()
+	for i := 0; i < operations; i++ {
+		wg.Add(1)
+		if parallel {
+			go run(randomTransaction(r))
+		} else {
+			run(randomTransaction(r))
+		}
+	}
+	wg.Wait()
+	elapsed := time.Since(ts)
+
+	if len(durations) == 0 {
+		return result
+	}
+
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	var sum time.Duration
+	for _, duration := range durations {
+		sum += duration
+	}
+	result.MinMs = durationToMs(durations[0])
+	result.AvgMs = durationToMs(sum / time.Duration(len(durations)))
+	result.P95Ms = durationToMs(durations[(len(durations)*95+99)/100-1])
+	result.MaxMs = durationToMs(durations[len(durations)-1])
+	result.PowPerSecond = float64(len(durations)) / elapsed.Seconds()
+
+	return result
+}
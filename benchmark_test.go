@@ -0,0 +1,62 @@
+package powsrv
+
+import (
+	"errors"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/iotaledger/giota"
+)
+
+func TestBenchmark(t *testing.T) {
+	var calls int32
+	fast := &PowDevice{Index: 0, PowType: "fast", PowFunc: func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		atomic.AddInt32(&calls, 1)
+		if (len(trytes) != transactionTrytesSize) || (mwm != 14) {
+			return "", errors.New("unexpected request")
+		}
+		time.Sleep(2 * time.Millisecond)
+		return fakeNonce, nil
+	}}
+	failing := &PowDevice{Index: 1, PowType: "failing", PowFunc: func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		atomic.AddInt32(&calls, 1)
+		return "", errors.New("broken")
+	}}
+	disabled := &PowDevice{Index: 2, PowType: "disabled", disabled: 1}
+	SetPowDevices([]*PowDevice{fast, failing, disabled})
+
+	report := Benchmark(GetPowDevices(), 10, 14)
+
+	if (report.MWM != 14) || (len(report.Devices) != 2) {
+		t.Fatalf("Unexpected report: %+v", report)
+	}
+	result := report.Devices[0]
+	if (result.Index != 0) || (result.Operations != 10) || (result.Errors != 0) || (result.MinMs < 2) || (result.MinMs > result.AvgMs) || (result.AvgMs > result.P95Ms) || (result.P95Ms > result.MaxMs) || (result.PowPerSecond <= 0) {
+		t.Errorf("Unexpected result of the fast device: %+v", result)
+	}
+	if result := report.Devices[1]; (result.Errors != 10) || (result.PowPerSecond != 0) {
+		t.Errorf("Unexpected result of the failing device: %+v", result)
+	}
+	if (report.Aggregate.Index != -1) || (report.Aggregate.Operations != 20) || (report.Aggregate.Errors+int(fast.Requests()) != 30) {
+		t.Errorf("Unexpected aggregate result: %+v", report.Aggregate)
+	}
+	if calls != 40 {
+		t.Errorf("Expected 40 PoW operations, got %d", calls)
+	}
+}
+
+func TestRandomTransaction(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	first, second := randomTransaction(r), randomTransaction(r)
+	if (len(first) != transactionTrytesSize) || (first == second) {
+		t.Fatal("Expected different transactions")
+	}
+	if _, err := giota.NewTransaction(first); err != nil {
+		t.Error(err)
+	}
+	if first[2268:2295] != "999999999999999999999999999" {
+		t.Errorf("Expected a value of zero: %v", first[2268:2295])
+	}
+}
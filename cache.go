@@ -0,0 +1,125 @@
+package powsrv
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/iotaledger/giota"
+)
+
+// powCache is an LRU cache of the nonces of finished POW requests
+// The key contains the MWM, so a nonce is only returned for requests with exactly the MWM it was computed for.
+type powCache struct {
+	mutex   sync.Mutex
+	size    int           // Maximum number of entries (0 = disabled)
+	ttl     time.Duration // Entries are dropped after this time (0 = no expiry)
+	entries map[powCacheKey]*list.Element
+	lru     *list.List // Most recently used entry first
+
+	hits   uint64
+	misses uint64
+}
+
+// powCacheKey identifies a POW request by the hash of the trytes and the MWM
+type powCacheKey struct {
+	hash [sha256.Size]byte
+	mwm  int
+}
+
+// powCacheEntry is the nonce of a POW request
+type powCacheEntry struct {
+	key   powCacheKey
+	nonce giota.Trytes
+	added time.Time
+}
+
+var resultCache = newPowCache(0, 0)
+
+func newPowCache(size int, ttl time.Duration) *powCache {
+	return &powCache{size: size, ttl: ttl, entries: make(map[powCacheKey]*list.Element), lru: list.New()}
+}
+
+// SetPowCache enables the cache of the POW results with size entries that expire after ttl (size 0 = disabled)
+// The cached results are dropped, the hit and miss counters are kept.
+func SetPowCache(size int, ttl time.Duration) {
+	resultCache.mutex.Lock()
+	defer resultCache.mutex.Unlock()
+
+	resultCache.size = size
+	resultCache.ttl = ttl
+	resultCache.entries = make(map[powCacheKey]*list.Element)
+	resultCache.lru.Init()
+}
+
+// powCacheSettings returns the size and the ttl of the cache
+func powCacheSettings() (int, time.Duration) {
+	resultCache.mutex.Lock()
+	defer resultCache.mutex.Unlock()
+
+	return resultCache.size, resultCache.ttl
+}
+
+func newPowCacheKey(trytes giota.Trytes, mwm int) powCacheKey {
+	return powCacheKey{hash: sha256.Sum256([]byte(trytes)), mwm: mwm}
+}
+
+// get returns the cached nonce of the request
+func (c *powCache) get(trytes giota.Trytes, mwm int) (giota.Trytes, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.size == 0 {
+		return "", false
+	}
+
+	element, exists := c.entries[newPowCacheKey(trytes, mwm)]
+	if exists {
+		entry := element.Value.(*powCacheEntry)
+		if (c.ttl > 0) && (time.Since(entry.added) > c.ttl) {
+			c.remove(element)
+			exists = false
+		}
+	}
+	if !exists {
+		atomic.AddUint64(&c.misses, 1)
+		return "", false
+	}
+
+	c.lru.MoveToFront(element)
+	atomic.AddUint64(&c.hits, 1)
+	return element.Value.(*powCacheEntry).nonce, true
+}
+
+// add stores the nonce of a finished request, the least recently used entry is dropped if the cache is full
+func (c *powCache) add(trytes giota.Trytes, mwm int, nonce giota.Trytes) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.size == 0 {
+		return
+	}
+
+	key := newPowCacheKey(trytes, mwm)
+	if element, exists := c.entries[key]; exists {
+		c.remove(element)
+	}
+
+	c.entries[key] = c.lru.PushFront(&powCacheEntry{key: key, nonce: nonce, added: time.Now()})
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove drops an entry, the caller has to hold the mutex
+func (c *powCache) remove(element *list.Element) {
+	delete(c.entries, element.Value.(*powCacheEntry).key)
+	c.lru.Remove(element)
+}
+
+// counters returns the number of hits and misses of the cache
+func (c *powCache) counters() (hits uint64, misses uint64) {
+	return atomic.LoadUint64(&c.hits), atomic.LoadUint64(&c.misses)
+}
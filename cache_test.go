@@ -0,0 +1,106 @@
+package powsrv
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/iotaledger/giota"
+	"github.com/spf13/viper"
+)
+
+func TestPowCache(t *testing.T) {
+	cache := newPowCache(2, time.Hour)
+
+	cache.add("AAA", 14, "NONCE14")
+	if nonce, hit := cache.get("AAA", 14); !hit || (nonce != "NONCE14") {
+		t.Errorf("Expected a hit, got %v, %v", nonce, hit)
+	}
+
+	// A result must never be used for another MWM, especially not for a higher one
+	if _, hit := cache.get("AAA", 15); hit {
+		t.Error("Result of MWM 14 returned for MWM 15")
+	}
+	if _, hit := cache.get("AAA", 13); hit {
+		t.Error("Result of MWM 14 returned for MWM 13")
+	}
+	if _, hit := cache.get("AAB", 14); hit {
+		t.Error("Result returned for other trytes")
+	}
+
+	// The least recently used entry is dropped
+	cache.add("BBB", 14, "NONCEB")
+	cache.get("AAA", 14)
+	cache.add("CCC", 14, "NONCEC")
+	if _, hit := cache.get("BBB", 14); hit {
+		t.Error("Least recently used entry was not dropped")
+	}
+	if _, hit := cache.get("AAA", 14); !hit {
+		t.Error("Recently used entry was dropped")
+	}
+
+	if hits, misses := cache.counters(); (hits != 3) || (misses != 4) {
+		t.Errorf("Expected 3 hits and 4 misses, got %d and %d", hits, misses)
+	}
+}
+
+func TestPowCacheTTL(t *testing.T) {
+	cache := newPowCache(10, 50*time.Millisecond)
+
+	cache.add("AAA", 14, "NONCE")
+	if _, hit := cache.get("AAA", 14); !hit {
+		t.Fatal("Expected a hit before the expiry")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if _, hit := cache.get("AAA", 14); hit {
+		t.Error("Expired entry returned")
+	}
+	if len(cache.entries) != 0 {
+		t.Errorf("Expired entry was not removed: %v", len(cache.entries))
+	}
+
+	// A disabled cache stores nothing
+	disabled := newPowCache(0, time.Hour)
+	disabled.add("AAA", 14, "NONCE")
+	if _, hit := disabled.get("AAA", 14); hit {
+		t.Error("Disabled cache returned a result")
+	}
+}
+
+func TestPowCacheServer(t *testing.T) {
+	SetPowCache(10, time.Minute)
+	defer SetPowCache(0, 0)
+
+	config := viper.New()
+	config.Set("pow.maxMinWeightMagnitude", 243)
+	powClient := newPipeClient(config)
+	defer powClient.Close()
+
+	var calls int32
+	SetPowDevices([]*PowDevice{{PowType: "test", PowFunc: func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		atomic.AddInt32(&calls, 1)
+		return fakeNonce, nil
+	}}})
+
+	data, err := giota.ToTrytes(transaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hits, misses := resultCache.counters()
+	for _, mwm := range []int{14, 14, 15} {
+		nonce, err := powClient.PowFunc(data, mwm)
+		if (err != nil) || (nonce != fakeNonce) {
+			t.Fatalf("Unexpected result %v: %v", nonce, err)
+		}
+	}
+
+	if calls != 2 {
+		t.Errorf("Expected 2 PoW operations, got %d", calls)
+	}
+	stats := GetServerStats()
+	if (stats.CacheHits != hits+1) || (stats.CacheMisses != misses+2) {
+		t.Errorf("Expected 1 hit and 2 misses, got %d and %d", stats.CacheHits-hits, stats.CacheMisses-misses)
+	}
+}
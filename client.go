@@ -1,211 +1,737 @@
 package powsrv
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/iotaledger/giota"
-	"github.com/sigurn/crc8"
+
+	"github.com/muxxer/powsrv/ipc"
 )
 
+// frameVersionTimeout is the deadline of the frame version negotiation
+const frameVersionTimeout = 5 * time.Second
+
 // PowClient is the client that connects to the powSrv
+// After Init, PowFunc and the other requests are safe for concurrent use by multiple goroutines.
+// All state of the requests belongs to the PowClient, so several clients can be used in one process.
 type PowClient struct {
-	PowSrvPath     string // Path to the powSrv Unix socket
-	WriteTimeOutMs int64  // Timeout in ms to write to the Unix socket
-	ReadTimeOutMs  int    // Timeout in ms to read the Unix socket
+	PowSrvPath     string // Path to the powSrv Unix socket (deprecated, use Network and Address)
+	Network        string // Network of the powSrv: 'unix', 'tcp' or 'pipe' (Windows) (default: DefaultNetwork)
+	Address        string // Address of the powSrv, e.g. '/tmp/powSrv.sock', '192.168.1.10:5000' or '\\.\pipe\powSrv'
+	WriteTimeOutMs int64  // Timeout in ms to write to the socket
+	ReadTimeOutMs  int    // Timeout in ms without any answer of the powSrv, progress notifications restart the timeout
+
+	ReconnectAttempts   int // Maximum number of reconnect attempts after the connection dropped (0 = no reconnect)
+	ReconnectIntervalMs int // Interval in ms before the first reconnect attempt, doubled after every failed attempt
+	KeepAliveIntervalMs int // Interval in ms of the pings, so the powSrv doesn't close the quiet connection (0 = no pings)
+
+	TLSConfig *tls.Config // Optional, TLS is used for 'tcp' connections if set
+	AuthToken string      // Shared secret of the powSrv ("server.authToken"), the handshake is done in Init
+
+	PreferredDevice string // Optional, the POW requests are only done by this device: "fpga", "cpu" or a device index (default: "any")
+	VerifyResults   bool   // Check the nonces of the powSrv against the MWM, ErrInvalidNonce is returned if they don't reach it
+
+	OnProgress           func(reqID byte, elapsed time.Duration) // Optional, called for every progress notification of a running request
+	OnServerNotification func(message string)                    // Optional, called for the notifications that don't belong to a request, e.g. "DEVICE_LOST PiDiver"
+
+	connection   *serverConnection
+	closed       bool          // Connection was closed by the user, no reconnect
+	reconnecting chan struct{} // Closed as soon as the running reconnect is finished
+	writeMutex   sync.Mutex
+	pendingMutex sync.Mutex
+	pending      map[byte]*pendingRequest // Requests that wait for a response, indexed by ReqID
+	pendingSlots chan struct{}            // Limits the outstanding requests to the number of available ReqIDs
+	reqID        byte
+
+	unmatchedResponses uint64 // Responses without a waiting request (duplicates or late responses after a timeout)
+
+	info   *PowInfo      // Answer of GetPowInfo for the current connection (guarded by pendingMutex)
+	limits *ServerLimits // Answer of GetLimits for the current connection (guarded by pendingMutex)
+}
+
+// ipcResponse is the result of a request that is handed from receive to the waiting sender
+type ipcResponse struct {
+	frame *ipc.Frame
+	err   error
 }
 
-var reqID byte
+// serverConnection is the connection to the powSrv
+type serverConnection struct {
+	net.Conn
+	reader  *ipc.FrameReader
+	writer  *ipc.FrameWriter // Uses the frame version that was negotiated with the powSrv
+	address string           // Remote address of the powSrv for the frame dumps
+}
 
-func receive(c net.Conn, timeoutMs int) (response []byte, Error error) {
-	frameState := FrameStateSearchEnq
-	frameLength := 0
-	var frameData []byte
+// newServerConnection starts with IpcFrameV1, which is supported by all servers
+func newServerConnection(c net.Conn) *serverConnection {
+	return &serverConnection{Conn: c, reader: ipc.NewFrameReader(c), writer: ipc.NewFrameWriter(c, ipc.Version1), address: remoteAddress(c)}
+}
 
-	ts := time.Now()
-	td := time.Duration(timeoutMs) * time.Millisecond
+// pendingRequest is a request that waits for the response of the powSrv
+type pendingRequest struct {
+	conn     *serverConnection // Connection the request was sent on
+	response chan ipcResponse
+	progress chan struct{} // Signals a progress notification of the powSrv
+}
 
-	for {
-		if time.Since(ts) > td {
-			return nil, errors.New("Receive timeout")
+// endpoint returns the configured network and address of the powSrv
+func (p *PowClient) endpoint() (network string, address string) {
+	network = p.Network
+	if network == "" {
+		network = DefaultNetwork
+	}
+
+	address = p.Address
+	if address == "" {
+		address = p.PowSrvPath
+	}
+	return network, address
+}
+
+// dial connects to the configured network address of the powSrv
+func (p *PowClient) dial() (net.Conn, error) {
+	network, address := p.endpoint()
+
+	switch {
+	case network == "pipe":
+		return dialPipe(address)
+	case (p.TLSConfig != nil) && (network == "tcp"):
+		return tls.Dial(network, address, p.TLSConfig)
+	default:
+		return net.Dial(network, address)
+	}
+}
+
+// connect dials the powSrv, authenticates if an AuthToken is set and selects the PreferredDevice
+func (p *PowClient) connect() (*serverConnection, error) {
+	conn, err := p.dial()
+	if err != nil {
+		return nil, err
+	}
+	c := newServerConnection(conn)
+
+	if p.AuthToken != "" {
+		err = p.authenticate(c)
+		if err != nil {
+			c.Close()
+			return nil, err
 		}
+	}
 
-		buf := make([]byte, 3072) // ((8019 is the TransactionTrinarySize) / 3) + Overhead) => 3072
-		bufLength, err := c.Read(buf)
+	// Without a selection, older servers without ipc.CmdSelectDevice can be used
+	if (p.PreferredDevice != "") && (p.PreferredDevice != DeviceClassAny) {
+		err = p.selectDevice(c)
 		if err != nil {
-			continue
+			c.Close()
+			return nil, err
 		}
+	}
+	return c, nil
+}
+
+// Init connects to the powSrv and starts receiving the responses
+func (p *PowClient) Init() error {
+	c, err := p.connect()
+	if err != nil {
+		return err
+	}
 
-		bufferIdx := -1
-		for {
-			bufferIdx++
+	p.pendingMutex.Lock()
+	p.connection = c
+	p.closed = false
+	p.info = nil
+	p.limits = nil
+	if p.pending == nil {
+		p.pending = make(map[byte]*pendingRequest)
+	}
+	if p.pendingSlots == nil {
+		p.pendingSlots = make(chan struct{}, 256)
+	}
+	p.pendingMutex.Unlock()
 
-			if bufLength > bufferIdx {
-				switch frameState {
+	go p.receive(c)
+	go p.keepAlive(c)
+	p.negotiateFrameVersion(c)
+	p.loadLimits()
+	return nil
+}
 
-				case FrameStateSearchEnq:
-					if buf[bufferIdx] == 0x05 {
-						// Init variables for new message
-						frameLength = -1
-						frameData = nil
-						frameState = FrameStateSearchVersion
-					}
+// negotiateFrameVersion asks the powSrv for the supported frame versions and uses IpcFrameV2 if possible
+// Servers that don't know CmdGetFrameVersions answer with an error, so IpcFrameV1 is kept.
+func (p *PowClient) negotiateFrameVersion(c *serverConnection) {
+	ctx, cancel := context.WithTimeout(context.Background(), frameVersionTimeout)
+	defer cancel()
 
-				case FrameStateSearchVersion:
-					if buf[bufferIdx] == 0x01 {
-						frameState = FrameStateSearchLength
-					} else {
-						frameState = FrameStateSearchEnq
-					}
+	versions, err := p.sendIpcFrameToServer(ctx, ipc.CmdGetFrameVersions, nil)
+	if err != nil {
+		return
+	}
 
-				case FrameStateSearchLength:
-					if frameLength == -1 {
-						// Receive first byte
-						frameLength = int(buf[bufferIdx]) << 8
-					} else {
-						// Receive second byte and go on
-						frameLength |= int(buf[bufferIdx])
-						frameState = FrameStateSearchData
-					}
+	if bytes.IndexByte(versions, ipc.Version2) != -1 {
+		c.writer.SetVersion(ipc.Version2)
+	}
+}
 
-				case FrameStateSearchData:
-					missingByteCount := frameLength - len(frameData)
-					if (bufLength - bufferIdx) >= missingByteCount {
-						// Frame completely received
-						frameData = append(frameData, buf[bufferIdx:(bufferIdx+missingByteCount)]...)
-						bufferIdx += missingByteCount - 1
-						frameState = FrameStateSearchCRC
-					} else {
-						// Frame not completed in this read => Copy the remaining bytes
-						frameData = append(frameData, buf[bufferIdx:bufLength]...)
-						bufferIdx = bufLength
-					}
+// Close closes the connection to the powSrv
+func (p *PowClient) Close() error {
+	p.pendingMutex.Lock()
+	c := p.connection
+	p.connection = nil
+	p.closed = true
+	p.pendingMutex.Unlock()
 
-				case FrameStateSearchCRC:
-					crc := crc8.Checksum(frameData, crc8Table)
-					if buf[bufferIdx] != crc {
-						return nil, fmt.Errorf("Wrong Checksum! CRC: %X, Expected: %X", crc, buf[bufferIdx])
-					}
+	if c == nil {
+		return ErrNotConnected
+	}
+	return c.Close()
+}
 
-					return frameData, nil
+// connected returns true if the connection to the powSrv is established
+func (p *PowClient) connected() bool {
+	p.pendingMutex.Lock()
+	defer p.pendingMutex.Unlock()
+	return p.connection != nil
+}
 
-				}
-			} else {
-				// Received Buffer completely handled, break the loop to receive the next message
-				break
-			}
-		}
+// UnmatchedResponses returns the number of responses that were received without a waiting request,
+// e.g. duplicated responses or responses that arrived after the request timed out
+func (p *PowClient) UnmatchedResponses() uint64 {
+	return atomic.LoadUint64(&p.unmatchedResponses)
+}
+
+// deliver hands a response of the connection to the request with the given ReqID
+// The entry is removed afterwards, so a duplicated or late response is dropped
+func (p *PowClient) deliver(c *serverConnection, reqID byte, response ipcResponse) {
+	p.pendingMutex.Lock()
+	request, exists := p.pending[reqID]
+	exists = exists && (request.conn == c)
+	if exists {
+		delete(p.pending, reqID)
+	}
+	p.pendingMutex.Unlock()
+
+	if !exists {
+		atomic.AddUint64(&p.unmatchedResponses, 1)
+		return
 	}
+	request.response <- response
 }
 
-// sendToServer sends an IpcMessage struct to the powSrv
-// It returns the response bytes or an error
-func (p PowClient) sendToServer(requestMsg *IpcMessage) (response []byte, Error error) {
-	request, err := requestMsg.ToBytes()
-	if err != nil {
-		return nil, err
+// notifyProgress restarts the timeout of the request with the given ReqID and calls OnProgress
+func (p *PowClient) notifyProgress(c *serverConnection, reqID byte, elapsed time.Duration) {
+	p.pendingMutex.Lock()
+	request, exists := p.pending[reqID]
+	p.pendingMutex.Unlock()
+
+	if !exists || (request.conn != c) {
+		// Late notification of a finished request
+		return
 	}
 
-	c, err := net.Dial("unix", p.PowSrvPath)
-	if err != nil {
-		return nil, err
+	select {
+	case request.progress <- struct{}{}:
+	default:
+		// The previous notification is not handled yet
 	}
-	defer c.Close()
 
-	if p.WriteTimeOutMs != 0 {
-		err = c.SetWriteDeadline(time.Now().Add(time.Millisecond * time.Duration(p.WriteTimeOutMs)))
+	if p.OnProgress != nil {
+		p.OnProgress(reqID, elapsed)
+	}
+}
+
+// disconnect fails all pending requests of the connection after it was lost
+// It returns true if the connection was not closed by the user, so a reconnect should be done
+func (p *PowClient) disconnect(c *serverConnection) (lost bool) {
+	c.Close()
+
+	p.pendingMutex.Lock()
+	if p.connection == c {
+		p.connection = nil
+		p.info = nil
+		p.limits = nil
+		lost = true
+		if p.ReconnectAttempts > 0 {
+			p.reconnecting = make(chan struct{})
+		}
+	}
+	var lostRequests []*pendingRequest
+	for reqID, request := range p.pending {
+		if request.conn == c {
+			lostRequests = append(lostRequests, request)
+			delete(p.pending, reqID)
+		}
+	}
+	p.pendingMutex.Unlock()
+
+	for _, request := range lostRequests {
+		request.response <- ipcResponse{err: ErrConnectionLost}
+	}
+
+	return lost
+}
+
+// reconnect re-dials the powSrv with exponential backoff
+func (p *PowClient) reconnect() {
+	interval := time.Duration(p.ReconnectIntervalMs) * time.Millisecond
+
+	for attempt := 0; attempt < p.ReconnectAttempts; attempt++ {
+		time.Sleep(interval)
+		interval *= 2
+
+		// The powSrv may have changed, so the frame version is negotiated again
+		c, err := p.connect()
 		if err != nil {
-			return nil, err
+			continue
 		}
+
+		p.pendingMutex.Lock()
+		closed := p.closed
+		if closed {
+			c.Close()
+		} else {
+			p.connection = c
+			go p.receive(c)
+			go p.keepAlive(c)
+		}
+		close(p.reconnecting)
+		p.reconnecting = nil
+		p.pendingMutex.Unlock()
+
+		if !closed {
+			p.negotiateFrameVersion(c)
+			p.loadLimits()
+		}
+		return
+	}
+
+	// Give up, further requests fail with ErrNotConnected
+	p.pendingMutex.Lock()
+	close(p.reconnecting)
+	p.reconnecting = nil
+	p.pendingMutex.Unlock()
+}
+
+// keepAlive pings the powSrv every KeepAliveIntervalMs until the connection is closed or replaced
+// Failed pings are ignored, a lost connection is detected by receive.
+func (p *PowClient) keepAlive(c *serverConnection) {
+	if p.KeepAliveIntervalMs <= 0 {
+		return
 	}
 
-	if p.ReadTimeOutMs != 0 {
-		err = c.SetReadDeadline(time.Now().Add(time.Millisecond * time.Duration(p.ReadTimeOutMs)))
+	ticker := time.NewTicker(time.Duration(p.KeepAliveIntervalMs) * time.Millisecond)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		p.pendingMutex.Lock()
+		current := p.connection == c
+		p.pendingMutex.Unlock()
+		if !current {
+			return
+		}
+
+		p.Ping()
+	}
+}
+
+// receive reads the frames of the powSrv and hands them to the waiting requests until the connection is closed
+func (p *PowClient) receive(c *serverConnection) {
+	for {
+		frame, err := c.reader.ReadFrame()
+		traceReceivedFrame(c.address, frame, err)
+		if frameErr, ok := err.(*ipc.FrameError); ok {
+			if frameErr.Frame != nil {
+				p.deliver(c, frameErr.Frame.ReqID, ipcResponse{err: err})
+			}
+			// ReqID unknown => the request runs into the timeout
+			continue
+		}
 		if err != nil {
-			return nil, err
+			// io.EOF or network error
+			if p.disconnect(c) && p.ReconnectAttempts > 0 {
+				p.reconnect()
+			}
+			return
+		}
+
+		if frame.Command == ipc.CmdNotification {
+			// Progress notifications keep the request alive, other notifications do not belong to a request
+			if elapsed, ok := parseProgressNotification(frame.Data); ok {
+				p.notifyProgress(c, frame.ReqID, elapsed)
+			} else if p.OnServerNotification != nil {
+				p.OnServerNotification(string(frame.Data))
+			}
+			continue
 		}
+
+		p.deliver(c, frame.ReqID, ipcResponse{frame: frame})
 	}
+}
 
-	_, err = c.Write(request)
-	if err != nil {
-		return nil, err
+// sendToServer writes a frame to the powSrv
+func (p *PowClient) sendToServer(c *serverConnection, reqID byte, command byte, data []byte) error {
+	// The write deadline of concurrent requests must not interfere
+	p.writeMutex.Lock()
+	defer p.writeMutex.Unlock()
+
+	if p.WriteTimeOutMs != 0 {
+		err := c.SetWriteDeadline(time.Now().Add(time.Millisecond * time.Duration(p.WriteTimeOutMs)))
+		if err != nil {
+			return err
+		}
 	}
 
-	response, err = receive(c, p.ReadTimeOutMs)
-	return response, err
+	err := c.writer.WriteFrame(reqID, command, data)
+	if err == nil {
+		traceFrame(frameSent, c.address, reqID, command, data, "")
+	}
+	return err
 }
 
-// sendIpcFrameV1ToServer creates an IpcFrameV1 and calls sendToServer
+// sendIpcFrameToServer sends an IPC frame with the negotiated frame version to the server
 // The answer of the server is evaluated and returned to the caller
-func (p PowClient) sendIpcFrameV1ToServer(command byte, data []byte) (response []byte, Error error) {
-	reqID++
+// If the context is done or the server does not answer within ReadTimeOutMs, the request is cancelled on the server
+func (p *PowClient) sendIpcFrameToServer(ctx context.Context, command byte, data []byte) (response []byte, Error error) {
+	request := &pendingRequest{response: make(chan ipcResponse, 1), progress: make(chan struct{}, 1)}
 
-	requestMsg, err := NewIpcMessageV1(reqID, command, data)
-	if err != nil {
-		return nil, err
+	p.pendingMutex.Lock()
+	pendingSlots := p.pendingSlots
+	p.pendingMutex.Unlock()
+
+	if pendingSlots == nil {
+		return nil, ErrNotConnected
 	}
 
-	resp, err := p.sendToServer(requestMsg)
-	if err != nil {
-		return nil, err
+	// Wait until a ReqID is free
+	select {
+	case pendingSlots <- struct{}{}:
+		defer func() { <-pendingSlots }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
 
-	frame, err := BytesToIpcFrameV1(resp)
+	p.pendingMutex.Lock()
+	for (p.connection == nil) && (p.reconnecting != nil) {
+		// Wait until the reconnect is finished
+		reconnecting := p.reconnecting
+		p.pendingMutex.Unlock()
+		select {
+		case <-reconnecting:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		p.pendingMutex.Lock()
+	}
+
+	c := p.connection
+	if c == nil {
+		p.pendingMutex.Unlock()
+		return nil, ErrNotConnected
+	}
+	// A ReqID is never reused while the request is still pending.
+	// There are at most 256 pending requests, so a free ReqID always exists.
+	p.reqID++
+	for _, exists := p.pending[p.reqID]; exists; _, exists = p.pending[p.reqID] {
+		p.reqID++
+	}
+	reqID := p.reqID
+	request.conn = c
+	p.pending[reqID] = request
+	p.pendingMutex.Unlock()
+
+	err := p.sendToServer(c, reqID, command, data)
 	if err != nil {
+		p.pendingMutex.Lock()
+		delete(p.pending, reqID)
+		p.pendingMutex.Unlock()
 		return nil, err
 	}
 
-	if frame.ReqID != reqID {
-		return nil, fmt.Errorf("Wrong ReqID! ReqID: %X, Expected: %X", frame.ReqID, reqID)
+	readTimeout := time.Duration(p.ReadTimeOutMs) * time.Millisecond
+	var timer *time.Timer
+	var timeout <-chan time.Time
+	if readTimeout != 0 {
+		timer = time.NewTimer(readTimeout)
+		defer timer.Stop()
+		timeout = timer.C
 	}
 
+	var resp ipcResponse
+	for received := false; !received; {
+		select {
+		case resp = <-request.response:
+			received = true
+		case <-request.progress:
+			// The server is still working on the request => restart the timeout
+			if timer != nil {
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(readTimeout)
+			}
+		case <-timeout:
+			p.cancelRequest(c, reqID, request)
+			return nil, ErrTimeout
+		case <-ctx.Done():
+			p.cancelRequest(c, reqID, request)
+			return nil, ctx.Err()
+		}
+	}
+
+	if resp.err != nil {
+		return nil, resp.err
+	}
+	frame := resp.frame
+
 	switch frame.Command {
 
-	case IpcCmdResponse:
+	case ipc.CmdResponse:
 		return frame.Data, nil
 
-	case IpcCmdError:
-		return nil, fmt.Errorf(string(frame.Data))
+	case ipc.CmdError:
+		serverErr := parseServerError(frame.Data)
+		if retryAfter, ok := parseRateLimited(serverErr); ok {
+			return nil, &RateLimitError{RetryAfter: retryAfter, err: serverErr}
+		}
+		return nil, serverErr
 
 	default:
 		//
-		// IpcCmdNotification, IpcCmdGetServerVersion, IpcCmdGetPowType, IpcCmdGetPowVersion, IpcCmdPowFunc
+		// ipc.CmdNotification, ipc.CmdGetServerVersion, ipc.CmdGetPowType, ipc.CmdGetPowVersion, ipc.CmdPowFunc, ipc.CmdCancel
 		return nil, fmt.Errorf("Unknown command! Cmd: %X", frame.Command)
 	}
 }
 
+// cancelRequest tells the server to stop working on the request and frees the ReqID afterwards,
+// otherwise the cancel could hit a new request with the same ReqID
+func (p *PowClient) cancelRequest(c *serverConnection, reqID byte, request *pendingRequest) {
+	p.sendToServer(c, reqID, ipc.CmdCancel, nil)
+
+	p.pendingMutex.Lock()
+	if p.pending[reqID] == request {
+		delete(p.pending, reqID)
+	}
+	p.pendingMutex.Unlock()
+}
+
 // GetPowInfo returns information about the powSrv version, POW hardware type, and POW hardware version
-func (p PowClient) GetPowInfo() (ServerVersion string, PowType string, PowVersion string, Error error) {
-	serverVersion, err := p.sendIpcFrameV1ToServer(IpcCmdGetServerVersion, nil)
+// The answer is cached until the connection is lost, use RefreshPowInfo to get the current state of the devices.
+func (p *PowClient) GetPowInfo() (ServerVersion string, PowType string, PowVersion string, Error error) {
+	return p.GetPowInfoWithContext(context.Background())
+}
+
+// GetPowInfoWithContext returns information about the powSrv version, POW hardware type, and POW hardware version
+// The requests are cancelled as soon as the context is done
+func (p *PowClient) GetPowInfoWithContext(ctx context.Context) (ServerVersion string, PowType string, PowVersion string, Error error) {
+	p.pendingMutex.Lock()
+	info := p.info
+	p.pendingMutex.Unlock()
+
+	if info == nil {
+		var err error
+		info, err = p.requestPowInfo(ctx)
+		if err != nil {
+			return "", "", "", err
+		}
+	}
+	return info.ServerVersion, info.PowType, info.PowVersion, nil
+}
+
+// RefreshPowInfo asks the powSrv for the current POW types and versions and updates the cache of GetPowInfo
+func (p *PowClient) RefreshPowInfo() (ServerVersion string, PowType string, PowVersion string, Error error) {
+	return p.RefreshPowInfoWithContext(context.Background())
+}
+
+// RefreshPowInfoWithContext asks the powSrv for the current POW types and versions and updates the cache of GetPowInfo
+// The requests are cancelled as soon as the context is done
+func (p *PowClient) RefreshPowInfoWithContext(ctx context.Context) (ServerVersion string, PowType string, PowVersion string, Error error) {
+	info, err := p.requestPowInfo(ctx)
 	if err != nil {
 		return "", "", "", err
 	}
+	return info.ServerVersion, info.PowType, info.PowVersion, nil
+}
 
-	powType, err := p.sendIpcFrameV1ToServer(IpcCmdGetPowType, nil)
+// requestPowInfo gets the info with a single ipc.CmdGetInfo and caches it
+// Servers without the command answer with a ServerError, they are asked with the three separate commands.
+func (p *PowClient) requestPowInfo(ctx context.Context) (*PowInfo, error) {
+	p.pendingMutex.Lock()
+	c := p.connection
+	p.pendingMutex.Unlock()
+
+	info := &PowInfo{}
+	response, err := p.sendIpcFrameToServer(ctx, ipc.CmdGetInfo, nil)
+	var serverErr *ServerError
+	switch {
+	case errors.As(err, &serverErr):
+		info, err = p.requestPowInfoSeparately(ctx)
+		if err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	default:
+		err = json.Unmarshal(response, info)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The answer of a lost connection may be outdated after the reconnect
+	p.pendingMutex.Lock()
+	if (c != nil) && (p.connection == c) {
+		p.info = info
+	}
+	p.pendingMutex.Unlock()
+
+	return info, nil
+}
+
+// requestPowInfoSeparately gets the info with ipc.CmdGetServerVersion, ipc.CmdGetPowType and ipc.CmdGetPowVersion
+func (p *PowClient) requestPowInfoSeparately(ctx context.Context) (*PowInfo, error) {
+	serverVersion, err := p.sendIpcFrameToServer(ctx, ipc.CmdGetServerVersion, nil)
 	if err != nil {
-		return "", "", "", err
+		return nil, err
 	}
 
-	powVersion, err := p.sendIpcFrameV1ToServer(IpcCmdGetPowVersion, nil)
+	powType, err := p.sendIpcFrameToServer(ctx, ipc.CmdGetPowType, nil)
 	if err != nil {
-		return "", "", "", err
+		return nil, err
+	}
+
+	powVersion, err := p.sendIpcFrameToServer(ctx, ipc.CmdGetPowVersion, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PowInfo{ServerVersion: string(serverVersion), PowType: string(powType), PowVersion: string(powVersion)}, nil
+}
+
+// Ping checks that the powSrv answers and keeps the connection alive
+func (p *PowClient) Ping() error {
+	return p.PingWithContext(context.Background())
+}
+
+// PingWithContext checks that the powSrv answers and keeps the connection alive
+// The request is cancelled as soon as the context is done
+func (p *PowClient) PingWithContext(ctx context.Context) error {
+	_, err := p.sendIpcFrameToServer(ctx, ipc.CmdPing, nil)
+	return err
+}
+
+// GetServerStats returns the statistics of the powSrv
+func (p *PowClient) GetServerStats() (ServerStats, error) {
+	var stats ServerStats
+	response, err := p.sendIpcFrameToServer(context.Background(), ipc.CmdGetServerStats, nil)
+	if err != nil {
+		return stats, err
+	}
+
+	err = json.Unmarshal(response, &stats)
+	return stats, err
+}
+
+// GetDevices returns the state of every device of the powSrv
+// Servers without ipc.CmdGetDeviceList answer with a ServerError, use GetPowInfo for them.
+func (p *PowClient) GetDevices() ([]DeviceInfo, error) {
+	response, err := p.sendIpcFrameToServer(context.Background(), ipc.CmdGetDeviceList, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var devices []DeviceInfo
+	err = json.Unmarshal(response, &devices)
+	return devices, err
+}
+
+// HealthCheck lets the powSrv do a trivial POW on every device and returns the results
+func (p *PowClient) HealthCheck() ([]DeviceHealth, error) {
+	response, err := p.sendIpcFrameToServer(context.Background(), ipc.CmdHealthCheck, nil)
+	if err != nil {
+		return nil, err
 	}
 
-	return string(serverVersion), string(powType), string(powVersion), nil
+	var health []DeviceHealth
+	err = json.Unmarshal(response, &health)
+	return health, err
 }
 
 // PowFunc does the POW
-func (p PowClient) PowFunc(trytes giota.Trytes, minWeightMagnitude int) (result giota.Trytes, Error error) {
-	if (minWeightMagnitude < 0) || (minWeightMagnitude > 243) {
-		return "", fmt.Errorf("minWeightMagnitude out of range [0-243]: %v", minWeightMagnitude)
+func (p *PowClient) PowFunc(trytes giota.Trytes, minWeightMagnitude int) (result giota.Trytes, Error error) {
+	return p.PowFuncWithContext(context.Background(), trytes, minWeightMagnitude)
+}
+
+// PowFuncWithContext does the POW
+// The POW is cancelled as soon as the context is done
+func (p *PowClient) PowFuncWithContext(ctx context.Context, trytes giota.Trytes, minWeightMagnitude int) (result giota.Trytes, Error error) {
+	return p.PowFuncPriorityWithContext(ctx, trytes, minWeightMagnitude, ipc.PriorityNormal)
+}
+
+// PowFuncPriority does the POW with the priority ipc.PriorityNormal or ipc.PriorityHigh
+// Queued requests with ipc.PriorityHigh are started before the normal requests of all clients.
+// Servers without priorities reject requests with ipc.PriorityHigh as INVALID_TRYTES.
+func (p *PowClient) PowFuncPriority(trytes giota.Trytes, minWeightMagnitude int, priority byte) (result giota.Trytes, Error error) {
+	return p.PowFuncPriorityWithContext(context.Background(), trytes, minWeightMagnitude, priority)
+}
+
+// PowFuncPriorityWithContext does the POW with the priority
+// The POW is cancelled as soon as the context is done
+func (p *PowClient) PowFuncPriorityWithContext(ctx context.Context, trytes giota.Trytes, minWeightMagnitude int, priority byte) (result giota.Trytes, Error error) {
+	if priority > ipc.PriorityHigh {
+		return "", fmt.Errorf("priority out of range [0-1]: %v", priority)
 	}
 
 	data := []byte{byte(minWeightMagnitude)}
 	data = append(data, []byte(string(trytes))...)
+	if priority != ipc.PriorityNormal {
+		// Without the byte, older servers still accept the normal requests
+		data = append(data, priority)
+	}
+	return p.pow(ctx, data, trytes, minWeightMagnitude)
+}
+
+// PowRaw does the POW of an arbitrary payload with ipc.AlgorithmCurlRaw and returns the nonce
+// The nonce can be checked with VerifyRawPow. Servers without a device for the algorithm return ErrUnsupportedAlgorithm.
+func (p *PowClient) PowRaw(data []byte, minWeightMagnitude int) (nonce giota.Trytes, Error error) {
+	return p.PowRawWithContext(context.Background(), data, minWeightMagnitude)
+}
 
-	response, err := p.sendIpcFrameV1ToServer(IpcCmdPowFunc, data)
+// PowRawWithContext does the POW of an arbitrary payload
+// The POW is cancelled as soon as the context is done
+func (p *PowClient) PowRawWithContext(ctx context.Context, data []byte, minWeightMagnitude int) (nonce giota.Trytes, Error error) {
+	trytes, err := RawPowTrytes(data)
+	if err != nil {
+		return "", err
+	}
+
+	request := []byte{byte(minWeightMagnitude), ipc.PriorityNormal, ipc.AlgorithmCurlRaw}
+	request = append(request, data...)
+	return p.pow(ctx, request, trytes, minWeightMagnitude)
+}
+
+// pow sends an ipc.CmdPowFunc request and returns the nonce
+// The trytes are hashed with the nonce if the results are verified.
+func (p *PowClient) pow(ctx context.Context, data []byte, trytes giota.Trytes, minWeightMagnitude int) (result giota.Trytes, Error error) {
+	if (minWeightMagnitude < 0) || (minWeightMagnitude > 243) {
+		return "", fmt.Errorf("minWeightMagnitude out of range [0-243]: %v", minWeightMagnitude)
+	}
+	if err := p.checkMwm(minWeightMagnitude); err != nil {
+		return "", err
+	}
+
+	response, err := p.sendIpcFrameToServer(ctx, ipc.CmdPowFunc, data)
 	if err != nil {
 		return "", err
 	}
@@ -215,5 +741,72 @@ func (p PowClient) PowFunc(trytes giota.Trytes, minWeightMagnitude int) (result
 		return "", err
 	}
 
-	return result, err
+	if p.VerifyResults {
+		err = verifyNonce(trytes, result, minWeightMagnitude)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return result, nil
+}
+
+// PowBatch does the POW of all transactions of a bundle in a single request
+// The trunkTransaction of every following transaction is set to the hash of the previous one by the server.
+// If reversed is true, the POW is done from the last to the first transaction.
+func (p *PowClient) PowBatch(trytes []giota.Trytes, minWeightMagnitude int, reversed bool) (result []giota.Trytes, Error error) {
+	return p.PowBatchWithContext(context.Background(), trytes, minWeightMagnitude, reversed)
+}
+
+// PowBatchWithContext does the POW of all transactions of a bundle in a single request
+// The POW is cancelled as soon as the context is done
+func (p *PowClient) PowBatchWithContext(ctx context.Context, trytes []giota.Trytes, minWeightMagnitude int, reversed bool) (result []giota.Trytes, Error error) {
+	if (minWeightMagnitude < 0) || (minWeightMagnitude > 243) {
+		return nil, fmt.Errorf("minWeightMagnitude out of range [0-243]: %v", minWeightMagnitude)
+	}
+	if err := p.checkMwm(minWeightMagnitude); err != nil {
+		return nil, err
+	}
+
+	if (len(trytes) == 0) || (len(trytes) > MaxPowBatchSize) {
+		return nil, fmt.Errorf("Number of transactions out of range [1-%d]: %v", MaxPowBatchSize, len(trytes))
+	}
+
+	var flags byte
+	if reversed {
+		flags |= ipc.PowBatchFlagReversed
+	}
+
+	data := []byte{byte(minWeightMagnitude), flags}
+	for _, transaction := range trytes {
+		if len(transaction) != transactionTrytesSize {
+			return nil, fmt.Errorf("%w: length is not %d trytes: %d", ErrInvalidTrytes, transactionTrytesSize, len(transaction))
+		}
+		data = append(data, []byte(string(transaction))...)
+	}
+
+	response, err := p.sendIpcFrameToServer(ctx, ipc.CmdPowFuncBatch, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(response) != len(trytes)*transactionTrytesSize {
+		return nil, fmt.Errorf("Wrong length of the batch response: %d", len(response))
+	}
+
+	for i := 0; i < len(response); i += transactionTrytesSize {
+		transaction, err := giota.ToTrytes(string(response[i:(i + transactionTrytesSize)]))
+		if err != nil {
+			return nil, err
+		}
+		if p.VerifyResults {
+			err = verifyNonce(transaction, transaction[transactionTrytesSize-nonceTrytesSize:], minWeightMagnitude)
+			if err != nil {
+				return nil, err
+			}
+		}
+		result = append(result, transaction)
+	}
+
+	return result, nil
 }
@@ -1,10 +1,24 @@
 package powsrv
 
 import (
+	"context"
+	"errors"
+	"io/ioutil"
 	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/iotaledger/giota"
+	"github.com/spf13/viper"
+
+	"github.com/muxxer/powsrv/ipc"
+	"github.com/muxxer/powsrv/testsrv"
 )
 
 const (
@@ -12,11 +26,16 @@ const (
 	MWM         int = 14
 )
 
-var socketPath = "/tmp/powSrv.sock"
 var transaction = "999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999A9RGRKVGWMWMKOLVMDFWJUHNUNYWZTJADGGPZGXNLERLXYWJE9WQHWWBMCPZMVVMJUMWWBLZLNMLDCGDJ999999999999999999999999999999999999999999999999999999YGYQIVD99999999999999999999TXEFLKNPJRBYZPORHZU9CEMFIFVVQBUSTDGSJCZMBTZCDTTJVUFPTCCVHHORPMGCURKTH9VGJIXUQJVHK999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999999"
 
-func TestPOW(t *testing.T) {
-	powClient := &PowClient{PowSrvPath: socketPath, WriteTimeOutMs: 500, ReadTimeOutMs: 5000}
+// testPOW sends the given number of POW requests with random trytes
+func testPOW(t *testing.T, powClient *PowClient, requests int) {
+	err := powClient.Init()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer powClient.Close()
 
 	serverVersion, powType, powVersion, err := powClient.GetPowInfo()
 	if err != nil {
@@ -29,7 +48,7 @@ func TestPOW(t *testing.T) {
 	// test transaction data
 	randomTrytes := make([]rune, 256)
 
-	for i := 0; i < 10000; i++ {
+	for i := 0; i < requests; i++ {
 		for j := 0; j < 256; j++ {
 			randomTrytes[j] = rune(TRYTE_CHARS[rand.Intn(len(TRYTE_CHARS))])
 		}
@@ -48,3 +67,649 @@ func TestPOW(t *testing.T) {
 		t.Logf("Client received: %v", response)
 	}
 }
+
+// startTestServer starts a powSrv on a temporary Unix socket that returns the trytes unchanged as POW result
+// If dropFirstConnection is set, the first connection is closed as soon as the first request was received
+func startTestServer(t testing.TB, dropFirstConnection bool) (path string, cleanup func()) {
+	dir, err := ioutil.TempDir("", "powsrv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path = filepath.Join(dir, "powSrv.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	config := viper.New()
+	config.Set("pow.maxMinWeightMagnitude", 243)
+	config.Set("pow.healthCheckTimeoutMs", 200)
+	config.Set("server.progressIntervalMs", 50)
+
+	SetPowDevices([]*PowDevice{{PowType: "test", PowFunc: func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return trytes, nil
+	}}})
+
+	go func() {
+		drop := dropFirstConnection
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			if drop {
+				drop = false
+				c.Read(make([]byte, 3072))
+				c.Close()
+				continue
+			}
+
+			go HandleClientConnection(c, config)
+		}
+	}()
+
+	return path, func() {
+		ln.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// cancelTestClient returns a client of a server with a single device that blocks until release is closed
+// The nonce of the device is the beginning of the transaction, so a result can be matched to its request.
+func cancelTestClient(calls *int32, release chan struct{}) *PowClient {
+	config := viper.New()
+	config.Set("pow.maxMinWeightMagnitude", 243)
+	powClient := newPipeClient(config)
+
+	SetPowDevices([]*PowDevice{{PowType: "test", PowFunc: func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		atomic.AddInt32(calls, 1)
+		<-release
+		return trytes[:nonceTrytesSize], nil
+	}}})
+	return powClient
+}
+
+// pendingRequests returns the number of requests of the client that wait for a response
+func pendingRequests(powClient *PowClient) int {
+	powClient.pendingMutex.Lock()
+	defer powClient.pendingMutex.Unlock()
+	return len(powClient.pending)
+}
+
+func TestPowFuncCancelQueued(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	powClient := cancelTestClient(&calls, release)
+	defer powClient.Close()
+
+	data, err := giota.ToTrytes(transaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first request blocks the device, the second one is queued
+	running := make(chan error, 1)
+	go func() {
+		_, err := powClient.PowFunc(data, MWM)
+		running <- err
+	}()
+	for atomic.LoadInt32(&calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	queued := make(chan error, 1)
+	go func() {
+		_, err := powClient.PowFuncWithContext(ctx, data, MWM)
+		queued <- err
+	}()
+	waitForQueueLength(t, powClient, 1)
+
+	// The server dequeues the cancelled request and the client frees its ReqID
+	cancel()
+	if err := <-queued; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	waitForQueueLength(t, powClient, 0)
+	if n := pendingRequests(powClient); n != 1 {
+		t.Errorf("Expected only the running request to be pending, got %d", n)
+	}
+
+	close(release)
+	if err := <-running; err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("Expected 1 PoW operation, the cancelled request was done too: %d", n)
+	}
+}
+
+func TestPowFuncCancelRunning(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	powClient := cancelTestClient(&calls, release)
+	defer powClient.Close()
+
+	cancelled, err := giota.ToTrytes(transaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := giota.ToTrytes("A" + transaction[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() {
+		_, err := powClient.PowFuncWithContext(ctx, cancelled, MWM)
+		result <- err
+	}()
+	for atomic.LoadInt32(&calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The running POW can't be aborted, but the ReqID is freed at once
+	cancel()
+	if err := <-result; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if n := pendingRequests(powClient); n != 0 {
+		t.Errorf("Expected no pending request, got %d", n)
+	}
+
+	// The next request reuses the ReqID of the cancelled one
+	powClient.pendingMutex.Lock()
+	powClient.reqID--
+	powClient.pendingMutex.Unlock()
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+	}()
+	nonce, err := powClient.PowFunc(next, MWM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nonce != next[:nonceTrytesSize] {
+		t.Errorf("Result of the cancelled request was delivered to the reused ReqID: %v", nonce)
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("Expected 2 PoW operations, got %d", n)
+	}
+}
+
+func TestPowFuncPendingReqID(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	powClient := cancelTestClient(&calls, release)
+	defer powClient.Close()
+
+	data, err := giota.ToTrytes(transaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := powClient.PowFunc(data, MWM)
+		result <- err
+	}()
+	for atomic.LoadInt32(&calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// A second request with the ReqID of the running one is rejected, the answer goes to the pending request
+	powClient.pendingMutex.Lock()
+	c, reqID := powClient.connection, powClient.reqID
+	powClient.pendingMutex.Unlock()
+	err = powClient.sendToServer(c, reqID, ipc.CmdPowFunc, append([]byte{byte(MWM)}, []byte(string(data))...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var serverErr *ServerError
+	if err := <-result; !errors.As(err, &serverErr) || (serverErr.Code != ipc.ErrorInvalidRequest) {
+		t.Errorf("Expected INVALID_REQUEST, got %v", err)
+	}
+	close(release)
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("Expected 1 PoW operation, got %d", n)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	path, cleanup := startTestServer(t, true)
+	defer cleanup()
+
+	powClient := &PowClient{PowSrvPath: path, WriteTimeOutMs: 500, ReadTimeOutMs: 5000, ReconnectAttempts: 5, ReconnectIntervalMs: 10}
+	err := powClient.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer powClient.Close()
+
+	data, err := giota.ToTrytes(transaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first connection was dropped during the frame version negotiation of Init
+	response, err := powClient.PowFunc(data, MWM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response != data {
+		t.Errorf("Wrong response after reconnect: %v", response)
+	}
+}
+
+func TestConcurrentRequestIDs(t *testing.T) {
+	const concurrentRequests = 300
+
+	path, cleanup := startTestServer(t, false)
+	defer cleanup()
+
+	powClient := &PowClient{PowSrvPath: path, WriteTimeOutMs: 500, ReadTimeOutMs: 10000}
+	err := powClient.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer powClient.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrentRequests; i++ {
+		randomTrytes := make([]rune, 256)
+		for j := 0; j < 256; j++ {
+			randomTrytes[j] = rune(TRYTE_CHARS[rand.Intn(len(TRYTE_CHARS))])
+		}
+
+		data, err := giota.ToTrytes(string(randomTrytes) + transaction[256:])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		wg.Add(1)
+		go func(data giota.Trytes) {
+			defer wg.Done()
+
+			response, err := powClient.PowFunc(data, MWM)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			if response != data {
+				t.Error("Response does not match the request")
+			}
+		}(data)
+	}
+	wg.Wait()
+}
+
+func testPowBatch(t *testing.T, powClient *PowClient, reversed bool) {
+	const nonce = "NONCE9999999999999999999999"
+
+	var bundle []giota.Trytes
+	for i := 0; i < 3; i++ {
+		data, err := giota.ToTrytes(string(TRYTE_CHARS[i+1]) + transaction[1:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		bundle = append(bundle, data)
+	}
+
+	result, err := powClient.PowBatch(bundle, MWM, reversed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != len(bundle) {
+		t.Fatalf("Wrong number of transactions: %d", len(result))
+	}
+
+	var previous *giota.Transaction
+	for step := range result {
+		index := step
+		if reversed {
+			index = len(result) - 1 - step
+		}
+
+		tx, err := giota.NewTransaction(result[index])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tx.Nonce != nonce {
+			t.Errorf("Wrong nonce of transaction %d: %v", index, tx.Nonce)
+		}
+
+		if previous != nil && tx.TrunkTransaction != previous.Hash() {
+			t.Errorf("Transaction %d does not reference the previous transaction", index)
+		}
+		previous = tx
+	}
+}
+
+func TestPowBatch(t *testing.T) {
+	path, cleanup := startTestServer(t, false)
+	defer cleanup()
+
+	SetPowDevices([]*PowDevice{{PowType: "test", PowFunc: func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return "NONCE9999999999999999999999", nil
+	}}})
+
+	powClient := &PowClient{PowSrvPath: path, WriteTimeOutMs: 500, ReadTimeOutMs: 5000}
+	err := powClient.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer powClient.Close()
+
+	testPowBatch(t, powClient, false)
+	testPowBatch(t, powClient, true)
+
+	// The largest batch fits into a single frame
+	bundle := make([]giota.Trytes, MaxPowBatchSize)
+	for i := range bundle {
+		bundle[i] = giota.Trytes(transaction)
+	}
+	_, err = powClient.PowBatch(bundle, MWM, false)
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = powClient.PowBatch(append(bundle, giota.Trytes(transaction)), MWM, false)
+	if err == nil {
+		t.Error("Batch with too many transactions succeeded")
+	}
+}
+
+func TestProgressNotifications(t *testing.T) {
+	path, cleanup := startTestServer(t, false)
+	defer cleanup()
+
+	SetPowDevices([]*PowDevice{{PowType: "test", PowFunc: func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		time.Sleep(500 * time.Millisecond)
+		return trytes, nil
+	}}})
+
+	var progressCount int32
+	powClient := &PowClient{PowSrvPath: path, WriteTimeOutMs: 500, ReadTimeOutMs: 200, OnProgress: func(reqID byte, elapsed time.Duration) {
+		atomic.AddInt32(&progressCount, 1)
+	}}
+	err := powClient.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer powClient.Close()
+
+	data, err := giota.ToTrytes(transaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The POW takes longer than the read timeout, but the server is not silent
+	_, err = powClient.PowFunc(data, MWM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if atomic.LoadInt32(&progressCount) == 0 {
+		t.Error("OnProgress was not called")
+	}
+}
+
+func TestFrameVersionNegotiation(t *testing.T) {
+	path, cleanup := startTestServer(t, false)
+	defer cleanup()
+
+	powClient := &PowClient{PowSrvPath: path, WriteTimeOutMs: 500, ReadTimeOutMs: 5000}
+	err := powClient.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer powClient.Close()
+
+	writer := powClient.connection.writer
+	if writer.Version() != ipc.Version2 {
+		t.Errorf("IpcFrameV2 not negotiated")
+	}
+
+	data, err := giota.ToTrytes(transaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Clients without IpcFrameV2 support use the same server
+	for _, version := range []byte{ipc.Version2, ipc.Version1} {
+		writer.SetVersion(version)
+		response, err := powClient.PowFunc(data, MWM)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response != data {
+			t.Errorf("Wrong response with frame version %d", version)
+		}
+	}
+}
+
+func TestFrameVersionFallback(t *testing.T) {
+	// Server that only knows IpcFrameV1 and no CmdGetFrameVersions
+	client, server := net.Pipe()
+	defer server.Close()
+
+	go func() {
+		reader := ipc.NewFrameReader(server)
+		writer := ipc.NewFrameWriter(server, ipc.Version1)
+		for {
+			frame, err := reader.ReadFrame()
+			if _, ok := err.(*ipc.FrameError); ok {
+				continue
+			}
+			if err != nil {
+				return
+			}
+			if frame.Version != ipc.Version1 {
+				continue
+			}
+
+			// Old servers don't know the newer commands
+			command := byte(ipc.CmdResponse)
+			if (frame.Command == ipc.CmdGetFrameVersions) || (frame.Command == ipc.CmdGetInfo) {
+				command = ipc.CmdError
+			}
+			writer.WriteFrame(frame.ReqID, command, []byte(powSrvVersion))
+		}
+	}()
+
+	c := newServerConnection(client)
+	powClient := &PowClient{ReadTimeOutMs: 5000}
+	powClient.pending = make(map[byte]*pendingRequest)
+	powClient.pendingSlots = make(chan struct{}, 256)
+	powClient.connection = c
+	go powClient.receive(c)
+	defer powClient.Close()
+
+	powClient.negotiateFrameVersion(c)
+	if c.writer.Version() != ipc.Version1 {
+		t.Fatal("IpcFrameV2 used with a server that doesn't support it")
+	}
+
+	serverVersion, _, _, err := powClient.GetPowInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serverVersion != powSrvVersion {
+		t.Errorf("Wrong server version: %v", serverVersion)
+	}
+}
+
+// TestPOWMock runs the POW test against the mock server
+func TestPOWMock(t *testing.T) {
+	server := testsrv.StartMockServer(t, testsrv.Options{})
+	testPOW(t, &PowClient{Network: server.Network(), Address: server.Address(), WriteTimeOutMs: 500, ReadTimeOutMs: 5000}, 100)
+}
+
+func TestMockServerFaults(t *testing.T) {
+	data, err := giota.ToTrytes(transaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		opts  testsrv.Options
+		check func(err error) bool
+	}{
+		{"No fault", testsrv.Options{}, func(err error) bool { return err == nil }},
+		{"Delayed response", testsrv.Options{Delay: 500 * time.Millisecond}, func(err error) bool { return errors.Is(err, ErrTimeout) }},
+		{"Corrupted CRC", testsrv.Options{CorruptCRCEvery: 1}, func(err error) bool {
+			var frameErr *ipc.FrameError
+			return errors.As(err, &frameErr)
+		}},
+		{"Dropped connection", testsrv.Options{DropAfter: 1}, func(err error) bool {
+			return errors.Is(err, ErrConnectionLost) || errors.Is(err, ErrNotConnected)
+		}},
+		{"Error frame", testsrv.Options{PowError: ipc.ErrorQueueFull}, func(err error) bool { return errors.Is(err, ErrServerBusy) }},
+	}
+
+	for _, test := range tests {
+		server := testsrv.StartMockServer(t, test.opts)
+
+		// The faults also hit the frame version negotiation of Init, so the connection is set up manually
+		conn, err := net.Dial(server.Network(), server.Address())
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := newServerConnection(conn)
+		powClient := &PowClient{WriteTimeOutMs: 500, ReadTimeOutMs: 200}
+		powClient.pending = make(map[byte]*pendingRequest)
+		powClient.pendingSlots = make(chan struct{}, 256)
+		powClient.connection = c
+		go powClient.receive(c)
+
+		response, err := powClient.PowFunc(data, MWM)
+		if !test.check(err) {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+		if (err == nil) && (response != testsrv.DefaultNonce) {
+			t.Errorf("%s: wrong response: %v", test.name, response)
+		}
+		powClient.Close()
+	}
+}
+
+func TestConcurrentClients(t *testing.T) {
+	const concurrentRequests = 200
+
+	// The nonce of every server is made of the request and the tryte of the server,
+	// so a response that was delivered to the wrong request or client is detected
+	serverTrytes := []string{"A", "B"}
+	clients := make([]*PowClient, len(serverTrytes))
+	for i, serverTryte := range serverTrytes {
+		serverTryte := serverTryte
+		server := testsrv.StartMockServer(t, testsrv.Options{PowFunc: func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+			time.Sleep(time.Duration(rand.Intn(5)) * time.Millisecond)
+			return trytes[:26] + giota.Trytes(serverTryte), nil
+		}})
+
+		clients[i] = &PowClient{Network: server.Network(), Address: server.Address(), WriteTimeOutMs: 500, ReadTimeOutMs: 10000}
+		err := clients[i].Init()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer clients[i].Close()
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrentRequests; i++ {
+		randomTrytes := make([]rune, 256)
+		for j := 0; j < 256; j++ {
+			randomTrytes[j] = rune(TRYTE_CHARS[rand.Intn(len(TRYTE_CHARS))])
+		}
+
+		data, err := giota.ToTrytes(string(randomTrytes) + transaction[256:])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for c, client := range clients {
+			expected := data[:26] + giota.Trytes(serverTrytes[c])
+
+			wg.Add(1)
+			go func(client *PowClient, data giota.Trytes, expected giota.Trytes) {
+				defer wg.Done()
+
+				response, err := client.PowFunc(data, MWM)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				if response != expected {
+					t.Error("Response does not match the request and server")
+				}
+			}(client, data, expected)
+		}
+	}
+	wg.Wait()
+
+	for c, client := range clients {
+		if client.UnmatchedResponses() != 0 {
+			t.Errorf("Client %d: unexpected unmatched responses: %d", c, client.UnmatchedResponses())
+		}
+	}
+}
+
+func TestGetDevices(t *testing.T) {
+	config := viper.New()
+	config.Set("pow.maxMinWeightMagnitude", 243)
+	powClient := newPipeClient(config)
+	defer powClient.Close()
+
+	disabled := &PowDevice{Index: 1, PowType: "USBDiver", PowVersion: "unknown", disabled: 1}
+	SetPowDevices([]*PowDevice{{Index: 0, PowType: "PiDiver", PowVersion: "core=3 driver=pidiver", PowFunc: func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return fakeNonce, nil
+	}}, disabled})
+
+	data, _ := giota.ToTrytes(transaction)
+	if _, err := powClient.PowFunc(data, MWM); err != nil {
+		t.Fatal(err)
+	}
+
+	devices, err := powClient.GetDevices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []DeviceInfo{
+		{Index: 0, Type: "PiDiver", Version: "core=3 driver=pidiver", Enabled: true, TotalRequests: 1, AvgDurationMs: devices[0].AvgDurationMs},
+		{Index: 1, Type: "USBDiver", Version: "unknown"},
+	}
+	if !reflect.DeepEqual(devices, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, devices)
+	}
+
+	// The old commands return the same information
+	_, powType, powVersion, err := powClient.GetPowInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (powType != "[0] PiDiver, [1] USBDiver (disabled)") || (powVersion != "[0] core=3 driver=pidiver, [1] unknown") {
+		t.Errorf("Unexpected pow info: %q, %q", powType, powVersion)
+	}
+
+	// Servers without the command answer with an error
+	server := testsrv.StartMockServer(t, testsrv.Options{})
+	mockClient := &PowClient{Network: server.Network(), Address: server.Address(), WriteTimeOutMs: 500, ReadTimeOutMs: 5000}
+	if err := mockClient.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer mockClient.Close()
+	var serverErr *ServerError
+	if _, err := mockClient.GetDevices(); !errors.As(err, &serverErr) {
+		t.Errorf("Expected a ServerError of the mock server, got: %v", err)
+	}
+}
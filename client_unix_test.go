@@ -0,0 +1,134 @@
+//go:build unix
+
+package powsrv
+
+import (
+	"fmt"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/iotaledger/giota"
+
+	"github.com/muxxer/powsrv/ipc"
+)
+
+// pollInterval is the interval in which the former request path checked for its response
+const pollInterval = 1 * time.Millisecond
+
+// pollPowFunc is the former request path as the baseline of the benchmark,
+// the request polls for its response instead of waiting on the channel
+func pollPowFunc(p *PowClient, trytes giota.Trytes, minWeightMagnitude int) error {
+	request := &pendingRequest{response: make(chan ipcResponse, 1), progress: make(chan struct{}, 1)}
+
+	p.pendingMutex.Lock()
+	c := p.connection
+	p.reqID++
+	for _, exists := p.pending[p.reqID]; exists; _, exists = p.pending[p.reqID] {
+		p.reqID++
+	}
+	reqID := p.reqID
+	request.conn = c
+	p.pending[reqID] = request
+	p.pendingMutex.Unlock()
+
+	data := append([]byte{byte(minWeightMagnitude)}, []byte(string(trytes))...)
+	err := p.sendToServer(c, reqID, ipc.CmdPowFunc, data)
+	if err != nil {
+		return err
+	}
+
+	for {
+		p.pendingMutex.Lock()
+		var resp *ipcResponse
+		select {
+		case response := <-request.response:
+			resp = &response
+		default:
+		}
+		p.pendingMutex.Unlock()
+
+		if resp != nil {
+			if resp.err != nil {
+				return resp.err
+			}
+			if resp.frame.Command != ipc.CmdResponse {
+				return fmt.Errorf("Unexpected command %X: %s", resp.frame.Command, resp.frame.Data)
+			}
+			return nil
+		}
+
+		time.Sleep(pollInterval)
+	}
+}
+
+// BenchmarkPowFuncConcurrent measures the CPU time of 8 concurrent PowFunc calls (client and in-process server)
+// The "polling" case is the former request path, so both can be compared with benchstat
+func BenchmarkPowFuncConcurrent(b *testing.B) {
+	b.Run("channels", func(b *testing.B) {
+		benchmarkPowFuncConcurrent(b, func(p *PowClient, trytes giota.Trytes) error {
+			_, err := p.PowFunc(trytes, MWM)
+			return err
+		})
+	})
+	b.Run("polling", func(b *testing.B) {
+		benchmarkPowFuncConcurrent(b, func(p *PowClient, trytes giota.Trytes) error {
+			return pollPowFunc(p, trytes, MWM)
+		})
+	})
+}
+
+func benchmarkPowFuncConcurrent(b *testing.B, powFunc func(p *PowClient, trytes giota.Trytes) error) {
+	const concurrentRequests = 8
+
+	path, cleanup := startTestServer(b, false)
+	defer cleanup()
+
+	var devices []*PowDevice
+	for i := 0; i < concurrentRequests; i++ {
+		devices = append(devices, &PowDevice{Index: i, PowType: "test", PowFunc: func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+			time.Sleep(time.Millisecond)
+			return trytes, nil
+		}})
+	}
+	SetPowDevices(devices)
+
+	powClient := &PowClient{PowSrvPath: path, WriteTimeOutMs: 500, ReadTimeOutMs: 5000}
+	err := powClient.Init()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer powClient.Close()
+
+	data, err := giota.ToTrytes(transaction)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	var usageStart, usageEnd syscall.Rusage
+	syscall.Getrusage(syscall.RUSAGE_SELF, &usageStart)
+	b.ResetTimer()
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrentRequests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < b.N; j++ {
+				err := powFunc(powClient, data)
+				if err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	b.StopTimer()
+	syscall.Getrusage(syscall.RUSAGE_SELF, &usageEnd)
+
+	cpuTime := time.Duration(usageEnd.Utime.Nano()+usageEnd.Stime.Nano()) - time.Duration(usageStart.Utime.Nano()+usageStart.Stime.Nano())
+	b.ReportMetric(float64(cpuTime.Nanoseconds())/float64(b.N), "cpu-ns/op")
+}
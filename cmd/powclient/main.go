@@ -0,0 +1,288 @@
+/*
+Command powclient sends requests to a running powSrv from the shell.
+
+	powclient info                                 Server version, POW types and versions and the state of the devices
+	powclient stats                                Statistics of the server as JSON
+	powclient pow --mwm 14 --trytes-file tx.trytes Transaction trytes with the nonce (trytes are read from stdin without --trytes-file)
+	powclient bench -n 20                          Round-trip latency of pings, or of POW requests with --mwm
+	powclient discover                             powSrv instances on the LAN that answer the discovery ("server.discoveryPort")
+
+All commands accept --network, --address, --auth-token and --device, like the fields of powsrv.PowClient.
+With --trace, every IPC frame that is sent or received is logged to stderr.
+Errors are printed to stderr and end the command with exit code 1.
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/iotaledger/giota"
+	flag "github.com/spf13/pflag"
+
+	"github.com/muxxer/powsrv"
+	"github.com/muxxer/powsrv/ipc"
+	"github.com/muxxer/powsrv/logs"
+)
+
+const (
+	transactionTrytesSize = 2673
+	nonceTrytesSize       = 27
+)
+
+const usage = `Usage: powclient <command> [flags]
+
+Commands:
+  info      Print the server version and the POW types and versions of the devices
+  stats     Print the statistics of the server as JSON
+  pow       Do the POW of a transaction and print the trytes with the nonce
+  bench     Measure the round-trip latency through the socket
+  discover  List the powSrv instances on the LAN
+
+Run "powclient <command> --help" for the flags of a command.
+`
+
+// command is a subcommand with its flags
+type command struct {
+	flags     *flag.FlagSet
+	run       func(powClient *powsrv.PowClient) error
+	noConnect bool // The command doesn't use the connection to a powSrv, powClient is not initialized
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	// Flags of all commands
+	clientFlags := flag.NewFlagSet("client", flag.ContinueOnError)
+	network := clientFlags.String("network", powsrv.DefaultNetwork, "Network of the powSrv: 'unix', 'tcp' or 'pipe' (Windows)")
+	address := clientFlags.StringP("address", "a", powsrv.DefaultAddress, "Address of the powSrv, e.g. '/tmp/powSrv.sock' or '192.168.1.10:5000'")
+	authToken := clientFlags.String("auth-token", "", "Shared secret of the powSrv (default: $POWSRV_SERVER_AUTHTOKEN)")
+	timeoutMs := clientFlags.Int("timeout-ms", 60000, "Timeout in ms without any answer of the powSrv")
+	device := clientFlags.String("device", powsrv.DeviceClassAny, "Device that does the POW: 'any', 'fpga', 'cpu' or a device index")
+	trace := clientFlags.Bool("trace", false, "Log every IPC frame that is sent or received to stderr")
+
+	commands := map[string]*command{
+		"info":  {flags: flag.NewFlagSet("info", flag.ContinueOnError), run: info},
+		"stats": {flags: flag.NewFlagSet("stats", flag.ContinueOnError), run: stats},
+	}
+
+	powFlags := flag.NewFlagSet("pow", flag.ContinueOnError)
+	powMWM := powFlags.IntP("mwm", "m", 14, "Min-Weight-Magnitude of the POW")
+	trytesFile := powFlags.StringP("trytes-file", "f", "", "File with the transaction trytes (default: stdin)")
+	highPriority := powFlags.Bool("high-priority", false, "Start the POW before the queued normal requests of the powSrv")
+	commands["pow"] = &command{flags: powFlags, run: func(powClient *powsrv.PowClient) error {
+		return pow(powClient, *trytesFile, *powMWM, *highPriority)
+	}}
+
+	benchFlags := flag.NewFlagSet("bench", flag.ContinueOnError)
+	benchRequests := benchFlags.IntP("requests", "n", 20, "Number of requests")
+	benchMWM := benchFlags.IntP("mwm", "m", 0, "Send POW requests of an empty transaction with this Min-Weight-Magnitude instead of pings (0 = pings)")
+	commands["bench"] = &command{flags: benchFlags, run: func(powClient *powsrv.PowClient) error {
+		return bench(powClient, *benchRequests, *benchMWM)
+	}}
+
+	discoverFlags := flag.NewFlagSet("discover", flag.ContinueOnError)
+	discoveryPort := discoverFlags.Int("discovery-port", powsrv.DefaultDiscoveryPort, "UDP port of the discovery (\"server.discoveryPort\" of the powSrv)")
+	discoveryTimeoutMs := discoverFlags.Int("wait-ms", 1000, "Time in ms to wait for the answers")
+	commands["discover"] = &command{flags: discoverFlags, noConnect: true, run: func(powClient *powsrv.PowClient) error {
+		return discover(*discoveryPort, powClient.AuthToken, time.Duration(*discoveryTimeoutMs)*time.Millisecond)
+	}}
+
+	cmd, exists := commands[os.Args[1]]
+	if !exists {
+		if (os.Args[1] != "help") && (os.Args[1] != "--help") && (os.Args[1] != "-h") {
+			fmt.Fprintf(os.Stderr, "Unknown command: %v\n\n", os.Args[1])
+		}
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	cmd.flags.AddFlagSet(clientFlags)
+	err := cmd.flags.Parse(os.Args[2:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		os.Exit(2)
+	}
+
+	if *authToken == "" {
+		*authToken = os.Getenv("POWSRV_SERVER_AUTHTOKEN")
+	}
+	if *trace {
+		logs.SetLogLevel(logs.LevelTrace)
+	}
+
+	powClient := &powsrv.PowClient{Network: *network, Address: *address, AuthToken: *authToken, PreferredDevice: *device, WriteTimeOutMs: 5000, ReadTimeOutMs: *timeoutMs}
+	powClient.OnServerNotification = func(message string) {
+		fmt.Fprintf(os.Stderr, "powSrv: %v\n", message)
+	}
+	if cmd.noConnect {
+		err = cmd.run(powClient)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	err = powClient.Init()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "powSrv not reachable: %v\n", err)
+		os.Exit(1)
+	}
+
+	err = cmd.run(powClient)
+	powClient.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// info prints the server version and the POW types and versions, one "key: value" line each
+// Servers with the device list add a "device: <JSON>" line for every device.
+func info(powClient *powsrv.PowClient) error {
+	serverVersion, powType, powVersion, err := powClient.GetPowInfo()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("serverVersion: %s\n", serverVersion)
+	fmt.Printf("powType: %s\n", powType)
+	fmt.Printf("powVersion: %s\n", powVersion)
+
+	devices, err := powClient.GetDevices()
+	var serverErr *powsrv.ServerError
+	if errors.As(err, &serverErr) {
+		// Older servers don't know the command
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, device := range devices {
+		data, err := json.Marshal(device)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("device: %s\n", data)
+	}
+	return nil
+}
+
+// stats prints the statistics of the server as indented JSON
+func stats(powClient *powsrv.PowClient) error {
+	stats, err := powClient.GetServerStats()
+	if err != nil {
+		return err
+	}
+
+	result, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(result))
+	return nil
+}
+
+// pow does the POW of the transaction in the file, or on stdin, and prints the trytes with the nonce
+func pow(powClient *powsrv.PowClient, trytesFile string, mwm int, highPriority bool) error {
+	var data []byte
+	var err error
+	if trytesFile != "" {
+		data, err = ioutil.ReadFile(trytesFile)
+	} else {
+		data, err = ioutil.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		return err
+	}
+
+	trytes, err := giota.ToTrytes(strings.TrimSpace(string(data)))
+	if err != nil {
+		return fmt.Errorf("Invalid trytes: %v", err)
+	}
+	if len(trytes) != transactionTrytesSize {
+		return fmt.Errorf("Invalid transaction size: %d trytes instead of %d", len(trytes), transactionTrytesSize)
+	}
+
+	priority := byte(ipc.PriorityNormal)
+	if highPriority {
+		priority = ipc.PriorityHigh
+	}
+	nonce, err := powClient.PowFuncPriority(trytes, mwm, priority)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(trytes[:transactionTrytesSize-nonceTrytesSize] + nonce)
+	return nil
+}
+
+// discover prints the powSrv instances that answered the discovery, one "address (version): POW types" line each
+func discover(port int, authToken string, timeout time.Duration) error {
+	endpoints, err := powsrv.DiscoverOnPort(port, authToken, timeout)
+	if err != nil {
+		return err
+	}
+	if len(endpoints) == 0 {
+		return errors.New("No powSrv found")
+	}
+
+	for _, endpoint := range endpoints {
+		encryption := ""
+		if endpoint.TLS {
+			encryption = ", TLS"
+		}
+		fmt.Printf("%s (%s%s, %s): %s\n", endpoint.Address, endpoint.Network, encryption, endpoint.ServerVersion, endpoint.PowTypes)
+	}
+	return nil
+}
+
+// bench sends the requests one after the other and prints the round-trip latencies in a single "key=value" line
+func bench(powClient *powsrv.PowClient, requests int, mwm int) error {
+	if requests < 1 {
+		return fmt.Errorf("Invalid number of requests: %d", requests)
+	}
+
+	emptyTransaction := giota.Trytes(strings.Repeat("9", transactionTrytesSize))
+
+	durations := make([]time.Duration, 0, requests)
+	for i := 0; i < requests; i++ {
+		ts := time.Now()
+		var err error
+		if mwm > 0 {
+			_, err = powClient.PowFunc(emptyTransaction, mwm)
+		} else {
+			err = powClient.Ping()
+		}
+		if err != nil {
+			return err
+		}
+		durations = append(durations, time.Since(ts))
+	}
+
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	var sum time.Duration
+	for _, duration := range durations {
+		sum += duration
+	}
+
+	ms := func(duration time.Duration) float64 {
+		return float64(duration) / float64(time.Millisecond)
+	}
+	fmt.Printf("requests=%d min=%.3fms avg=%.3fms p95=%.3fms max=%.3fms\n", requests,
+		ms(durations[0]), ms(sum/time.Duration(requests)), ms(durations[(requests*95+99)/100-1]), ms(durations[requests-1]))
+	return nil
+}
@@ -0,0 +1,225 @@
+package powsrv
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/muxxer/powsrv/logs"
+)
+
+/*
+	Discovery of powSrv instances on the LAN
+	========================================
+
+	Clients broadcast a probe to the UDP port "server.discoveryPort", every powSrv answers with its endpoint.
+
+	Probe (discoveryPacketSize bytes):
+		[0..7]		"POWSRV?"
+		[7]		Version of the discovery (1)
+		[8..24]		Random nonce of the client
+		[24..56]	HMAC-SHA256 of the nonce with "server.authToken" as key (zeros if the server has no token)
+		[56..]		Zero padding
+
+	Response (at most discoveryPacketSize bytes):
+		[0..7]		"POWSRV!"
+		[7]		Version of the discovery (1)
+		[8..24]		Nonce of the probe
+		[24..]		JSON	powsrv.Endpoint
+
+	Probes with a wrong size or HMAC are ignored. The responses are never bigger than the probes and rate-limited,
+	so the responder can't be used to amplify traffic.
+*/
+
+// DefaultDiscoveryPort is the UDP port of the discovery, if "server.discoveryPort" is enabled
+const DefaultDiscoveryPort = 5001
+
+const (
+	discoveryVersion            = 1
+	discoveryPacketSize         = 512
+	discoveryNonceSize          = 16
+	discoveryHeaderSize         = 8 + discoveryNonceSize
+	discoveryResponsesPerMinute = 120 // Answered probes of all clients
+)
+
+var discoveryProbeMagic = []byte("POWSRV?")
+var discoveryResponseMagic = []byte("POWSRV!")
+
+// Endpoint is a powSrv that answered the discovery
+type Endpoint struct {
+	Network       string `json:"network"`       // Network of the listener, "tcp"
+	Address       string `json:"address"`       // Address of the listener, e.g. "192.168.1.10:5000"
+	TLS           bool   `json:"tls"`           // The listener requires TLS
+	ServerVersion string `json:"serverVersion"` // Version of the powSrv
+	PowTypes      string `json:"powTypes"`      // Types of all devices, e.g. "[0] PiDiver, [1] gIOTA-PowC"
+}
+
+// ServeDiscovery answers the discovery probes on the connection with the endpoint until the connection is closed
+// Network, Address and TLS of the endpoint describe the listener of the server, an address without a host
+// (e.g. ":5000") is completed by the client with the source address of the response.
+// If authToken is set, only probes that are signed with it are answered.
+func ServeDiscovery(conn net.PacketConn, endpoint Endpoint, authToken string) error {
+	limiter := newRateLimiter(discoveryResponsesPerMinute)
+	probe := make([]byte, discoveryPacketSize+1)
+
+	for {
+		n, addr, err := conn.ReadFrom(probe)
+		if err != nil {
+			return err
+		}
+
+		nonce, ok := parseDiscoveryProbe(probe[:n], authToken)
+		if !ok {
+			logs.Log.Debugf("Invalid discovery probe of %v", addr)
+			continue
+		}
+
+		if allowed, _ := limiter.allow(); !allowed {
+			logs.Log.Debugf("Discovery probe of %v dropped, rate limit exceeded", addr)
+			continue
+		}
+
+		endpoint.ServerVersion = powSrvVersion
+		endpoint.PowTypes = getDispatcher().powTypes()
+		response, err := discoveryResponse(nonce, endpoint)
+		if err != nil {
+			logs.Log.Warningf("Discovery response could not be created: %v", err)
+			continue
+		}
+		conn.WriteTo(response, addr)
+	}
+}
+
+// parseDiscoveryProbe returns the nonce of a valid probe
+func parseDiscoveryProbe(probe []byte, authToken string) ([]byte, bool) {
+	if (len(probe) != discoveryPacketSize) || !bytes.HasPrefix(probe, discoveryProbeMagic) || (probe[7] != discoveryVersion) {
+		return nil, false
+	}
+
+	nonce := probe[8:discoveryHeaderSize]
+	if authToken == "" {
+		return nonce, true
+	}
+	mac := probe[discoveryHeaderSize : discoveryHeaderSize+32]
+	return nonce, hmac.Equal(mac, authHMAC(authToken, nonce))
+}
+
+// discoveryResponse returns the response to the probe with the nonce
+// The POW types are shortened if the response would be bigger than the probe.
+func discoveryResponse(nonce []byte, endpoint Endpoint) ([]byte, error) {
+	for {
+		data, err := json.Marshal(endpoint)
+		if err != nil {
+			return nil, err
+		}
+
+		response := append(append(append([]byte{}, discoveryResponseMagic...), discoveryVersion), nonce...)
+		response = append(response, data...)
+		if len(response) <= discoveryPacketSize {
+			return response, nil
+		}
+
+		if endpoint.PowTypes == "" {
+			return nil, errors.New("Discovery response too big")
+		}
+		endpoint.PowTypes = endpoint.PowTypes[:len(endpoint.PowTypes)/2]
+	}
+}
+
+// newDiscoveryProbe returns a probe with a random nonce
+func newDiscoveryProbe(authToken string) (probe []byte, nonce []byte, err error) {
+	nonce = make([]byte, discoveryNonceSize)
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	probe = make([]byte, discoveryPacketSize)
+	copy(probe, discoveryProbeMagic)
+	probe[7] = discoveryVersion
+	copy(probe[8:], nonce)
+	if authToken != "" {
+		copy(probe[discoveryHeaderSize:], authHMAC(authToken, nonce))
+	}
+	return probe, nonce, nil
+}
+
+// Discover broadcasts a probe to DefaultDiscoveryPort and returns the powSrv instances that answered within the timeout
+func Discover(timeout time.Duration) ([]Endpoint, error) {
+	return DiscoverOnPort(DefaultDiscoveryPort, "", timeout)
+}
+
+// DiscoverOnPort broadcasts a probe to the UDP port and returns the powSrv instances that answered within the timeout
+// The authToken is needed for servers with "server.authToken".
+func DiscoverOnPort(port int, authToken string, timeout time.Duration) ([]Endpoint, error) {
+	return discover(&net.UDPAddr{IP: net.IPv4bcast, Port: port}, authToken, timeout)
+}
+
+// discover sends a probe to the address and collects the responses until the timeout expires
+func discover(addr *net.UDPAddr, authToken string, timeout time.Duration) ([]Endpoint, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	probe, nonce, err := newDiscoveryProbe(authToken)
+	if err != nil {
+		return nil, err
+	}
+	_, err = conn.WriteTo(probe, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	var endpoints []Endpoint
+	found := make(map[string]bool)
+	response := make([]byte, discoveryPacketSize)
+	for {
+		n, from, err := conn.ReadFrom(response)
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return endpoints, nil
+		}
+		if err != nil {
+			return endpoints, err
+		}
+
+		endpoint, ok := parseDiscoveryResponse(response[:n], nonce, from)
+		if !ok || found[endpoint.Address] {
+			continue
+		}
+		found[endpoint.Address] = true
+		endpoints = append(endpoints, endpoint)
+	}
+}
+
+// parseDiscoveryResponse returns the endpoint of a response to the probe with the nonce
+// An address without a host is completed with the host of the sender.
+func parseDiscoveryResponse(response []byte, nonce []byte, from net.Addr) (Endpoint, bool) {
+	var endpoint Endpoint
+	if (len(response) < discoveryHeaderSize) || !bytes.HasPrefix(response, discoveryResponseMagic) ||
+		(response[7] != discoveryVersion) || !bytes.Equal(response[8:discoveryHeaderSize], nonce) {
+		return endpoint, false
+	}
+
+	if json.Unmarshal(response[discoveryHeaderSize:], &endpoint) != nil {
+		return endpoint, false
+	}
+
+	host, port, err := net.SplitHostPort(endpoint.Address)
+	if err != nil {
+		return endpoint, false
+	}
+	if (host == "") || net.ParseIP(host).IsUnspecified() {
+		if udpAddr, ok := from.(*net.UDPAddr); ok {
+			endpoint.Address = net.JoinHostPort(udpAddr.IP.String(), port)
+		}
+	}
+	return endpoint, true
+}
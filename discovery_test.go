@@ -0,0 +1,78 @@
+package powsrv
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startDiscovery answers the probes on a local UDP port
+func startDiscovery(t *testing.T, authToken string) (*net.UDPAddr, func()) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ServeDiscovery(conn, Endpoint{Network: "tcp", Address: ":5000"}, authToken)
+	return conn.LocalAddr().(*net.UDPAddr), func() { conn.Close() }
+}
+
+func TestDiscover(t *testing.T) {
+	SetPowDevices([]*PowDevice{{PowType: "test"}})
+
+	addr, cleanup := startDiscovery(t, "")
+	defer cleanup()
+
+	endpoints, err := discover(addr, "", 500*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Endpoint{Network: "tcp", Address: "127.0.0.1:5000", ServerVersion: powSrvVersion, PowTypes: "[0] test"}
+	if (len(endpoints) != 1) || (endpoints[0] != expected) {
+		t.Errorf("Expected %+v, got %+v", expected, endpoints)
+	}
+}
+
+func TestDiscoverAuth(t *testing.T) {
+	addr, cleanup := startDiscovery(t, "secret")
+	defer cleanup()
+
+	for _, test := range []struct {
+		authToken string
+		found     int
+	}{
+		{"", 0},
+		{"wrong", 0},
+		{"secret", 1},
+	} {
+		endpoints, err := discover(addr, test.authToken, 300*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(endpoints) != test.found {
+			t.Errorf("Token %q: expected %d endpoints, got %+v", test.authToken, test.found, endpoints)
+		}
+	}
+}
+
+func TestDiscoveryAmplification(t *testing.T) {
+	// Probes that are smaller than the response are ignored
+	probe, nonce, err := newDiscoveryProbe("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := parseDiscoveryProbe(probe[:64], ""); ok {
+		t.Error("Short probe accepted")
+	}
+	if _, ok := parseDiscoveryProbe(probe, ""); !ok {
+		t.Error("Valid probe rejected")
+	}
+
+	response, err := discoveryResponse(nonce, Endpoint{Network: "tcp", Address: ":5000", PowTypes: strings.Repeat("[0] PiDiver, ", 100)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response) > len(probe) {
+		t.Errorf("Response of %d bytes is bigger than the probe", len(response))
+	}
+}
@@ -0,0 +1,1069 @@
+package powsrv
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/iotaledger/giota"
+
+	"github.com/muxxer/powsrv/ipc"
+	"github.com/muxxer/powsrv/logs"
+)
+
+// PowConfigDevice is the configuration of a single POW device
+type PowConfigDevice struct {
+	Type      string // Registered driver, e.g. 'pidiver', 'usbdiver', 'ftdiver', 'powsrv', 'giota' or 'giota-go' (see Drivers)
+	Core      string // Core/config file to upload to FPGA
+	Device    string // Device file for usb communication, or the address of the 'powsrv' type
+	Network   string // Network of the 'powsrv' type: 'unix' or 'tcp'
+	AuthToken string // Shared secret of the 'powsrv' type
+
+	ForceFlash     bool // Write the core to the flash of the 'pidiver', 'usbdiver' or 'ftdiver' even if it is up to date
+	ForceConfigure bool // Configure the FPGA of the 'pidiver', 'usbdiver' or 'ftdiver' with the core even if it is already configured
+
+	Workers     int  // Number of goroutines of a single POW of the 'giota' types (0 = giota default, NumCPU-1)
+	LowPriority bool // The powSrv process runs with the lowest priority, so the POW of the 'giota' types doesn't starve other processes (Linux only)
+}
+
+// PowDevice is a single POW implementation that is used by the dispatcher
+// Devices of the configuration are created with NewPowDevice by their registered PowDriver.
+type PowDevice struct {
+	Index      int           // Index of the device in the configuration
+	PowType    string        // Name of the used POW implementation (e.g. PiDiver)
+	PowVersion string        // Version of the used POW implementation (e.g. PiDiver FPGA Core Version)
+	PowClass   string        // DeviceClassFPGA or DeviceClassCPU, empty if the driver doesn't report it
+	PowFunc    giota.PowFunc // Function pointer for POW
+	PowMutex   *sync.Mutex   // Secures the hardware POW
+	CloseFunc  func() error  // Releases the device on shutdown (optional)
+	AbortFunc  func() error  // Stops the running POW after the job timeout (optional)
+	Algorithms []byte        // Supported ipc.Algorithm* IDs, nil = only ipc.AlgorithmCurlTransaction
+
+	busy     bool    // Device is currently doing POW (guarded by the dispatcher mutex)
+	job      *powJob // Job that is in progress on the device (guarded by the dispatcher mutex)
+	disabled int32   // Device is not used for POW, e.g. because the initialization failed
+	requests uint64  // Number of POW requests done by this device
+	errors   uint64  // Number of POW requests that failed on this device
+	// Nonces of this device whose transaction hash didn't reach the MWM, they are counted as errors too
+	verificationFailures uint64
+	lastError            int32 // Last POW request on this device failed (1) or succeeded (0)
+	// POW requests that failed on this device in a row, the device is suspect until its next successful POW
+	consecutiveErrors int32
+	durations         durationBuffer // Durations of the last POW requests
+	estimate          int64          // Moving average of the POW durations in ns, used by the scheduler
+	// Sequence number of the last job that was started on the device (guarded by the dispatcher mutex)
+	lastStarted uint64
+}
+
+// Requests returns the number of POW requests done by the device
+func (d *PowDevice) Requests() uint64 {
+	return atomic.LoadUint64(&d.requests)
+}
+
+// Disabled returns true if the device is not used for POW
+func (d *PowDevice) Disabled() bool {
+	return atomic.LoadInt32(&d.disabled) == 1
+}
+
+// release frees the hardware/connection of the device
+func (d *PowDevice) release() {
+	if d.CloseFunc == nil {
+		return
+	}
+
+	err := d.CloseFunc()
+	if err != nil {
+		logs.Log.Warningf("Device %v could not be released: %v", d, err)
+	}
+}
+
+// lastPowFailed returns true if the last POW request on this device failed
+func (d *PowDevice) lastPowFailed() bool {
+	return atomic.LoadInt32(&d.lastError) == 1
+}
+
+// String returns the index and the type of the device
+func (d *PowDevice) String() string {
+	return fmt.Sprintf("[%d] %s", d.Index, d.PowType)
+}
+
+// errQueueFull is returned if the maximum number of queued POW requests is reached
+var errQueueFull = &ServerError{Code: ipc.ErrorQueueFull}
+
+// powJob is a POW request that is queued in the dispatcher
+type powJob struct {
+	dispatcher *powDispatcher
+	owner      *jobOwner // nil for POW requests of the powSrv itself, they are not limited
+	trytes     giota.Trytes
+	mwm        int
+	priority   byte                                 // ipc.PriorityNormal or ipc.PriorityHigh
+	algorithm  byte                                 // ipc.AlgorithmCurlTransaction or another algorithm of the devices
+	selection  deviceSelection                      // Devices that may do the POW
+	queued     time.Time                            // Time the job was queued, used by the scheduler
+	log        *logs.Entry                          // Logs the lines of the request with its correlation ID
+	done       func(result giota.Trytes, err error) // Called by the worker as soon as the POW is finished
+
+	// Identical requests that are coalesced with this job (guarded by the dispatcher mutex)
+	key       powCacheKey
+	leader    *powJob   // Job that does the POW for this request, nil if the request has its own job
+	followers []*powJob // Requests that wait for the result of this job
+	cancelled bool      // The request of the job was cancelled, but the job is kept for its followers
+
+	failedOn []*PowDevice // Devices that failed the POW of the job, it is not started on them again (guarded by the dispatcher mutex)
+}
+
+// jobOwner is the client of POW requests
+// The workers take the jobs round-robin across the owners, so a client that queues a lot of requests can't starve the others.
+type jobOwner struct {
+	running    int32                // POW requests of the owner that are in progress
+	lastServed uint64               // Sequence number of the last job of the owner that was started, used for the round-robin
+	selection  atomic.Value         // deviceSelection of the following POW requests, set by ipc.CmdSelectDevice
+	address    string               // Remote address of the client, published with the POW events
+	notify     func(message string) // Sends an ipc.CmdNotification to the client, nil for clients without notifications
+	lastDevice atomic.Value         // *PowDevice that did the last successful POW of the owner
+}
+
+// powType returns the type of the device that did the last POW of the owner, e.g. "PiDiver"
+// Before the first POW, the types of all devices are returned.
+func (o *jobOwner) powType() string {
+	if device, ok := o.lastDevice.Load().(*PowDevice); ok {
+		return device.PowType
+	}
+	return getDispatcher().powTypes()
+}
+
+// powVersion returns the version of the device that did the last POW of the owner
+// Before the first POW, the versions of all devices are returned.
+func (o *jobOwner) powVersion() string {
+	if device, ok := o.lastDevice.Load().(*PowDevice); ok {
+		return device.PowVersion
+	}
+	return getDispatcher().powVersions()
+}
+
+// powDispatcher hands POW requests to the first idle device
+// Every device has its own worker. If all devices are busy, the requests are queued until a worker gets idle.
+type powDispatcher struct {
+	devices []*PowDevice
+	workers map[*PowDevice]bool // Devices with a running worker
+	queue   []*powJob
+	closed  bool
+	mutex   sync.Mutex
+	jobs    *sync.Cond // Signals new jobs to the workers
+	started uint64     // Number of started jobs, the sequence number of PowDevice.lastStarted
+
+	highPriorityStreak int // Jobs with ipc.PriorityHigh that were started in a row while jobs with ipc.PriorityNormal waited
+
+	// Queued and running jobs by their trytes and MWM, if identical requests are coalesced
+	pending map[powCacheKey]*powJob
+
+	wakeup   *time.Timer // Wakes up the workers for jobs that wait for a faster device
+	wakeupAt time.Time
+}
+
+var dispatcher = newPowDispatcher(nil)
+var dispatcherMutex = &sync.RWMutex{}
+var maxQueueDepth int32                  // 0 = unlimited
+var maxPendingPerClient int32            // 0 = unlimited
+var coalesceRequests int32               // Identical requests share a single POW (1) or not (0)
+var powFailover int32                    // Failed jobs are retried on another device (1) or not (0)
+var maxConsecutiveErrors int32           // Devices are disabled after this number of failed POW requests in a row (0 = never)
+var maxConsecutiveHighPriority int32 = 4 // High priority jobs that are started in a row while normal jobs wait (0 = unlimited)
+var jobsServed uint64                    // Sequence number of the started jobs of all dispatchers
+
+// PowObserver is called after every POW with the used device, the duration and the error of the POW
+type PowObserver func(device *PowDevice, duration time.Duration, err error)
+
+var powObservers []PowObserver
+var powObserversMutex = &sync.RWMutex{}
+
+func newPowDispatcher(devices []*PowDevice) *powDispatcher {
+	d := &powDispatcher{devices: devices, workers: make(map[*PowDevice]bool), pending: make(map[powCacheKey]*powJob)}
+	d.jobs = sync.NewCond(&d.mutex)
+
+	for _, device := range devices {
+		if !device.Disabled() {
+			d.startWorker(device)
+		}
+	}
+	return d
+}
+
+// SetPowDevices sets the devices that are used for POW
+// The jobs that are queued for the previous devices are still finished
+func SetPowDevices(devices []*PowDevice) {
+	for _, device := range devices {
+		if device.PowMutex == nil {
+			device.PowMutex = &sync.Mutex{}
+		}
+	}
+
+	dispatcherMutex.Lock()
+	previous := dispatcher
+	dispatcher = newPowDispatcher(devices)
+	dispatcherMutex.Unlock()
+
+	previous.stop()
+}
+
+// SetPowFunc sets the function pointer for POW (deprecated, use SetPowDevices)
+// All devices are replaced by a single device with the function.
+func SetPowFunc(f giota.PowFunc) {
+	if f == nil {
+		SetPowDevices(nil)
+		return
+	}
+	SetPowDevices([]*PowDevice{{PowFunc: f}})
+}
+
+// DisablePowDevice stops the usage of the device for POW
+// A POW that is in progress on the device is finished. Queued jobs that only the device could do fail.
+func DisablePowDevice(device *PowDevice) {
+	enabled := atomic.SwapInt32(&device.disabled, 1) == 0
+
+	// Let the worker of the device exit
+	d := getDispatcher()
+	d.mutex.Lock()
+	drained := func() {}
+	if enabled && d.contains(device) {
+		drained = d.drainDevice(device)
+	}
+	d.mutex.Unlock()
+	d.jobs.Broadcast()
+
+	drained()
+}
+
+// ReplacePowDevice replaces the device with the same index, e.g. after a disabled device was initialized again
+func ReplacePowDevice(device *PowDevice) {
+	d := getDispatcher()
+	devices := d.currentDevices()
+
+	updated := make([]*PowDevice, len(devices))
+	for i, previous := range devices {
+		updated[i] = previous
+		if previous.Index == device.Index {
+			updated[i] = device
+		}
+	}
+	d.setDevices(updated)
+}
+
+// UpdatePowDevices changes the devices that are used for POW without interrupting the devices that are kept
+// Removed devices finish their POW in progress and are released afterwards. Queued jobs are done by the remaining devices,
+// jobs that no remaining device can do fail.
+func UpdatePowDevices(devices []*PowDevice) {
+	getDispatcher().setDevices(devices)
+}
+
+// SetMaxQueueDepth sets the maximum number of POW requests that wait for an idle device (0 = unlimited)
+func SetMaxQueueDepth(depth int) {
+	atomic.StoreInt32(&maxQueueDepth, int32(depth))
+}
+
+// SetMaxPendingPerClient sets the maximum number of POW requests of a single client that are in progress at the same time (0 = unlimited)
+// Further requests of the client stay queued while requests of other clients are started.
+func SetMaxPendingPerClient(pending int) {
+	atomic.StoreInt32(&maxPendingPerClient, int32(pending))
+
+	// Let the workers start the jobs that were held back
+	d := getDispatcher()
+	d.mutex.Lock()
+	d.mutex.Unlock()
+	d.jobs.Broadcast()
+}
+
+// SetCoalesceRequests enables the coalescing of identical requests
+// A request with the same trytes and MWM as a queued or running request doesn't start another POW,
+// it gets the result of the first request instead.
+func SetCoalesceRequests(enabled bool) {
+	var value int32
+	if enabled {
+		value = 1
+	}
+	atomic.StoreInt32(&coalesceRequests, value)
+}
+
+// SetPowFailover enables the retry of failed POW requests on other devices
+// A request that failed on a device is queued again for the remaining devices, the client only gets an error
+// if all devices that may do its POW failed.
+func SetPowFailover(enabled bool) {
+	var value int32
+	if enabled {
+		value = 1
+	}
+	atomic.StoreInt32(&powFailover, value)
+}
+
+// SetMaxConsecutiveHighPriority sets the number of jobs with ipc.PriorityHigh that are started in a row while jobs
+// with ipc.PriorityNormal wait (0 = unlimited)
+// Afterwards a normal job is started, so the normal jobs can't be starved by a steady stream of high priority jobs.
+func SetMaxConsecutiveHighPriority(jobs int) {
+	atomic.StoreInt32(&maxConsecutiveHighPriority, int32(jobs))
+}
+
+// SetMaxConsecutiveErrors sets the number of failed POW requests in a row after which a device is disabled (0 = never)
+// Disabled devices are initialized again by the retry loop of the server.
+func SetMaxConsecutiveErrors(errors int) {
+	atomic.StoreInt32(&maxConsecutiveErrors, int32(errors))
+}
+
+// AddPowObserver registers a function that is called after every POW
+func AddPowObserver(observer PowObserver) {
+	powObserversMutex.Lock()
+	defer powObserversMutex.Unlock()
+
+	powObservers = append(powObservers, observer)
+}
+
+// notifyPowObservers calls all registered observers
+func notifyPowObservers(device *PowDevice, duration time.Duration, err error) {
+	powObserversMutex.RLock()
+	defer powObserversMutex.RUnlock()
+
+	for _, observer := range powObservers {
+		observer(device, duration, err)
+	}
+}
+
+// PowFunc does the POW on the device that is chosen by the scheduler policy
+func PowFunc(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+	return getDispatcher().powFunc(trytes, mwm)
+}
+
+// GetPowDevices returns the devices that are used for POW
+func GetPowDevices() []*PowDevice {
+	return getDispatcher().currentDevices()
+}
+
+// getDispatcher returns the dispatcher of the current devices
+func getDispatcher() *powDispatcher {
+	dispatcherMutex.RLock()
+	defer dispatcherMutex.RUnlock()
+
+	return dispatcher
+}
+
+// submit queues a POW request of the owner, done is called as soon as the POW is finished
+// The lines of the worker are logged with the fields of log, a new correlation ID is used if it is nil.
+func (d *powDispatcher) submit(owner *jobOwner, trytes giota.Trytes, mwm int, priority byte, algorithm byte, log *logs.Entry, done func(result giota.Trytes, err error)) (*powJob, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if (len(d.devices) == 0) || d.closed {
+		return nil, newServerError(ipc.ErrorNoDevice, "powFunc not initialized")
+	}
+
+	selection := owner.deviceSelection()
+	if !d.hasEnabledDevice(selection) {
+		if !selection.isAny() {
+			return nil, newServerError(ipc.ErrorNoDevice, "No POW device of the selection \"%v\" available", selection)
+		}
+		return nil, newServerError(ipc.ErrorNoDevice, "No POW device available")
+	}
+	if !d.hasAlgorithmDevice(selection, algorithm) {
+		return nil, newServerError(ipc.ErrorUnsupportedAlgorithm, "%d", algorithm)
+	}
+
+	if depth := int(atomic.LoadInt32(&maxQueueDepth)); (depth > 0) && (len(d.queue) >= depth) {
+		return nil, errQueueFull
+	}
+
+	if log == nil {
+		log = logs.WithFields(logs.Fields{"corrID": logs.NewCorrelationID()})
+	}
+
+	job := &powJob{dispatcher: d, owner: owner, trytes: trytes, mwm: mwm, priority: priority, algorithm: algorithm, selection: selection, queued: time.Now(), log: log, done: done}
+
+	if atomic.LoadInt32(&coalesceRequests) == 1 {
+		job.key = newPowCacheKey(trytes, mwm)
+		// Requests pinned to other devices don't wait for the job, they would lose the selected device
+		if leader, exists := d.pending[job.key]; exists && (leader.selection == selection) && (leader.algorithm == algorithm) {
+			log.Debugf("Request coalesced with an identical request that is queued or running")
+			job.leader = leader
+			leader.followers = append(leader.followers, job)
+			if priority > leader.priority {
+				// The queued job is started as early as its most urgent request
+				leader.priority = priority
+			}
+			return job, nil
+		}
+		if _, exists := d.pending[job.key]; !exists {
+			d.pending[job.key] = job
+		}
+	}
+
+	d.queue = append(d.queue, job)
+	// All workers are woken up, the scheduler policy decides which device starts the job
+	d.jobs.Broadcast()
+
+	return job, nil
+}
+
+// cancel removes a job from the queue
+// It returns false if the job is not queued anymore, because a worker already started the POW.
+// A job with coalesced requests is kept for them, only its own request is cancelled.
+func (d *powDispatcher) cancel(job *powJob) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if job.leader != nil {
+		leader := job.leader
+		for i, follower := range leader.followers {
+			if follower == job {
+				leader.followers = append(leader.followers[:i], leader.followers[i+1:]...)
+				break
+			}
+		}
+		if leader.cancelled && (len(leader.followers) == 0) {
+			d.dequeue(leader)
+		}
+		return true
+	}
+
+	if !d.queued(job) {
+		return false
+	}
+
+	job.cancelled = true
+	if len(job.followers) == 0 {
+		d.dequeue(job)
+	}
+	return true
+}
+
+// queued returns true if the job waits for a worker
+// The dispatcher mutex must be held by the caller
+func (d *powDispatcher) queued(job *powJob) bool {
+	for _, queuedJob := range d.queue {
+		if queuedJob == job {
+			return true
+		}
+	}
+	return false
+}
+
+// dequeue removes a job from the queue
+// The dispatcher mutex must be held by the caller
+func (d *powDispatcher) dequeue(job *powJob) {
+	for i, queuedJob := range d.queue {
+		if queuedJob == job {
+			d.queue = append(d.queue[:i], d.queue[i+1:]...)
+			break
+		}
+	}
+	if d.pending[job.key] == job {
+		delete(d.pending, job.key)
+	}
+}
+
+// servedBy records the device that did the POW for the owners of the job and of all coalesced requests
+// The dispatcher mutex must be held by the caller
+func (job *powJob) servedBy(device *PowDevice) {
+	if job.owner != nil {
+		job.owner.lastDevice.Store(device)
+	}
+	for _, follower := range job.followers {
+		if follower.owner != nil {
+			follower.owner.lastDevice.Store(device)
+		}
+	}
+}
+
+// complete unregisters a finished job and returns the functions that receive its result,
+// the done function of the job and of all coalesced requests that weren't cancelled
+// The dispatcher mutex must be held by the caller
+func (d *powDispatcher) complete(job *powJob) []func(result giota.Trytes, err error) {
+	if d.pending[job.key] == job {
+		delete(d.pending, job.key)
+	}
+
+	var done []func(result giota.Trytes, err error)
+	if !job.cancelled {
+		done = append(done, job.done)
+	}
+	for _, follower := range job.followers {
+		done = append(done, follower.done)
+	}
+	job.followers = nil
+	return done
+}
+
+// powFunc queues a POW request and waits for the result
+func (d *powDispatcher) powFunc(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+	type powResult struct {
+		trytes giota.Trytes
+		err    error
+	}
+	resultChan := make(chan powResult, 1)
+
+	_, err := d.submit(nil, trytes, mwm, ipc.PriorityNormal, ipc.AlgorithmCurlTransaction, nil, func(result giota.Trytes, err error) {
+		resultChan <- powResult{trytes: result, err: err}
+	})
+	if err != nil {
+		return "", err
+	}
+
+	result := <-resultChan
+	return result.trytes, result.err
+}
+
+// queueLength returns the number of POW requests that wait for an idle device
+func (d *powDispatcher) queueLength() int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return len(d.queue)
+}
+
+// setDevices changes the devices of the dispatcher
+// The slice is replaced instead of modified, so that the devices returned by currentDevices stay unchanged
+func (d *powDispatcher) setDevices(devices []*PowDevice) {
+	for _, device := range devices {
+		if device.PowMutex == nil {
+			device.PowMutex = &sync.Mutex{}
+		}
+	}
+
+	d.mutex.Lock()
+
+	previous := d.devices
+	d.devices = devices
+
+	for _, device := range devices {
+		if !d.workers[device] && !device.Disabled() {
+			d.startWorker(device)
+		}
+	}
+
+	var drained []func()
+	for _, device := range previous {
+		if d.contains(device) {
+			continue
+		}
+		// Disabled devices were already drained
+		if !device.Disabled() {
+			drained = append(drained, d.drainDevice(device))
+		}
+		// Devices with a running worker are released as soon as the worker exits
+		if !d.workers[device] {
+			go device.release()
+		}
+	}
+	d.mutex.Unlock()
+
+	// Let the workers of the removed devices exit
+	d.jobs.Broadcast()
+
+	for _, drained := range drained {
+		drained()
+	}
+}
+
+// drainDevice handles the jobs of a device that was disabled or removed
+// Queued jobs that another device can do stay queued for it, the others fail with ipc.ErrorNoDevice. The clients of the
+// affected jobs, including the job in progress on the device, get an ipc.NotificationDeviceLost before the errors.
+// The dispatcher mutex must be held by the caller, the returned function has to be called after the mutex is released.
+func (d *powDispatcher) drainDevice(device *PowDevice) func() {
+	owners := make(map[*jobOwner]bool)
+	addOwners := func(job *powJob) {
+		if (job.owner != nil) && !job.cancelled {
+			owners[job.owner] = true
+		}
+		for _, follower := range job.followers {
+			if follower.owner != nil {
+				owners[follower.owner] = true
+			}
+		}
+	}
+
+	if device.job != nil {
+		addOwners(device.job)
+	}
+
+	var requeued, failed int
+	var done []func(result giota.Trytes, err error)
+	queue := make([]*powJob, 0, len(d.queue))
+	for _, job := range d.queue {
+		if !job.eligible(device) {
+			queue = append(queue, job)
+			continue
+		}
+
+		addOwners(job)
+		if d.hasEligibleDevice(job) {
+			requeued++
+			queue = append(queue, job)
+			continue
+		}
+		failed++
+		job.log.Warningf("POW device %v was lost, no other device can do the PoW", device)
+		done = append(done, d.complete(job)...)
+	}
+	d.queue = queue
+
+	if len(owners) > 0 {
+		logs.Log.Warningf("POW device %v was lost: %d queued requests are left to the other devices, %d failed, %d clients notified", device, requeued, failed, len(owners))
+	}
+
+	err := newServerError(ipc.ErrorNoDevice, "POW device %v was lost", device)
+	message := fmt.Sprintf("%s %s", ipc.NotificationDeviceLost, device.PowType)
+	return func() {
+		for owner := range owners {
+			if owner.notify != nil {
+				owner.notify(message)
+			}
+		}
+		for _, done := range done {
+			done("", err)
+		}
+	}
+}
+
+// startWorker starts the worker of a device
+// The dispatcher mutex must be held by the caller, if the dispatcher is already in use
+func (d *powDispatcher) startWorker(device *PowDevice) {
+	d.workers[device] = true
+	go d.worker(device)
+}
+
+// currentDevices returns the devices of the dispatcher
+func (d *powDispatcher) currentDevices() []*PowDevice {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return d.devices
+}
+
+// deviceStats returns the statistics of all devices
+func (d *powDispatcher) deviceStats() []DeviceStats {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	stats := make([]DeviceStats, 0, len(d.devices))
+	for _, device := range d.devices {
+		avg, median, max := device.durations.stats()
+		stats = append(stats, DeviceStats{
+			Index:                device.Index,
+			PowType:              device.PowType,
+			Busy:                 device.busy,
+			Disabled:             device.Disabled(),
+			Requests:             atomic.LoadUint64(&device.requests),
+			Errors:               atomic.LoadUint64(&device.errors),
+			VerificationFailures: atomic.LoadUint64(&device.verificationFailures),
+			AvgDurationMs:        durationToMs(avg),
+			MedianDurationMs:     durationToMs(median),
+			MaxDurationMs:        durationToMs(max),
+			EstimatedMs:          durationToMs(device.estimatedDuration()),
+		})
+	}
+	return stats
+}
+
+// priorityStats returns the statistics of the POW requests by their priority
+func (d *powDispatcher) priorityStats() []PriorityStats {
+	var queued [2]int
+	d.mutex.Lock()
+	for _, job := range d.queue {
+		queued[job.priority]++
+	}
+	d.mutex.Unlock()
+
+	stats := make([]PriorityStats, 0, len(queued))
+	for priority := range queued {
+		avg, median, max := priorityWaits[priority].stats()
+		stats = append(stats, PriorityStats{
+			Priority:     priority,
+			Requests:     atomic.LoadUint64(&priorityRequests[priority]),
+			Queued:       queued[priority],
+			AvgWaitMs:    durationToMs(avg),
+			MedianWaitMs: durationToMs(median),
+			MaxWaitMs:    durationToMs(max),
+		})
+	}
+	return stats
+}
+
+// hasEnabledDevice returns true if at least one device of the selection is used for POW
+// The dispatcher mutex must be held by the caller
+func (d *powDispatcher) hasEnabledDevice(selection deviceSelection) bool {
+	for _, device := range d.devices {
+		if !device.Disabled() && selection.matches(device) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasAlgorithmDevice returns true if at least one enabled device of the selection supports the algorithm
+// The dispatcher mutex must be held by the caller
+func (d *powDispatcher) hasAlgorithmDevice(selection deviceSelection, algorithm byte) bool {
+	for _, device := range d.devices {
+		if !device.Disabled() && selection.matches(device) && device.supportsAlgorithm(algorithm) {
+			return true
+		}
+	}
+	return false
+}
+
+// active returns true if the device is used for POW
+// The dispatcher mutex must be held by the caller
+func (d *powDispatcher) active(device *PowDevice) bool {
+	return !device.Disabled() && d.contains(device)
+}
+
+// contains returns true if the device belongs to the dispatcher
+// The dispatcher mutex must be held by the caller
+func (d *powDispatcher) contains(device *PowDevice) bool {
+	for _, current := range d.devices {
+		if current == device {
+			return true
+		}
+	}
+	return false
+}
+
+// nextJob returns the index of the queued job that is started next on the device, or -1 if no job can be started
+// Jobs that are pinned to other devices and jobs of owners that reached maxPendingPerClient are skipped.
+// Of the remaining jobs, the oldest job of the owner that was served least recently is taken, jobs without owner first.
+// Jobs with ipc.PriorityHigh are taken before the normal jobs, unless maxConsecutiveHighPriority of them were started
+// in a row. bypassed is true if a high priority job is taken while a normal job could be started.
+// The dispatcher mutex must be held by the caller
+func (d *powDispatcher) nextJob(device *PowDevice) (next int, bypassed bool) {
+	maxPending := atomic.LoadInt32(&maxPendingPerClient)
+
+	best := [2]int{-1, -1} // Next job of every priority
+	for i, job := range d.queue {
+		if !job.eligible(device) {
+			continue
+		}
+
+		if (job.owner != nil) && (maxPending > 0) && (atomic.LoadInt32(&job.owner.running) >= maxPending) {
+			continue
+		}
+
+		if current := best[job.priority]; (current == -1) || job.servedBefore(d.queue[current]) {
+			best[job.priority] = i
+		}
+	}
+
+	high, normal := best[ipc.PriorityHigh], best[ipc.PriorityNormal]
+	maxStreak := int(atomic.LoadInt32(&maxConsecutiveHighPriority))
+	switch {
+	case high == -1:
+		return normal, false
+	case normal == -1:
+		return high, false
+	case (maxStreak > 0) && (d.highPriorityStreak >= maxStreak):
+		return normal, false
+	default:
+		return high, true
+	}
+}
+
+// servedBefore returns true if the job is started before the other job of the same priority
+// Jobs without owner are started first, otherwise the owner that was served least recently is next.
+func (job *powJob) servedBefore(other *powJob) bool {
+	if other.owner == nil {
+		return false
+	}
+	if job.owner == nil {
+		return true
+	}
+	return job.owner.lastServed < other.owner.lastServed
+}
+
+// next blocks until a job can be started and returns it
+// It returns nil if the device is not used anymore, or if the dispatcher was stopped and all queued jobs are done
+func (d *powDispatcher) next(device *PowDevice) *powJob {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var i int
+	var bypassed bool
+	for {
+		if !d.active(device) {
+			return nil
+		}
+
+		i, bypassed = d.nextJob(device)
+		if (i != -1) && d.mayStart(device, d.queue[i]) {
+			break
+		}
+
+		if d.closed && (len(d.queue) == 0) {
+			return nil
+		}
+		d.jobs.Wait()
+	}
+
+	job := d.queue[i]
+	d.queue = append(d.queue[:i], d.queue[i+1:]...)
+	device.busy = true
+	device.job = job
+	d.started++
+	device.lastStarted = d.started
+
+	if bypassed {
+		d.highPriorityStreak++
+	} else if job.priority == ipc.PriorityNormal {
+		d.highPriorityStreak = 0
+	}
+	if len(job.failedOn) == 0 {
+		// Retries of failed jobs would count the duration of the failed POW as waiting time
+		countPriorityStart(job.priority, time.Since(job.queued))
+	}
+
+	if job.owner != nil {
+		job.owner.lastServed = atomic.AddUint64(&jobsServed, 1)
+		atomic.AddInt32(&job.owner.running, 1)
+	}
+
+	return job
+}
+
+// worker does the POW of the queued jobs on a device
+func (d *powDispatcher) worker(device *PowDevice) {
+	for job := d.next(device); job != nil; job = d.next(device) {
+		log := job.log.WithFields(logs.Fields{"component": "dispatcher", "deviceIndex": device.Index, "mwm": job.mwm})
+
+		device.PowMutex.Lock()
+		log.Debugf("Starting PoW on device %v", device)
+		ts := time.Now()
+		result, running, err := runPow(device, job, log)
+		duration := time.Since(ts)
+		timedOut := running != nil
+		if (err == nil) && device.verifiesResults() {
+			if verr := verifyNonce(job.trytes, result, job.mwm); verr != nil {
+				countVerificationFailure(device)
+				err = toServerError(verr, ipc.ErrorPowFailed)
+			}
+		}
+		atomic.AddUint64(&device.requests, 1)
+		var consecutiveErrors int32
+		if err != nil {
+			atomic.AddUint64(&device.errors, 1)
+			atomic.StoreInt32(&device.lastError, 1)
+			if !timedOut {
+				// A timeout is caused by the MWM of the request rather than by the device
+				consecutiveErrors = atomic.AddInt32(&device.consecutiveErrors, 1)
+			}
+		} else {
+			atomic.StoreInt32(&device.lastError, 0)
+			atomic.StoreInt32(&device.consecutiveErrors, 0)
+			device.updateEstimate(duration)
+		}
+		device.durations.add(duration)
+		if !timedOut {
+			device.PowMutex.Unlock()
+		}
+
+		countPow(device, duration, err)
+		notifyPowObservers(device, duration, err)
+		publishPowEvent(device, job, ts, duration, err)
+
+		d.mutex.Lock()
+		device.busy = timedOut
+		device.job = nil
+		if job.owner != nil {
+			atomic.AddInt32(&job.owner.running, -1)
+		}
+		// The POW of a device that was lost during the POW is retried on the remaining devices
+		retried := (err != nil) && !timedOut && d.retry(job, device, !d.active(device))
+		var done []func(result giota.Trytes, err error)
+		if !retried {
+			if err == nil {
+				job.servedBy(device)
+			}
+			done = d.complete(job)
+		}
+		d.mutex.Unlock()
+		// Queued jobs of the owner may be started now
+		d.jobs.Broadcast()
+
+		// Summary of the request, from the submission to the result
+		log = log.WithFields(logs.Fields{"durationMs": int64(duration / time.Millisecond)})
+		switch {
+		case retried:
+			log.Warningf("PoW failed on device %v after %d ms, retrying on another device: %v", device, int64(time.Since(job.queued)/time.Millisecond), err)
+		case err != nil:
+			log.Warningf("PoW failed on device %v after %d ms: %v", device, int64(time.Since(job.queued)/time.Millisecond), err)
+		default:
+			log.Infof("PoW completed in %d ms on device %v, MWM %d", int64(time.Since(job.queued)/time.Millisecond), device, job.mwm)
+		}
+
+		if limit := atomic.LoadInt32(&maxConsecutiveErrors); (limit > 0) && (consecutiveErrors >= limit) && !device.Disabled() {
+			// The worker exits before it takes the next job
+			log.Errorf("POW device %v failed %d times in a row, it is disabled", device, consecutiveErrors)
+			atomic.StoreInt32(&device.disabled, 1)
+			d.mutex.Lock()
+			drained := d.drainDevice(device)
+			d.mutex.Unlock()
+			drained()
+		}
+
+		for _, done := range done {
+			done(result, err)
+		}
+
+		if timedOut {
+			// The device doesn't take the next job before the abandoned POW returned
+			<-running
+			log.Warningf("Abandoned PoW on device %v returned after %d ms", device, int64(time.Since(ts)/time.Millisecond))
+			device.PowMutex.Unlock()
+
+			d.mutex.Lock()
+			device.busy = false
+			d.mutex.Unlock()
+		}
+	}
+
+	d.mutex.Lock()
+	delete(d.workers, device)
+	removed := !d.closed && !d.contains(device)
+	d.mutex.Unlock()
+
+	if removed {
+		device.release()
+	}
+}
+
+// eligible returns true if the device may start the job
+// The device must be selected by the client, support the algorithm and must not have failed the POW of the job before.
+// The dispatcher mutex must be held by the caller
+func (job *powJob) eligible(device *PowDevice) bool {
+	if !job.selection.matches(device) || !device.supportsAlgorithm(job.algorithm) {
+		return false
+	}
+	for _, failed := range job.failedOn {
+		if failed == device {
+			return false
+		}
+	}
+	return true
+}
+
+// retry queues a job that failed on the device again for the other eligible devices, if failover is enabled or
+// the device was lost
+// The job is put in front of the queue, its requests already waited. It returns false if no eligible device is left.
+// The dispatcher mutex must be held by the caller
+func (d *powDispatcher) retry(job *powJob, device *PowDevice, lost bool) bool {
+	if !lost && (atomic.LoadInt32(&powFailover) == 0) {
+		return false
+	}
+
+	job.failedOn = append(job.failedOn, device)
+	if d.hasEligibleDevice(job) {
+		d.queue = append([]*powJob{job}, d.queue...)
+		return true
+	}
+	return false
+}
+
+// hasEligibleDevice returns true if at least one device that is used for POW may start the job
+// The dispatcher mutex must be held by the caller
+func (d *powDispatcher) hasEligibleDevice(job *powJob) bool {
+	for _, device := range d.devices {
+		if d.active(device) && job.eligible(device) {
+			return true
+		}
+	}
+	return false
+}
+
+// stop lets the workers exit as soon as all queued jobs are done
+func (d *powDispatcher) stop() {
+	d.mutex.Lock()
+	d.closed = true
+	d.mutex.Unlock()
+	d.jobs.Broadcast()
+}
+
+// close stops the workers and releases all devices
+func (d *powDispatcher) close() {
+	d.stop()
+
+	for _, device := range d.currentDevices() {
+		device.release()
+	}
+}
+
+// PowInfo contains the server version and the POW types and versions of all devices, returned by ipc.CmdGetInfo
+type PowInfo struct {
+	ServerVersion string `json:"serverVersion"`
+	PowType       string `json:"powType"`    // Types of all devices, e.g. "[0] PiDiver, [1] gIOTA-PowC"
+	PowVersion    string `json:"powVersion"` // Versions of all devices, e.g. "[0] 1.1, [1] "
+}
+
+// currentPowInfo returns the server version and the POW types and versions of all devices
+func currentPowInfo() PowInfo {
+	d := getDispatcher()
+	return PowInfo{ServerVersion: powSrvVersion, PowType: d.powTypes(), PowVersion: d.powVersions()}
+}
+
+// DeviceInfo is the state of a single POW device, returned by ipc.CmdGetDeviceList
+type DeviceInfo struct {
+	Index         int     `json:"index"`
+	Type          string  `json:"type"`            // Name of the used POW implementation (e.g. PiDiver)
+	Version       string  `json:"version"`         // Version of the used POW implementation (e.g. "core=3 driver=pidiver")
+	Class         string  `json:"class,omitempty"` // DeviceClassFPGA or DeviceClassCPU, used by ipc.CmdSelectDevice
+	Enabled       bool    `json:"enabled"`
+	Busy          bool    `json:"busy"`
+	TotalRequests uint64  `json:"totalRequests"`
+	AvgDurationMs float64 `json:"avgDurationMs"` // Average POW duration of the last 100 POW requests
+}
+
+// GetDeviceList returns the state of all devices
+func GetDeviceList() []DeviceInfo {
+	return getDispatcher().deviceList()
+}
+
+// deviceList returns the state of all devices
+func (d *powDispatcher) deviceList() []DeviceInfo {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	devices := make([]DeviceInfo, 0, len(d.devices))
+	for _, device := range d.devices {
+		avg, _, _ := device.durations.stats()
+		devices = append(devices, DeviceInfo{
+			Index:         device.Index,
+			Type:          device.PowType,
+			Version:       device.PowVersion,
+			Class:         device.PowClass,
+			Enabled:       !device.Disabled(),
+			Busy:          device.busy,
+			TotalRequests: atomic.LoadUint64(&device.requests),
+			AvgDurationMs: durationToMs(avg),
+		})
+	}
+	return devices
+}
+
+// powTypes returns the types of all devices, e.g. "[0] PiDiver, [1] gIOTA-PowC"
+func (d *powDispatcher) powTypes() string {
+	var result string
+	for i, device := range d.deviceList() {
+		if i > 0 {
+			result += ", "
+		}
+		result += fmt.Sprintf("[%d] %s", device.Index, device.Type)
+		if !device.Enabled {
+			result += " (disabled)"
+		}
+	}
+	return result
+}
+
+// powVersions returns the versions of all devices, e.g. "[0] 1.1, [1] "
+func (d *powDispatcher) powVersions() string {
+	var result string
+	for i, device := range d.deviceList() {
+		if i > 0 {
+			result += ", "
+		}
+		result += fmt.Sprintf("[%d] %s", device.Index, device.Version)
+	}
+	return result
+}
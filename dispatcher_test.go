@@ -0,0 +1,274 @@
+package powsrv
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/iotaledger/giota"
+
+	"github.com/muxxer/powsrv/ipc"
+)
+
+// blockingDevice returns a device that blocks every POW until release is closed
+func blockingDevice(calls *int32, release chan struct{}, err error) *PowDevice {
+	return &PowDevice{PowType: "test", PowFunc: func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		atomic.AddInt32(calls, 1)
+		<-release
+		if err != nil {
+			return "", err
+		}
+		return fakeNonce, nil
+	}}
+}
+
+// submitIdentical submits the same request from n goroutines and returns the channel of their results
+func submitIdentical(t *testing.T, d *powDispatcher, n int) chan error {
+	results := make(chan error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := d.submit(&jobOwner{}, giota.Trytes(transaction), 14, ipc.PriorityNormal, ipc.AlgorithmCurlTransaction, nil, func(result giota.Trytes, err error) {
+				if (err == nil) && (result != fakeNonce) {
+					err = errors.New("unexpected result " + string(result))
+				}
+				results <- err
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+func TestSetPowFunc(t *testing.T) {
+	SetPowFunc(func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return fakeNonce, nil
+	})
+	defer SetPowDevices(nil)
+
+	result := make(chan giota.Trytes, 1)
+	_, err := getDispatcher().submit(&jobOwner{}, giota.Trytes(transaction), 14, ipc.PriorityNormal, ipc.AlgorithmCurlTransaction, nil, func(nonce giota.Trytes, err error) {
+		if err != nil {
+			t.Error(err)
+		}
+		result <- nonce
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nonce := <-result; nonce != fakeNonce {
+		t.Errorf("Unexpected nonce %v", nonce)
+	}
+}
+
+func TestCoalesceRequests(t *testing.T) {
+	SetCoalesceRequests(true)
+	defer SetCoalesceRequests(false)
+
+	for _, powErr := range []error{nil, errors.New("device failed")} {
+		var calls int32
+		release := make(chan struct{})
+		SetPowDevices([]*PowDevice{blockingDevice(&calls, release, powErr), blockingDevice(&calls, release, powErr)})
+		d := getDispatcher()
+
+		results := submitIdentical(t, d, 20)
+		close(release)
+
+		for i := 0; i < 20; i++ {
+			err := <-results
+			if (powErr == nil) && (err != nil) {
+				t.Error(err)
+			}
+			if (powErr != nil) && (err != powErr) {
+				t.Errorf("Expected the error of the device, got: %v", err)
+			}
+		}
+		if calls != 1 {
+			t.Errorf("Expected a single POW, got %d", calls)
+		}
+		if len(d.pending) != 0 {
+			t.Errorf("Finished job is still pending")
+		}
+	}
+}
+
+func TestCoalesceRequestsCancel(t *testing.T) {
+	SetCoalesceRequests(true)
+	defer SetCoalesceRequests(false)
+
+	var calls int32
+	release := make(chan struct{})
+	SetPowDevices([]*PowDevice{blockingDevice(&calls, release, nil)})
+	d := getDispatcher()
+
+	// The device is busy, so the following requests stay queued
+	busy := make(chan struct{})
+	d.submit(nil, "BUSY", 14, ipc.PriorityNormal, ipc.AlgorithmCurlTransaction, nil, func(result giota.Trytes, err error) { close(busy) })
+	for atomic.LoadInt32(&calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	var received int32
+	submit := func() *powJob {
+		job, err := d.submit(&jobOwner{}, giota.Trytes(transaction), 14, ipc.PriorityNormal, ipc.AlgorithmCurlTransaction, nil, func(result giota.Trytes, err error) {
+			atomic.AddInt32(&received, 1)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return job
+	}
+	leader, follower, other := submit(), submit(), submit()
+
+	// Cancelling the first request keeps the job for the others
+	if !d.cancel(leader) || !d.cancel(follower) {
+		t.Fatal("Queued requests could not be cancelled")
+	}
+	if d.queueLength() != 1 {
+		t.Fatalf("Job of the waiting request was removed from the queue")
+	}
+
+	// The job is removed as soon as no request waits for it
+	if !d.cancel(other) {
+		t.Fatal("Queued request could not be cancelled")
+	}
+	if (d.queueLength() != 0) || (d.pending[leader.key] != nil) {
+		t.Fatalf("Job without waiting requests is still queued")
+	}
+
+	// A new request doesn't join the cancelled job
+	last := make(chan struct{}, 2)
+	for i := 0; i < 2; i++ {
+		d.submit(&jobOwner{}, giota.Trytes(transaction), 14, ipc.PriorityNormal, ipc.AlgorithmCurlTransaction, nil, func(result giota.Trytes, err error) { last <- struct{}{} })
+	}
+	close(release)
+	<-busy
+	<-last
+	<-last
+
+	if calls := atomic.LoadInt32(&calls); calls != 2 {
+		t.Errorf("Expected 2 POWs, got %d", calls)
+	}
+	if received := atomic.LoadInt32(&received); received != 0 {
+		t.Errorf("Cancelled requests received %d results", received)
+	}
+}
+
+func TestPriorities(t *testing.T) {
+	SetMaxConsecutiveHighPriority(2)
+	defer SetMaxConsecutiveHighPriority(4)
+
+	// The MWM identifies the jobs
+	var mutex sync.Mutex
+	var started []int
+	busy := make(chan struct{})
+	release := make(chan struct{})
+	SetPowDevices([]*PowDevice{{PowType: "test", PowFunc: func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		if trytes == "BUSY" {
+			close(busy)
+			<-release
+			return fakeNonce, nil
+		}
+		mutex.Lock()
+		started = append(started, mwm)
+		mutex.Unlock()
+		return fakeNonce, nil
+	}}})
+	d := getDispatcher()
+
+	// The device is busy, so the following requests stay queued
+	done := make(chan struct{}, 8)
+	d.submit(nil, "BUSY", 14, ipc.PriorityNormal, ipc.AlgorithmCurlTransaction, nil, func(result giota.Trytes, err error) { done <- struct{}{} })
+	<-busy
+
+	owner := &jobOwner{}
+	for _, job := range []struct {
+		mwm      int
+		priority byte
+	}{{1, ipc.PriorityNormal}, {11, ipc.PriorityHigh}, {12, ipc.PriorityHigh}, {2, ipc.PriorityNormal}, {13, ipc.PriorityHigh}, {14, ipc.PriorityHigh}, {15, ipc.PriorityHigh}} {
+		_, err := d.submit(owner, giota.Trytes(transaction), job.mwm, job.priority, ipc.AlgorithmCurlTransaction, nil, func(result giota.Trytes, err error) { done <- struct{}{} })
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	close(release)
+	for i := 0; i < 8; i++ {
+		<-done
+	}
+
+	// At most 2 high priority jobs are started in a row while normal jobs wait
+	expected := []int{11, 12, 1, 13, 14, 2, 15}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if fmt.Sprint(started) != fmt.Sprint(expected) {
+		t.Errorf("Expected the order %v, got %v", expected, started)
+	}
+}
+
+func TestDeviceRemoved(t *testing.T) {
+	// The POW of the USB device fails as soon as it is unplugged
+	var calls int32
+	release := make(chan struct{})
+	usb := blockingDevice(&calls, release, errors.New("device unplugged"))
+	usb.PowType = "USBDiver"
+	SetPowDevices([]*PowDevice{usb})
+	d := getDispatcher()
+
+	var mutex sync.Mutex
+	var notifications []string
+	owner := &jobOwner{notify: func(message string) {
+		mutex.Lock()
+		notifications = append(notifications, message)
+		mutex.Unlock()
+	}}
+
+	// The first job is running on the device, the second one is queued
+	results := make(chan error, 2)
+	for mwm := 13; mwm <= 14; mwm++ {
+		_, err := d.submit(owner, giota.Trytes(transaction), mwm, ipc.PriorityNormal, ipc.AlgorithmCurlTransaction, nil, func(result giota.Trytes, err error) {
+			results <- err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for atomic.LoadInt32(&calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Both jobs are done by the remaining device, even without failover
+	cpu := &PowDevice{Index: 1, PowType: "cpu", PowFunc: func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return fakeNonce, nil
+	}}
+	UpdatePowDevices([]*PowDevice{cpu})
+	close(release)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-results:
+			if err != nil {
+				t.Error(err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Job of the removed device was not finished")
+		}
+	}
+	if cpu.Requests() != 2 {
+		t.Errorf("Expected 2 requests on the remaining device, got %d", cpu.Requests())
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if fmt.Sprint(notifications) != "[DEVICE_LOST USBDiver]" {
+		t.Errorf("Wrong notifications: %v", notifications)
+	}
+}
@@ -0,0 +1,88 @@
+package powsrv
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/iotaledger/giota"
+)
+
+// PowDriver is the interface of the POW hardware/software of a device
+// Drivers are registered with RegisterDriver and created for every configured device of their type.
+type PowDriver interface {
+	Init(config PowConfigDevice) error                      // Initializes the hardware, called once before the first POW
+	Pow(trytes giota.Trytes, mwm int) (giota.Trytes, error) // Does the POW and returns the nonce
+	Type() string                                           // Name of the used POW implementation (e.g. PiDiver)
+	Version() string                                        // Version of the used POW implementation (e.g. PiDiver FPGA Core Version)
+	Close() error                                           // Releases the hardware on shutdown or after a reload
+}
+
+// DriverFactory creates a new, uninitialized driver
+type DriverFactory func() PowDriver
+
+var drivers = make(map[string]DriverFactory)
+var driversMutex = &sync.RWMutex{}
+
+// RegisterDriver makes a driver available for the devices with the given type
+// It is usually called in the init function of the driver package. The type is case-insensitive.
+func RegisterDriver(powType string, factory DriverFactory) {
+	driversMutex.Lock()
+	defer driversMutex.Unlock()
+
+	powType = strings.ToLower(powType)
+	if _, exists := drivers[powType]; exists {
+		panic(fmt.Sprintf("POW driver %v registered twice", powType))
+	}
+	drivers[powType] = factory
+}
+
+// Drivers returns the sorted types of all registered drivers
+func Drivers() []string {
+	driversMutex.RLock()
+	defer driversMutex.RUnlock()
+
+	var types []string
+	for powType := range drivers {
+		types = append(types, powType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// NewPowDevice creates and initializes the driver of the configured device type
+func NewPowDevice(index int, config PowConfigDevice) (*PowDevice, error) {
+	driversMutex.RLock()
+	factory, exists := drivers[strings.ToLower(config.Type)]
+	driversMutex.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("Unknown POW type: %v (registered drivers: %v)", config.Type, strings.Join(Drivers(), ", "))
+	}
+
+	driver := factory()
+	err := driver.Init(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewPowDeviceFromDriver(index, driver), nil
+}
+
+// NewPowDeviceFromDriver creates a device for an initialized driver
+// The class of the device is set if the driver implements ClassifiedDriver, the abort if it implements AbortableDriver
+// and the algorithms if it implements AlgorithmDriver.
+func NewPowDeviceFromDriver(index int, driver PowDriver) *PowDevice {
+	device := &PowDevice{Index: index, PowType: driver.Type(), PowVersion: driver.Version(), PowFunc: driver.Pow, CloseFunc: driver.Close}
+	if classified, ok := driver.(ClassifiedDriver); ok {
+		device.PowClass = classified.Class()
+	}
+	if abortable, ok := driver.(AbortableDriver); ok {
+		device.AbortFunc = abortable.Abort
+	}
+	if algorithms, ok := driver.(AlgorithmDriver); ok {
+		device.Algorithms = algorithms.Algorithms()
+	}
+	return device
+}
@@ -0,0 +1,87 @@
+package powsrv
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iotaledger/giota"
+)
+
+const fakeNonce = "FAKENONCE999999999999999999"
+
+// fakeDriver returns a fixed nonce after the latency, its initialization fails for the device "fail"
+type fakeDriver struct {
+	latency time.Duration
+	closed  bool
+}
+
+func (d *fakeDriver) Init(config PowConfigDevice) error {
+	if config.Device == "fail" {
+		return errors.New("fake device not found")
+	}
+	return nil
+}
+
+func (d *fakeDriver) Pow(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+	time.Sleep(d.latency)
+	return fakeNonce, nil
+}
+
+func (d *fakeDriver) Type() string {
+	return "Fake"
+}
+
+func (d *fakeDriver) Version() string {
+	return "1.0"
+}
+
+func (d *fakeDriver) Close() error {
+	d.closed = true
+	return nil
+}
+
+var registerFakeDriver sync.Once
+var lastFakeDriver *fakeDriver
+
+func TestDriver(t *testing.T) {
+	registerFakeDriver.Do(func() {
+		RegisterDriver("fake", func() PowDriver {
+			lastFakeDriver = &fakeDriver{}
+			return lastFakeDriver
+		})
+	})
+
+	_, err := NewPowDevice(0, PowConfigDevice{Type: "unknown"})
+	if (err == nil) || !strings.Contains(err.Error(), "fake") {
+		t.Errorf("Expected the registered drivers in the error, got: %v", err)
+	}
+
+	_, err = NewPowDevice(0, PowConfigDevice{Type: "fake", Device: "fail"})
+	if err == nil {
+		t.Error("Expected the error of Init")
+	}
+
+	// The type is case-insensitive
+	device, err := NewPowDevice(1, PowConfigDevice{Type: "FAKE"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (device.Index != 1) || (device.PowType != "Fake") || (device.PowVersion != "1.0") {
+		t.Errorf("Unexpected device: %+v", device)
+	}
+
+	// The dispatcher uses the driver for the POW
+	SetPowDevices([]*PowDevice{device})
+	result, err := PowFunc(giota.Trytes(transaction), MWM)
+	if (err != nil) || (result != fakeNonce) {
+		t.Errorf("Unexpected result: %v, %v", result, err)
+	}
+
+	device.release()
+	if !lastFakeDriver.closed {
+		t.Error("Driver was not closed")
+	}
+}
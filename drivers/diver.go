@@ -0,0 +1,125 @@
+package drivers
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/iotaledger/giota"
+	"github.com/muxxer/ftdiver"
+	"github.com/shufps/pidiver/pidiver"
+	"github.com/shufps/pidiver/raspberry"
+
+	"github.com/muxxer/powsrv"
+	"github.com/muxxer/powsrv/logs"
+)
+
+func init() {
+	powsrv.RegisterDriver("pidiver", func() powsrv.PowDriver { return &diverDriver{diverType: "pidiver"} })
+	powsrv.RegisterDriver("usbdiver", func() powsrv.PowDriver { return &diverDriver{diverType: "usbdiver"} })
+	powsrv.RegisterDriver("ftdiver", func() powsrv.PowDriver { return &diverDriver{diverType: "ftdiver"} })
+}
+
+// The pidiver library has no device handles, the state of the devices is kept in package variables:
+// pidiver.PowPiDiver uses the low-level driver of the last InitPiDiver (PiDiver and FTDIver),
+// pidiver.PowUSBDiver the serial port of InitUSBDiver. Each of them gets its own lock,
+// so a USBDiver works in parallel to a PiDiver or FTDIver.
+var (
+	piDiverMutex  = &sync.Mutex{} // Secures pidiver.PowPiDiver of the 'pidiver' and 'ftdiver' types
+	usbDiverMutex = &sync.Mutex{} // Secures pidiver.PowUSBDiver of the 'usbdiver' type
+)
+
+// diverDriver does the POW on the FPGA of a PiDiver, USBDiver or FTDIver
+type diverDriver struct {
+	diverType  string // 'pidiver', 'usbdiver' or 'ftdiver'
+	powType    string
+	powVersion string // FPGA core version and driver, read during the initialization
+	powFunc    giota.PowFunc
+	mutex      *sync.Mutex // Lock of the pidiver library state that powFunc uses
+}
+
+// Init uploads the core to the FPGA
+// A failed flashing only returns the error, the server disables the device and keeps running.
+func (d *diverDriver) Init(config powsrv.PowConfigDevice) error {
+	var err error
+	getVersion := pidiver.GetFPGAVersion
+
+	piconfig := pidiver.PiDiverConfig{
+		Device:         config.Device,
+		ConfigFile:     config.Core,
+		ForceFlash:     config.ForceFlash,
+		ForceConfigure: config.ForceConfigure}
+
+	if config.ForceFlash || config.ForceConfigure {
+		logs.Log.Warningf("Flashing core %v to the %v (forceFlash=%v, forceConfigure=%v), don't disconnect the device", config.Core, d.diverType, config.ForceFlash, config.ForceConfigure)
+	}
+	ts := time.Now()
+
+	switch d.diverType {
+	case "pidiver":
+		piconfig.Device = ""
+		llStruct := raspberry.GetLowLevel()
+		err = pidiver.InitPiDiver(&llStruct, &piconfig)
+		d.powFunc = pidiver.PowPiDiver
+		d.powType = "PiDiver"
+		d.mutex = piDiverMutex
+
+	case "usbdiver":
+		err = pidiver.InitUSBDiver(&piconfig)
+		d.powFunc = pidiver.PowUSBDiver
+		d.powType = "USBDiver"
+		d.mutex = usbDiverMutex
+		getVersion = pidiver.GetUSBDiverFPGAVersion
+
+	case "ftdiver":
+		llStruct := ftdiver.GetLowLevel()
+		err = pidiver.InitPiDiver(&llStruct, &piconfig)
+		d.powFunc = pidiver.PowPiDiver
+		d.powType = "ftdiver"
+		d.mutex = piDiverMutex
+	}
+	if err != nil {
+		if config.ForceFlash || config.ForceConfigure {
+			return fmt.Errorf("Flashing core %v failed after %d ms: %v", config.Core, int64(time.Since(ts)/time.Millisecond), err)
+		}
+		return err
+	}
+
+	if config.ForceFlash || config.ForceConfigure {
+		logs.Log.Infof("Core %v flashed to the %v in %d ms", config.Core, d.diverType, int64(time.Since(ts)/time.Millisecond))
+	}
+
+	d.powVersion = "unknown"
+	coreVersion, err := getVersion()
+	if err != nil {
+		logs.Log.Warningf("FPGA core version of the %v could not be read: %v", d.powType, err)
+		return nil
+	}
+	d.powVersion = fmt.Sprintf("core=%v driver=%v", coreVersion, d.diverType)
+	return nil
+}
+
+// Pow does the POW on the FPGA, the drivers that share the state of the pidiver library are serialized
+func (d *diverDriver) Pow(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.powFunc(trytes, mwm)
+}
+
+func (d *diverDriver) Type() string {
+	return d.powType
+}
+
+// Class returns powsrv.DeviceClassFPGA, so clients can pin their requests to the FPGA
+func (d *diverDriver) Class() string {
+	return powsrv.DeviceClassFPGA
+}
+
+// Version returns the FPGA core version and the driver, e.g. "core=3 driver=usbdiver"
+func (d *diverDriver) Version() string {
+	return d.powVersion
+}
+
+func (d *diverDriver) Close() error {
+	return nil
+}
@@ -0,0 +1,11 @@
+/*
+Package drivers contains the POW drivers of the powSrv.
+
+Every driver registers itself with powsrv.RegisterDriver in its init function,
+so importing the package makes all drivers available:
+
+	import _ "github.com/muxxer/powsrv/drivers"
+
+Out-of-tree drivers implement powsrv.PowDriver and register themselves the same way.
+*/
+package drivers
@@ -0,0 +1,112 @@
+package drivers
+
+import (
+	"sync"
+
+	"github.com/iotaledger/giota"
+
+	"github.com/muxxer/powsrv"
+	"github.com/muxxer/powsrv/ipc"
+	"github.com/muxxer/powsrv/logs"
+)
+
+func init() {
+	powsrv.RegisterDriver("giota", newGiotaDriver("", ""))
+	powsrv.RegisterDriver("giota-go", newGiotaDriver("PowGo", "gIOTA-Go"))
+	powsrv.RegisterDriver("giota-cl", newGiotaDriver("PowCL", "gIOTA-PowCL"))
+	powsrv.RegisterDriver("giota-sse", newGiotaDriver("PowSSE", "gIOTA-PowSSE"))
+	powsrv.RegisterDriver("giota-carm64", newGiotaDriver("PowCARM64", "gIOTA-PowCARM64"))
+	powsrv.RegisterDriver("giota-c128", newGiotaDriver("PowC128", "gIOTA-PowC128"))
+	powsrv.RegisterDriver("giota-c", newGiotaDriver("PowC", "gIOTA-PowC"))
+}
+
+// giotaMutex secures giota.PowProcs, which is shared by all giota devices
+var giotaMutex = &sync.Mutex{}
+
+// giotaDriver does the POW in software with one of the POW implementations of giota
+type giotaDriver struct {
+	powName     string // Name of the giota POW implementation, the best one is used if empty
+	powType     string
+	powFunc     giota.PowFunc
+	workers     int  // Number of goroutines of a single POW (giota.PowProcs)
+	lowPriority bool // The process runs with the lowest priority
+}
+
+func newGiotaDriver(powName string, powType string) powsrv.DriverFactory {
+	return func() powsrv.PowDriver {
+		return &giotaDriver{powName: powName, powType: powType}
+	}
+}
+
+// Init selects the POW implementation, the best available one is used if it is not available
+func (d *giotaDriver) Init(config powsrv.PowConfigDevice) error {
+	switch d.powName {
+	case "":
+		d.powType, d.powFunc = giota.GetBestPoW()
+
+	case "PowGo":
+		d.powFunc = giota.PowGo
+
+	default:
+		powFunc, err := giota.GetPowFunc(d.powName)
+		if err == nil {
+			d.powFunc = powFunc
+		} else {
+			d.powType, d.powFunc = giota.GetBestPoW()
+			logs.Log.Infof("POW type '%s' not available. Using '%s' instead", d.powName, d.powType)
+		}
+	}
+
+	d.workers = config.Workers
+	if d.workers <= 0 {
+		giotaMutex.Lock()
+		d.workers = giota.PowProcs
+		giotaMutex.Unlock()
+	}
+	d.lowPriority = config.LowPriority
+
+	logs.Log.Infof("POW type '%s' uses %d workers, low priority: %v", d.powType, d.workers, d.lowPriority)
+	if d.lowPriority && !lowPrioritySupported {
+		logs.Log.Warning("Low priority POW is only supported on Linux, the POW runs with normal priority")
+	}
+	if d.lowPriority {
+		// The whole process is niced, the other devices and the clients are served with low priority too
+		err := setLowPriority()
+		if err != nil {
+			logs.Log.Warningf("Priority of the process could not be lowered: %v", err)
+		}
+	}
+	return nil
+}
+
+// Pow does the POW with the configured number of workers
+// The POW of all giota devices is serialized, so the CPU never runs more than the workers of one device.
+func (d *giotaDriver) Pow(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+	giotaMutex.Lock()
+	defer giotaMutex.Unlock()
+
+	giota.PowProcs = d.workers
+	return d.powFunc(trytes, mwm)
+}
+
+func (d *giotaDriver) Type() string {
+	return d.powType
+}
+
+// Class returns powsrv.DeviceClassCPU, so clients can pin their requests to the software POW
+func (d *giotaDriver) Class() string {
+	return powsrv.DeviceClassCPU
+}
+
+// Algorithms returns the algorithms of the software POW, it hashes any transaction-sized trytes
+func (d *giotaDriver) Algorithms() []byte {
+	return []byte{ipc.AlgorithmCurlTransaction, ipc.AlgorithmCurlRaw}
+}
+
+func (d *giotaDriver) Version() string {
+	return ""
+}
+
+func (d *giotaDriver) Close() error {
+	return nil
+}
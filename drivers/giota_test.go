@@ -0,0 +1,59 @@
+package drivers
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/iotaledger/giota"
+
+	"github.com/muxxer/powsrv"
+)
+
+func TestGiotaWorkers(t *testing.T) {
+	const workers = 2
+
+	driver := &giotaDriver{powName: "PowGo", powType: "gIOTA-Go"}
+	err := driver.Init(powsrv.PowConfigDevice{Type: "giota-go", Workers: workers})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every POW runs giota.PowProcs goroutines
+	var running int32
+	var maxRunning int32
+	driver.powFunc = func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		current := atomic.AddInt32(&running, int32(giota.PowProcs))
+		for {
+			max := atomic.LoadInt32(&maxRunning)
+			if (current <= max) || atomic.CompareAndSwapInt32(&maxRunning, max, current) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&running, -int32(giota.PowProcs))
+		return trytes, nil
+	}
+
+	// Several devices of the same type share the CPU
+	other := &giotaDriver{powName: "PowGo", powType: "gIOTA-Go"}
+	other.Init(powsrv.PowConfigDevice{Type: "giota-go", Workers: workers})
+	other.powFunc = driver.powFunc
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		for _, d := range []*giotaDriver{driver, other} {
+			wg.Add(1)
+			go func(d *giotaDriver) {
+				defer wg.Done()
+				d.Pow("", 14)
+			}(d)
+		}
+	}
+	wg.Wait()
+
+	if maxRunning > workers {
+		t.Errorf("Expected at most %d POW workers, got %d", workers, maxRunning)
+	}
+}
@@ -0,0 +1,45 @@
+package drivers
+
+import (
+	"io/ioutil"
+	"strconv"
+	"syscall"
+)
+
+const lowPrioritySupported = true
+
+// lowPriorityNice is the niceness of the process with low priority
+const lowPriorityNice = 19
+
+// setLowPriority lowers the priority of all threads of the process to the niceness lowPriorityNice
+// giota's POW starts goroutines that run on any thread of the Go runtime, so a single POW thread can't be niced.
+// On Linux the niceness belongs to the thread, threads that are started later inherit it from their creator.
+func setLowPriority() error {
+	reniced := make(map[int]bool)
+	for {
+		tasks, err := ioutil.ReadDir("/proc/self/task")
+		if err != nil {
+			return err
+		}
+
+		// Repeat until no thread was started in the meantime
+		found := false
+		for _, task := range tasks {
+			tid, err := strconv.Atoi(task.Name())
+			if (err != nil) || reniced[tid] {
+				continue
+			}
+			found = true
+			reniced[tid] = true
+
+			err = syscall.Setpriority(syscall.PRIO_PROCESS, tid, lowPriorityNice)
+			if (err != nil) && (err != syscall.ESRCH) {
+				// ESRCH: The thread already exited
+				return err
+			}
+		}
+		if !found {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package drivers
+
+import (
+	"io/ioutil"
+	"runtime"
+	"strconv"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+// checkNiceness checks the niceness of all threads of the process
+func checkNiceness(t *testing.T) {
+	tasks, err := ioutil.ReadDir("/proc/self/task")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, task := range tasks {
+		tid, _ := strconv.Atoi(task.Name())
+		priority, err := syscall.Getpriority(syscall.PRIO_PROCESS, tid)
+		if err != nil {
+			continue
+		}
+
+		// The raw syscall returns 20 - niceness
+		if nice := 20 - priority; nice != lowPriorityNice {
+			t.Errorf("Expected niceness %d of thread %d, got %d", lowPriorityNice, tid, nice)
+		}
+	}
+}
+
+func TestSetLowPriority(t *testing.T) {
+	err := setLowPriority()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkNiceness(t)
+
+	// Locked goroutines force the runtime to start new threads, they inherit the niceness
+	var wg sync.WaitGroup
+	release := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runtime.LockOSThread()
+			defer runtime.UnlockOSThread()
+			<-release
+		}()
+	}
+	runtime.Gosched()
+	checkNiceness(t)
+	close(release)
+	wg.Wait()
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package drivers
+
+const lowPrioritySupported = false
+
+// setLowPriority keeps the normal priority, the niceness of the threads is only supported on Linux
+func setLowPriority() error {
+	return nil
+}
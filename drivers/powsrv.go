@@ -0,0 +1,82 @@
+package drivers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/iotaledger/giota"
+
+	"github.com/muxxer/powsrv"
+)
+
+func init() {
+	powsrv.RegisterDriver("powsrv", func() powsrv.PowDriver { return &powSrvDriver{} })
+}
+
+// powSrvDriver forwards the POW to another powSrv
+type powSrvDriver struct {
+	client     *powsrv.PowClient
+	powType    string
+	powVersion string
+
+	mutex  sync.Mutex
+	cancel context.CancelFunc // Cancels the running POW request, nil if none is running
+}
+
+// Init connects to the other powSrv, Device is its address
+func (d *powSrvDriver) Init(config powsrv.PowConfigDevice) error {
+	d.client = &powsrv.PowClient{Network: config.Network, Address: config.Device, AuthToken: config.AuthToken, WriteTimeOutMs: 500, ReadTimeOutMs: 120000}
+	err := d.client.Init()
+	if err != nil {
+		return fmt.Errorf("Connection to powSrv \"%v\" failed: %v", config.Device, err)
+	}
+
+	_, remotePowType, remotePowVersion, err := d.client.GetPowInfo()
+	if err != nil {
+		d.client.Close()
+		return fmt.Errorf("Connection to powSrv \"%v\" failed: %v", config.Device, err)
+	}
+	d.powType = fmt.Sprintf("powSrv (%v)", remotePowType)
+	d.powVersion = remotePowVersion
+	return nil
+}
+
+func (d *powSrvDriver) Pow(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	d.mutex.Lock()
+	d.cancel = cancel
+	d.mutex.Unlock()
+
+	defer func() {
+		d.mutex.Lock()
+		d.cancel = nil
+		d.mutex.Unlock()
+		cancel()
+	}()
+
+	return d.client.PowFuncWithContext(ctx, trytes, mwm)
+}
+
+// Abort cancels the running POW request, the other powSrv stops it if it is still queued
+func (d *powSrvDriver) Abort() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.cancel != nil {
+		d.cancel()
+	}
+	return nil
+}
+
+func (d *powSrvDriver) Type() string {
+	return d.powType
+}
+
+func (d *powSrvDriver) Version() string {
+	return d.powVersion
+}
+
+func (d *powSrvDriver) Close() error {
+	return d.client.Close()
+}
@@ -0,0 +1,171 @@
+package powsrv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/muxxer/powsrv/ipc"
+)
+
+// ErrNotConnected is returned if a request is sent before Init was called successfully
+var ErrNotConnected = errors.New("Not connected to powSrv")
+
+// ErrConnectionLost is returned for requests that were in-flight when the connection to the powSrv dropped
+var ErrConnectionLost = errors.New("Connection to powSrv lost")
+
+// ErrTimeout is returned if the powSrv didn't answer within ReadTimeOutMs
+// errors.Is(ErrTimeout, context.DeadlineExceeded) is true, like for a context that expired.
+var ErrTimeout error = timeoutError{}
+
+// ErrServerBusy is returned if all devices of the powSrv are busy and its queue is full
+var ErrServerBusy = errors.New("powSrv is busy")
+
+// ErrAuthRequired is returned if the powSrv requires an AuthToken, but none is set
+var ErrAuthRequired = errors.New("powSrv requires authentication")
+
+// ErrAuthFailed is returned if the AuthToken doesn't match the token of the powSrv
+var ErrAuthFailed = errors.New("Authentication at powSrv failed")
+
+// ErrMWMTooHigh is returned if the MinWeightMagnitude is higher than the maximum of the powSrv
+var ErrMWMTooHigh = errors.New("MinWeightMagnitude too high for powSrv")
+
+// ErrMWMTooLow is returned if the MinWeightMagnitude is lower than the minimum of the powSrv
+var ErrMWMTooLow = errors.New("MinWeightMagnitude too low for powSrv")
+
+// ErrInvalidTrytes is returned if the powSrv rejected the trytes of the transactions
+var ErrInvalidTrytes = errors.New("Invalid transaction trytes")
+
+// ErrNoSuchDevice is returned if no device of the powSrv matches the PreferredDevice
+var ErrNoSuchDevice = errors.New("No such POW device")
+
+// ErrNoDevice is returned if no device of the powSrv can do the POW, e.g. because the device of the PreferredDevice was lost
+var ErrNoDevice = errors.New("No POW device of powSrv available")
+
+// ErrPowTimeout is returned if the device of the powSrv didn't finish the POW within its job timeout
+var ErrPowTimeout = errors.New("POW timeout of powSrv expired")
+
+// ErrUnsupportedAlgorithm is returned if no device of the powSrv supports the POW algorithm of the request
+var ErrUnsupportedAlgorithm = errors.New("POW algorithm not supported by powSrv")
+
+// ErrInvalidNonce is returned if the hash of the transaction with the nonce of the powSrv doesn't reach the MWM (PowClient.VerifyResults)
+// errors.As with an *InvalidNonceError gives the achieved weight.
+var ErrInvalidNonce = errors.New("Invalid nonce")
+
+// ErrPipeUnsupported is returned for the network "pipe" on other platforms than Windows
+var ErrPipeUnsupported = errors.New("Named pipes are only supported on Windows")
+
+// serverErrorCodes maps the error codes of the powSrv to the errors of the client
+var serverErrorCodes = map[string]error{
+	ipc.ErrorQueueFull:            ErrServerBusy,
+	ipc.ErrorAuthRequired:         ErrAuthRequired,
+	ipc.ErrorAuthFailed:           ErrAuthFailed,
+	ipc.ErrorMwmTooHigh:           ErrMWMTooHigh,
+	ipc.ErrorMwmTooLow:            ErrMWMTooLow,
+	ipc.ErrorInvalidTrytes:        ErrInvalidTrytes,
+	ipc.ErrorNoSuchDevice:         ErrNoSuchDevice,
+	ipc.ErrorNoDevice:             ErrNoDevice,
+	ipc.ErrorPowTimeout:           ErrPowTimeout,
+	ipc.ErrorUnsupportedAlgorithm: ErrUnsupportedAlgorithm,
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string {
+	return "powSrv did not answer in time"
+}
+
+func (timeoutError) Is(target error) bool {
+	return target == context.DeadlineExceeded
+}
+
+// InvalidNonceError is returned if the transaction hash has less trailing zero trits than the MWM
+// errors.Is(err, ErrInvalidNonce) is true.
+type InvalidNonceError struct {
+	Weight int // Trailing zero trits of the transaction hash
+	MWM    int // Requested MinWeightMagnitude
+}
+
+func (e *InvalidNonceError) Error() string {
+	return fmt.Sprintf("%v: weight %d is below MWM %d", ErrInvalidNonce, e.Weight, e.MWM)
+}
+
+func (e *InvalidNonceError) Is(target error) bool {
+	return target == ErrInvalidNonce
+}
+
+// ServerError is an error that was reported by the powSrv
+// Use errors.Is to check for the well-known errors, e.g. errors.Is(err, ErrServerBusy).
+type ServerError struct {
+	Code    string // Machine-readable code, e.g. ipc.ErrorQueueFull (empty for servers that don't send codes)
+	Message string // Details of the error, e.g. the allowed maximum for ipc.ErrorMwmTooHigh
+}
+
+// newServerError creates a ServerError with a formatted message
+func newServerError(code string, format string, args ...interface{}) *ServerError {
+	return &ServerError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func (e *ServerError) Error() string {
+	if e.Code == "" {
+		return e.Message
+	}
+	if e.Message == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Message
+}
+
+// Is returns true if target is the client error of the code
+func (e *ServerError) Is(target error) bool {
+	return (e.Code != "") && (serverErrorCodes[e.Code] == target)
+}
+
+// payload returns the CmdError message of the error, the code is the first token
+func (e *ServerError) payload() []byte {
+	if (e.Code == "") || (e.Message == "") {
+		return []byte(e.Code + e.Message)
+	}
+	return []byte(e.Code + " " + e.Message)
+}
+
+// toServerError returns err as ServerError, errors without code get the given code
+func toServerError(err error, code string) *ServerError {
+	var serverErr *ServerError
+	if errors.As(err, &serverErr) {
+		return serverErr
+	}
+	return &ServerError{Code: code, Message: err.Error()}
+}
+
+// parseServerError parses the message of a CmdError frame
+// Older servers don't send a code, their messages are returned as ServerError without code.
+func parseServerError(data []byte) *ServerError {
+	message := string(data)
+
+	code := message
+	details := ""
+	if i := strings.IndexByte(message, ' '); i != -1 {
+		code = message[:i]
+		details = message[i+1:]
+	}
+
+	if !isErrorCode(code) {
+		return &ServerError{Message: message}
+	}
+	return &ServerError{Code: code, Message: details}
+}
+
+// isErrorCode returns true if s looks like an error code, e.g. "QUEUE_FULL"
+func isErrorCode(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if !(((c >= 'A') && (c <= 'Z')) || ((c >= '0') && (c <= '9')) || (c == '_')) {
+			return false
+		}
+	}
+	return true
+}
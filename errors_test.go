@@ -0,0 +1,64 @@
+package powsrv
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/muxxer/powsrv/ipc"
+)
+
+func TestParseServerError(t *testing.T) {
+	tests := []struct {
+		data    string
+		code    string
+		message string
+		err     error
+	}{
+		{"QUEUE_FULL", ipc.ErrorQueueFull, "", ErrServerBusy},
+		{"AUTH_REQUIRED", ipc.ErrorAuthRequired, "", ErrAuthRequired},
+		{"AUTH_FAILED", ipc.ErrorAuthFailed, "", ErrAuthFailed},
+		{"MWM_TOO_HIGH 14", ipc.ErrorMwmTooHigh, "14", ErrMWMTooHigh},
+		{"INVALID_TRYTES", ipc.ErrorInvalidTrytes, "", ErrInvalidTrytes},
+		{"POW_FAILED device not responding", ipc.ErrorPowFailed, "device not responding", nil},
+		{"SOME_FUTURE_ERROR with details", "SOME_FUTURE_ERROR", "with details", nil},
+		// Older servers send plain messages without a code
+		{"Unknown command", "", "Unknown command", nil},
+		{"", "", "", nil},
+	}
+
+	for _, test := range tests {
+		serverErr := parseServerError([]byte(test.data))
+		if (serverErr.Code != test.code) || (serverErr.Message != test.message) {
+			t.Errorf("%q: expected code %q and message %q, got %q and %q", test.data, test.code, test.message, serverErr.Code, serverErr.Message)
+		}
+
+		if test.err != nil && !errors.Is(serverErr, test.err) {
+			t.Errorf("%q: expected errors.Is %v", test.data, test.err)
+		}
+		if errors.Is(serverErr, ErrServerBusy) && (test.err != ErrServerBusy) {
+			t.Errorf("%q: unexpected errors.Is ErrServerBusy", test.data)
+		}
+
+		if string(serverErr.payload()) != test.data {
+			t.Errorf("%q: payload doesn't round trip: %q", test.data, serverErr.payload())
+		}
+	}
+}
+
+func TestServerErrorAs(t *testing.T) {
+	var err error = &RateLimitError{RetryAfter: time.Second, err: parseServerError([]byte("RATE_LIMITED 1000"))}
+
+	var serverErr *ServerError
+	if !errors.As(err, &serverErr) {
+		t.Fatal("Expected RateLimitError to unwrap to a ServerError")
+	}
+	if serverErr.Code != ipc.ErrorRateLimited {
+		t.Errorf("Expected code %v, got %v", ipc.ErrorRateLimited, serverErr.Code)
+	}
+
+	if !errors.Is(ErrTimeout, context.DeadlineExceeded) {
+		t.Error("Expected ErrTimeout to be context.DeadlineExceeded")
+	}
+}
@@ -0,0 +1,144 @@
+package powsrv
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/muxxer/powsrv/logs"
+)
+
+/*
+	POW events
+	==========
+
+	Consumers that connect to the events listener ("server.eventsAddress") receive a line of JSON for every POW
+	that was finished by a device from connect-time onward:
+
+	{"timestamp":"2020-01-02T15:04:05.123Z","deviceIndex":0,"powType":"PiDiver","mwm":14,"durationMs":512,"requester":"192.168.1.20:51234 (tcp)","success":true}
+	{"timestamp":"2020-01-02T15:04:06.456Z","deviceIndex":1,"powType":"gIOTA-PowC","mwm":14,"durationMs":3,"success":false,"error":"POW_FAILED: ..."}
+
+	The consumers don't send anything. A consumer that can't keep up with the events is disconnected, so it never
+	slows down the POW requests.
+*/
+
+const (
+	eventBufferSize   = 256 // Events that are queued for a consumer before it is dropped
+	eventWriteTimeout = 5 * time.Second
+)
+
+// PowEvent is published for every POW that was finished by a device
+type PowEvent struct {
+	Timestamp   time.Time `json:"timestamp"`           // Start of the POW
+	DeviceIndex int       `json:"deviceIndex"`         // Index of the device in the device list
+	PowType     string    `json:"powType"`             // Type of the device, e.g. "PiDiver"
+	MWM         int       `json:"mwm"`                 // MinWeightMagnitude of the request
+	DurationMs  int64     `json:"durationMs"`          // Duration of the POW on the device
+	Requester   string    `json:"requester,omitempty"` // Remote address of the client, empty for POW requests of the powSrv itself
+	Success     bool      `json:"success"`
+	Error       string    `json:"error,omitempty"` // Reason of the failure
+}
+
+// eventConsumer is a connection that receives the POW events
+type eventConsumer struct {
+	conn   net.Conn
+	events chan []byte // Lines that wait to be written, closed as soon as the consumer is dropped
+}
+
+var eventConsumers = make(map[*eventConsumer]struct{})
+var eventConsumersMutex = &sync.Mutex{}
+
+// ServeEvents publishes the POW events to every connection of the listener until the listener is closed
+func ServeEvents(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+
+		consumer := &eventConsumer{conn: conn, events: make(chan []byte, eventBufferSize)}
+		eventConsumersMutex.Lock()
+		eventConsumers[consumer] = struct{}{}
+		eventConsumersMutex.Unlock()
+		logs.Log.Debugf("Event consumer %v connected", conn.RemoteAddr())
+
+		go consumer.writeEvents()
+		go func() {
+			// The consumers don't send anything, the read only returns as soon as the connection is closed
+			io.Copy(ioutil.Discard, conn)
+			consumer.drop()
+		}()
+	}
+}
+
+// writeEvents writes the queued events to the consumer until it is dropped
+func (c *eventConsumer) writeEvents() {
+	defer c.conn.Close()
+
+	for line := range c.events {
+		c.conn.SetWriteDeadline(time.Now().Add(eventWriteTimeout))
+		_, err := c.conn.Write(line)
+		if err != nil {
+			logs.Log.Debugf("Event consumer %v dropped: %v", c.conn.RemoteAddr(), err)
+			c.drop()
+			return
+		}
+	}
+}
+
+// drop removes the consumer, its connection is closed by writeEvents
+func (c *eventConsumer) drop() {
+	eventConsumersMutex.Lock()
+	defer eventConsumersMutex.Unlock()
+
+	if _, ok := eventConsumers[c]; ok {
+		delete(eventConsumers, c)
+		close(c.events)
+	}
+}
+
+// publishPowEvent sends the event of a finished POW to all consumers
+func publishPowEvent(device *PowDevice, job *powJob, started time.Time, duration time.Duration, err error) {
+	eventConsumersMutex.Lock()
+	defer eventConsumersMutex.Unlock()
+
+	if len(eventConsumers) == 0 {
+		return
+	}
+
+	event := PowEvent{
+		Timestamp:   started.UTC(),
+		DeviceIndex: device.Index,
+		PowType:     device.PowType,
+		MWM:         job.mwm,
+		DurationMs:  duration.Milliseconds(),
+		Success:     err == nil,
+	}
+	if job.owner != nil {
+		event.Requester = job.owner.address
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		logs.Log.Warningf("POW event could not be created: %v", err)
+		return
+	}
+	line = append(line, '\n')
+
+	for consumer := range eventConsumers {
+		select {
+		case consumer.events <- line:
+		default:
+			// A slow consumer must not block the worker
+			logs.Log.Warningf("Event consumer %v dropped, too many pending events", consumer.conn.RemoteAddr())
+			delete(eventConsumers, consumer)
+			close(consumer.events)
+		}
+	}
+}
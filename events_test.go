@@ -0,0 +1,119 @@
+package powsrv
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/iotaledger/giota"
+)
+
+func TestPowEvents(t *testing.T) {
+	path, cleanup := startTestServer(t, false)
+	defer cleanup()
+
+	var fail int32
+	SetPowDevices([]*PowDevice{{PowType: "test", PowFunc: func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		if atomic.LoadInt32(&fail) == 1 {
+			return "", errors.New("device broken")
+		}
+		return fakeNonce, nil
+	}}})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go ServeEvents(ln)
+
+	consumer, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Close()
+	consumer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	events := bufio.NewScanner(consumer)
+
+	// Events are only published to the consumers that are already registered
+	for i := 0; ; i++ {
+		eventConsumersMutex.Lock()
+		registered := len(eventConsumers) == 1
+		eventConsumersMutex.Unlock()
+		if registered {
+			break
+		}
+		if i == 100 {
+			t.Fatal("Consumer was not registered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	powClient := &PowClient{PowSrvPath: path, WriteTimeOutMs: 500, ReadTimeOutMs: 5000}
+	err = powClient.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer powClient.Close()
+
+	nextEvent := func() PowEvent {
+		if !events.Scan() {
+			t.Fatalf("No event received: %v", events.Err())
+		}
+		var event PowEvent
+		err := json.Unmarshal(events.Bytes(), &event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return event
+	}
+
+	ts := time.Now()
+	_, err = powClient.PowFunc(giota.Trytes(transaction), MWM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := nextEvent()
+	if !event.Success || (event.Error != "") || (event.PowType != "test") || (event.DeviceIndex != 0) || (event.MWM != MWM) ||
+		(event.Requester != path+" (unix)") || (event.DurationMs < 0) || event.Timestamp.Before(ts.Add(-time.Second)) {
+		t.Errorf("Wrong event of a successful POW: %+v", event)
+	}
+
+	atomic.StoreInt32(&fail, 1)
+	_, err = powClient.PowFunc(giota.Trytes(transaction), 9)
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+	event = nextEvent()
+	if event.Success || (event.Error == "") || (event.MWM != 9) || (event.Requester != path+" (unix)") {
+		t.Errorf("Wrong event of a failed POW: %+v", event)
+	}
+}
+
+func TestPowEventsSlowConsumer(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	// The consumer never reads, its buffer fills up
+	consumer := &eventConsumer{conn: server, events: make(chan []byte, eventBufferSize)}
+	eventConsumersMutex.Lock()
+	eventConsumers[consumer] = struct{}{}
+	eventConsumersMutex.Unlock()
+
+	device := &PowDevice{PowType: "test"}
+	job := &powJob{mwm: MWM}
+	for i := 0; i <= eventBufferSize; i++ {
+		publishPowEvent(device, job, time.Now(), time.Millisecond, nil)
+	}
+
+	eventConsumersMutex.Lock()
+	_, registered := eventConsumers[consumer]
+	eventConsumersMutex.Unlock()
+	if registered {
+		t.Error("Slow consumer was not dropped")
+	}
+}
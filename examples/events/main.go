@@ -0,0 +1,52 @@
+/*
+Command events prints the POW events of a powSrv ("server.eventsAddress").
+
+	go run ./examples/events --address localhost:5002
+*/
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+
+	flag "github.com/spf13/pflag"
+
+	"github.com/muxxer/powsrv"
+)
+
+func main() {
+	address := flag.String("address", "localhost:5002", "Address of the events listener of the powSrv")
+	flag.Parse()
+
+	conn, err := net.Dial("tcp", *address)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		var event powsrv.PowEvent
+		err := json.Unmarshal(scanner.Bytes(), &event)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid event: %v\n", err)
+			continue
+		}
+
+		status := "ok"
+		if !event.Success {
+			status = "failed: " + event.Error
+		}
+		fmt.Printf("%v [%d] %v MWM %d %d ms %v %v\n", event.Timestamp.Format("15:04:05.000"), event.DeviceIndex, event.PowType, event.MWM, event.DurationMs, event.Requester, status)
+	}
+
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Fprintln(os.Stderr, "Connection closed by the powSrv")
+}
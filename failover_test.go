@@ -0,0 +1,134 @@
+package powsrv
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/iotaledger/giota"
+
+	"github.com/muxxer/powsrv/ipc"
+)
+
+// flakyDriver fails the POW as long as fail is set
+type flakyDriver struct {
+	fail  int32
+	calls int32
+}
+
+func (d *flakyDriver) Init(config PowConfigDevice) error {
+	return nil
+}
+
+func (d *flakyDriver) Pow(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+	atomic.AddInt32(&d.calls, 1)
+	if atomic.LoadInt32(&d.fail) == 1 {
+		return "", errors.New("USB error")
+	}
+	return fakeNonce, nil
+}
+
+func (d *flakyDriver) Type() string {
+	return "Flaky"
+}
+
+func (d *flakyDriver) Version() string {
+	return ""
+}
+
+func (d *flakyDriver) Close() error {
+	return nil
+}
+
+// setFail lets the following POWs of the driver fail or succeed
+func (d *flakyDriver) setFail(fail bool) {
+	var value int32
+	if fail {
+		value = 1
+	}
+	atomic.StoreInt32(&d.fail, value)
+}
+
+func TestPowFailover(t *testing.T) {
+	SetPowFailover(true)
+	defer SetPowFailover(false)
+
+	// The idle devices take turns, so every device gets requests
+	err := SetSchedulerPolicy(SchedulerRoundRobin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer SetSchedulerPolicy(SchedulerFirstIdle)
+
+	failing, working := &flakyDriver{}, &flakyDriver{}
+	failing.setFail(true)
+	SetPowDevices([]*PowDevice{NewPowDeviceFromDriver(0, failing), NewPowDeviceFromDriver(1, working)})
+
+	for i := 0; i < 10; i++ {
+		result, err := PowFunc(giota.Trytes(transaction), MWM)
+		if (err != nil) || (result != fakeNonce) {
+			t.Fatalf("Request was not retried on the working device: %v, %v", result, err)
+		}
+	}
+	if atomic.LoadInt32(&failing.calls) == 0 {
+		t.Error("Failing device was not used")
+	}
+
+	// The error is returned as soon as all devices failed, every device is tried once
+	working.setFail(true)
+	atomic.StoreInt32(&failing.calls, 0)
+	atomic.StoreInt32(&working.calls, 0)
+	_, err = PowFunc(giota.Trytes(transaction), MWM)
+	if err == nil {
+		t.Fatal("Expected the error of the devices")
+	}
+	if (atomic.LoadInt32(&failing.calls) != 1) || (atomic.LoadInt32(&working.calls) != 1) {
+		t.Errorf("Expected a single POW per device, got %d and %d", failing.calls, working.calls)
+	}
+
+	// Without failover, the error of the first device is returned
+	SetPowFailover(false)
+	working.setFail(false)
+	SetPowDevices([]*PowDevice{NewPowDeviceFromDriver(0, failing)})
+	_, err = PowFunc(giota.Trytes(transaction), MWM)
+	if err == nil {
+		t.Error("Expected the error of the device")
+	}
+}
+
+func TestMaxConsecutiveErrors(t *testing.T) {
+	SetMaxConsecutiveErrors(3)
+	defer SetMaxConsecutiveErrors(0)
+
+	driver := &flakyDriver{}
+	device := NewPowDeviceFromDriver(0, driver)
+	SetPowDevices([]*PowDevice{device})
+
+	pow := func(count int) {
+		for i := 0; i < count; i++ {
+			PowFunc(giota.Trytes(transaction), MWM)
+		}
+	}
+
+	// A successful POW resets the counter
+	driver.setFail(true)
+	pow(2)
+	driver.setFail(false)
+	pow(1)
+	driver.setFail(true)
+	pow(2)
+	if device.Disabled() {
+		t.Fatal("Device disabled without 3 errors in a row")
+	}
+
+	pow(1)
+	if !device.Disabled() {
+		t.Fatal("Device not disabled after 3 errors in a row")
+	}
+
+	_, err := PowFunc(giota.Trytes(transaction), MWM)
+	var serverErr *ServerError
+	if !errors.As(err, &serverErr) || (serverErr.Code != ipc.ErrorNoDevice) {
+		t.Errorf("Expected NO_DEVICE, got: %v", err)
+	}
+}
@@ -0,0 +1,27 @@
+//go:build hardware
+
+// The tests in this file need a running powSrv with POW hardware: go test -tags=hardware
+
+package powsrv
+
+import (
+	"os"
+	"testing"
+)
+
+// socketPath is the Unix socket of the powSrv with the POW hardware
+var socketPath = "/tmp/powSrv.sock"
+
+func TestPOW(t *testing.T) {
+	testPOW(t, &PowClient{PowSrvPath: socketPath, WriteTimeOutMs: 500, ReadTimeOutMs: 5000}, 10000)
+}
+
+// TestPOWTCP runs against a powSrv listening on the TCP address in POWSRV_TEST_TCP_ADDRESS
+func TestPOWTCP(t *testing.T) {
+	address := os.Getenv("POWSRV_TEST_TCP_ADDRESS")
+	if address == "" {
+		t.Skip("POWSRV_TEST_TCP_ADDRESS not set")
+	}
+
+	testPOW(t, &PowClient{Network: "tcp", Address: address, WriteTimeOutMs: 500, ReadTimeOutMs: 5000}, 10000)
+}
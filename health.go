@@ -0,0 +1,112 @@
+package powsrv
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/iotaledger/giota"
+)
+
+const (
+	healthCheckMWM     = 9               // Trivial POW to check the devices
+	HealthCheckTimeout = 5 * time.Second // Deadline of the POW of a single device
+)
+
+// DeviceHealth is the result of the health check of a single POW device
+type DeviceHealth struct {
+	Index     int     `json:"index"`
+	PowType   string  `json:"powType"`
+	OK        bool    `json:"ok"`
+	Busy      bool    `json:"busy"` // Device was busy, the last POW result is used
+	LatencyMs float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Healthy returns true if all devices passed the health check
+func Healthy(health []DeviceHealth) bool {
+	if len(health) == 0 {
+		return false
+	}
+
+	for _, device := range health {
+		if !device.OK {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckHealth does a trivial POW on every idle device
+// Busy devices are not interrupted, they are reported as healthy if their last POW succeeded
+func CheckHealth(timeout time.Duration) []DeviceHealth {
+	d := getDispatcher()
+	devices := d.currentDevices()
+
+	results := make(chan DeviceHealth, len(devices))
+	for _, device := range devices {
+		go func(device *PowDevice) {
+			results <- d.checkDeviceHealth(device, timeout)
+		}(device)
+	}
+
+	health := make([]DeviceHealth, len(devices))
+	for range devices {
+		result := <-results
+		for i, device := range devices {
+			if device.Index == result.Index {
+				health[i] = result
+			}
+		}
+	}
+	return health
+}
+
+// checkDeviceHealth does a trivial POW on the device
+// If the device does not answer within the timeout, the POW keeps running in the background
+func (d *powDispatcher) checkDeviceHealth(device *PowDevice, timeout time.Duration) DeviceHealth {
+	health := DeviceHealth{Index: device.Index, PowType: device.PowType}
+
+	if device.Disabled() {
+		health.Error = "disabled"
+		return health
+	}
+
+	d.mutex.Lock()
+	health.Busy = device.busy
+	d.mutex.Unlock()
+
+	if health.Busy {
+		health.OK = !device.lastPowFailed()
+		if !health.OK {
+			health.Error = "last POW failed"
+		}
+		return health
+	}
+
+	trytes, _ := giota.ToTrytes(strings.Repeat("9", 2673))
+
+	finished := make(chan error, 1)
+	ts := time.Now()
+	go func() {
+		device.PowMutex.Lock()
+		defer device.PowMutex.Unlock()
+
+		_, err := device.PowFunc(trytes, healthCheckMWM)
+		finished <- err
+	}()
+
+	var err error
+	select {
+	case err = <-finished:
+	case <-time.After(timeout):
+		err = errors.New("timeout")
+	}
+
+	health.LatencyMs = durationToMs(time.Since(ts))
+	health.OK = (err == nil)
+	if err != nil {
+		health.Error = err.Error()
+	}
+	return health
+}
@@ -0,0 +1,375 @@
+package powsrv
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/iotaledger/giota"
+	"github.com/spf13/viper"
+
+	"github.com/muxxer/powsrv/ipc"
+	"github.com/muxxer/powsrv/logs"
+)
+
+/*
+	HTTP API
+	========
+
+	The HTTP listener ("server.httpAddress") accepts the attachToTangle command of the IRI HTTP API:
+
+	POST / {"command": "attachToTangle", "trunkTransaction": "...", "branchTransaction": "...", "minWeightMagnitude": 14, "trytes": ["...", "..."]}
+	=> {"trytes": ["...", "..."], "duration": 120}
+
+	The transactions are chained like IRI does: the first transaction references trunkTransaction and branchTransaction,
+	every following one the previous transaction and trunkTransaction. The result has the reversed order of the request.
+
+	Single transactions are attached with the simple pow command:
+
+	POST / {"command": "pow", "trytes": "...", "mwm": 14}
+	=> {"trytes": "...", "nonce": "...", "duration": 120}
+
+	Errors are answered with {"error": "..."} and a 4xx/5xx status code.
+	If "server.authToken" is set, the requests need the header "Authorization: Bearer <token>".
+*/
+
+const (
+	// maxHTTPRequestSize is the maximum size of a request body, enough for bundles of about 350 transactions
+	maxHTTPRequestSize = 1 << 20
+
+	// Offsets of the fields of a transaction in trytes
+	trunkTransactionOffset       = 2430
+	branchTransactionOffset      = 2511
+	attachmentTimestampOffset    = 2619
+	attachmentTimestampEndOffset = 2646
+	hashTrytesSize               = 81
+	nonceTrytesSize              = 27
+	timestampTrytesSize          = 9
+)
+
+// maxAttachmentTimestamp is the upper bound of the attachment timestamp, the highest value of 27 trits
+const maxAttachmentTimestamp = "MMMMMMMMM"
+
+// httpRequest contains the fields of all commands of the HTTP API
+type httpRequest struct {
+	Command string `json:"command"`
+
+	// attachToTangle
+	TrunkTransaction   string          `json:"trunkTransaction"`
+	BranchTransaction  string          `json:"branchTransaction"`
+	MinWeightMagnitude *int            `json:"minWeightMagnitude"`
+	Trytes             json.RawMessage `json:"trytes"` // List of transactions for attachToTangle, single transaction for pow
+
+	// pow
+	Mwm *int `json:"mwm"`
+}
+
+// httpError is an error of the HTTP API with its status code
+type httpError struct {
+	status int
+	err    error
+}
+
+func (e *httpError) Error() string {
+	return e.err.Error()
+}
+
+// badRequest returns an error with the status 400
+func badRequest(err error) *httpError {
+	return &httpError{status: http.StatusBadRequest, err: err}
+}
+
+// HTTPHandler returns the HTTP handler of the IRI-compatible attachToTangle API
+func HTTPHandler(config *viper.Viper) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		log := logs.WithFields(logs.Fields{"component": "http", "remoteAddr": r.RemoteAddr, "corrID": logs.NewCorrelationID()})
+		response, err := handleHTTPRequest(w, r, log, config)
+		if err != nil {
+			status := http.StatusInternalServerError
+			var httpErr *httpError
+			if errors.As(err, &httpErr) {
+				status = httpErr.status
+			} else {
+				status = errorStatus(err)
+			}
+
+			log.Debugf("HTTP request failed: %v", err)
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+
+		json.NewEncoder(w).Encode(response)
+	})
+}
+
+// handleHTTPRequest checks and executes a request of the HTTP API
+func handleHTTPRequest(w http.ResponseWriter, r *http.Request, log *logs.Entry, config *viper.Viper) (interface{}, error) {
+	if r.Method != http.MethodPost {
+		return nil, &httpError{status: http.StatusMethodNotAllowed, err: errors.New("Only POST requests are supported")}
+	}
+
+	if token := config.GetString("server.authToken"); token != "" {
+		auth := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(auth), []byte(token)) != 1 {
+			return nil, &httpError{status: http.StatusUnauthorized, err: ErrAuthFailed}
+		}
+	}
+
+	var request httpRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxHTTPRequestSize)).Decode(&request)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, &httpError{status: http.StatusRequestEntityTooLarge, err: errors.New("Request body too large")}
+		}
+		return nil, badRequest(errors.New("Invalid JSON request"))
+	}
+
+	owner := &jobOwner{address: r.RemoteAddr + " (http)"}
+	switch request.Command {
+	case "attachToTangle":
+		return attachToTangle(r.Context(), owner, log, &request, config)
+	case "pow":
+		return httpPow(r.Context(), owner, log, &request, config)
+	default:
+		return nil, badRequest(errors.New("Unknown command: " + request.Command))
+	}
+}
+
+// errorStatus returns the HTTP status of an error of the dispatcher
+func errorStatus(err error) int {
+	var serverErr *ServerError
+	if !errors.As(err, &serverErr) {
+		return http.StatusInternalServerError
+	}
+
+	switch serverErr.Code {
+	case ipc.ErrorQueueFull, ipc.ErrorNoDevice, ipc.ErrorShuttingDown:
+		return http.StatusServiceUnavailable
+	case ipc.ErrorMwmTooHigh, ipc.ErrorMwmTooLow, ipc.ErrorInvalidTrytes, ipc.ErrorInvalidRequest:
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// checkHTTPMwm returns an error if the MinWeightMagnitude is missing or outside the limits of the server
+func checkHTTPMwm(mwm *int, config *viper.Viper) error {
+	if mwm == nil {
+		return badRequest(errors.New("MinWeightMagnitude missing"))
+	}
+	if (*mwm < 0) || (*mwm > 243) {
+		return badRequest(errors.New("MinWeightMagnitude out of range [0-243]"))
+	}
+	minMwm, maxMwm := mwmLimits(config)
+	if *mwm > maxMwm {
+		return newServerError(ipc.ErrorMwmTooHigh, "%d", maxMwm)
+	}
+	if *mwm < minMwm {
+		return newServerError(ipc.ErrorMwmTooLow, "%d", minMwm)
+	}
+	return nil
+}
+
+// parseHash returns the trytes of a transaction hash
+func parseHash(hash string) (giota.Trytes, error) {
+	trytes, err := giota.ToTrytes(hash)
+	if (err != nil) || (len(trytes) != hashTrytesSize) {
+		return "", badRequest(errors.New("Invalid transaction hash: " + hash))
+	}
+	return trytes, nil
+}
+
+// attachToTangle does the POW of the transactions of a bundle like the attachToTangle command of IRI
+func attachToTangle(ctx context.Context, owner *jobOwner, log *logs.Entry, request *httpRequest, config *viper.Viper) (interface{}, error) {
+	err := checkHTTPMwm(request.MinWeightMagnitude, config)
+	if err != nil {
+		return nil, err
+	}
+
+	trunk, err := parseHash(request.TrunkTransaction)
+	if err != nil {
+		return nil, err
+	}
+	branch, err := parseHash(request.BranchTransaction)
+	if err != nil {
+		return nil, err
+	}
+
+	var trytes []string
+	err = json.Unmarshal(request.Trytes, &trytes)
+	if (err != nil) || (len(trytes) == 0) {
+		return nil, badRequest(errors.New("trytes must be a list of transactions"))
+	}
+
+	var transactions []giota.Trytes
+	for _, t := range trytes {
+		transaction, err := parseTransaction([]byte(t))
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, transaction)
+	}
+
+	ts := time.Now()
+	result := make([]string, len(transactions))
+	var previous giota.Trytes
+	for i, transaction := range transactions {
+		if i == 0 {
+			transaction = setApprovees(transaction, trunk, branch)
+		} else {
+			transaction = setApprovees(transaction, previous, trunk)
+		}
+		transaction = setAttachmentTimestamp(transaction, time.Now())
+
+		transaction, err = httpPowTransaction(ctx, owner, log.WithFields(logs.Fields{"batchIndex": i}), transaction, *request.MinWeightMagnitude)
+		if err != nil {
+			return nil, err
+		}
+
+		tx, err := giota.NewTransaction(transaction)
+		if err != nil {
+			return nil, err
+		}
+		previous = tx.Hash()
+
+		// IRI returns the transactions in the reversed order
+		result[len(transactions)-1-i] = string(transaction)
+	}
+
+	log.Infof("attachToTangle of %d transactions completed in %d ms", len(result), time.Since(ts).Milliseconds())
+	return map[string]interface{}{"trytes": result, "duration": time.Since(ts).Milliseconds()}, nil
+}
+
+// httpPow does the POW of a single transaction
+func httpPow(ctx context.Context, owner *jobOwner, log *logs.Entry, request *httpRequest, config *viper.Viper) (interface{}, error) {
+	err := checkHTTPMwm(request.Mwm, config)
+	if err != nil {
+		return nil, err
+	}
+
+	var trytes string
+	err = json.Unmarshal(request.Trytes, &trytes)
+	if err != nil {
+		return nil, badRequest(errors.New("trytes must be a single transaction"))
+	}
+
+	transaction, err := parseTransaction([]byte(trytes))
+	if err != nil {
+		return nil, err
+	}
+
+	ts := time.Now()
+	transaction, err = httpPowTransaction(ctx, owner, log, transaction, *request.Mwm)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{"trytes": transaction, "nonce": transaction[transactionTrytesSize-nonceTrytesSize:], "duration": time.Since(ts).Milliseconds()}, nil
+}
+
+// httpPowTransaction does the POW of a transaction with the dispatcher and returns the transaction with the nonce
+// The job is removed from the queue if the HTTP client disconnects.
+func httpPowTransaction(ctx context.Context, owner *jobOwner, log *logs.Entry, transaction giota.Trytes, mwm int) (giota.Trytes, error) {
+	if !startRequest() {
+		return "", &ServerError{Code: ipc.ErrorShuttingDown}
+	}
+
+	countRequest()
+
+	if nonce, hit := resultCache.get(transaction, mwm); hit {
+		log.WithFields(logs.Fields{"mwm": mwm}).Infof("PoW result taken from the cache, MWM %d", mwm)
+		finishRequest()
+		return transaction[:transactionTrytesSize-nonceTrytesSize] + nonce, nil
+	}
+
+	type powResult struct {
+		nonce giota.Trytes
+		err   error
+	}
+	resultChan := make(chan powResult, 1)
+
+	// The result is cached by the callback, so a POW that outlives a disconnected client is not lost
+	d := getDispatcher()
+	job, err := d.submit(owner, transaction, mwm, ipc.PriorityNormal, ipc.AlgorithmCurlTransaction, log, func(nonce giota.Trytes, err error) {
+		defer finishRequest()
+
+		if err == nil {
+			resultCache.add(transaction, mwm, nonce)
+		}
+		resultChan <- powResult{nonce: nonce, err: err}
+	})
+	if err != nil {
+		finishRequest()
+		countError()
+		return "", err
+	}
+
+	select {
+	case result := <-resultChan:
+		if result.err != nil {
+			countError()
+			return "", newServerError(ipc.ErrorPowFailed, "%v", result.err)
+		}
+		return transaction[:transactionTrytesSize-nonceTrytesSize] + result.nonce, nil
+
+	case <-ctx.Done():
+		// A POW that is already running is finished by the device anyway
+		if d.cancel(job) {
+			finishRequest()
+		}
+		countError()
+		return "", ctx.Err()
+	}
+}
+
+// setApprovees sets the trunkTransaction and the branchTransaction of a transaction
+func setApprovees(transaction giota.Trytes, trunk giota.Trytes, branch giota.Trytes) giota.Trytes {
+	return transaction[:trunkTransactionOffset] + trunk + branch + transaction[branchTransactionOffset+hashTrytesSize:]
+}
+
+// setAttachmentTimestamp sets the attachment timestamp in ms and its bounds like IRI does
+func setAttachmentTimestamp(transaction giota.Trytes, t time.Time) giota.Trytes {
+	timestamp := int64ToTrytes(t.UnixNano()/int64(time.Millisecond), timestampTrytesSize)
+	lowerBound := int64ToTrytes(0, timestampTrytesSize)
+	return transaction[:attachmentTimestampOffset] + timestamp + lowerBound + maxAttachmentTimestamp + transaction[attachmentTimestampEndOffset:]
+}
+
+// int64ToTrytes converts a value to balanced ternary trytes of the given size
+func int64ToTrytes(value int64, size int) giota.Trytes {
+	trits := make([]int, size*3)
+	negative := value < 0
+	if negative {
+		value = -value
+	}
+
+	for i := 0; (i < len(trits)) && (value != 0); i++ {
+		remainder := int(value % 3)
+		value /= 3
+		if remainder == 2 {
+			remainder = -1
+			value++
+		}
+		trits[i] = remainder
+		if negative {
+			trits[i] = -remainder
+		}
+	}
+
+	trytes := make([]byte, size)
+	for i := range trytes {
+		v := trits[3*i] + 3*trits[3*i+1] + 9*trits[3*i+2]
+		if v < 0 {
+			v += 27
+		}
+		trytes[i] = giota.TryteAlphabet[v]
+	}
+	return giota.Trytes(trytes)
+}
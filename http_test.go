@@ -0,0 +1,207 @@
+package powsrv
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/iotaledger/giota"
+	"github.com/spf13/viper"
+)
+
+const (
+	testTrunk  = "TRUNK9999999999999999999999999999999999999999999999999999999999999999999999999999"
+	testBranch = "BRANCH999999999999999999999999999999999999999999999999999999999999999999999999999"
+)
+
+// postHTTP sends a request to the HTTP API and returns the status and the decoded response
+func postHTTP(t *testing.T, handler http.Handler, method string, token string, body string) (int, map[string]interface{}) {
+	req := httptest.NewRequest(method, "/", strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	var response map[string]interface{}
+	err := json.Unmarshal(rec.Body.Bytes(), &response)
+	if err != nil {
+		t.Fatalf("Invalid JSON response: %v", rec.Body.String())
+	}
+	return rec.Code, response
+}
+
+func TestHTTPAttachToTangle(t *testing.T) {
+	SetPowDevices([]*PowDevice{{PowType: "test", PowFunc: func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return fakeNonce, nil
+	}}})
+
+	config := viper.New()
+	config.Set("pow.maxMinWeightMagnitude", 14)
+	handler := HTTPHandler(config)
+
+	request, _ := json.Marshal(map[string]interface{}{
+		"command":            "attachToTangle",
+		"trunkTransaction":   testTrunk,
+		"branchTransaction":  testBranch,
+		"minWeightMagnitude": 14,
+		"trytes":             []string{transaction, transaction},
+	})
+	status, response := postHTTP(t, handler, http.MethodPost, "", string(request))
+	if status != http.StatusOK {
+		t.Fatalf("Unexpected status %v: %v", status, response)
+	}
+
+	result, ok := response["trytes"].([]interface{})
+	if !ok || (len(result) != 2) {
+		t.Fatalf("Unexpected trytes: %v", response)
+	}
+
+	// The result is reversed, the last transaction approves the first one and the trunk
+	first := giota.Trytes(result[1].(string))
+	last := giota.Trytes(result[0].(string))
+	if (first[trunkTransactionOffset:branchTransactionOffset] != testTrunk) || (first[branchTransactionOffset:branchTransactionOffset+hashTrytesSize] != testBranch) {
+		t.Error("First transaction doesn't approve the trunk and the branch")
+	}
+	firstTx, _ := giota.NewTransaction(first)
+	if (last[trunkTransactionOffset:branchTransactionOffset] != firstTx.Hash()) || (last[branchTransactionOffset:branchTransactionOffset+hashTrytesSize] != testTrunk) {
+		t.Error("Second transaction doesn't approve the first transaction and the trunk")
+	}
+	if (first[transactionTrytesSize-nonceTrytesSize:] != fakeNonce) || (last[attachmentTimestampEndOffset-timestampTrytesSize:attachmentTimestampEndOffset] != maxAttachmentTimestamp) {
+		t.Error("Nonce or attachment timestamp not set")
+	}
+
+	// The simple pow command returns the transaction with the nonce
+	status, response = postHTTP(t, handler, http.MethodPost, "", `{"command": "pow", "mwm": 14, "trytes": "`+transaction+`"}`)
+	if (status != http.StatusOK) || (response["nonce"] != fakeNonce) || (response["trytes"] != transaction[:transactionTrytesSize-nonceTrytesSize]+fakeNonce) {
+		t.Errorf("Unexpected pow response %v: %v", status, response)
+	}
+}
+
+func TestHTTPErrors(t *testing.T) {
+	SetPowDevices([]*PowDevice{{PowType: "test", PowFunc: func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return fakeNonce, nil
+	}}})
+
+	config := viper.New()
+	config.Set("pow.maxMinWeightMagnitude", 14)
+	config.Set("pow.minMinWeightMagnitude", 9)
+	config.Set("server.authToken", "secret")
+	handler := HTTPHandler(config)
+
+	pow := `{"command": "pow", "mwm": 14, "trytes": "` + transaction + `"}`
+	tests := []struct {
+		name   string
+		method string
+		token  string
+		body   string
+		status int
+	}{
+		{"wrong method", http.MethodGet, "secret", "", http.StatusMethodNotAllowed},
+		{"missing token", http.MethodPost, "", pow, http.StatusUnauthorized},
+		{"wrong token", http.MethodPost, "wrong", pow, http.StatusUnauthorized},
+		{"invalid JSON", http.MethodPost, "secret", "{", http.StatusBadRequest},
+		{"unknown command", http.MethodPost, "secret", `{"command": "getNodeInfo"}`, http.StatusBadRequest},
+		{"MWM too high", http.MethodPost, "secret", `{"command": "pow", "mwm": 15, "trytes": "` + transaction + `"}`, http.StatusBadRequest},
+		{"MWM too low", http.MethodPost, "secret", `{"command": "pow", "mwm": 8, "trytes": "` + transaction + `"}`, http.StatusBadRequest},
+		{"MWM missing", http.MethodPost, "secret", `{"command": "pow", "trytes": "` + transaction + `"}`, http.StatusBadRequest},
+		{"invalid trytes", http.MethodPost, "secret", `{"command": "pow", "mwm": 14, "trytes": "abc"}`, http.StatusBadRequest},
+		{"invalid trunk", http.MethodPost, "secret", `{"command": "attachToTangle", "minWeightMagnitude": 14, "trunkTransaction": "abc", "branchTransaction": "` + testBranch + `", "trytes": ["` + transaction + `"]}`, http.StatusBadRequest},
+		{"body too large", http.MethodPost, "secret", `{"command": "pow", "trytes": "` + strings.Repeat("9", maxHTTPRequestSize) + `"}`, http.StatusRequestEntityTooLarge},
+		{"valid request", http.MethodPost, "secret", pow, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		status, response := postHTTP(t, handler, test.method, test.token, test.body)
+		if status != test.status {
+			t.Errorf("%v: expected status %v, got %v: %v", test.name, test.status, status, response)
+		}
+		if (status != http.StatusOK) && (response["error"] == nil) {
+			t.Errorf("%v: error missing in the response: %v", test.name, response)
+		}
+	}
+
+	// No device is available
+	SetPowDevices(nil)
+	status, response := postHTTP(t, handler, http.MethodPost, "secret", pow)
+	if status != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %v without devices, got %v: %v", http.StatusServiceUnavailable, status, response)
+	}
+}
+
+func TestHTTPClientDisconnect(t *testing.T) {
+	SetPowCache(10, time.Minute)
+	defer SetPowCache(0, 0)
+
+	var calls int32
+	release := make(chan struct{})
+	SetPowDevices([]*PowDevice{blockingDevice(&calls, release, nil)})
+
+	config := viper.New()
+	config.Set("pow.maxMinWeightMagnitude", 14)
+	handler := HTTPHandler(config)
+
+	// The handler returns as soon as the client is gone, even though the POW is still running
+	ctx, cancel := context.WithCancel(context.Background())
+	pow := `{"command": "pow", "mwm": 14, "trytes": "` + transaction + `"}`
+	returned := make(chan struct{})
+	go func() {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(pow)).WithContext(ctx)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		close(returned)
+	}()
+
+	for atomic.LoadInt32(&calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatal("Handler blocked after the client disconnected")
+	}
+
+	// The result of the finished POW is cached for the next request
+	close(release)
+	for deadline := time.Now().Add(time.Second); ; {
+		if _, hit := resultCache.get(giota.Trytes(transaction), 14); hit {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Result of the POW was not cached")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	status, response := postHTTP(t, handler, http.MethodPost, "", pow)
+	if (status != http.StatusOK) || (response["nonce"] != fakeNonce) {
+		t.Errorf("Unexpected pow response %v: %v", status, response)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("Expected 1 PoW operation, got %d", n)
+	}
+}
+
+func TestInt64ToTrytes(t *testing.T) {
+	tests := []struct {
+		value  int64
+		trytes giota.Trytes
+	}{
+		{0, "999999999"},
+		{1, "A99999999"},
+		{-1, "Z99999999"},
+		{13, "M99999999"},
+		{14, "NA9999999"},
+	}
+
+	for _, test := range tests {
+		if trytes := int64ToTrytes(test.value, 9); trytes != test.trytes {
+			t.Errorf("Expected %v for %v, got %v", test.trytes, test.value, trytes)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package ipc
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// fuzzSeeds returns valid frames of both versions as starting points of the fuzzer
+func fuzzSeeds(f *testing.F, frameOnly bool) {
+	for _, version := range []byte{Version1, Version2} {
+		for _, data := range [][]byte{nil, []byte("PROGRESS 100"), bytes.Repeat([]byte{'9'}, 2674)} {
+			message, err := NewMessage(version, 0x42, CmdPowFunc, data)
+			if err != nil {
+				f.Fatal(err)
+			}
+			encoded, err := message.ToBytes()
+			if err != nil {
+				f.Fatal(err)
+			}
+			if frameOnly {
+				// StartByte, FrameVersion, FRAME_LENGTH and CRC are not part of the frame
+				encoded = encoded[2+lengthSize(version) : len(encoded)-crcSize(version)]
+			}
+			f.Add(encoded)
+		}
+	}
+}
+
+func FuzzBytesToFrameV1(f *testing.F) {
+	fuzzSeeds(f, true)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		frame, err := BytesToFrameV1(data)
+		if err != nil {
+			return
+		}
+
+		encoded, err := frame.ToBytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(encoded, data) {
+			t.Errorf("Frame changed after decoding and encoding: %X => %X", data, encoded)
+		}
+	})
+}
+
+func FuzzFrameReader(f *testing.F) {
+	fuzzSeeds(f, false)
+	f.Add([]byte{StartByte, StartByte, Version1, 0xFF, 0xFF})
+	f.Add([]byte{StartByte, Version2, 0xFF, 0xFF, 0xFF, 0xFF})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		reader := NewFrameReader(bytes.NewReader(data))
+
+		// Every frame consumes at least one byte, so the reader must be done after len(data) frames
+		for i := 0; i <= len(data); i++ {
+			frame, err := reader.ReadFrame()
+			if err == io.EOF {
+				return
+			}
+			if _, ok := err.(*FrameError); ok {
+				continue
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !ValidCommand(frame.Command) {
+				t.Errorf("Frame with unknown command: %X", frame.Command)
+			}
+			if len(frame.Data) > MaxDataLengthV2 {
+				t.Errorf("Frame is too big: %d", len(frame.Data))
+			}
+		}
+		t.Fatal("Reader did not finish")
+	})
+}
@@ -0,0 +1,506 @@
+/*
+Package ipc implements the frames of the interprocess communication between powSrv and its clients.
+
+	Interprocess communication protocol
+	===================================
+
+	----- FRAME_VERSION==0x01 -----
+	[0] START_BYTE | [1] FRAME_VERSION | [2..3] FRAME_LENGTH | [4..4+FRAME_LENGTH] FRAME_DATA | [4+FRAME_LENGTH] CRC8
+
+	----- FRAME_VERSION==0x02 -----
+	[0] START_BYTE | [1] FRAME_VERSION | [2..5] FRAME_LENGTH | [6..6+FRAME_LENGTH] FRAME_DATA | [6+FRAME_LENGTH..9+FRAME_LENGTH] CRC32
+
+	START_BYTE:
+		Start of the IPC frame
+		ENQ Byte (0x05) - Enquiry
+
+	FRAME_VERSION:
+		Version of the IPC frame, for future extensions of the protocol
+		The client uses Version1 until CmdGetFrameVersions reports that the server supports a newer version.
+		The server answers with the frame version of the last request of the client.
+
+	FRAME_LENGTH:
+		Size of the FRAME_DATA
+
+	FRAME_DATA:
+		----- FRAME_VERSION==0x01 -----
+
+		[4] REQ_ID | [5] IPC_CMD | [6..7] DATA_LENGTH | [8..8+DATA_LENGTH] DATA
+
+		----- FRAME_VERSION==0x02 -----
+
+		[6] REQ_ID | [7] IPC_CMD | [8..11] DATA_LENGTH | [12..12+DATA_LENGTH] DATA
+
+		The offsets of DATA below are given for FRAME_VERSION==0x01.
+
+		REQ_ID:
+			ID of the message, set by the client.
+			Server will respond to the client with the same ID.
+			This way the client knows which response is assigned to which request.
+
+		IPC_CMD:
+			CmdNotification     = 0x01 // S => C: Text messages to the client
+			CmdResponse         = 0x02 // S => C: Response to a IPC_CMD
+			CmdError            = 0x03 // S => C: Exceptions that should be raised in the client
+			CmdGetServerVersion = 0x04 // C => S: Get the version of this application
+			CmdGetPowType       = 0x05 // C => S: Get the name of the used POW implementation (e.g. PiDiver)
+			CmdGetPowVersion    = 0x06 // C => S: Get the version of the used POW implementation (e.g. PiDiver FPGA Core Version)
+			CmdPowFunc          = 0x07 // C => S: Do POW
+			CmdCancel           = 0x08 // C => S: Cancel the request with the same REQ_ID
+			CmdGetServerStats   = 0x09 // C => S: Get the statistics of the server as JSON
+			CmdHealthCheck      = 0x0A // C => S: Do a trivial POW on every device and get the result as JSON
+			CmdPowFuncBatch     = 0x0B // C => S: Do POW on all transactions of a bundle
+			CmdGetFrameVersions = 0x0C // C => S: Get the frame versions that are supported by the server
+			CmdAuth             = 0x0D // C => S: Authenticate with the shared secret of the server
+			CmdPing             = 0x0E // C => S: Keep the connection alive
+			CmdGetDeviceList    = 0x0F // C => S: Get the state of every device as JSON
+			CmdSelectDevice     = 0x10 // C => S: Pin the following POW requests of the connection to a device or device class
+			CmdGetInfo          = 0x11 // C => S: Get the server version and the POW types and versions as JSON
+			CmdGetLimits        = 0x12 // C => S: Get the limits and features of the server as JSON
+
+		DATA_LENGTH:
+			Size of the DATA
+
+		DATA:
+			Data with variable length
+
+			----- IPC_CMD==CmdNotification -----
+			[8..8+DATA_LENGTH]	String	Notification
+
+			While a POW request is running, the server sends "PROGRESS <elapsed ms>" with the REQ_ID of the request
+			every "server.progressIntervalMs". The client restarts its read timeout for the request.
+
+			If a device is removed or disabled while it has queued or running POW requests of the client, the server
+			sends "DEVICE_LOST <type>" with REQ_ID 0. The requests are done by the remaining devices, only requests that
+			no other device can do fail with ErrorNoDevice.
+
+			----- IPC_CMD==CmdResponse -----
+			[8..8+DATA_LENGTH] ReponseData
+
+			----- IPC_CMD==CmdError -----
+			[8..8+DATA_LENGTH] ExceptionMessage
+
+			----- IPC_CMD==CmdGetServerVersion -----
+			[8..8+DATA_LENGTH] 	String	ServerVersion
+
+			----- IPC_CMD==CmdGetPowType -----
+			[8..8+DATA_LENGTH] 	String	PowType
+
+			----- IPC_CMD==CmdGetPowVersion -----
+			[8..8+DATA_LENGTH] 	String	PowVersion
+
+			PowType and PowVersion belong to the device that did the last POW of the connection, e.g. "PiDiver".
+			Before the first POW, they list all devices, e.g. "[0] PiDiver, [1] gIOTA-PowC".
+
+			----- IPC_CMD==CmdPowFunc ----
+			Request:
+			[8]			Byte	MinWeightMagnitude
+			[9..2681]		Trytes	Transaction (2673 trytes)
+			[2682]			Byte	Priority (PriorityNormal or PriorityHigh, optional)
+
+			Request with an algorithm:
+			[8]			Byte	MinWeightMagnitude
+			[9]			Byte	Priority (PriorityNormal or PriorityHigh)
+			[10]			Byte	Algorithm (AlgorithmCurlTransaction or AlgorithmCurlRaw)
+			[11..8+DATA_LENGTH]	Data	Transaction (2673 trytes) or raw payload (1 to powsrv.MaxRawPowSize bytes)
+
+			Response:
+			[8..8+DATA_LENGTH] 	Trytes POW result
+
+			Requests without the priority byte have PriorityNormal. Queued requests with PriorityHigh are started
+			first, but at most "pow.maxConsecutiveHighPriority" in a row while requests with PriorityNormal wait.
+			The priority byte of a request with an algorithm is never a tryte, so the server can tell both formats apart.
+			AlgorithmCurlRaw hashes the payload with 2 trytes per byte, padded with 9s to a transaction (see
+			powsrv.RawPowTrytes). Algorithms that no device supports are answered with UNSUPPORTED_ALGORITHM.
+
+			----- IPC_CMD==CmdCancel ----
+			No data, the server does not respond to this command
+
+			----- IPC_CMD==CmdGetServerStats ----
+			[8..8+DATA_LENGTH] 	JSON	powsrv.ServerStats
+
+			----- IPC_CMD==CmdHealthCheck ----
+			[8..8+DATA_LENGTH] 	JSON	[]powsrv.DeviceHealth
+
+			----- IPC_CMD==CmdPowFuncBatch ----
+			Request:
+			[8]			Byte	MinWeightMagnitude
+			[9]			Byte	Flags (PowBatchFlagReversed)
+			[10..8+DATA_LENGTH]	Trytes	Transactions (2673 trytes each, at most powsrv.MaxPowBatchSize)
+
+			The trunkTransaction of every following transaction is set to the hash of the previous one.
+
+			Response:
+			[8..8+DATA_LENGTH]	Trytes	Transactions with nonce, in the order of the request
+
+			----- IPC_CMD==CmdGetFrameVersions ----
+			[8..8+DATA_LENGTH] 	Bytes	Supported FRAME_VERSIONs
+
+			----- IPC_CMD==CmdAuth ----
+			Request without data:
+			[8..8+DATA_LENGTH]	Bytes	New nonce of the server
+
+			Request:
+			[8..8+DATA_LENGTH]	Bytes	HMAC-SHA256 of the nonce with "server.authToken" as key
+			Response without data if the HMAC is valid, every nonce can only be used once.
+
+			If "server.authToken" is set, clients have to authenticate before any other command
+			except CmdGetServerVersion, CmdGetFrameVersions and CmdGetLimits.
+
+			----- IPC_CMD==CmdPing ----
+			No data, the server responds without data.
+
+			Connections without any frame for "server.clientIdleTimeoutSeconds" are closed by the server,
+			unless a POW request of the connection is in progress. Quiet clients send pings to keep the connection.
+
+			----- IPC_CMD==CmdGetDeviceList ----
+			[8..8+DATA_LENGTH] 	JSON	[]powsrv.DeviceInfo
+
+			CmdGetPowType and CmdGetPowVersion return the same information as "[<index>] <value>, ..." strings.
+
+			----- IPC_CMD==CmdSelectDevice ----
+			Request:
+			[8..8+DATA_LENGTH]	String	"any", "fpga", "cpu" or the index of a device, e.g. "1"
+
+			Response without data. The following CmdPowFunc and CmdPowFuncBatch requests of the connection are only
+			done by the selected devices, "any" (or no data) restores the default. Connections without CmdSelectDevice
+			may use every device.
+
+			----- IPC_CMD==CmdGetInfo ----
+			[8..8+DATA_LENGTH] 	JSON	powsrv.PowInfo
+
+			The server version and the POW types and versions of all devices in a single round trip, e.g. to show the
+			state of the devices. Unlike CmdGetPowType and CmdGetPowVersion, it doesn't depend on the last POW.
+
+			----- IPC_CMD==CmdGetLimits ----
+			[8..8+DATA_LENGTH] 	JSON	powsrv.ServerLimits
+
+			The minimum and maximum MWM, queue depth and pending requests per client, whether the connection has to authenticate,
+			the frame versions and the optional features of the server. Allowed without authentication.
+
+		Errors that the client can handle are reported with a well-known CmdError message:
+			QUEUE_FULL	All devices are busy and the maximum number of queued POW requests is reached
+			AUTH_REQUIRED	The command is only allowed after CmdAuth
+			AUTH_FAILED	The HMAC of CmdAuth is wrong
+			RATE_LIMITED <retry-after ms>	The client exceeded "pow.maxRequestsPerMinute"
+			MWM_TOO_HIGH <max>	MinWeightMagnitude of the request is higher than "pow.maxMinWeightMagnitude"
+			MWM_TOO_LOW <min>	MinWeightMagnitude of the request is lower than "pow.minMinWeightMagnitude"
+			INVALID_TRYTES	The request doesn't contain the valid trytes of whole transactions
+			NO_SUCH_DEVICE <selection>	No device of the server matches the selection of CmdSelectDevice
+			UNSUPPORTED_ALGORITHM <algorithm>	No device of the server supports the algorithm of CmdPowFunc
+
+		Every CmdError message starts with a machine-readable code, followed by a space and the details:
+			INVALID_FRAME	The frame of the request is corrupted
+			INVALID_REQUEST	The data of the request is incomplete or too big
+			UNKNOWN_COMMAND	The IPC_CMD can't be handled by the server
+			SHUTTING_DOWN	The server doesn't accept new POW requests
+			NO_DEVICE	No POW device is available
+			POW_FAILED	The POW device reported an error
+			POW_TIMEOUT <ms>	The POW device didn't finish within "pow.jobTimeoutSeconds", the result is abandoned
+			INTERNAL_ERROR	Any other error of the server
+
+	CRC8:
+		Checksum of the whole FRAME_DATA (CRC-8/MAXIM)
+
+	CRC32:
+		Checksum of the whole FRAME_DATA (CRC-32/IEEE)
+
+*/
+
+package ipc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/lunixbochs/struc"
+	"github.com/sigurn/crc8"
+)
+
+const (
+	// Different states of the receivement of the frame via interprocess communication
+	FrameStateSearchEnq     byte = 1 // FrameStateSearchEnq: Search the Start byte of the frame
+	FrameStateSearchVersion byte = 2 // Search the Version byte of the frame
+	FrameStateSearchLength  byte = 3 // Search the length information of the frame
+	FrameStateSearchData    byte = 4 // Search all the data embedded in the frame
+	FrameStateSearchCRC     byte = 5 // Search the CRC checksum of the embedded data
+
+	CmdNotification     = 0x01 // S => C: Text messages to the client
+	CmdResponse         = 0x02 // S => C: Response to a IPC_CMD
+	CmdError            = 0x03 // S => C: Exceptions that should be raised in the client
+	CmdGetServerVersion = 0x04 // C => S: Get the version of this application
+	CmdGetPowType       = 0x05 // C => S: Get the name of the used POW implementation (e.g. PiDiver)
+	CmdGetPowVersion    = 0x06 // C => S: Get the version of the used POW implementation (e.g. PiDiver FPGA Core Version)
+	CmdPowFunc          = 0x07 // C => S: Do POW
+	CmdCancel           = 0x08 // C => S: Cancel the request with the same REQ_ID
+	CmdGetServerStats   = 0x09 // C => S: Get the statistics of the server as JSON
+	CmdHealthCheck      = 0x0A // C => S: Do a trivial POW on every device and get the result as JSON
+	CmdPowFuncBatch     = 0x0B // C => S: Do POW on all transactions of a bundle
+	CmdGetFrameVersions = 0x0C // C => S: Get the frame versions that are supported by the server
+	CmdAuth             = 0x0D // C => S: Authenticate with the shared secret of the server
+	CmdPing             = 0x0E // C => S: Keep the connection alive
+	CmdGetDeviceList    = 0x0F // C => S: Get the state of every device as JSON
+	CmdSelectDevice     = 0x10 // C => S: Pin the following POW requests of the connection to a device or device class
+	CmdGetInfo          = 0x11 // C => S: Get the server version and the POW types and versions as JSON
+	CmdGetLimits        = 0x12 // C => S: Get the limits and features of the server as JSON
+
+	StartByte = 0x05 // ENQ
+	Version1  = 0x01 // 16 bit length, CRC8
+	Version2  = 0x02 // 32 bit length, CRC32
+
+	FrameHeaderSizeV1 = 4 // REQ_ID, IPC_CMD and 16 bit DATA_LENGTH
+	FrameHeaderSizeV2 = 6 // REQ_ID, IPC_CMD and 32 bit DATA_LENGTH
+
+	MaxFrameLengthV1 = 0xFFFF                              // FRAME_LENGTH is 16 bit
+	MaxFrameLengthV2 = MaxDataLengthV2 + FrameHeaderSizeV2 // Limits the memory of a single frame, FRAME_LENGTH could be 32 bit
+
+	MaxDataLengthV1 = MaxFrameLengthV1 - FrameHeaderSizeV1
+	MaxDataLengthV2 = 1 << 24 // Enough for the largest batch request
+
+	PowBatchFlagReversed = 0x01 // CmdPowFuncBatch: Do the POW from the last to the first transaction
+
+	PriorityNormal = 0x00 // CmdPowFunc: Default priority of the POW requests
+	PriorityHigh   = 0x01 // CmdPowFunc: The POW request is started before the queued requests with PriorityNormal
+
+	AlgorithmCurlTransaction = 0x00 // CmdPowFunc: Curl-P-81 POW of a transaction, the nonce is stored in the transaction
+	AlgorithmCurlRaw         = 0x01 // CmdPowFunc: Curl-P-81 POW of an arbitrary byte payload
+
+	NotificationProgress      = "PROGRESS"              // CmdNotification: The POW request with the same REQ_ID is still in progress
+	NotificationDeviceLost    = "DEVICE_LOST"           // CmdNotification: A device with POW requests of the client was removed or disabled, followed by its type
+	ErrorQueueFull            = "QUEUE_FULL"            // CmdError: All devices are busy and the queue is full
+	ErrorAuthRequired         = "AUTH_REQUIRED"         // CmdError: The client has to authenticate first
+	ErrorAuthFailed           = "AUTH_FAILED"           // CmdError: Wrong HMAC of CmdAuth
+	ErrorRateLimited          = "RATE_LIMITED"          // CmdError: Too many requests of the client, followed by the retry-after hint in ms
+	ErrorMwmTooHigh           = "MWM_TOO_HIGH"          // CmdError: MinWeightMagnitude is too high, followed by the allowed maximum
+	ErrorMwmTooLow            = "MWM_TOO_LOW"           // CmdError: MinWeightMagnitude is too low, followed by the allowed minimum
+	ErrorInvalidTrytes        = "INVALID_TRYTES"        // CmdError: Wrong length or invalid characters of the transaction trytes
+	ErrorNoSuchDevice         = "NO_SUCH_DEVICE"        // CmdError: No device matches the selection, followed by the selection
+	ErrorInvalidFrame         = "INVALID_FRAME"         // CmdError: Corrupted frame
+	ErrorInvalidRequest       = "INVALID_REQUEST"       // CmdError: Incomplete or too big request
+	ErrorUnknownCommand       = "UNKNOWN_COMMAND"       // CmdError: Unknown IPC_CMD
+	ErrorShuttingDown         = "SHUTTING_DOWN"         // CmdError: No new POW requests are accepted
+	ErrorNoDevice             = "NO_DEVICE"             // CmdError: No POW device available
+	ErrorPowFailed            = "POW_FAILED"            // CmdError: The POW device reported an error
+	ErrorPowTimeout           = "POW_TIMEOUT"           // CmdError: The POW device didn't finish within the job timeout, followed by the timeout in ms
+	ErrorUnsupportedAlgorithm = "UNSUPPORTED_ALGORITHM" // CmdError: No device supports the algorithm of the request, followed by the algorithm
+	ErrorInternal             = "INTERNAL_ERROR"        // CmdError: Any other error
+)
+
+var crc8Table = crc8.MakeTable(crc8.CRC8_MAXIM)
+
+// Message is an encoded IPC frame of any frame version
+type Message interface {
+	ToBytes() ([]byte, error)
+}
+
+// MessageV1 is the container of a FrameV1 with additional communication control data
+type MessageV1 struct {
+	StartByte    byte   `struc:"byte"`
+	FrameVersion byte   `struc:"byte"`
+	FrameLength  int    `struc:"uint16,sizeof=FrameData"`
+	FrameData    []byte `struc:"[]byte"`
+	CRC8         byte   `struc:"byte"`
+}
+
+// FrameV1 contains the information of the IPC communication
+type FrameV1 struct {
+	ReqID      byte   `struc:"byte"`
+	Command    byte   `struc:"byte"`
+	DataLength int    `struc:"uint16,sizeof=Data"`
+	Data       []byte `struc:"[]byte"`
+}
+
+// MessageV2 is the container of a FrameV2 with additional communication control data
+type MessageV2 struct {
+	StartByte    byte   `struc:"byte"`
+	FrameVersion byte   `struc:"byte"`
+	FrameLength  int    `struc:"uint32,sizeof=FrameData"`
+	FrameData    []byte `struc:"[]byte"`
+	CRC32        uint32 `struc:"uint32"`
+}
+
+// FrameV2 contains the information of the IPC communication with 32 bit data length
+type FrameV2 struct {
+	ReqID      byte   `struc:"byte"`
+	Command    byte   `struc:"byte"`
+	DataLength int    `struc:"uint32,sizeof=Data"`
+	Data       []byte `struc:"[]byte"`
+}
+
+// Frame is a received IPC frame, independent of the frame version
+type Frame struct {
+	Version byte
+	ReqID   byte
+	Command byte
+	Data    []byte
+}
+
+// commandNames are the names of the IPC_CMDs, used by the frame dumps
+var commandNames = map[byte]string{
+	CmdNotification:     "CmdNotification",
+	CmdResponse:         "CmdResponse",
+	CmdError:            "CmdError",
+	CmdGetServerVersion: "CmdGetServerVersion",
+	CmdGetPowType:       "CmdGetPowType",
+	CmdGetPowVersion:    "CmdGetPowVersion",
+	CmdPowFunc:          "CmdPowFunc",
+	CmdCancel:           "CmdCancel",
+	CmdGetServerStats:   "CmdGetServerStats",
+	CmdHealthCheck:      "CmdHealthCheck",
+	CmdPowFuncBatch:     "CmdPowFuncBatch",
+	CmdGetFrameVersions: "CmdGetFrameVersions",
+	CmdAuth:             "CmdAuth",
+	CmdPing:             "CmdPing",
+	CmdGetDeviceList:    "CmdGetDeviceList",
+	CmdSelectDevice:     "CmdSelectDevice",
+	CmdGetInfo:          "CmdGetInfo",
+	CmdGetLimits:        "CmdGetLimits",
+}
+
+// CommandName returns the name of the IPC_CMD, e.g. "CmdPowFunc", or its hex value if the command is unknown
+func CommandName(command byte) string {
+	if name, exists := commandNames[command]; exists {
+		return name
+	}
+	return fmt.Sprintf("0x%02X", command)
+}
+
+// ValidCommand returns true if the command is a known IPC_CMD
+func ValidCommand(command byte) bool {
+	return (command >= CmdNotification) && (command <= CmdGetLimits)
+}
+
+// checkFrameLength checks that DATA_LENGTH matches the size of the frame before the data is unpacked,
+// otherwise a malicious DATA_LENGTH could allocate a lot of memory
+func checkFrameLength(data []byte, headerSize int) error {
+	if len(data) < headerSize {
+		return fmt.Errorf("Frame is too short: %d", len(data))
+	}
+
+	var dataLength uint64
+	for _, b := range data[2:headerSize] {
+		dataLength = (dataLength << 8) | uint64(b)
+	}
+	if dataLength != uint64(len(data)-headerSize) {
+		return fmt.Errorf("Wrong data length! Length: %d, Expected: %d", dataLength, len(data)-headerSize)
+	}
+	return nil
+}
+
+// pack converts a struc struct to a byte slice
+func pack(data interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := struc.Pack(&buf, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// ToBytes converts a MessageV1 to a byte slice
+func (m *MessageV1) ToBytes() ([]byte, error) {
+	return pack(m)
+}
+
+// ToBytes converts a FrameV1 to a byte slice
+func (f *FrameV1) ToBytes() ([]byte, error) {
+	return pack(f)
+}
+
+// ToBytes converts a MessageV2 to a byte slice
+func (m *MessageV2) ToBytes() ([]byte, error) {
+	return pack(m)
+}
+
+// ToBytes converts a FrameV2 to a byte slice
+func (f *FrameV2) ToBytes() ([]byte, error) {
+	return pack(f)
+}
+
+// BytesToMessageV1 converts a byte slice to a MessageV1
+func BytesToMessageV1(data []byte) (*MessageV1, error) {
+	msg := new(MessageV1)
+	err := struc.Unpack(bytes.NewBuffer(data), msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// BytesToFrameV1 converts a byte slice to a FrameV1
+func BytesToFrameV1(data []byte) (*FrameV1, error) {
+	err := checkFrameLength(data, FrameHeaderSizeV1)
+	if err != nil {
+		return nil, err
+	}
+
+	frame := new(FrameV1)
+	err = struc.Unpack(bytes.NewBuffer(data), frame)
+	if err != nil {
+		return nil, err
+	}
+
+	return frame, nil
+}
+
+// BytesToFrameV2 converts a byte slice to a FrameV2
+func BytesToFrameV2(data []byte) (*FrameV2, error) {
+	err := checkFrameLength(data, FrameHeaderSizeV2)
+	if err != nil {
+		return nil, err
+	}
+
+	frame := new(FrameV2)
+	err = struc.Unpack(bytes.NewBuffer(data), frame)
+	if err != nil {
+		return nil, err
+	}
+
+	return frame, nil
+}
+
+// NewMessageV1 creates a new FrameV1 embedded in a MessageV1
+func NewMessageV1(requestID byte, command byte, data []byte) (*MessageV1, error) {
+	if len(data) > MaxDataLengthV1 {
+		return nil, errors.New("Message is too big")
+	}
+
+	frame := &FrameV1{ReqID: requestID, Command: command, DataLength: len(data), Data: data}
+	frameBytes, err := frame.ToBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	message := &MessageV1{StartByte: StartByte, FrameVersion: Version1, FrameLength: len(frameBytes), FrameData: frameBytes, CRC8: crc8.Checksum(frameBytes, crc8Table)}
+
+	return message, nil
+}
+
+// NewMessageV2 creates a new FrameV2 embedded in a MessageV2
+func NewMessageV2(requestID byte, command byte, data []byte) (*MessageV2, error) {
+	if len(data) > MaxDataLengthV2 {
+		return nil, errors.New("Message is too big")
+	}
+
+	frame := &FrameV2{ReqID: requestID, Command: command, DataLength: len(data), Data: data}
+	frameBytes, err := frame.ToBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	message := &MessageV2{StartByte: StartByte, FrameVersion: Version2, FrameLength: len(frameBytes), FrameData: frameBytes, CRC32: crc32.ChecksumIEEE(frameBytes)}
+
+	return message, nil
+}
+
+// NewMessage creates a new IPC message with the given frame version
+func NewMessage(version byte, requestID byte, command byte, data []byte) (Message, error) {
+	if version == Version2 {
+		return NewMessageV2(requestID, command, data)
+	}
+	return NewMessageV1(requestID, command, data)
+}
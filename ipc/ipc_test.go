@@ -0,0 +1,206 @@
+package ipc
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/sigurn/crc8"
+)
+
+// chunkReader returns the data in chunks of the given size
+type chunkReader struct {
+	data      []byte
+	chunkSize int
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := r.chunkSize
+	if n > len(p) {
+		n = len(p)
+	}
+	n = copy(p[:n], r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// readAll reads all frames of the stream until io.EOF
+func readAll(t *testing.T, reader *FrameReader) (frames []*Frame, errs []error) {
+	for {
+		frame, err := reader.ReadFrame()
+		if err == io.EOF {
+			return frames, errs
+		}
+		if frameErr, ok := err.(*FrameError); ok {
+			frame = frameErr.Frame
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, frame)
+		errs = append(errs, err)
+	}
+}
+
+func encode(t *testing.T, version byte, reqID byte, command byte, data []byte) []byte {
+	var buf bytes.Buffer
+	err := NewFrameWriter(&buf, version).WriteFrame(reqID, command, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+	transaction := bytes.Repeat([]byte{'9'}, 2673)
+
+	tests := []struct {
+		name    string
+		version byte
+		data    []byte
+	}{
+		{"V1 empty", Version1, nil},
+		{"V1 transaction", Version1, transaction},
+		{"V1 maximum", Version1, bytes.Repeat([]byte{0xAA}, MaxDataLengthV1)},
+		{"V2 empty", Version2, nil},
+		{"V2 transaction", Version2, transaction},
+		{"V2 larger than V1", Version2, bytes.Repeat([]byte{StartByte}, 0x10000)},
+	}
+
+	for _, test := range tests {
+		encoded := encode(t, test.version, 0x42, CmdPowFunc, test.data)
+
+		// Garbage before and between the frames is skipped
+		stream := append([]byte{0x00, StartByte, 0x07}, encoded...)
+		stream = append(stream, 0xFF)
+		stream = append(stream, encoded...)
+
+		for _, chunkSize := range []int{1, 7, 3072, 1 << 20} {
+			frames, errs := readAll(t, NewFrameReader(&chunkReader{data: stream, chunkSize: chunkSize}))
+			if len(frames) != 2 {
+				t.Fatalf("%s (chunk size %d): %d frames received", test.name, chunkSize, len(frames))
+			}
+			for i, frame := range frames {
+				if errs[i] != nil {
+					t.Fatalf("%s (chunk size %d): %v", test.name, chunkSize, errs[i])
+				}
+				if (frame.Version != test.version) || (frame.ReqID != 0x42) || (frame.Command != CmdPowFunc) || !bytes.Equal(frame.Data, test.data) {
+					t.Errorf("%s (chunk size %d): wrong frame %d", test.name, chunkSize, i)
+				}
+			}
+		}
+	}
+
+	_, err := NewMessageV1(0, CmdPowFunc, make([]byte, MaxDataLengthV1+1))
+	if err == nil {
+		t.Error("V1 message larger than the frame succeeded")
+	}
+}
+
+func TestFrameCorruption(t *testing.T) {
+	tests := []struct {
+		name    string
+		version byte
+		offset  int // Offset of the corrupted byte from the end of the frame
+	}{
+		{"V1 data", Version1, 10},
+		{"V1 checksum", Version1, 1},
+		{"V2 data", Version2, 10},
+		{"V2 checksum", Version2, 2},
+	}
+
+	for _, test := range tests {
+		encoded := encode(t, test.version, 0x42, CmdPowFunc, bytes.Repeat([]byte{'9'}, 2673))
+		encoded[len(encoded)-test.offset] ^= 0x01
+
+		// The frame after the corrupted one is still received
+		stream := append(encoded, encode(t, test.version, 0x43, CmdPowFunc, nil)...)
+
+		frames, errs := readAll(t, NewFrameReader(bytes.NewReader(stream)))
+		if len(frames) != 2 {
+			t.Fatalf("%s: %d frames received", test.name, len(frames))
+		}
+		if errs[0] == nil {
+			t.Errorf("%s: corruption not detected", test.name)
+		}
+		if (frames[0] == nil) || (frames[0].ReqID != 0x42) {
+			t.Errorf("%s: ReqID of the corrupted frame unknown", test.name)
+		}
+		if frameErr, ok := errs[0].(*FrameError); !ok || !bytes.Equal(frameErr.Raw, encoded) {
+			t.Errorf("%s: received bytes of the corrupted frame missing", test.name)
+		}
+		if (errs[1] != nil) || (frames[1].ReqID != 0x43) {
+			t.Errorf("%s: frame after the corrupted frame lost", test.name)
+		}
+	}
+}
+
+func TestFrameViolations(t *testing.T) {
+	valid := encode(t, Version1, 0x43, CmdPowFunc, []byte("test"))
+
+	// DATA_LENGTH doesn't match FRAME_LENGTH, but the checksum is right
+	frameData := []byte{0x42, CmdPowFunc, 0x00, 0x05, 't', 'e', 's', 't'}
+	wrongDataLength := append([]byte{StartByte, Version1, 0x00, byte(len(frameData))}, frameData...)
+	wrongDataLength = append(wrongDataLength, crc8.Checksum(frameData, crc8Table))
+
+	tests := []struct {
+		name  string
+		frame []byte
+		reqID bool // ReqID of the broken frame is known
+	}{
+		{"Unknown command", []byte{StartByte, Version1, 0x00, 0x04, 0x42, 0xFF, 0x00, 0x00, 0x00}, true},
+		{"Frame too short", []byte{StartByte, Version1, 0x00, 0x02, 0x42, CmdPowFunc, 0x00}, false},
+		{"Frame too big", []byte{StartByte, Version2, 0x7F, 0xFF, 0xFF, 0xFF}, false},
+		{"Wrong data length", wrongDataLength, true},
+	}
+
+	for _, test := range tests {
+		// Repeated ENQ bytes before the valid frame don't hide it
+		stream := append(test.frame, StartByte, StartByte)
+		stream = append(stream, valid...)
+
+		frames, errs := readAll(t, NewFrameReader(bytes.NewReader(stream)))
+		if (len(errs) == 0) || (errs[0] == nil) {
+			t.Fatalf("%s: violation not detected", test.name)
+		}
+		if test.reqID && ((frames[0] == nil) || (frames[0].ReqID != 0x42)) {
+			t.Errorf("%s: ReqID of the broken frame unknown", test.name)
+		}
+		last := len(frames) - 1
+		if (errs[last] != nil) || (frames[last].ReqID != 0x43) {
+			t.Errorf("%s: valid frame after the violation lost", test.name)
+		}
+	}
+}
+
+func TestFrameWriterVersion(t *testing.T) {
+	var buf bytes.Buffer
+	writer := NewFrameWriter(&buf, Version1)
+	writer.SetVersion(Version2)
+	if writer.Version() != Version2 {
+		t.Fatalf("Wrong version: %d", writer.Version())
+	}
+
+	err := writer.WriteFrame(0x01, CmdResponse, []byte("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame, err := NewFrameReader(&buf).ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (frame.Version != Version2) || (string(frame.Data) != "test") {
+		t.Errorf("Wrong frame: %+v", frame)
+	}
+}
+
+func TestCommandName(t *testing.T) {
+	if name := CommandName(CmdPowFunc); name != "CmdPowFunc" {
+		t.Errorf("Wrong name: %v", name)
+	}
+	if name := CommandName(0x7F); name != "0x7F" {
+		t.Errorf("Wrong name of an unknown command: %v", name)
+	}
+}
@@ -0,0 +1,227 @@
+package ipc
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/sigurn/crc8"
+)
+
+// FrameError is returned by the FrameReader for a corrupted frame
+// The following frames of the stream can still be read.
+type FrameError struct {
+	Frame *Frame // Only set if the ReqID of the frame is known, e.g. for a wrong checksum
+	Raw   []byte // Received bytes of a frame with a wrong checksum, nil for the other errors
+	Err   error
+}
+
+func (e *FrameError) Error() string {
+	return e.Err.Error()
+}
+
+// FrameReader reads the IPC frames of a stream
+// It tolerates partial reads and skips garbage between the frames.
+// The state machine is reset on any violation of the protocol, so the reader never waits for
+// more data than the largest legal frame and never allocates more than the received bytes.
+type FrameReader struct {
+	r      io.Reader
+	buf    []byte
+	unread []byte // Received bytes that are not handled yet
+
+	state       byte
+	version     byte
+	lengthBytes int // Received bytes of FRAME_LENGTH
+	frameLength int
+	frameData   []byte
+	crc         []byte
+}
+
+// NewFrameReader creates a FrameReader that reads from r
+func NewFrameReader(r io.Reader) *FrameReader {
+	return &FrameReader{r: r, buf: make([]byte, 3072), state: FrameStateSearchEnq} // ((8019 is the TransactionTrinarySize) / 3) + Overhead) => 3072
+}
+
+// ReadFrame blocks until the next complete frame is received
+// A corrupted frame is reported as *FrameError, all other errors are the errors of the underlying reader.
+func (r *FrameReader) ReadFrame() (*Frame, error) {
+	for {
+		frame, complete, err := r.parse()
+		if complete {
+			return frame, err
+		}
+
+		n, err := r.r.Read(r.buf)
+		if n > 0 {
+			r.unread = r.buf[:n]
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// parse handles the unread bytes until a frame is complete or all bytes are handled
+func (r *FrameReader) parse() (frame *Frame, complete bool, err error) {
+	for len(r.unread) > 0 {
+		b := r.unread[0]
+
+		switch r.state {
+
+		case FrameStateSearchEnq:
+			if b == StartByte {
+				r.state = FrameStateSearchVersion
+			}
+
+		case FrameStateSearchVersion:
+			switch b {
+			case StartByte:
+				// Garbage before the frame ended with ENQ => this could be the start of the frame
+			case Version1, Version2:
+				r.version = b
+				r.lengthBytes = 0
+				r.frameLength = 0
+				r.frameData = nil
+				r.crc = nil
+				r.state = FrameStateSearchLength
+			default:
+				r.state = FrameStateSearchEnq
+			}
+
+		case FrameStateSearchLength:
+			r.frameLength = (r.frameLength << 8) | int(b)
+			r.lengthBytes++
+			if r.lengthBytes < lengthSize(r.version) {
+				break
+			}
+
+			if r.frameLength < headerSize(r.version) {
+				r.state = FrameStateSearchEnq
+				r.unread = r.unread[1:]
+				return nil, true, &FrameError{Err: fmt.Errorf("Frame is too short: %d", r.frameLength)}
+			}
+			if r.frameLength > maxFrameLength(r.version) {
+				r.state = FrameStateSearchEnq
+				r.unread = r.unread[1:]
+				return nil, true, &FrameError{Err: fmt.Errorf("Frame is too big: %d", r.frameLength)}
+			}
+
+			r.state = FrameStateSearchData
+
+		case FrameStateSearchData:
+			missingByteCount := r.frameLength - len(r.frameData)
+			if missingByteCount > len(r.unread) {
+				missingByteCount = len(r.unread)
+			}
+			if len(r.frameData) < 2 {
+				// The data is only stored after REQ_ID and IPC_CMD are checked
+				missingByteCount = 1
+			}
+			r.frameData = append(r.frameData, r.unread[:missingByteCount]...)
+			r.unread = r.unread[missingByteCount:]
+
+			if (len(r.frameData) == 2) && !ValidCommand(r.frameData[1]) {
+				r.state = FrameStateSearchEnq
+				frame := &Frame{Version: r.version, ReqID: r.frameData[0], Command: r.frameData[1]}
+				return nil, true, &FrameError{Frame: frame, Err: fmt.Errorf("Unknown command! Cmd: %X", r.frameData[1])}
+			}
+
+			if len(r.frameData) == r.frameLength {
+				r.state = FrameStateSearchCRC
+			}
+			continue
+
+		case FrameStateSearchCRC:
+			r.crc = append(r.crc, b)
+			if len(r.crc) == crcSize(r.version) {
+				// Search for the next message
+				r.state = FrameStateSearchEnq
+				r.unread = r.unread[1:]
+				frame, err := r.decode()
+				return frame, true, err
+			}
+		}
+
+		r.unread = r.unread[1:]
+	}
+
+	return nil, false, nil
+}
+
+// decode converts the received frame data and checks the checksum
+func (r *FrameReader) decode() (*Frame, error) {
+	// The header was already received, so the sender of a broken frame can be told
+	header := &Frame{Version: r.version, ReqID: r.frameData[0], Command: r.frameData[1]}
+
+	if r.version == Version2 {
+		frameV2, err := BytesToFrameV2(r.frameData)
+		if err != nil {
+			return nil, &FrameError{Frame: header, Err: err}
+		}
+		frame := &Frame{Version: r.version, ReqID: frameV2.ReqID, Command: frameV2.Command, Data: frameV2.Data}
+
+		crc := crc32.ChecksumIEEE(r.frameData)
+		expected := uint32(r.crc[0])<<24 | uint32(r.crc[1])<<16 | uint32(r.crc[2])<<8 | uint32(r.crc[3])
+		if crc != expected {
+			return nil, &FrameError{Frame: frame, Raw: r.raw(), Err: fmt.Errorf("Wrong Checksum! CRC: %X, Expected: %X", crc, expected)}
+		}
+		return frame, nil
+	}
+
+	frameV1, err := BytesToFrameV1(r.frameData)
+	if err != nil {
+		return nil, &FrameError{Frame: header, Err: err}
+	}
+	frame := &Frame{Version: r.version, ReqID: frameV1.ReqID, Command: frameV1.Command, Data: frameV1.Data}
+
+	crc := crc8.Checksum(r.frameData, crc8Table)
+	if crc != r.crc[0] {
+		return nil, &FrameError{Frame: frame, Raw: r.raw(), Err: fmt.Errorf("Wrong Checksum! CRC: %X, Expected: %X", crc, r.crc[0])}
+	}
+	return frame, nil
+}
+
+// raw returns the bytes of the received frame, from the start byte to the checksum
+func (r *FrameReader) raw() []byte {
+	size := lengthSize(r.version)
+	raw := make([]byte, 0, 2+size+len(r.frameData)+len(r.crc))
+	raw = append(raw, StartByte, r.version)
+	for i := size - 1; i >= 0; i-- {
+		raw = append(raw, byte(r.frameLength>>(8*uint(i))))
+	}
+	raw = append(raw, r.frameData...)
+	return append(raw, r.crc...)
+}
+
+// lengthSize returns the size of FRAME_LENGTH of the frame version
+func lengthSize(version byte) int {
+	if version == Version2 {
+		return 4
+	}
+	return 2
+}
+
+// headerSize returns the size of the fields of the frame before DATA
+func headerSize(version byte) int {
+	if version == Version2 {
+		return FrameHeaderSizeV2
+	}
+	return FrameHeaderSizeV1
+}
+
+// maxFrameLength returns the maximum FRAME_LENGTH of the frame version
+func maxFrameLength(version byte) int {
+	if version == Version2 {
+		return MaxFrameLengthV2
+	}
+	return MaxFrameLengthV1
+}
+
+// crcSize returns the size of the checksum of the frame version
+func crcSize(version byte) int {
+	if version == Version2 {
+		return 4
+	}
+	return 1
+}
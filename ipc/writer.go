@@ -0,0 +1,49 @@
+package ipc
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// FrameWriter writes IPC frames to a stream
+// It is safe for concurrent use, the bytes of different frames don't interleave.
+type FrameWriter struct {
+	w       io.Writer
+	mutex   sync.Mutex
+	version uint32
+}
+
+// NewFrameWriter creates a FrameWriter that writes frames of the given version to w
+func NewFrameWriter(w io.Writer, version byte) *FrameWriter {
+	return &FrameWriter{w: w, version: uint32(version)}
+}
+
+// Version returns the frame version of the written frames
+func (w *FrameWriter) Version() byte {
+	return byte(atomic.LoadUint32(&w.version))
+}
+
+// SetVersion changes the frame version of the following frames
+func (w *FrameWriter) SetVersion(version byte) {
+	atomic.StoreUint32(&w.version, uint32(version))
+}
+
+// WriteFrame encodes a frame and writes it to the stream
+func (w *FrameWriter) WriteFrame(reqID byte, command byte, data []byte) error {
+	msg, err := NewMessage(w.Version(), reqID, command, data)
+	if err != nil {
+		return err
+	}
+
+	encoded, err := msg.ToBytes()
+	if err != nil {
+		return err
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	_, err = w.w.Write(encoded)
+	return err
+}
@@ -0,0 +1,144 @@
+package powsrv
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LifetimeStats are the counters of the POW requests, since the start or over all runs of the powSrv ("server.statsFile")
+type LifetimeStats struct {
+	Requests   uint64                   `json:"requests"`   // Number of received POW requests
+	Errors     uint64                   `json:"errors"`     // Number of POW requests that were answered with an error
+	PowSeconds float64                  `json:"powSeconds"` // Time the devices were doing POW
+	PowTypes   map[string]*PowTypeStats `json:"powTypes"`   // Counters of the devices by their POW type, e.g. "PiDiver"
+}
+
+// PowTypeStats are the counters of all devices of a POW type
+type PowTypeStats struct {
+	Requests   uint64  `json:"requests"`
+	Errors     uint64  `json:"errors"`
+	PowSeconds float64 `json:"powSeconds"`
+}
+
+var lifetimeMutex = &sync.Mutex{}
+var lifetimeLoaded = &LifetimeStats{PowTypes: make(map[string]*PowTypeStats)} // Counters of the previous runs
+var sessionPowTypes = make(map[string]*PowTypeStats)                          // Counters of the devices since the start
+
+// countPow counts a finished POW of a device
+func countPow(device *PowDevice, duration time.Duration, err error) {
+	lifetimeMutex.Lock()
+	defer lifetimeMutex.Unlock()
+
+	stats, exists := sessionPowTypes[device.PowType]
+	if !exists {
+		stats = &PowTypeStats{}
+		sessionPowTypes[device.PowType] = stats
+	}
+	stats.Requests++
+	if err != nil {
+		stats.Errors++
+	}
+	stats.PowSeconds += duration.Seconds()
+}
+
+// add adds the counters of other
+func (s *LifetimeStats) add(other *LifetimeStats) {
+	s.Requests += other.Requests
+	s.Errors += other.Errors
+	s.PowSeconds += other.PowSeconds
+	for powType, stats := range other.PowTypes {
+		if _, exists := s.PowTypes[powType]; !exists {
+			s.PowTypes[powType] = &PowTypeStats{}
+		}
+		s.PowTypes[powType].Requests += stats.Requests
+		s.PowTypes[powType].Errors += stats.Errors
+		s.PowTypes[powType].PowSeconds += stats.PowSeconds
+	}
+}
+
+// sinceStartStats returns the counters since the start of the powSrv
+func sinceStartStats() *LifetimeStats {
+	lifetimeMutex.Lock()
+	defer lifetimeMutex.Unlock()
+
+	stats := &LifetimeStats{
+		Requests: atomic.LoadUint64(&totalRequests),
+		Errors:   atomic.LoadUint64(&totalErrors),
+		PowTypes: make(map[string]*PowTypeStats, len(sessionPowTypes)),
+	}
+	for powType, session := range sessionPowTypes {
+		copied := *session
+		stats.PowTypes[powType] = &copied
+		stats.PowSeconds += session.PowSeconds
+	}
+	return stats
+}
+
+// lifetimeStats returns the counters of the previous runs and the current one
+func lifetimeStats() *LifetimeStats {
+	stats := sinceStartStats()
+
+	lifetimeMutex.Lock()
+	defer lifetimeMutex.Unlock()
+
+	stats.add(lifetimeLoaded)
+	return stats
+}
+
+// LoadLifetimeStats adds the counters of the stats file to the lifetime statistics
+// A missing file is no error, the counters start at zero.
+func LoadLifetimeStats(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	loaded := &LifetimeStats{}
+	err = json.Unmarshal(data, loaded)
+	if err != nil {
+		return fmt.Errorf("Stats file %v is corrupt: %v", path, err)
+	}
+
+	lifetimeMutex.Lock()
+	defer lifetimeMutex.Unlock()
+
+	lifetimeLoaded.add(loaded)
+	return nil
+}
+
+// SaveLifetimeStats writes the lifetime statistics to the stats file
+// The file is replaced atomically, so a crash during the write doesn't corrupt the previous file.
+func SaveLifetimeStats(path string) error {
+	data, err := json.MarshalIndent(lifetimeStats(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,111 @@
+package powsrv
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// resetLifetimeStats drops the counters of the previous runs and of the devices
+func resetLifetimeStats() {
+	lifetimeMutex.Lock()
+	defer lifetimeMutex.Unlock()
+
+	lifetimeLoaded = &LifetimeStats{PowTypes: make(map[string]*PowTypeStats)}
+	sessionPowTypes = make(map[string]*PowTypeStats)
+}
+
+func TestLoadLifetimeStats(t *testing.T) {
+	resetLifetimeStats()
+	defer resetLifetimeStats()
+	dir := t.TempDir()
+
+	// A missing file is no error
+	if err := LoadLifetimeStats(filepath.Join(dir, "missing.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	corrupt := filepath.Join(dir, "corrupt.json")
+	ioutil.WriteFile(corrupt, []byte(`{"requests": 10, "powTypes": `), 0644)
+	if err := LoadLifetimeStats(corrupt); err == nil {
+		t.Error("Expected an error for a corrupt file")
+	}
+
+	path := filepath.Join(dir, "stats.json")
+	ioutil.WriteFile(path, []byte(`{"requests": 100, "errors": 2, "powSeconds": 50, "powTypes": {"PiDiver": {"requests": 90, "errors": 1, "powSeconds": 45}, "gIOTA-PowC": {"requests": 10, "errors": 1, "powSeconds": 5}}}`), 0644)
+	if err := LoadLifetimeStats(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// The loaded counters are merged with the counters since the start
+	countPow(&PowDevice{PowType: "PiDiver"}, 2*time.Second, nil)
+	countPow(&PowDevice{PowType: "PiDiver"}, time.Second, errors.New("failed"))
+	countPow(&PowDevice{PowType: "USBDiver"}, time.Second, nil)
+
+	sinceStart := sinceStartStats()
+	lifetime := lifetimeStats()
+	if (lifetime.Requests != 100+sinceStart.Requests) || (lifetime.Errors != 2+sinceStart.Errors) || (lifetime.PowSeconds != 54) {
+		t.Errorf("Unexpected lifetime counters: %+v, since start: %+v", lifetime, sinceStart)
+	}
+	expected := map[string]PowTypeStats{
+		"PiDiver":    {Requests: 92, Errors: 2, PowSeconds: 48},
+		"gIOTA-PowC": {Requests: 10, Errors: 1, PowSeconds: 5},
+		"USBDiver":   {Requests: 1, Errors: 0, PowSeconds: 1},
+	}
+	for powType, stats := range expected {
+		if (lifetime.PowTypes[powType] == nil) || (*lifetime.PowTypes[powType] != stats) {
+			t.Errorf("%v: expected %+v, got %+v", powType, stats, lifetime.PowTypes[powType])
+		}
+	}
+	if (len(sinceStart.PowTypes) != 2) || (sinceStart.PowSeconds != 4) {
+		t.Errorf("Unexpected counters since the start: %+v", sinceStart)
+	}
+}
+
+func TestSaveLifetimeStats(t *testing.T) {
+	resetLifetimeStats()
+	defer resetLifetimeStats()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "stats.json")
+
+	ioutil.WriteFile(path, []byte(`{"requests": 5}`), 0644)
+	if err := LoadLifetimeStats(path); err != nil {
+		t.Fatal(err)
+	}
+	countPow(&PowDevice{PowType: "PiDiver"}, time.Second, nil)
+
+	// The previous file is replaced, no temporary file is left
+	if err := SaveLifetimeStats(path); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("Expected only the stats file, got %d files", len(files))
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved LifetimeStats
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if (saved.Requests < 5) || (saved.PowTypes["PiDiver"] == nil) || (saved.PowTypes["PiDiver"].Requests != 1) {
+		t.Errorf("Unexpected saved counters: %s", data)
+	}
+
+	// A failed write doesn't touch the previous file
+	os.Chmod(dir, 0500)
+	defer os.Chmod(dir, 0700)
+	if (os.Geteuid() != 0) && (SaveLifetimeStats(path) == nil) {
+		t.Error("Expected an error for a read-only directory")
+	}
+	if current, _ := ioutil.ReadFile(path); string(current) != string(data) {
+		t.Error("Stats file was changed by a failed write")
+	}
+}
@@ -0,0 +1,182 @@
+package powsrv
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/muxxer/powsrv/ipc"
+)
+
+// Features of the server, reported by ipc.CmdGetLimits
+const (
+	FeatureBatch         = "batch"         // ipc.CmdPowFuncBatch
+	FeatureCancel        = "cancel"        // ipc.CmdCancel
+	FeatureProgress      = "progress"      // PROGRESS notifications during a POW request ("server.progressIntervalMs")
+	FeatureSelectDevice  = "selectDevice"  // ipc.CmdSelectDevice
+	FeatureDeviceList    = "deviceList"    // ipc.CmdGetDeviceList
+	FeatureHealthCheck   = "healthCheck"   // ipc.CmdHealthCheck
+	FeatureGetInfo       = "getInfo"       // ipc.CmdGetInfo
+	FeatureVerifyResults = "verifyResults" // The nonces of the devices are checked before they are returned ("pow.verifyResults")
+	FeatureRawPow        = "rawPow"        // At least one device supports ipc.AlgorithmCurlRaw (PowClient.PowRaw)
+)
+
+// Network presets of "pow.network"
+const (
+	NetworkMainnet = "mainnet"
+	NetworkDevnet  = "devnet"
+)
+
+// networkMwm is the MinWeightMagnitude of the network presets
+var networkMwm = map[string]int{
+	NetworkMainnet: 14,
+	NetworkDevnet:  9,
+}
+
+// ServerLimits contains the limits and features of the powSrv, returned by ipc.CmdGetLimits
+type ServerLimits struct {
+	MinMWM              int      `json:"minMWM"`              // "pow.minMinWeightMagnitude" (0 for older servers)
+	MaxMWM              int      `json:"maxMWM"`              // "pow.maxMinWeightMagnitude"
+	MaxQueueDepth       int      `json:"maxQueueDepth"`       // "pow.maxQueueDepth" (0 = unlimited)
+	MaxPendingPerClient int      `json:"maxPendingPerClient"` // "pow.maxPendingPerClient" (0 = unlimited)
+	AuthRequired        bool     `json:"authRequired"`        // The connection has to authenticate with ipc.CmdAuth
+	ProtocolVersions    []int    `json:"protocolVersions"`    // Supported frame versions
+	Features            []string `json:"features"`            // Supported optional features, e.g. FeatureBatch
+}
+
+// HasFeature returns true if the server supports the feature
+func (l *ServerLimits) HasFeature(feature string) bool {
+	for _, f := range l.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// ApplyNetworkPreset sets the MWM limits of the "pow.network" preset, e.g. 14 for "mainnet"
+// "pow.minMinWeightMagnitude" and "pow.maxMinWeightMagnitude" are only set if the config doesn't contain them.
+func ApplyNetworkPreset(config *viper.Viper) error {
+	network := config.GetString("pow.network")
+	if network == "" {
+		return nil
+	}
+
+	mwm, exists := networkMwm[strings.ToLower(network)]
+	if !exists {
+		return fmt.Errorf("Unknown network: %v", network)
+	}
+	for _, key := range []string{"pow.minMinWeightMagnitude", "pow.maxMinWeightMagnitude"} {
+		if !config.IsSet(key) {
+			config.Set(key, mwm)
+		}
+	}
+	return nil
+}
+
+// mwmLimits returns the minimum and maximum MinWeightMagnitude of the POW requests
+func mwmLimits(config *viper.Viper) (minMwm int, maxMwm int) {
+	return config.GetInt("pow.minMinWeightMagnitude"), config.GetInt("pow.maxMinWeightMagnitude")
+}
+
+// serverLimits returns the limits of the server for the connection
+func (c *clientConnection) serverLimits(config *viper.Viper, progressInterval time.Duration) ServerLimits {
+	features := []string{FeatureBatch, FeatureCancel, FeatureSelectDevice, FeatureDeviceList, FeatureHealthCheck, FeatureGetInfo}
+	if progressInterval > 0 {
+		features = append(features, FeatureProgress)
+	}
+	if atomic.LoadInt32(&verifyResults) == 1 {
+		features = append(features, FeatureVerifyResults)
+	}
+	if getDispatcher().supportsAlgorithm(ipc.AlgorithmCurlRaw) {
+		features = append(features, FeatureRawPow)
+	}
+
+	minMwm, maxMwm := mwmLimits(config)
+	return ServerLimits{
+		MinMWM:              minMwm,
+		MaxMWM:              maxMwm,
+		MaxQueueDepth:       int(atomic.LoadInt32(&maxQueueDepth)),
+		MaxPendingPerClient: int(atomic.LoadInt32(&maxPendingPerClient)),
+		AuthRequired:        c.authRequired,
+		ProtocolVersions:    []int{ipc.Version1, ipc.Version2},
+		Features:            features,
+	}
+}
+
+// GetLimits returns the limits and features of the powSrv
+// The limits are cached until the connection is lost. Servers without ipc.CmdGetLimits answer with a ServerError.
+func (p *PowClient) GetLimits() (ServerLimits, error) {
+	p.pendingMutex.Lock()
+	limits := p.limits
+	p.pendingMutex.Unlock()
+
+	if limits != nil {
+		return *limits, nil
+	}
+
+	limits, err := p.requestLimits(context.Background())
+	if err != nil {
+		return ServerLimits{}, err
+	}
+	return *limits, nil
+}
+
+// loadLimits caches the limits of the new connection, so POW requests with an MWM outside the limits fail without a round trip
+// Errors are ignored, e.g. of older servers without ipc.CmdGetLimits.
+func (p *PowClient) loadLimits() {
+	ctx, cancel := context.WithTimeout(context.Background(), frameVersionTimeout)
+	defer cancel()
+
+	p.requestLimits(ctx)
+}
+
+// requestLimits asks the powSrv for its limits and caches them
+func (p *PowClient) requestLimits(ctx context.Context) (*ServerLimits, error) {
+	p.pendingMutex.Lock()
+	c := p.connection
+	p.pendingMutex.Unlock()
+
+	response, err := p.sendIpcFrameToServer(ctx, ipc.CmdGetLimits, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	limits := &ServerLimits{}
+	err = json.Unmarshal(response, limits)
+	if err != nil {
+		return nil, err
+	}
+
+	// The limits of a lost connection may be outdated after the reconnect
+	p.pendingMutex.Lock()
+	if (c != nil) && (p.connection == c) {
+		p.limits = limits
+	}
+	p.pendingMutex.Unlock()
+
+	return limits, nil
+}
+
+// checkMwm returns ErrMWMTooHigh or ErrMWMTooLow if the MWM is outside the cached limits of the powSrv
+func (p *PowClient) checkMwm(mwm int) error {
+	p.pendingMutex.Lock()
+	limits := p.limits
+	p.pendingMutex.Unlock()
+
+	if limits == nil {
+		return nil
+	}
+	if mwm > limits.MaxMWM {
+		return fmt.Errorf("%w: %d (maximum %d)", ErrMWMTooHigh, mwm, limits.MaxMWM)
+	}
+	if mwm < limits.MinMWM {
+		return fmt.Errorf("%w: %d (minimum %d)", ErrMWMTooLow, mwm, limits.MinMWM)
+	}
+	return nil
+}
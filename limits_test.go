@@ -0,0 +1,99 @@
+package powsrv
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/iotaledger/giota"
+	"github.com/spf13/viper"
+
+	"github.com/muxxer/powsrv/ipc"
+)
+
+func TestGetLimits(t *testing.T) {
+	config := viper.New()
+	config.Set("server.progressIntervalMs", 50)
+	config.Set("pow.maxMinWeightMagnitude", 14)
+	config.Set("pow.minMinWeightMagnitude", 9)
+	client, cleanup := startAuthServer(t, "tcp", config)
+	defer cleanup()
+
+	SetMaxQueueDepth(5)
+	defer SetMaxQueueDepth(0)
+
+	// The limits are available before the authentication
+	err := client.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	limits, err := client.GetLimits()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ServerLimits{
+		MinMWM:           9,
+		MaxMWM:           14,
+		MaxQueueDepth:    5,
+		AuthRequired:     true,
+		ProtocolVersions: []int{ipc.Version1, ipc.Version2},
+		Features:         []string{FeatureBatch, FeatureCancel, FeatureSelectDevice, FeatureDeviceList, FeatureHealthCheck, FeatureGetInfo, FeatureProgress},
+	}
+	if !reflect.DeepEqual(limits, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, limits)
+	}
+	if !limits.HasFeature(FeatureBatch) || limits.HasFeature(FeatureVerifyResults) {
+		t.Errorf("Wrong features: %v", limits.Features)
+	}
+
+	// A too high MWM fails locally, the server would answer AUTH_REQUIRED
+	_, err = client.PowFunc(giota.Trytes(transaction), 15)
+	var serverErr *ServerError
+	if !errors.Is(err, ErrMWMTooHigh) || errors.As(err, &serverErr) {
+		t.Errorf("Expected a local ErrMWMTooHigh, got: %v", err)
+	}
+	_, err = client.PowFunc(giota.Trytes(transaction), 8)
+	if !errors.Is(err, ErrMWMTooLow) || errors.As(err, &serverErr) {
+		t.Errorf("Expected a local ErrMWMTooLow, got: %v", err)
+	}
+	_, err = client.PowFunc(giota.Trytes(transaction), 14)
+	if !errors.Is(err, ErrAuthRequired) {
+		t.Errorf("Expected ErrAuthRequired, got: %v", err)
+	}
+}
+
+func TestApplyNetworkPreset(t *testing.T) {
+	for _, test := range []struct {
+		network  string
+		min, max interface{} // Explicit settings, nil if not set
+		expected [2]int
+		err      bool
+	}{
+		{"", nil, nil, [2]int{0, 0}, false},
+		{"mainnet", nil, nil, [2]int{14, 14}, false},
+		{"Devnet", nil, nil, [2]int{9, 9}, false},
+		{"mainnet", 13, nil, [2]int{13, 14}, false},
+		{"devnet", nil, 20, [2]int{9, 20}, false},
+		{"testnet", nil, nil, [2]int{0, 0}, true},
+	} {
+		config := viper.New()
+		config.Set("pow.network", test.network)
+		if test.min != nil {
+			config.Set("pow.minMinWeightMagnitude", test.min)
+		}
+		if test.max != nil {
+			config.Set("pow.maxMinWeightMagnitude", test.max)
+		}
+
+		err := ApplyNetworkPreset(config)
+		if (err != nil) != test.err {
+			t.Errorf("Network %q: unexpected error: %v", test.network, err)
+		}
+		minMwm, maxMwm := mwmLimits(config)
+		if [2]int{minMwm, maxMwm} != test.expected {
+			t.Errorf("Network %q: expected %v, got [%d %d]", test.network, test.expected, minMwm, maxMwm)
+		}
+	}
+}
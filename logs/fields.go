@@ -0,0 +1,118 @@
+package logs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/op/go-logging"
+)
+
+// Fields are the structured values of a log entry, e.g. "remoteAddr", "reqID", "deviceIndex" or "durationMs"
+type Fields map[string]interface{}
+
+// fieldLogger is implemented by the loggers that write the fields as separate values, e.g. JSONLogger
+type fieldLogger interface {
+	logFields(level logging.Level, fields Fields, message string)
+}
+
+var correlationIDs uint64
+
+// NewCorrelationID returns a new ID that is shared by all log entries of a request
+func NewCorrelationID() string {
+	return fmt.Sprintf("%08x", atomic.AddUint64(&correlationIDs, 1))
+}
+
+// Entry logs messages with a fixed set of fields, e.g. of a single POW request
+// With the text format, the fields are appended to the message.
+type Entry struct {
+	fields Fields
+}
+
+// WithFields returns an entry with the given fields
+func WithFields(fields Fields) *Entry {
+	return &Entry{fields: fields}
+}
+
+// WithFields returns an entry with the fields of e and the given fields
+func (e *Entry) WithFields(fields Fields) *Entry {
+	merged := make(Fields, len(e.fields)+len(fields))
+	for key, value := range e.fields {
+		merged[key] = value
+	}
+	for key, value := range fields {
+		merged[key] = value
+	}
+	return &Entry{fields: merged}
+}
+
+// log writes the message with the fields of the entry
+func (e *Entry) log(level logging.Level, format string, args []interface{}) {
+	message := fmt.Sprintf(format, args...)
+
+	logger := Log
+	if l, ok := logger.(fieldLogger); ok {
+		l.logFields(level, e.fields, message)
+		return
+	}
+
+	if len(e.fields) > 0 {
+		message += " [" + e.String() + "]"
+	}
+	switch level {
+	case levelTrace:
+		logger.Debug("[TRACE] " + message)
+	case logging.DEBUG:
+		logger.Debug(message)
+	case logging.INFO:
+		logger.Info(message)
+	case logging.NOTICE:
+		logger.Notice(message)
+	case logging.WARNING:
+		logger.Warning(message)
+	case logging.ERROR:
+		logger.Error(message)
+	default:
+		logger.Critical(message)
+	}
+}
+
+// String returns the sorted fields of the entry, e.g. "corrID=00000001 reqID=3"
+func (e *Entry) String() string {
+	keys := make([]string, 0, len(e.fields))
+	for key := range e.fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		values[i] = fmt.Sprintf("%s=%v", key, e.fields[key])
+	}
+	return strings.Join(values, " ")
+}
+
+// Tracef logs the message with LevelTrace, the arguments are only formatted if TraceEnabled
+func (e *Entry) Tracef(format string, args ...interface{}) {
+	if !TraceEnabled() {
+		return
+	}
+	e.log(levelTrace, format, args)
+}
+
+func (e *Entry) Debugf(format string, args ...interface{}) {
+	e.log(logging.DEBUG, format, args)
+}
+
+func (e *Entry) Infof(format string, args ...interface{}) {
+	e.log(logging.INFO, format, args)
+}
+
+func (e *Entry) Warningf(format string, args ...interface{}) {
+	e.log(logging.WARNING, format, args)
+}
+
+func (e *Entry) Errorf(format string, args ...interface{}) {
+	e.log(logging.ERROR, format, args)
+}
@@ -0,0 +1,171 @@
+package logs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/op/go-logging"
+)
+
+// Log formats of "log.format"
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+var levelNames = map[logging.Level]string{
+	logging.CRITICAL: "CRITICAL",
+	logging.ERROR:    "ERROR",
+	logging.WARNING:  "WARNING",
+	logging.NOTICE:   "NOTICE",
+	logging.INFO:     "INFO",
+	logging.DEBUG:    "DEBUG",
+	levelTrace:       "TRACE",
+}
+
+// SetFormat switches the format of the log entries, FormatText (default) or FormatJSON
+// JSON entries are written to the output of Setup, one object per line.
+func SetFormat(format string) error {
+	switch format {
+	case "", FormatText:
+		Log = logging.MustGetLogger("powSrv")
+	case FormatJSON:
+		outputMutex.Lock()
+		w, mirror := outputWriter, mirrorWriter
+		outputMutex.Unlock()
+		Log = newJSONLogger(w, mirror)
+	default:
+		return fmt.Errorf("Unknown log format: %v", format)
+	}
+	return nil
+}
+
+// JSONLogger writes the log entries as JSON objects with the time, the level, the component and the fields of the entry
+type JSONLogger struct {
+	w      io.Writer
+	mirror io.Writer // Receives WARNING and more severe entries too (optional)
+	mutex  sync.Mutex
+}
+
+// NewJSONLogger returns a logger that writes JSON objects to w
+func NewJSONLogger(w io.Writer) *JSONLogger {
+	return &JSONLogger{w: w}
+}
+
+// newJSONLogger returns a logger that writes JSON objects to w and mirrors the warnings and errors to mirror
+func newJSONLogger(w io.Writer, mirror io.Writer) *JSONLogger {
+	return &JSONLogger{w: w, mirror: mirror}
+}
+
+// logFields writes an entry with the given fields
+func (l *JSONLogger) logFields(level logging.Level, fields Fields, message string) {
+	if int32(level) > atomic.LoadInt32(&currentLevel) {
+		return
+	}
+
+	entry := make(map[string]interface{}, len(fields)+4)
+	for key, value := range fields {
+		entry[key] = value
+	}
+	entry["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	entry["level"] = levelNames[level]
+	entry["msg"] = message
+	if _, exists := entry["component"]; !exists {
+		entry["component"] = callerComponent()
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		data, _ = json.Marshal(map[string]interface{}{"time": entry["time"], "level": entry["level"], "msg": message})
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	data = append(data, '\n')
+	l.w.Write(data)
+	if (l.mirror != nil) && (level <= logging.WARNING) {
+		l.mirror.Write(data)
+	}
+}
+
+// callerComponent returns the package name of the first caller outside of the loggers, e.g. "powsrv" or "drivers"
+func callerComponent() string {
+	pc := make([]uintptr, 16)
+	frames := runtime.CallersFrames(pc[:runtime.Callers(3, pc)])
+	for {
+		frame, more := frames.Next()
+		function := frame.Function[strings.LastIndex(frame.Function, "/")+1:]
+		if more && (strings.HasPrefix(function, "logs.(*JSONLogger)") || strings.HasPrefix(function, "logs.(*Entry)")) {
+			continue
+		}
+		if i := strings.Index(function, "."); i != -1 {
+			return function[:i]
+		}
+		return function
+	}
+}
+
+func (l *JSONLogger) Debug(args ...interface{}) {
+	l.logFields(logging.DEBUG, nil, fmt.Sprint(args...))
+}
+
+func (l *JSONLogger) Debugf(format string, args ...interface{}) {
+	l.logFields(logging.DEBUG, nil, fmt.Sprintf(format, args...))
+}
+
+func (l *JSONLogger) Info(args ...interface{}) {
+	l.logFields(logging.INFO, nil, fmt.Sprint(args...))
+}
+
+func (l *JSONLogger) Infof(format string, args ...interface{}) {
+	l.logFields(logging.INFO, nil, fmt.Sprintf(format, args...))
+}
+
+func (l *JSONLogger) Notice(args ...interface{}) {
+	l.logFields(logging.NOTICE, nil, fmt.Sprint(args...))
+}
+
+func (l *JSONLogger) Noticef(format string, args ...interface{}) {
+	l.logFields(logging.NOTICE, nil, fmt.Sprintf(format, args...))
+}
+
+func (l *JSONLogger) Warning(args ...interface{}) {
+	l.logFields(logging.WARNING, nil, fmt.Sprint(args...))
+}
+
+func (l *JSONLogger) Warningf(format string, args ...interface{}) {
+	l.logFields(logging.WARNING, nil, fmt.Sprintf(format, args...))
+}
+
+func (l *JSONLogger) Error(args ...interface{}) {
+	l.logFields(logging.ERROR, nil, fmt.Sprint(args...))
+}
+
+func (l *JSONLogger) Errorf(format string, args ...interface{}) {
+	l.logFields(logging.ERROR, nil, fmt.Sprintf(format, args...))
+}
+
+func (l *JSONLogger) Critical(args ...interface{}) {
+	l.logFields(logging.CRITICAL, nil, fmt.Sprint(args...))
+}
+
+func (l *JSONLogger) Criticalf(format string, args ...interface{}) {
+	l.logFields(logging.CRITICAL, nil, fmt.Sprintf(format, args...))
+}
+
+func (l *JSONLogger) Fatal(args ...interface{}) {
+	l.logFields(logging.CRITICAL, nil, fmt.Sprint(args...))
+	os.Exit(1)
+}
+
+func (l *JSONLogger) Fatalf(format string, args ...interface{}) {
+	l.logFields(logging.CRITICAL, nil, fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
@@ -1,26 +1,143 @@
 package logs
 
 import (
+	"io"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/op/go-logging"
 )
 
+// Logger is the interface of the logger that is used by all packages of powSrv
+// Library users of PowClient can replace Log, e.g. to forward the messages to their own logger.
+type Logger interface {
+	Debug(args ...interface{})
+	Debugf(format string, args ...interface{})
+	Info(args ...interface{})
+	Infof(format string, args ...interface{})
+	Notice(args ...interface{})
+	Noticef(format string, args ...interface{})
+	Warning(args ...interface{})
+	Warningf(format string, args ...interface{})
+	Error(args ...interface{})
+	Errorf(format string, args ...interface{})
+	Critical(args ...interface{})
+	Criticalf(format string, args ...interface{})
+	Fatal(args ...interface{})
+	Fatalf(format string, args ...interface{})
+}
+
 var LOG_FORMAT = "%{color}[%{level:.4s}] %{time:15:04:05.000000} %{id:06x} [%{shortpkg}] %{longfunc} -> %{color:reset}%{message}"
-var Log = logging.MustGetLogger("powSrv")
+var Log Logger = logging.MustGetLogger("powSrv")
+
+// Output configures where the log entries are written to
+type Output struct {
+	Path       string // Log file (empty = stdout)
+	MaxSizeMB  int    // Size at which the log file is rotated (0 = no rotation)
+	MaxBackups int    // Number of rotated log files that are kept
+}
+
+var (
+	outputMutex  sync.Mutex
+	outputWriter io.Writer = os.Stdout
+	mirrorWriter io.Writer
+	outputFile   *RotatingWriter
+)
+
+// LevelTrace is the log level below DEBUG, e.g. for a dump of every IPC frame
+const LevelTrace = "TRACE"
+
+// levelTrace is the go-logging level of LevelTrace, its entries are written with DEBUG by the text format
+const levelTrace = logging.DEBUG + 1
+
+// currentLevel is the level that was set with SetLogLevel, it is restored if the output is switched
+var currentLevel = int32(logging.DEBUG)
+
+// Setup configures the output of the loggers
+// If a log file is set, WARNING and more severe entries are mirrored to stderr.
+func Setup(output Output) error {
+	var w io.Writer = os.Stdout
+	var mirror io.Writer
+	var file *RotatingWriter
+	if output.Path != "" {
+		var err error
+		file, err = NewRotatingWriter(output.Path, output.MaxSizeMB, output.MaxBackups)
+		if err != nil {
+			return err
+		}
+		w = file
+		mirror = os.Stderr
+	}
+
+	outputMutex.Lock()
+	previousFile := outputFile
+	outputWriter, mirrorWriter, outputFile = w, mirror, file
+	outputMutex.Unlock()
 
-func Setup() {
-	backend1 := logging.NewLogBackend(os.Stdout, "", 0)
 	logging.SetFormatter(logging.MustStringFormatter(LOG_FORMAT))
-	logging.SetBackend(backend1)
+	backend := logging.NewLogBackend(w, "", 0)
+	if mirror != nil {
+		mirrorBackend := logging.AddModuleLevel(logging.NewLogBackend(mirror, "", 0))
+		mirrorBackend.SetLevel(logging.WARNING, "")
+		logging.SetBackend(backend, mirrorBackend)
+	} else {
+		logging.SetBackend(backend)
+	}
+	// SetBackend resets the level
+	logging.SetLevel(backendLevel(logging.Level(atomic.LoadInt32(&currentLevel))), "powSrv")
+
+	if _, isJSON := Log.(*JSONLogger); isJSON {
+		Log = newJSONLogger(w, mirror)
+	}
+
+	if previousFile != nil {
+		previousFile.Close()
+	}
+	return nil
+}
+
+// Reopen reopens the log file, e.g. on SIGUSR1 after logrotate moved it
+func Reopen() error {
+	outputMutex.Lock()
+	file := outputFile
+	outputMutex.Unlock()
+
+	if file == nil {
+		return nil
+	}
+	return file.Reopen()
 }
 
+// SetLogLevel sets the level of the log entries, e.g. "INFO", "DEBUG" or LevelTrace
 func SetLogLevel(logLevel string) {
 	level, err := logging.LogLevel(logLevel)
+	if strings.EqualFold(logLevel, LevelTrace) {
+		level, err = levelTrace, nil
+	}
 	if err == nil {
-		logging.SetLevel(level, "powSrv")
+		atomic.StoreInt32(&currentLevel, int32(level))
+		// SetLevel is not safe while other goroutines log, e.g. if only TRACE is switched on or off
+		if logging.GetLevel("powSrv") != backendLevel(level) {
+			logging.SetLevel(backendLevel(level), "powSrv")
+		}
 	} else {
 		Log.Warningf("Could not set log level to %v: %v", logLevel, err)
 		Log.Warning("Using default log level")
 	}
 }
+
+// backendLevel returns the level of go-logging, the entries of LevelTrace are written with DEBUG
+func backendLevel(level logging.Level) logging.Level {
+	if level > logging.DEBUG {
+		return logging.DEBUG
+	}
+	return level
+}
+
+// TraceEnabled returns true if the entries of LevelTrace are logged
+// Callers check it before they format expensive trace entries.
+func TraceEnabled() bool {
+	return atomic.LoadInt32(&currentLevel) >= int32(levelTrace)
+}
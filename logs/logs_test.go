@@ -0,0 +1,244 @@
+package logs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordingLogger keeps the messages of the text format
+type recordingLogger struct {
+	Logger
+	messages []string
+}
+
+func (l *recordingLogger) Info(args ...interface{}) {
+	l.messages = append(l.messages, fmt.Sprint(args...))
+}
+
+func TestJSONLogger(t *testing.T) {
+	var buf bytes.Buffer
+	previous := Log
+	defer func() { Log = previous }()
+	Log = NewJSONLogger(&buf)
+	SetLogLevel("INFO")
+
+	entry := WithFields(Fields{"component": "server", "remoteAddr": "127.0.0.1:1234"}).WithFields(Fields{"corrID": "00000001", "reqID": 3})
+	entry.WithFields(Fields{"deviceIndex": 1, "durationMs": 42}).Infof("PoW completed in %d ms", 42)
+	entry.Debugf("Filtered by the level")
+	Log.Warningf("Plain message")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 entries, got: %v", lines)
+	}
+
+	var first map[string]interface{}
+	err := json.Unmarshal([]byte(lines[0]), &first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{"level": "INFO", "msg": "PoW completed in 42 ms", "component": "server", "remoteAddr": "127.0.0.1:1234", "corrID": "00000001", "reqID": 3.0, "deviceIndex": 1.0, "durationMs": 42.0}
+	for key, value := range expected {
+		if first[key] != value {
+			t.Errorf("Field %v: expected %v, got %v", key, value, first[key])
+		}
+	}
+	if first["time"] == nil {
+		t.Error("Time missing")
+	}
+
+	// Entries without fields get the package of the caller as component
+	var second map[string]interface{}
+	err = json.Unmarshal([]byte(lines[1]), &second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (second["level"] != "WARNING") || (second["component"] != "logs") {
+		t.Errorf("Unexpected entry: %v", second)
+	}
+}
+
+func TestTextFields(t *testing.T) {
+	previous := Log
+	defer func() { Log = previous }()
+	logger := &recordingLogger{}
+	Log = logger
+
+	WithFields(Fields{"reqID": 3, "corrID": "00000001"}).Infof("Received %v", "PowFunc")
+	if (len(logger.messages) != 1) || (logger.messages[0] != "Received PowFunc [corrID=00000001 reqID=3]") {
+		t.Errorf("Unexpected messages: %v", logger.messages)
+	}
+}
+
+func TestTraceLevel(t *testing.T) {
+	var buf bytes.Buffer
+	previous := Log
+	defer func() { Log = previous }()
+	Log = NewJSONLogger(&buf)
+	defer SetLogLevel("DEBUG")
+
+	entry := WithFields(Fields{"reqID": 3})
+	SetLogLevel("DEBUG")
+	entry.Tracef("Filtered by the level")
+	if TraceEnabled() || (buf.Len() != 0) {
+		t.Errorf("Trace entry written with DEBUG: %v", buf.String())
+	}
+
+	SetLogLevel(LevelTrace)
+	entry.Tracef("Frame %v", "recv")
+	entry.Debugf("Debug entry")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if !TraceEnabled() || (len(lines) != 2) {
+		t.Fatalf("Expected 2 entries, got: %v", lines)
+	}
+
+	var trace map[string]interface{}
+	err := json.Unmarshal([]byte(lines[0]), &trace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (trace["level"] != "TRACE") || (trace["msg"] != "Frame recv") {
+		t.Errorf("Unexpected entry: %v", trace)
+	}
+}
+
+func TestSetFormat(t *testing.T) {
+	previous := Log
+	defer func() { Log = previous }()
+
+	if err := SetFormat(FormatJSON); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := Log.(*JSONLogger); !ok {
+		t.Errorf("Expected the JSON logger, got %T", Log)
+	}
+	if err := SetFormat("xml"); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
+
+func TestRotatingWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "powsrv.log")
+	w, err := NewRotatingWriter(path, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// Concurrent writes must not interleave
+	line := strings.Repeat("x", 1023) + "\n"
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 512; j++ {
+				w.Write([]byte(line))
+			}
+		}()
+	}
+	wg.Wait()
+
+	// 4 MiB were written: 1 MiB in each file, the oldest backups are discarded
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) != 1024*1024 {
+			t.Errorf("%v: expected 1 MiB, got %d bytes", name, len(data))
+		}
+		for _, l := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+			if l+"\n" != line {
+				t.Fatalf("%v: interleaved line of %d bytes", name, len(l))
+			}
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected only 2 backups: %v", err)
+	}
+}
+
+func TestRotatingWriterReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "powsrv.log")
+	w, err := NewRotatingWriter(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	w.Write([]byte("first\n"))
+	// logrotate moves the file and sends SIGUSR1
+	if err := os.Rename(path, path+".old"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("second\n"))
+
+	if data, _ := os.ReadFile(path + ".old"); string(data) != "first\n" {
+		t.Errorf("Unexpected moved file: %q", data)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "second\n" {
+		t.Errorf("Unexpected new file: %q", data)
+	}
+}
+
+func TestSetupOutput(t *testing.T) {
+	previous := Log
+	defer func() { Log = previous }()
+	defer Setup(Output{})
+
+	path := filepath.Join(t.TempDir(), "powsrv.log")
+	if err := Setup(Output{Path: path, MaxSizeMB: 1, MaxBackups: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetFormat(FormatJSON); err != nil {
+		t.Fatal(err)
+	}
+	SetLogLevel("WARNING")
+	defer SetLogLevel("DEBUG")
+
+	Log.Info("Filtered by the level")
+	Log.Warning("Written to the file")
+	if err := Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	Log.Error("Written after the reopen")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if (len(lines) != 2) || !strings.Contains(lines[0], "Written to the file") || !strings.Contains(lines[1], "Written after the reopen") {
+		t.Errorf("Unexpected log file: %v", lines)
+	}
+
+	if err := Setup(Output{Path: filepath.Join(path, "missing", "powsrv.log")}); err == nil {
+		t.Error("Expected an error for an invalid path")
+	}
+}
+
+func TestJSONLoggerMirror(t *testing.T) {
+	var out, mirror bytes.Buffer
+	logger := newJSONLogger(&out, &mirror)
+	SetLogLevel("DEBUG")
+
+	logger.Info("Only in the output")
+	logger.Warning("In both")
+
+	if strings.Count(out.String(), "\n") != 2 {
+		t.Errorf("Expected 2 entries in the output: %v", out.String())
+	}
+	if (strings.Count(mirror.String(), "\n") != 1) || !strings.Contains(mirror.String(), "In both") {
+		t.Errorf("Expected only the warning in the mirror: %v", mirror.String())
+	}
+}
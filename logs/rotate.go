@@ -0,0 +1,117 @@
+package logs
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// RotatingWriter writes the log entries to a file that is rotated as soon as it reaches the maximum size
+// The rotated files are named <path>.1 (newest) to <path>.<maxBackups> (oldest).
+// Each call of Write is written completely before the next one starts, so lines of concurrent loggers don't interleave.
+type RotatingWriter struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mutex sync.Mutex
+	file  *os.File
+	size  int64
+}
+
+// NewRotatingWriter opens (or creates) the log file at path
+// maxSizeMB = 0 disables the rotation, maxBackups = 0 discards the file on rotation.
+func NewRotatingWriter(path string, maxSizeMB int, maxBackups int) (*RotatingWriter, error) {
+	w := &RotatingWriter{path: path, maxSize: int64(maxSizeMB) * 1024 * 1024, maxBackups: maxBackups}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// open opens the log file in append mode, the caller has to hold the mutex
+func (w *RotatingWriter) open() error {
+	file, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	w.file = file
+	w.size = info.Size()
+	return nil
+}
+
+// Write writes p to the log file and rotates the file beforehand if p doesn't fit anymore
+func (w *RotatingWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if (w.maxSize > 0) && (w.size > 0) && (w.size+int64(len(p)) > w.maxSize) {
+		if err := w.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "Log file %v could not be rotated: %v\n", w.path, err)
+			if w.file == nil {
+				return 0, err
+			}
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the backups by one and starts a new log file, the caller has to hold the mutex
+func (w *RotatingWriter) rotate() error {
+	w.file.Close()
+	w.file = nil
+
+	if w.maxBackups > 0 {
+		os.Remove(fmt.Sprintf("%v.%d", w.path, w.maxBackups))
+		for i := w.maxBackups - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%v.%d", w.path, i), fmt.Sprintf("%v.%d", w.path, i+1))
+		}
+		if err := os.Rename(w.path, w.path+".1"); err != nil && !os.IsNotExist(err) {
+			w.open()
+			return err
+		}
+	} else if err := os.Remove(w.path); err != nil && !os.IsNotExist(err) {
+		w.open()
+		return err
+	}
+
+	return w.open()
+}
+
+// Reopen closes the log file and opens it again at the configured path
+// This is needed after an external tool like logrotate moved the file.
+func (w *RotatingWriter) Reopen() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.file != nil {
+		w.file.Close()
+		w.file = nil
+	}
+	return w.open()
+}
+
+// Close closes the log file, further writes fail
+func (w *RotatingWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
@@ -0,0 +1,162 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/muxxer/powsrv"
+)
+
+// Upper bounds of the pow_duration_seconds histogram buckets
+var durationBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// deviceMetrics contains the metrics of a single POW device
+type deviceMetrics struct {
+	index         int
+	powType       string
+	requests      uint64
+	errors        uint64
+	bucketCounts  []uint64 // Cumulative counts of the durationBuckets
+	durationSum   float64
+	durationCount uint64
+}
+
+var devices = make(map[int]*deviceMetrics)
+var devicesMutex = &sync.Mutex{}
+var registerOnce sync.Once
+
+// observe is registered as powsrv.PowObserver and records the result of every POW
+func observe(device *powsrv.PowDevice, duration time.Duration, err error) {
+	devicesMutex.Lock()
+	defer devicesMutex.Unlock()
+
+	m, exists := devices[device.Index]
+	if !exists || (m.powType != device.PowType) {
+		m = &deviceMetrics{index: device.Index, powType: device.PowType, bucketCounts: make([]uint64, len(durationBuckets))}
+		devices[device.Index] = m
+	}
+
+	m.requests++
+	if err != nil {
+		m.errors++
+	}
+
+	seconds := duration.Seconds()
+	for i, bucket := range durationBuckets {
+		if seconds <= bucket {
+			m.bucketCounts[i]++
+		}
+	}
+	m.durationSum += seconds
+	m.durationCount++
+}
+
+// Register starts the collection of the POW metrics
+func Register() {
+	registerOnce.Do(func() {
+		powsrv.AddPowObserver(observe)
+	})
+}
+
+// Handler returns the HTTP handler that serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	Register()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(w)
+	})
+}
+
+// HealthHandler returns the HTTP handler that checks all POW devices
+// It responds with 200 if all devices are healthy and 503 otherwise
+func HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		health := powsrv.CheckHealth(powsrv.HealthCheckTimeout)
+
+		w.Header().Set("Content-Type", "application/json")
+		if !powsrv.Healthy(health) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(health)
+	})
+}
+
+// Start serves the metrics on "/metrics" and the health check on "/healthz" of the given address
+// An error is returned if the address can't be used, the server keeps running in the background otherwise
+func Start(address string) error {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	mux.Handle("/healthz", HealthHandler())
+	go http.Serve(ln, mux)
+
+	return nil
+}
+
+// writeMetrics writes all metrics in the Prometheus text format
+func writeMetrics(w io.Writer) {
+	stats := powsrv.GetServerStats()
+
+	devicesMutex.Lock()
+	var indexes []int
+	for index := range devices {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	fmt.Fprintln(w, "# HELP pow_requests_total Number of POW requests done by the device.")
+	fmt.Fprintln(w, "# TYPE pow_requests_total counter")
+	for _, index := range indexes {
+		m := devices[index]
+		fmt.Fprintf(w, "pow_requests_total{%s} %d\n", labels(m.powType, m.index), m.requests)
+	}
+
+	fmt.Fprintln(w, "# HELP pow_errors_total Number of POW requests that failed on the device.")
+	fmt.Fprintln(w, "# TYPE pow_errors_total counter")
+	for _, index := range indexes {
+		m := devices[index]
+		fmt.Fprintf(w, "pow_errors_total{%s} %d\n", labels(m.powType, m.index), m.errors)
+	}
+
+	fmt.Fprintln(w, "# HELP pow_duration_seconds Duration of the POW on the device.")
+	fmt.Fprintln(w, "# TYPE pow_duration_seconds histogram")
+	for _, index := range indexes {
+		m := devices[index]
+		for i, bucket := range durationBuckets {
+			fmt.Fprintf(w, "pow_duration_seconds_bucket{%s,le=\"%g\"} %d\n", labels(m.powType, m.index), bucket, m.bucketCounts[i])
+		}
+		fmt.Fprintf(w, "pow_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels(m.powType, m.index), m.durationCount)
+		fmt.Fprintf(w, "pow_duration_seconds_sum{%s} %g\n", labels(m.powType, m.index), m.durationSum)
+		fmt.Fprintf(w, "pow_duration_seconds_count{%s} %d\n", labels(m.powType, m.index), m.durationCount)
+	}
+	devicesMutex.Unlock()
+
+	fmt.Fprintln(w, "# HELP pow_queue_depth Number of POW requests that wait for an idle device.")
+	fmt.Fprintln(w, "# TYPE pow_queue_depth gauge")
+	fmt.Fprintf(w, "pow_queue_depth %d\n", stats.QueueLength)
+
+	fmt.Fprintln(w, "# HELP pow_device_busy Device is currently doing POW.")
+	fmt.Fprintln(w, "# TYPE pow_device_busy gauge")
+	for _, device := range stats.Devices {
+		busy := 0
+		if device.Busy {
+			busy = 1
+		}
+		fmt.Fprintf(w, "pow_device_busy{%s} %d\n", labels(device.PowType, device.Index), busy)
+	}
+}
+
+// labels returns the Prometheus labels of a device
+func labels(powType string, index int) string {
+	return fmt.Sprintf("type=%q,index=\"%d\"", powType, index)
+}
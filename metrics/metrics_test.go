@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/iotaledger/giota"
+
+	"github.com/muxxer/powsrv"
+)
+
+func TestMetrics(t *testing.T) {
+	server := httptest.NewServer(Handler())
+	defer server.Close()
+
+	powsrv.SetPowDevices([]*powsrv.PowDevice{{Index: 0, PowType: "gIOTA-Go", PowFunc: giota.PowGo}})
+
+	trytes, err := giota.ToTrytes(strings.Repeat("9", 2673))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		_, err := powsrv.PowFunc(trytes, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp, err := server.Client().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		`pow_requests_total{type="gIOTA-Go",index="0"} 3`,
+		`pow_errors_total{type="gIOTA-Go",index="0"} 0`,
+		`pow_duration_seconds_count{type="gIOTA-Go",index="0"} 3`,
+		`pow_duration_seconds_bucket{type="gIOTA-Go",index="0",le="+Inf"} 3`,
+		`pow_queue_depth 0`,
+		`pow_device_busy{type="gIOTA-Go",index="0"} 0`,
+	} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("Metric not found: %v\n%s", expected, body)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package powsrv
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/muxxer/powsrv/logs"
+)
+
+// outboxSize is the number of frames that are buffered for a client
+// It fits the responses of all 256 ReqIDs and their progress notifications, so only a client that doesn't read fills it.
+const outboxSize = 512
+
+// errConnectionClosed is returned if a frame is sent after the connection was closed
+var errConnectionClosed = errors.New("Connection closed")
+
+// errOutboxFull is returned if the client doesn't read its frames, the connection is closed
+var errOutboxFull = errors.New("Client doesn't read the responses")
+
+// outboxFrame is a frame that waits to be written to the client
+type outboxFrame struct {
+	reqID   byte
+	command byte
+	data    []byte
+}
+
+// send queues a frame for the client
+// It never blocks, so a client that stops reading can't stall the device workers.
+// If the outbox of the client is full, the connection is closed.
+func (c *clientConnection) send(reqID byte, command byte, data []byte) error {
+	select {
+	case <-c.closed:
+		return errConnectionClosed
+	default:
+	}
+
+	select {
+	case c.outbox <- outboxFrame{reqID: reqID, command: command, data: data}:
+		return nil
+	default:
+		logs.Log.Warningf("Closed connection of %v, the client doesn't read the responses", c.address())
+		c.close()
+		return errOutboxFull
+	}
+}
+
+// finish closes the connection as soon as the queued frames are written
+func (c *clientConnection) finish() {
+	c.finishOnce.Do(func() {
+		close(c.finished)
+	})
+}
+
+// close closes the connection, the queued frames are discarded
+func (c *clientConnection) close() {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.Close()
+	})
+}
+
+// writeFrames writes the queued frames to the client until the connection is closed
+// If a write takes longer than the timeout, the client is considered dead and the connection is closed.
+// After finish was called, the remaining frames are written before the connection is closed.
+func (c *clientConnection) writeFrames(timeout time.Duration) {
+	defer close(c.writerDone)
+	defer c.close()
+
+	for {
+		select {
+		case <-c.closed:
+			return
+
+		case frame := <-c.outbox:
+			if !c.writeFrame(frame, timeout) {
+				return
+			}
+
+		case <-c.finished:
+			for {
+				select {
+				case frame := <-c.outbox:
+					if !c.writeFrame(frame, timeout) {
+						return
+					}
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// writeFrame writes a single frame with the write deadline and returns false if the connection is broken
+func (c *clientConnection) writeFrame(frame outboxFrame, timeout time.Duration) bool {
+	if timeout > 0 {
+		c.SetWriteDeadline(time.Now().Add(timeout))
+	}
+
+	err := c.writer.WriteFrame(frame.reqID, frame.command, frame.data)
+	if err == nil {
+		traceFrame(frameSent, c.owner.address, frame.reqID, frame.command, frame.data, "")
+		return true
+	}
+
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		logs.Log.Warningf("Closed connection of %v, writing a frame took longer than %v", c.address(), timeout)
+	} else {
+		logs.Log.Debugf("Writing a frame to %v failed: %v", c.address(), err)
+	}
+	return false
+}
@@ -0,0 +1,25 @@
+//go:build !windows
+
+package powsrv
+
+import (
+	"net"
+)
+
+const (
+	// DefaultNetwork is the network of the local powSrv listener and of PowClient if no Network is set
+	DefaultNetwork = "unix"
+
+	// DefaultAddress is the address of the local powSrv listener
+	DefaultAddress = "/tmp/powSrv.sock"
+)
+
+// dialPipe fails, named pipes are only available on Windows
+func dialPipe(address string) (net.Conn, error) {
+	return nil, ErrPipeUnsupported
+}
+
+// ListenPipe fails, named pipes are only available on Windows
+func ListenPipe(address string) (net.Listener, error) {
+	return nil, ErrPipeUnsupported
+}
@@ -0,0 +1,21 @@
+//go:build !windows
+
+package powsrv
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPipeUnsupported(t *testing.T) {
+	powClient := &PowClient{Network: "pipe", Address: `\\.\pipe\powSrv`, WriteTimeOutMs: 500, ReadTimeOutMs: 5000}
+	err := powClient.Init()
+	if !errors.Is(err, ErrPipeUnsupported) {
+		t.Errorf("Expected ErrPipeUnsupported, got: %v", err)
+	}
+
+	_, err = ListenPipe(`\\.\pipe\powSrv`)
+	if !errors.Is(err, ErrPipeUnsupported) {
+		t.Errorf("Expected ErrPipeUnsupported, got: %v", err)
+	}
+}
@@ -0,0 +1,25 @@
+package powsrv
+
+import (
+	"net"
+
+	"github.com/Microsoft/go-winio"
+)
+
+const (
+	// DefaultNetwork is the network of the local powSrv listener and of PowClient if no Network is set
+	DefaultNetwork = "pipe"
+
+	// DefaultAddress is the address of the local powSrv listener
+	DefaultAddress = `\\.\pipe\powSrv`
+)
+
+// dialPipe connects to the named pipe of a powSrv
+func dialPipe(address string) (net.Conn, error) {
+	return winio.DialPipe(address, nil)
+}
+
+// ListenPipe creates a listener on a named pipe, e.g. \\.\pipe\powSrv
+func ListenPipe(address string) (net.Listener, error) {
+	return winio.ListenPipe(address, nil)
+}
@@ -0,0 +1,275 @@
+package powsrv
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/iotaledger/giota"
+)
+
+const (
+	defaultProbeIntervalMs = 10000
+	probeTimeout           = 5 * time.Second
+)
+
+// PowClientPool sends the requests to the fastest healthy powSrv of several endpoints
+// Requests that fail with a connection or busy error are retried on the next endpoint.
+// Endpoints that fail are marked down and probed periodically with RefreshPowInfo.
+type PowClientPool struct {
+	Clients         []*PowClient // Endpoints of the pool, Init is called by the pool
+	ProbeIntervalMs int          // Interval in ms to probe the endpoints (default: 10000)
+
+	mutex     sync.Mutex
+	endpoints []*poolEndpoint
+	stop      chan struct{}
+	done      sync.WaitGroup
+}
+
+// poolEndpoint is the state of an endpoint of the pool
+type poolEndpoint struct {
+	client  *PowClient
+	up      bool
+	latency time.Duration // Round trip time of the last probe
+	lastErr error
+}
+
+// PoolEndpointStatus is the state of an endpoint of a PowClientPool
+type PoolEndpointStatus struct {
+	Network string
+	Address string
+	Up      bool
+	Latency time.Duration
+	Error   error // Error that marked the endpoint down
+}
+
+// Init probes all endpoints and starts the periodic probing
+// It fails if none of the endpoints is reachable.
+func (p *PowClientPool) Init() error {
+	if len(p.Clients) == 0 {
+		return ErrNotConnected
+	}
+
+	p.mutex.Lock()
+	p.endpoints = make([]*poolEndpoint, len(p.Clients))
+	for i, client := range p.Clients {
+		p.endpoints[i] = &poolEndpoint{client: client}
+	}
+	p.stop = make(chan struct{})
+	p.mutex.Unlock()
+
+	p.probeAll()
+
+	if len(p.healthyEndpoints()) == 0 {
+		p.Close()
+		return ErrNotConnected
+	}
+
+	interval := time.Duration(p.ProbeIntervalMs) * time.Millisecond
+	if interval <= 0 {
+		interval = defaultProbeIntervalMs * time.Millisecond
+	}
+
+	p.done.Add(1)
+	go p.probeLoop(interval, p.stop)
+	return nil
+}
+
+// Close stops the probing and closes the connections to all endpoints
+func (p *PowClientPool) Close() error {
+	p.mutex.Lock()
+	stop := p.stop
+	p.stop = nil
+	endpoints := p.endpoints
+	p.mutex.Unlock()
+
+	if stop == nil {
+		return ErrNotConnected
+	}
+	close(stop)
+	p.done.Wait()
+
+	for _, e := range endpoints {
+		e.client.Close()
+	}
+	return nil
+}
+
+// Endpoints returns the state of all endpoints of the pool
+func (p *PowClientPool) Endpoints() []PoolEndpointStatus {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	status := make([]PoolEndpointStatus, 0, len(p.endpoints))
+	for _, e := range p.endpoints {
+		network, address := e.client.endpoint()
+		status = append(status, PoolEndpointStatus{Network: network, Address: address, Up: e.up, Latency: e.latency, Error: e.lastErr})
+	}
+	return status
+}
+
+// probeLoop probes all endpoints until stop is closed
+func (p *PowClientPool) probeLoop(interval time.Duration, stop chan struct{}) {
+	defer p.done.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			p.probeAll()
+		}
+	}
+}
+
+// probeAll probes all endpoints in parallel
+func (p *PowClientPool) probeAll() {
+	p.mutex.Lock()
+	endpoints := p.endpoints
+	p.mutex.Unlock()
+
+	var wg sync.WaitGroup
+	for _, e := range endpoints {
+		wg.Add(1)
+		go func(e *poolEndpoint) {
+			defer wg.Done()
+			p.probe(e)
+		}(e)
+	}
+	wg.Wait()
+}
+
+// probe (re)connects to the endpoint if needed and measures the round trip time of RefreshPowInfo
+func (p *PowClientPool) probe(e *poolEndpoint) {
+	if !e.client.connected() {
+		err := e.client.Init()
+		if err != nil {
+			p.markDown(e, err)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+
+	start := time.Now()
+	_, _, _, err := e.client.RefreshPowInfoWithContext(ctx)
+	if err != nil {
+		p.markDown(e, err)
+		return
+	}
+
+	p.mutex.Lock()
+	e.up = true
+	e.latency = time.Since(start)
+	e.lastErr = nil
+	p.mutex.Unlock()
+}
+
+// markDown excludes the endpoint from the requests until the next successful probe
+func (p *PowClientPool) markDown(e *poolEndpoint, err error) {
+	p.mutex.Lock()
+	e.up = false
+	e.lastErr = err
+	p.mutex.Unlock()
+}
+
+// healthyEndpoints returns the endpoints that are up, the fastest first
+func (p *PowClientPool) healthyEndpoints() []*poolEndpoint {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var healthy []*poolEndpoint
+	for _, e := range p.endpoints {
+		if e.up {
+			healthy = append(healthy, e)
+		}
+	}
+
+	sort.SliceStable(healthy, func(i, j int) bool {
+		return healthy[i].latency < healthy[j].latency
+	})
+	return healthy
+}
+
+// retryable returns true if the request can be sent to another endpoint,
+// down is true if the endpoint should not be used until the next successful probe
+func retryable(err error) (retry bool, down bool) {
+	var rateLimitErr *RateLimitError
+	var netErr net.Error
+
+	switch {
+	case errors.Is(err, ErrServerBusy), errors.As(err, &rateLimitErr):
+		return true, false
+	case errors.Is(err, ErrNotConnected), errors.Is(err, ErrConnectionLost), errors.Is(err, ErrTimeout), errors.As(err, &netErr):
+		return true, true
+	default:
+		return false, false
+	}
+}
+
+// do sends the request to the healthy endpoints until one of them succeeds
+// POW is idempotent, so a request that failed on one endpoint can safely be repeated on another one.
+func (p *PowClientPool) do(ctx context.Context, request func(client *PowClient) error) error {
+	err := ErrNotConnected
+
+	for _, e := range p.healthyEndpoints() {
+		err = request(e.client)
+		if err == nil {
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			return err
+		}
+
+		retry, down := retryable(err)
+		if down {
+			p.markDown(e, err)
+		}
+		if !retry {
+			return err
+		}
+	}
+
+	return err
+}
+
+// PowFunc does the POW on the fastest healthy endpoint
+// It has the signature of giota.PowFunc.
+func (p *PowClientPool) PowFunc(trytes giota.Trytes, minWeightMagnitude int) (result giota.Trytes, Error error) {
+	return p.PowFuncWithContext(context.Background(), trytes, minWeightMagnitude)
+}
+
+// PowFuncWithContext does the POW on the fastest healthy endpoint
+// The POW is cancelled as soon as the context is done
+func (p *PowClientPool) PowFuncWithContext(ctx context.Context, trytes giota.Trytes, minWeightMagnitude int) (result giota.Trytes, Error error) {
+	err := p.do(ctx, func(client *PowClient) error {
+		var err error
+		result, err = client.PowFuncWithContext(ctx, trytes, minWeightMagnitude)
+		return err
+	})
+	return result, err
+}
+
+// PowBatch does the POW of all transactions of a bundle on the fastest healthy endpoint
+func (p *PowClientPool) PowBatch(trytes []giota.Trytes, minWeightMagnitude int, reversed bool) (result []giota.Trytes, Error error) {
+	return p.PowBatchWithContext(context.Background(), trytes, minWeightMagnitude, reversed)
+}
+
+// PowBatchWithContext does the POW of all transactions of a bundle on the fastest healthy endpoint
+// The POW is cancelled as soon as the context is done
+func (p *PowClientPool) PowBatchWithContext(ctx context.Context, trytes []giota.Trytes, minWeightMagnitude int, reversed bool) (result []giota.Trytes, Error error) {
+	err := p.do(ctx, func(client *PowClient) error {
+		var err error
+		result, err = client.PowBatchWithContext(ctx, trytes, minWeightMagnitude, reversed)
+		return err
+	})
+	return result, err
+}
@@ -0,0 +1,142 @@
+package powsrv
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iotaledger/giota"
+	"github.com/spf13/viper"
+)
+
+// poolServer is a TCP powSrv whose client connections can be dropped
+type poolServer struct {
+	ln    net.Listener
+	mutex sync.Mutex
+	conns []net.Conn
+}
+
+func startPoolServer(t *testing.T, config *viper.Viper) *poolServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &poolServer{ln: ln}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mutex.Lock()
+			s.conns = append(s.conns, c)
+			s.mutex.Unlock()
+			go HandleClientConnection(c, config)
+		}
+	}()
+	return s
+}
+
+// dropConnections closes all client connections, the listener keeps running
+func (s *poolServer) dropConnections() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, c := range s.conns {
+		c.Close()
+	}
+	s.conns = nil
+}
+
+func (s *poolServer) close() {
+	s.ln.Close()
+	s.dropConnections()
+}
+
+func TestPoolFailover(t *testing.T) {
+	config := viper.New()
+	config.Set("pow.maxMinWeightMagnitude", 243)
+	SetPowDevices([]*PowDevice{{PowType: "test", PowFunc: func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return trytes, nil
+	}}})
+
+	primary := startPoolServer(t, config)
+	defer primary.close()
+	backup := startPoolServer(t, config)
+	defer backup.close()
+
+	// Nothing listens on the address of a closed listener
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unreachable := ln.Addr().String()
+	ln.Close()
+
+	pool := &PowClientPool{
+		Clients: []*PowClient{
+			{Network: "tcp", Address: primary.ln.Addr().String(), WriteTimeOutMs: 500, ReadTimeOutMs: 5000},
+			{Network: "tcp", Address: backup.ln.Addr().String(), WriteTimeOutMs: 500, ReadTimeOutMs: 5000},
+			{Network: "tcp", Address: unreachable, WriteTimeOutMs: 500, ReadTimeOutMs: 5000},
+		},
+		ProbeIntervalMs: 3600000,
+	}
+	err = pool.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	status := pool.Endpoints()
+	if !status[0].Up || !status[1].Up || status[2].Up {
+		t.Fatalf("Unexpected endpoint state after Init: %+v", status)
+	}
+
+	// Make the primary the fastest endpoint
+	pool.mutex.Lock()
+	pool.endpoints[0].latency = time.Millisecond
+	pool.endpoints[1].latency = time.Second
+	pool.mutex.Unlock()
+
+	data, err := giota.ToTrytes(transaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := pool.PowFunc(data, MWM)
+	if (err != nil) || (response != data) {
+		t.Fatalf("Unexpected result: %v", err)
+	}
+
+	// The request is retried on the backup after the primary dropped the connection
+	primary.dropConnections()
+	response, err = pool.PowFunc(data, MWM)
+	if (err != nil) || (response != data) {
+		t.Fatalf("Unexpected result after the primary failed: %v", err)
+	}
+	if pool.Endpoints()[0].Up {
+		t.Error("Expected the primary to be marked down")
+	}
+
+	// The next probe reconnects to the primary
+	pool.probeAll()
+	status = pool.Endpoints()
+	if !status[0].Up || !status[1].Up || status[2].Up {
+		t.Errorf("Unexpected endpoint state after the probe: %+v", status)
+	}
+
+	backup.close()
+	primary.close()
+	_, err = pool.PowFunc(data, MWM)
+	if err == nil {
+		t.Error("Expected an error without reachable endpoints")
+	}
+}
+
+func TestPoolNoEndpoint(t *testing.T) {
+	pool := &PowClientPool{Clients: []*PowClient{{Network: "unix", Address: "/nonexistent/powSrv.sock"}}}
+	if err := pool.Init(); err != ErrNotConnected {
+		t.Errorf("Expected ErrNotConnected, got: %v", err)
+	}
+}
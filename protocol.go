@@ -0,0 +1,71 @@
+package powsrv
+
+import "github.com/muxxer/powsrv/ipc"
+
+// Deprecated: The IPC protocol is implemented in the ipc package, these aliases are kept for existing clients.
+const (
+	FrameStateSearchEnq     = ipc.FrameStateSearchEnq
+	FrameStateSearchVersion = ipc.FrameStateSearchVersion
+	FrameStateSearchLength  = ipc.FrameStateSearchLength
+	FrameStateSearchData    = ipc.FrameStateSearchData
+	FrameStateSearchCRC     = ipc.FrameStateSearchCRC
+
+	IpcCmdNotification     = ipc.CmdNotification
+	IpcCmdResponse         = ipc.CmdResponse
+	IpcCmdError            = ipc.CmdError
+	IpcCmdGetServerVersion = ipc.CmdGetServerVersion
+	IpcCmdGetPowType       = ipc.CmdGetPowType
+	IpcCmdGetPowVersion    = ipc.CmdGetPowVersion
+	IpcCmdPowFunc          = ipc.CmdPowFunc
+	IpcCmdCancel           = ipc.CmdCancel
+	IpcCmdGetServerStats   = ipc.CmdGetServerStats
+	IpcCmdHealthCheck      = ipc.CmdHealthCheck
+	IpcCmdPowFuncBatch     = ipc.CmdPowFuncBatch
+	IpcCmdGetFrameVersions = ipc.CmdGetFrameVersions
+	IpcCmdGetDeviceList    = ipc.CmdGetDeviceList
+	IpcCmdSelectDevice     = ipc.CmdSelectDevice
+	IpcCmdGetInfo          = ipc.CmdGetInfo
+	IpcCmdGetLimits        = ipc.CmdGetLimits
+
+	IpcFrameVersion1 = ipc.Version1
+	IpcFrameVersion2 = ipc.Version2
+
+	PowBatchFlagReversed = ipc.PowBatchFlagReversed
+)
+
+// IpcMessage is the container of an IpcFrameV1 (deprecated, use ipc.MessageV1)
+type IpcMessage = ipc.MessageV1
+
+// IpcFrameV1 contains the information of the IPC communication (deprecated, use ipc.FrameV1)
+type IpcFrameV1 = ipc.FrameV1
+
+// IpcMessageV2 is the container of an IpcFrameV2 (deprecated, use ipc.MessageV2)
+type IpcMessageV2 = ipc.MessageV2
+
+// IpcFrameV2 contains the information of the IPC communication with 32 bit data length (deprecated, use ipc.FrameV2)
+type IpcFrameV2 = ipc.FrameV2
+
+// BytesToIpcMessage converts a byte slice to an IpcMessage (deprecated, use ipc.BytesToMessageV1)
+func BytesToIpcMessage(data []byte) (*IpcMessage, error) {
+	return ipc.BytesToMessageV1(data)
+}
+
+// BytesToIpcFrameV1 converts a byte slice to an IpcFrameV1 (deprecated, use ipc.BytesToFrameV1)
+func BytesToIpcFrameV1(data []byte) (*IpcFrameV1, error) {
+	return ipc.BytesToFrameV1(data)
+}
+
+// BytesToIpcFrameV2 converts a byte slice to an IpcFrameV2 (deprecated, use ipc.BytesToFrameV2)
+func BytesToIpcFrameV2(data []byte) (*IpcFrameV2, error) {
+	return ipc.BytesToFrameV2(data)
+}
+
+// NewIpcMessageV1 creates a new IpcFrameV1 embedded in an IpcMessage (deprecated, use ipc.NewMessageV1)
+func NewIpcMessageV1(requestID byte, command byte, data []byte) (*IpcMessage, error) {
+	return ipc.NewMessageV1(requestID, command, data)
+}
+
+// NewIpcMessageV2 creates a new IpcFrameV2 embedded in an IpcMessageV2 (deprecated, use ipc.NewMessageV2)
+func NewIpcMessageV2(requestID byte, command byte, data []byte) (*IpcMessageV2, error) {
+	return ipc.NewMessageV2(requestID, command, data)
+}
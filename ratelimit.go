@@ -0,0 +1,92 @@
+package powsrv
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/muxxer/powsrv/ipc"
+)
+
+// RateLimitError is returned if the powSrv rejected a request, because the client exceeded "pow.maxRequestsPerMinute"
+type RateLimitError struct {
+	RetryAfter time.Duration // Time until the powSrv accepts the next request
+	err        *ServerError
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("powSrv rate limit exceeded, retry after %v", e.RetryAfter)
+}
+
+// Unwrap returns the ServerError of the powSrv
+func (e *RateLimitError) Unwrap() error {
+	return e.err
+}
+
+// rateLimiter is a token bucket that allows requestsPerMinute requests per minute
+// Unused tokens are saved up to the requests of one minute.
+type rateLimiter struct {
+	mutex    sync.Mutex
+	interval time.Duration // Time to refill a single token
+	capacity float64
+	tokens   float64
+	last     time.Time
+}
+
+// newRateLimiter returns nil if requestsPerMinute is 0, a nil rateLimiter allows all requests
+func newRateLimiter(requestsPerMinute int) *rateLimiter {
+	if requestsPerMinute <= 0 {
+		return nil
+	}
+
+	return &rateLimiter{
+		interval: time.Minute / time.Duration(requestsPerMinute),
+		capacity: float64(requestsPerMinute),
+		tokens:   float64(requestsPerMinute),
+		last:     time.Now(),
+	}
+}
+
+// allow takes a token of the bucket
+// If the bucket is empty, it returns false and the time until the next token is available
+func (l *rateLimiter) allow() (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	l.tokens += float64(now.Sub(l.last)) / float64(l.interval)
+	if l.tokens > l.capacity {
+		l.tokens = l.capacity
+	}
+	l.last = now
+
+	if l.tokens < 1 {
+		return false, time.Duration((1 - l.tokens) * float64(l.interval))
+	}
+	l.tokens--
+	return true, 0
+}
+
+// rateLimitedError returns the error of a rejected request with the retry-after hint in ms
+func rateLimitedError(retryAfter time.Duration) *ServerError {
+	return newServerError(ipc.ErrorRateLimited, "%d", (retryAfter+time.Millisecond-1)/time.Millisecond)
+}
+
+// parseRateLimited returns the retry-after hint of a server error
+// It returns false if the error is not a RATE_LIMITED error
+func parseRateLimited(serverErr *ServerError) (time.Duration, bool) {
+	if serverErr.Code != ipc.ErrorRateLimited {
+		return 0, false
+	}
+
+	var retryAfterMs int64
+	_, err := fmt.Sscanf(serverErr.Message, "%d", &retryAfterMs)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(retryAfterMs) * time.Millisecond, true
+}
@@ -0,0 +1,142 @@
+package powsrv
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Scheduler policies of "pow.schedulerPolicy"
+const (
+	SchedulerFirstIdle    = "first-idle"    // The job is started on the first device that gets idle
+	SchedulerFastestFirst = "fastest-first" // The job waits for the fastest device, slower devices only get it after the spill threshold
+	SchedulerRoundRobin   = "round-robin"   // The idle devices take turns
+)
+
+const (
+	schedulerFirstIdle int32 = iota
+	schedulerFastestFirst
+	schedulerRoundRobin
+)
+
+// durationEstimateWeight is the weight of the newest POW duration in the moving average of a device
+const durationEstimateWeight = 0.2
+
+var schedulerPolicy int32 = schedulerFirstIdle
+var spillThreshold int64 = int64(100 * time.Millisecond) // Queue latency before fastest-first uses a slower device
+
+// SetSchedulerPolicy sets the policy that assigns the queued jobs to the devices
+func SetSchedulerPolicy(policy string) error {
+	var value int32
+	switch policy {
+	case SchedulerFirstIdle:
+		value = schedulerFirstIdle
+	case SchedulerFastestFirst:
+		value = schedulerFastestFirst
+	case SchedulerRoundRobin:
+		value = schedulerRoundRobin
+	default:
+		return fmt.Errorf("Unknown scheduler policy: %v", policy)
+	}
+	atomic.StoreInt32(&schedulerPolicy, value)
+
+	// Let the workers check the queued jobs with the new policy
+	d := getDispatcher()
+	d.mutex.Lock()
+	d.mutex.Unlock()
+	d.jobs.Broadcast()
+	return nil
+}
+
+// SetSpillThreshold sets the time a job waits for a faster device with the fastest-first policy
+func SetSpillThreshold(threshold time.Duration) {
+	atomic.StoreInt64(&spillThreshold, int64(threshold))
+}
+
+// estimatedDuration returns the moving average of the POW durations of the device, 0 if it is not known yet
+func (d *PowDevice) estimatedDuration() time.Duration {
+	return time.Duration(atomic.LoadInt64(&d.estimate))
+}
+
+// updateEstimate adds a duration of a successful POW to the moving average
+// Only the worker of the device updates the estimate.
+func (d *PowDevice) updateEstimate(duration time.Duration) {
+	estimate := d.estimatedDuration()
+	if estimate == 0 {
+		estimate = duration
+	} else {
+		estimate += time.Duration(durationEstimateWeight * float64(duration-estimate))
+	}
+	atomic.StoreInt64(&d.estimate, int64(estimate))
+}
+
+// mayStart returns true if the idle device may start the job according to the scheduler policy
+// Otherwise the job is left for another device, which is woken up by a broadcast.
+// Devices that may not start the job (see powJob.eligible) are not considered, the job would wait for them forever.
+// The dispatcher mutex must be held by the caller
+func (d *powDispatcher) mayStart(device *PowDevice, job *powJob) bool {
+	switch atomic.LoadInt32(&schedulerPolicy) {
+
+	case schedulerRoundRobin:
+		// The idle device that started a job least recently is next
+		for _, other := range d.devices {
+			if (other != device) && d.active(other) && job.eligible(other) && !other.busy && (other.lastStarted < device.lastStarted) {
+				return false
+			}
+		}
+		return true
+
+	case schedulerFastestFirst:
+		// Devices without measured durations are treated as fast, so they are measured
+		estimate := device.estimatedDuration()
+		fasterBusy := false
+		for _, other := range d.devices {
+			if (other == device) || !d.active(other) || !job.eligible(other) || (other.estimatedDuration() >= estimate) {
+				continue
+			}
+			if !other.busy {
+				return false
+			}
+			fasterBusy = true
+		}
+		if !fasterBusy {
+			return true
+		}
+
+		// Spill to the slower device if the job waited too long for the faster ones
+		waited := time.Since(job.queued)
+		threshold := time.Duration(atomic.LoadInt64(&spillThreshold))
+		if waited >= threshold {
+			return true
+		}
+		d.wakeAfter(threshold - waited)
+		return false
+
+	default:
+		return true
+	}
+}
+
+// wakeAfter wakes up the workers after the given time, so that the deferred jobs are checked again
+// The dispatcher mutex must be held by the caller
+func (d *powDispatcher) wakeAfter(delay time.Duration) {
+	wakeupAt := time.Now().Add(delay)
+	if (d.wakeup != nil) && !d.wakeupAt.After(wakeupAt) {
+		return
+	}
+	if d.wakeup != nil {
+		d.wakeup.Stop()
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(delay, func() {
+		d.mutex.Lock()
+		if d.wakeup == timer {
+			d.wakeup = nil
+		}
+		d.mutex.Unlock()
+		d.jobs.Broadcast()
+	})
+	d.wakeup = timer
+	d.wakeupAt = wakeupAt
+}
@@ -0,0 +1,101 @@
+package powsrv
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iotaledger/giota"
+)
+
+// runSchedulerJobs does the POW of the given number of jobs on a fast and a slow device
+// It returns the number of jobs that were done by every device.
+func runSchedulerJobs(t *testing.T, policy string, spill time.Duration, jobs int, concurrent bool, learned bool) (fast uint64, slow uint64) {
+	err := SetSchedulerPolicy(policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetSpillThreshold(spill)
+
+	fastDevice := NewPowDeviceFromDriver(0, &fakeDriver{latency: 5 * time.Millisecond})
+	slowDevice := NewPowDeviceFromDriver(1, &fakeDriver{latency: 50 * time.Millisecond})
+	if learned {
+		fastDevice.updateEstimate(5 * time.Millisecond)
+		slowDevice.updateEstimate(50 * time.Millisecond)
+	}
+	SetPowDevices([]*PowDevice{fastDevice, slowDevice})
+
+	var wg sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		pow := func() {
+			defer wg.Done()
+			_, err := PowFunc(giota.Trytes(transaction), MWM)
+			if err != nil {
+				t.Error(err)
+			}
+		}
+
+		if concurrent {
+			go pow()
+		} else {
+			pow()
+		}
+	}
+	wg.Wait()
+
+	return fastDevice.Requests(), slowDevice.Requests()
+}
+
+func TestSchedulerPolicies(t *testing.T) {
+	defer SetSchedulerPolicy(SchedulerFirstIdle)
+	defer SetSpillThreshold(100 * time.Millisecond)
+
+	// The devices take turns
+	fast, slow := runSchedulerJobs(t, SchedulerRoundRobin, 0, 20, false, false)
+	if (fast != 10) || (slow != 10) {
+		t.Errorf("round-robin: expected 10/10 jobs, got %d/%d", fast, slow)
+	}
+
+	// The durations are learned, the slow device only gets the job that measured it
+	fast, slow = runSchedulerJobs(t, SchedulerFastestFirst, time.Second, 20, false, false)
+	if (fast+slow != 20) || (slow > 1) {
+		t.Errorf("fastest-first: expected at most 1 job on the slow device, got %d/%d", fast, slow)
+	}
+
+	// The fast device finishes all jobs before the spill threshold
+	fast, slow = runSchedulerJobs(t, SchedulerFastestFirst, time.Second, 20, true, true)
+	if (fast != 20) || (slow != 0) {
+		t.Errorf("fastest-first: expected all jobs on the fast device, got %d/%d", fast, slow)
+	}
+
+	// The jobs spill to the slow device if they wait too long
+	fast, slow = runSchedulerJobs(t, SchedulerFastestFirst, 10*time.Millisecond, 20, true, true)
+	if (fast+slow != 20) || (slow == 0) || (fast <= slow) {
+		t.Errorf("fastest-first: expected most jobs on the fast device and some on the slow device, got %d/%d", fast, slow)
+	}
+
+	// Both devices work on the queued jobs
+	fast, slow = runSchedulerJobs(t, SchedulerFirstIdle, 0, 20, true, false)
+	if (fast+slow != 20) || (fast == 0) || (slow == 0) {
+		t.Errorf("first-idle: expected jobs on both devices, got %d/%d", fast, slow)
+	}
+
+	err := SetSchedulerPolicy("unknown")
+	if err == nil {
+		t.Error("Unknown policy accepted")
+	}
+}
+
+func TestDurationEstimate(t *testing.T) {
+	device := &PowDevice{}
+	device.updateEstimate(100 * time.Millisecond)
+	if device.estimatedDuration() != 100*time.Millisecond {
+		t.Errorf("The first duration is the estimate, got %v", device.estimatedDuration())
+	}
+
+	device.updateEstimate(200 * time.Millisecond)
+	if device.estimatedDuration() != 120*time.Millisecond {
+		t.Errorf("Expected the moving average 120ms, got %v", device.estimatedDuration())
+	}
+}
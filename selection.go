@@ -0,0 +1,133 @@
+package powsrv
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/muxxer/powsrv/ipc"
+	"github.com/muxxer/powsrv/logs"
+)
+
+// Device classes of the device selection (ipc.CmdSelectDevice, PowClient.PreferredDevice)
+const (
+	DeviceClassAny  = "any"  // Any device, the dispatcher decides
+	DeviceClassFPGA = "fpga" // PiDiver, USBDiver, FTDiver
+	DeviceClassCPU  = "cpu"  // Software POW of the giota types
+)
+
+// ClassifiedDriver is implemented by drivers that report the class of their device,
+// so that clients can select the device by its class
+type ClassifiedDriver interface {
+	Class() string // DeviceClassFPGA or DeviceClassCPU
+}
+
+// deviceSelection restricts the devices that may do the POW requests of a client
+type deviceSelection struct {
+	class string // DeviceClassFPGA or DeviceClassCPU, empty for a single device or any device
+	index int    // Index of the selected device, -1 for a class or any device
+}
+
+// anyDevice lets the dispatcher use every device
+var anyDevice = deviceSelection{index: -1}
+
+// parseDeviceSelection parses "any", a device class or a device index, an empty selection is "any"
+func parseDeviceSelection(s string) (deviceSelection, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+
+	switch s {
+	case "", DeviceClassAny:
+		return anyDevice, nil
+	case DeviceClassFPGA, DeviceClassCPU:
+		return deviceSelection{class: s, index: -1}, nil
+	}
+
+	index, err := strconv.Atoi(s)
+	if (err != nil) || (index < 0) {
+		return anyDevice, newServerError(ipc.ErrorNoSuchDevice, "%v", s)
+	}
+	return deviceSelection{index: index}, nil
+}
+
+// isAny returns true if every device may be used
+func (s deviceSelection) isAny() bool {
+	return (s.class == "") && (s.index == -1)
+}
+
+// matches returns true if the device may do the POW of the selection
+func (s deviceSelection) matches(device *PowDevice) bool {
+	switch {
+	case s.isAny():
+		return true
+	case s.class != "":
+		return device.PowClass == s.class
+	default:
+		return device.Index == s.index
+	}
+}
+
+// String returns the selection as it is sent by the client, e.g. "fpga" or "1"
+func (s deviceSelection) String() string {
+	switch {
+	case s.isAny():
+		return DeviceClassAny
+	case s.class != "":
+		return s.class
+	default:
+		return strconv.Itoa(s.index)
+	}
+}
+
+// selectDevice pins the following POW requests of the owner to the selected devices
+func (o *jobOwner) selectDevice(selection deviceSelection) {
+	o.selection.Store(selection)
+}
+
+// deviceSelection returns the devices that may do the POW requests of the owner
+// Requests without owner may use every device.
+func (o *jobOwner) deviceSelection() deviceSelection {
+	if o == nil {
+		return anyDevice
+	}
+	if selection, ok := o.selection.Load().(deviceSelection); ok {
+		return selection
+	}
+	return anyDevice
+}
+
+// checkDeviceSelection returns an error with the code ipc.ErrorNoSuchDevice if no device of the dispatcher matches the selection
+func (d *powDispatcher) checkDeviceSelection(selection deviceSelection) error {
+	for _, device := range d.currentDevices() {
+		if selection.matches(device) {
+			return nil
+		}
+	}
+	return newServerError(ipc.ErrorNoSuchDevice, "%v", selection)
+}
+
+// handleSelectDevice pins the following POW requests of the client to the selected devices
+func (c *clientConnection) handleSelectDevice(reqID byte, data []byte) {
+	selection, err := parseDeviceSelection(string(data))
+	if err == nil {
+		err = getDispatcher().checkDeviceSelection(selection)
+	}
+	if err != nil {
+		c.log.WithFields(logs.Fields{"reqID": reqID}).Debugf("%v", err)
+		c.sendError(reqID, err, ipc.ErrorNoSuchDevice)
+		return
+	}
+
+	c.log.WithFields(logs.Fields{"reqID": reqID}).Debugf("POW requests pinned to the device selection \"%v\"", selection)
+	c.owner.selectDevice(selection)
+	c.send(reqID, ipc.CmdResponse, nil)
+}
+
+// selectDevice asks the powSrv to do the POW requests of the connection on the PreferredDevice
+// The receive loop is not running yet, so the response is read directly.
+func (p *PowClient) selectDevice(c *serverConnection) error {
+	c.SetDeadline(time.Now().Add(authTimeout))
+	defer c.SetDeadline(time.Time{})
+
+	_, err := p.directRequest(c, ipc.CmdSelectDevice, []byte(p.PreferredDevice))
+	return err
+}
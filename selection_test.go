@@ -0,0 +1,88 @@
+package powsrv
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/iotaledger/giota"
+)
+
+// classDevice returns a device of the class that answers every POW with the nonce
+func classDevice(index int, class string, nonce giota.Trytes) *PowDevice {
+	return &PowDevice{Index: index, PowType: "test", PowClass: class, PowFunc: func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return nonce, nil
+	}}
+}
+
+func TestParseDeviceSelection(t *testing.T) {
+	for _, test := range []struct {
+		selection string
+		expected  deviceSelection
+		valid     bool
+	}{
+		{"", anyDevice, true},
+		{"any", anyDevice, true},
+		{"FPGA", deviceSelection{class: DeviceClassFPGA, index: -1}, true},
+		{"cpu", deviceSelection{class: DeviceClassCPU, index: -1}, true},
+		{"2", deviceSelection{index: 2}, true},
+		{"-1", anyDevice, false},
+		{"gpu", anyDevice, false},
+	} {
+		selection, err := parseDeviceSelection(test.selection)
+		if (err == nil) != test.valid {
+			t.Errorf("Selection %q: unexpected error %v", test.selection, err)
+			continue
+		}
+		if selection != test.expected {
+			t.Errorf("Selection %q: expected %+v, got %+v", test.selection, test.expected, selection)
+		}
+	}
+}
+
+func TestSelectDevice(t *testing.T) {
+	path, cleanup := startTestServer(t, false)
+	defer cleanup()
+
+	const fpgaNonce = "FPGANONCE999999999999999999"
+	const cpuNonce = "CPUNONCE9999999999999999999"
+	SetPowDevices([]*PowDevice{classDevice(0, DeviceClassFPGA, fpgaNonce), classDevice(1, DeviceClassCPU, cpuNonce)})
+
+	data, err := giota.ToTrytes(transaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		preferredDevice string
+		nonce           giota.Trytes
+	}{
+		{"fpga", fpgaNonce},
+		{"cpu", cpuNonce},
+		{"0", fpgaNonce},
+		{"1", cpuNonce},
+	} {
+		powClient := &PowClient{PowSrvPath: path, WriteTimeOutMs: 500, ReadTimeOutMs: 5000, PreferredDevice: test.preferredDevice}
+		err := powClient.Init()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Both devices are idle, only the selected one may do the POW
+		for i := 0; i < 5; i++ {
+			result, err := powClient.PowFunc(data, MWM)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result != test.nonce {
+				t.Errorf("Preferred device %q: expected %v, got %v", test.preferredDevice, test.nonce, result)
+			}
+		}
+		powClient.Close()
+	}
+
+	powClient := &PowClient{PowSrvPath: path, WriteTimeOutMs: 500, ReadTimeOutMs: 5000, PreferredDevice: "2"}
+	err = powClient.Init()
+	if !errors.Is(err, ErrNoSuchDevice) {
+		t.Errorf("Expected ErrNoSuchDevice, got: %v", err)
+	}
+}
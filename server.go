@@ -1,376 +1,703 @@
 package powsrv
 
 import (
-	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/iotaledger/giota"
-	"github.com/lunixbochs/struc"
-	"github.com/sigurn/crc8"
 	"github.com/spf13/viper"
 
+	"github.com/muxxer/powsrv/ipc"
 	"github.com/muxxer/powsrv/logs"
 )
 
-const (
-	// Different states of the receivement of the frame via interprocess communication
-	FrameStateSearchEnq     byte = 1 // FrameStateSearchEnq: Search the Start byte of the frame
-	FrameStateSearchVersion byte = 2 // Search the Version byte of the frame
-	FrameStateSearchLength  byte = 3 // Search the length information of the frame
-	FrameStateSearchData    byte = 4 // Search all the data embedded in the frame
-	FrameStateSearchCRC     byte = 5 // Search the CRC checksum of the embedded data
-
-	IpcCmdNotification     = 0x01 // S => C: Text messages to the client
-	IpcCmdResponse         = 0x02 // S => C: Response to a IPC_CMD
-	IpcCmdError            = 0x03 // S => C: Exceptions that should be raised in the client
-	IpcCmdGetServerVersion = 0x04 // C => S: Get the version of this application
-	IpcCmdGetPowType       = 0x05 // C => S: Get the name of the used POW implementation (e.g. PiDiver)
-	IpcCmdGetPowVersion    = 0x06 // C => S: Get the version of the used POW implementation (e.g. PiDiver FPGA Core Version)
-	IpcCmdPowFunc          = 0x07 // C => S: Do POW
-
-	powSrvVersion = "0.1.0"
-)
-
-var crc8Table = crc8.MakeTable(crc8.CRC8_MAXIM)
-var powMutex = &sync.Mutex{}
-var powFuncPtr giota.PowFunc
-
-/*
-	Interprocess communication protocol
-	===================================
-
-	[0] START_BYTE | [1] FRAME_VERSION | [2..3] FRAME_LENGTH | [4..4+FRAME_LENGTH] FRAME_DATA | [4+FRAME_LENGTH] CRC8
-
-	START_BYTE:
-		Start of the IPC frame
-		ENQ Byte (0x05) - Enquiry
+const powSrvVersion = "0.1.0"
+
+// clientConnection is a connection of a client to the powSrv
+type clientConnection struct {
+	net.Conn
+	writer     *ipc.FrameWriter // Uses the frame version of the last request for all messages to the client
+	outbox     chan outboxFrame // Frames that wait to be written by writeFrames
+	closed     chan struct{}    // Closed as soon as the connection is closed
+	closeOnce  sync.Once
+	finished   chan struct{} // Closed by finish, the connection is closed after the queued frames are written
+	finishOnce sync.Once
+	writerDone chan struct{} // Closed as soon as writeFrames returned
+
+	jobsMutex sync.Mutex
+	jobs      map[byte]*powJob       // POW requests of the client that are not finished yet, indexed by ReqID
+	progress  map[byte]chan struct{} // Stops the progress notifications of the running requests, indexed by ReqID
+
+	owner     jobOwner    // Owner of the POW requests of the client in the dispatcher
+	log       *logs.Entry // Logs with the remote address of the client
+	limiter   *rateLimiter
+	connected time.Time
+
+	requests    uint64
+	errors      uint64
+	rateLimited uint64
+	inFlight    int32 // POW requests of the client that are queued or in progress, the connection is not idle
+
+	// Only used by the goroutine that reads the frames of the client
+	authRequired  bool
+	authenticated bool
+	authNonce     []byte // Last nonce that was sent to the client
+}
 
-	FRAME_VERSION:
-		Version of the IPC frame, for future extensions of the protocol
+var connections = make(map[*clientConnection]struct{})
+var connectionsMutex = &sync.Mutex{}
 
-	FRAME_LENGTH:
-		Size of the FRAME_DATA
+var inFlightCount int              // POW requests that are in progress
+var inFlightFinished chan struct{} // Closed as soon as the last POW request is finished during shutdown
+var inFlightMutex = &sync.Mutex{}
+var shuttingDown bool
 
-	FRAME_DATA:
-		----- FRAME_VERSION==0x01 -----
+// startRequest registers a POW request of the client, so the connection is not closed as idle
+func (c *clientConnection) startRequest() bool {
+	if !startRequest() {
+		return false
+	}
+	atomic.AddInt32(&c.inFlight, 1)
+	return true
+}
 
-		[4] REQ_ID | [5] IPC_CMD | [6..7] DATA_LENGTH | [8..8+DATA_LENGTH] DATA
+// finishRequest unregisters a POW request of the client
+func (c *clientConnection) finishRequest() {
+	atomic.AddInt32(&c.inFlight, -1)
+	finishRequest()
+}
 
-		REQ_ID:
-			ID of the message, set by the client.
-			Server will respond to the client with the same ID.
-			This way the client knows which response is assigned to which request.
+// idle returns true if the client has no POW request in progress
+func (c *clientConnection) idle() bool {
+	return atomic.LoadInt32(&c.inFlight) == 0
+}
 
-		IPC_CMD:
-			IpcCmdNotification     = 0x01 // S => C: Text messages to the client
-			IpcCmdResponse         = 0x02 // S => C: Response to a IPC_CMD
-			IpcCmdError            = 0x03 // S => C: Exceptions that should be raised in the client
-			IpcCmdGetServerVersion = 0x04 // C => S: Get the version of this application
-			IpcCmdGetPowType       = 0x05 // C => S: Get the name of the used POW implementation (e.g. PiDiver)
-			IpcCmdGetPowVersion    = 0x06 // C => S: Get the version of the used POW implementation (e.g. PiDiver FPGA Core Version)
-			IpcCmdPowFunc          = 0x07 // C => S: Do POW
+// countRequest counts a received POW request of the client
+func (c *clientConnection) countRequest() {
+	countRequest()
+	atomic.AddUint64(&c.requests, 1)
+}
 
-		DATA_LENGTH:
-			Size of the DATA
+// countError counts a POW request of the client that was answered with an error
+func (c *clientConnection) countError() {
+	countError()
+	atomic.AddUint64(&c.errors, 1)
+}
 
-		DATA:
-			Data with variable length
+// allowRequest checks the rate limit of the client and answers a rejected request with RATE_LIMITED
+func (c *clientConnection) allowRequest(reqID byte) bool {
+	allowed, retryAfter := c.limiter.allow()
+	if !allowed {
+		c.log.WithFields(logs.Fields{"reqID": reqID}).Debugf("Rate limit exceeded")
+		atomic.AddUint64(&c.rateLimited, 1)
+		c.countError()
+		c.sendError(reqID, rateLimitedError(retryAfter), ipc.ErrorRateLimited)
+	}
+	return allowed
+}
 
-			----- IPC_CMD==IpcCmdNotification -----
-			[8..8+DATA_LENGTH]	String	Notification
+// checkMwm answers a request with MWM_TOO_HIGH if the MinWeightMagnitude exceeds the maximum of the server
+// The POW of a high MinWeightMagnitude could block a device for hours.
+func (c *clientConnection) checkMwm(reqID byte, mwm int, config *viper.Viper) bool {
+	minMwm, maxMwm := mwmLimits(config)
+	if mwm > maxMwm {
+		c.log.WithFields(logs.Fields{"reqID": reqID}).Debugf("MinWeightMagnitude too high. MWM: %v Allowed: %v", mwm, maxMwm)
+		c.countError()
+		c.sendError(reqID, newServerError(ipc.ErrorMwmTooHigh, "%d", maxMwm), ipc.ErrorMwmTooHigh)
+		return false
+	}
+	if mwm < minMwm {
+		c.log.WithFields(logs.Fields{"reqID": reqID}).Debugf("MinWeightMagnitude too low. MWM: %v Allowed: %v", mwm, minMwm)
+		c.countError()
+		c.sendError(reqID, newServerError(ipc.ErrorMwmTooLow, "%d", minMwm), ipc.ErrorMwmTooLow)
+		return false
+	}
+	return true
+}
 
-			----- IPC_CMD==IpcCmdResponse -----
-			[8..8+DATA_LENGTH] ReponseData
+// checkReqID answers a POW request with INVALID_REQUEST if another request of the client with the ReqID is still pending
+func (c *clientConnection) checkReqID(reqID byte) bool {
+	if c.jobPending(reqID) {
+		c.log.WithFields(logs.Fields{"reqID": reqID}).Debugf("ReqID is still pending")
+		c.countError()
+		c.sendError(reqID, newServerError(ipc.ErrorInvalidRequest, "ReqID %d is still pending", reqID), ipc.ErrorInvalidRequest)
+		return false
+	}
+	return true
+}
 
-			----- IPC_CMD==IpcCmdError -----
-			[8..8+DATA_LENGTH] ExceptionMessage
+// address returns a printable address of the client
+// Unix socket clients are usually unnamed, so the socket path is used instead
+func (c *clientConnection) address() string {
+	if addr := c.RemoteAddr(); (addr != nil) && (addr.String() != "") && (addr.String() != "@") {
+		return fmt.Sprintf("%v (%v)", addr, addr.Network())
+	}
+	return fmt.Sprintf("%v (%v)", c.LocalAddr(), c.LocalAddr().Network())
+}
 
-			----- IPC_CMD==IpcCmdGetServerVersion -----
-			[8..8+DATA_LENGTH] 	String	ServerVersion
+// stats returns the statistics of the client
+func (c *clientConnection) stats() ClientStats {
+	c.jobsMutex.Lock()
+	pending := len(c.jobs)
+	c.jobsMutex.Unlock()
+
+	return ClientStats{
+		Address:          c.address(),
+		ConnectedSeconds: int64(time.Since(c.connected) / time.Second),
+		Requests:         atomic.LoadUint64(&c.requests),
+		Errors:           atomic.LoadUint64(&c.errors),
+		RateLimited:      atomic.LoadUint64(&c.rateLimited),
+		Pending:          pending,
+		Running:          int(atomic.LoadInt32(&c.owner.running)),
+	}
+}
 
-			----- IPC_CMD==IpcCmdGetPowType -----
-			[8..8+DATA_LENGTH] 	String	PowType
+// clientStats returns the statistics of all connected clients
+func clientStats() []ClientStats {
+	connectionsMutex.Lock()
+	defer connectionsMutex.Unlock()
 
-			----- IPC_CMD==IpcCmdGetPowVersion -----
-			[8..8+DATA_LENGTH] 	String	PowVersion
+	stats := make([]ClientStats, 0, len(connections))
+	for c := range connections {
+		stats = append(stats, c.stats())
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].ConnectedSeconds > stats[j].ConnectedSeconds })
+	return stats
+}
 
-			----- IPC_CMD==IpcCmdPowFunc ----
-			[8..8+DATA_LENGTH] 	Trytes POW result
+// sendError sends an error to the client, the code is used for errors that are no ServerError
+func (c *clientConnection) sendError(reqID byte, err error, code string) error {
+	return c.send(reqID, ipc.CmdError, toServerError(err, code).payload())
+}
 
-	CRC8:
-		Checksum of the whole FRAME_DATA
+// errRequestCancelled is passed to the done function of a POW request that was cancelled by the client
+// The ReqID may already belong to a new request of the client, so nothing must be sent for it.
+var errRequestCancelled = errors.New("Request cancelled")
 
-*/
+// jobPending returns true if a POW request of the client with the ReqID is not finished yet
+func (c *clientConnection) jobPending(reqID byte) bool {
+	c.jobsMutex.Lock()
+	defer c.jobsMutex.Unlock()
 
-// IpcMessage is the container of an IPC frame with additional communication control data
-type IpcMessage struct {
-	StartByte    byte   `struc:"byte"`
-	FrameVersion byte   `struc:"byte"`
-	FrameLength  int    `struc:"uint16,sizeof=FrameData"`
-	FrameData    []byte `struc:"[]byte"`
-	CRC8         byte   `struc:"byte"`
+	_, pending := c.jobs[reqID]
+	return pending
 }
 
-// IpcFrameV1 contains the information of the IPC communication
-type IpcFrameV1 struct {
-	ReqID      byte   `struc:"byte"`
-	Command    byte   `struc:"byte"`
-	DataLength int    `struc:"uint16,sizeof=Data"`
-	Data       []byte `struc:"[]byte"`
-}
+// submitJob queues a POW request of the client and registers it, so it can be cancelled
+// done is called with errRequestCancelled if the request was cancelled while the POW was in progress.
+func (c *clientConnection) submitJob(reqID byte, trytes giota.Trytes, mwm int, priority byte, algorithm byte, log *logs.Entry, done func(result giota.Trytes, err error)) error {
+	// The lock is held until the job is registered, otherwise a fast worker could finish it before
+	c.jobsMutex.Lock()
+	defer c.jobsMutex.Unlock()
 
-// ToBytes converts an IpcMessage to a byte slice
-func (m *IpcMessage) ToBytes() ([]byte, error) {
-	var buf bytes.Buffer
-	err := struc.Pack(&buf, m)
-	if err != nil {
-		return nil, err
+	if _, pending := c.jobs[reqID]; pending {
+		return newServerError(ipc.ErrorInvalidRequest, "ReqID %d is still pending", reqID)
 	}
 
-	return buf.Bytes(), nil
-}
-
-// ToBytes converts an IpcFrameV1 to a byte slice
-func (f *IpcFrameV1) ToBytes() ([]byte, error) {
-	var buf bytes.Buffer
-	err := struc.Pack(&buf, f)
+	var job *powJob
+	job, err := getDispatcher().submit(&c.owner, trytes, mwm, priority, algorithm, log, func(result giota.Trytes, err error) {
+		if !c.finishJob(reqID, &job) {
+			done("", errRequestCancelled)
+			return
+		}
+		done(result, err)
+	})
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return buf.Bytes(), nil
+	if c.jobs == nil {
+		c.jobs = make(map[byte]*powJob)
+	}
+	c.jobs[reqID] = job
+	return nil
 }
 
-// BytesToIpcMessage converts a byte slice to an IpcMessage
-func BytesToIpcMessage(data []byte) (*IpcMessage, error) {
-	buf := bytes.NewBuffer(data)
+// finishJob unregisters a finished POW request of the client
+// It returns false if the request was cancelled, the ReqID is only freed if it still belongs to the job.
+// The job is passed by reference, because it is set by submitJob under the lock.
+func (c *clientConnection) finishJob(reqID byte, job **powJob) bool {
+	c.jobsMutex.Lock()
+	defer c.jobsMutex.Unlock()
 
-	msg := new(IpcMessage)
-	err := struc.Unpack(buf, &msg)
-	if err != nil {
-		return nil, err
+	if c.jobs[reqID] != *job {
+		return false
 	}
-
-	return msg, nil
+	delete(c.jobs, reqID)
+	return true
 }
 
-// BytesToIpcFrameV1 converts a byte slice to an IpcFrameV1
-func BytesToIpcFrameV1(data []byte) (*IpcFrameV1, error) {
-	buf := bytes.NewBuffer(data)
+// cancelJob removes a POW request of the client from the queue and frees its ReqID
+// A POW that is already in progress can't be aborted, its result is dropped by submitJob
+func (c *clientConnection) cancelJob(reqID byte) {
+	c.jobsMutex.Lock()
+	job, exists := c.jobs[reqID]
+	delete(c.jobs, reqID)
+	c.jobsMutex.Unlock()
 
-	frame := new(IpcFrameV1)
-	err := struc.Unpack(buf, &frame)
-	if err != nil {
-		return nil, err
-	}
+	c.stopProgress(reqID)
 
-	return frame, nil
+	if exists && job.dispatcher.cancel(job) {
+		c.finishRequest()
+	}
 }
 
-// NewIpcMessageV1 creates a new IpcFrameV1 embedded in an IpcMessage
-func NewIpcMessageV1(requestID byte, command byte, data []byte) (*IpcMessage, error) {
-	frameLength := len(data)
-	if frameLength > 0xFFFF {
-		return nil, errors.New("Message is too big")
+// startProgress sends progress notifications for the request every interval until stopProgress is called
+func (c *clientConnection) startProgress(reqID byte, interval time.Duration) {
+	if interval <= 0 {
+		return
 	}
 
-	frame := &IpcFrameV1{ReqID: requestID, Command: command, DataLength: len(data), Data: data}
-	frameBytes, err := frame.ToBytes()
-	if err != nil {
-		return nil, err
+	stop := make(chan struct{})
+	c.jobsMutex.Lock()
+	if c.progress == nil {
+		c.progress = make(map[byte]chan struct{})
 	}
+	c.progress[reqID] = stop
+	c.jobsMutex.Unlock()
+
+	go func() {
+		ts := time.Now()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				err := c.send(reqID, ipc.CmdNotification, []byte(fmt.Sprintf("%s %d", ipc.NotificationProgress, time.Since(ts)/time.Millisecond)))
+				if err != nil {
+					return
+				}
+			}
+		}
+	}()
+}
 
-	crc8 := crc8.Checksum(frameBytes, crc8Table)
-	message := &IpcMessage{StartByte: 0x05, FrameVersion: 0x01, FrameLength: frameLength, FrameData: frameBytes, CRC8: crc8}
+// stopProgress stops the progress notifications of the request
+func (c *clientConnection) stopProgress(reqID byte) {
+	c.jobsMutex.Lock()
+	stop, exists := c.progress[reqID]
+	delete(c.progress, reqID)
+	c.jobsMutex.Unlock()
 
-	return message, nil
+	if exists {
+		close(stop)
+	}
 }
 
-// sendToClient sends an IpcMessage to a client
-func sendToClient(c net.Conn, responseMsg *IpcMessage) (err error) {
-	response, err := responseMsg.ToBytes()
+// parseProgressNotification returns the elapsed time of a progress notification
+// It returns false if the notification is not a progress notification
+func parseProgressNotification(data []byte) (time.Duration, bool) {
+	var elapsedMs int64
+	_, err := fmt.Sscanf(string(data), ipc.NotificationProgress+" %d", &elapsedMs)
 	if err != nil {
-		return err
+		return 0, false
 	}
+	return time.Duration(elapsedMs) * time.Millisecond, true
+}
 
-	_, err = c.Write(response)
+// startRequest registers a POW request, so Shutdown waits for it
+// It returns false if the server is shutting down
+func startRequest() bool {
+	inFlightMutex.Lock()
+	defer inFlightMutex.Unlock()
 
-	return err
+	if shuttingDown {
+		return false
+	}
+	inFlightCount++
+	return true
 }
 
-// SetPowFunc sets the function pointer for POW
-func SetPowFunc(f giota.PowFunc) {
-	powFuncPtr = f
+// finishRequest unregisters a POW request
+func finishRequest() {
+	inFlightMutex.Lock()
+	defer inFlightMutex.Unlock()
+
+	inFlightCount--
+	if (inFlightCount == 0) && (inFlightFinished != nil) {
+		close(inFlightFinished)
+		inFlightFinished = nil
+	}
 }
 
-// powFunc calls the hardware POW secured by a Mutex
-func powFunc(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
-	powMutex.Lock()
-	defer powMutex.Unlock()
+// Shutdown stops the handling of new POW requests and notifies all connected clients.
+// It waits up to the grace period for the POW requests in progress, closes all connections and releases the devices.
+func Shutdown(grace time.Duration) {
+	finished := make(chan struct{})
+
+	inFlightMutex.Lock()
+	shuttingDown = true
+	if inFlightCount == 0 {
+		close(finished)
+	} else {
+		inFlightFinished = finished
+	}
+	inFlightMutex.Unlock()
+
+	connectionsMutex.Lock()
+	for c := range connections {
+		c.send(0, ipc.CmdNotification, []byte("shutting down"))
+	}
+	connectionsMutex.Unlock()
 
-	if powFuncPtr == nil {
-		return "", errors.New("powFunc not initialized")
+	select {
+	case <-finished:
+		logs.Log.Info("All POW requests finished")
+	case <-time.After(grace):
+		logs.Log.Warning("Grace period expired, aborting the POW requests in progress")
 	}
 
-	logs.Log.Debugf("Starting PoW! Weight: %d", mwm)
-	ts := time.Now()
-	result, err := powFuncPtr(trytes, mwm)
-	logs.Log.Debugf("Finished PoW! Time: %d [ms]", (int64(time.Since(ts) / time.Millisecond)))
+	// The responses of the finished requests may still be queued
+	connectionsMutex.Lock()
+	for c := range connections {
+		c.finish()
+	}
+	connectionsMutex.Unlock()
 
-	return result, err
+	getDispatcher().close()
 }
 
 // HandleClientConnection handles the communication to the client until the socket is closed
-func HandleClientConnection(c net.Conn, config *viper.Viper, powType string, powVersion string) {
-	frameState := FrameStateSearchEnq
-	frameLength := 0
-	var frameData []byte
-	defer c.Close()
+func HandleClientConnection(c net.Conn, config *viper.Viper) {
+	if !tlsHandshake(c) {
+		c.Close()
+		return
+	}
+
+	progressInterval := time.Duration(config.GetInt("server.progressIntervalMs")) * time.Millisecond
+	maxMalformedFrames := config.GetInt("server.maxMalformedFrames")
+	malformedFrames := 0
+	idleTimeout := time.Duration(config.GetInt("server.clientIdleTimeoutSeconds")) * time.Second
+
+	conn := &clientConnection{
+		Conn:         c,
+		writer:       ipc.NewFrameWriter(c, ipc.Version1),
+		limiter:      newRateLimiter(config.GetInt("pow.maxRequestsPerMinute")),
+		connected:    time.Now(),
+		authRequired: authRequired(c, config),
+		outbox:       make(chan outboxFrame, outboxSize),
+		closed:       make(chan struct{}),
+		finished:     make(chan struct{}),
+		writerDone:   make(chan struct{}),
+	}
+	conn.owner.address = conn.address()
+	conn.owner.notify = func(message string) {
+		conn.send(0, ipc.CmdNotification, []byte(message))
+	}
+	conn.log = logs.WithFields(logs.Fields{"component": "server", "remoteAddr": conn.owner.address})
+	connectionsMutex.Lock()
+	connections[conn] = struct{}{}
+	connectionsMutex.Unlock()
 
+	go conn.writeFrames(time.Duration(config.GetInt("server.writeTimeoutMs")) * time.Millisecond)
+
+	defer func() {
+		connectionsMutex.Lock()
+		delete(connections, conn)
+		connectionsMutex.Unlock()
+
+		// The last frames are still written, e.g. the error before a connection with malformed frames is dropped
+		conn.finish()
+		<-conn.writerDone
+	}()
+
+	reader := ipc.NewFrameReader(c)
 	for {
-		buf := make([]byte, 3072) // ((8019 is the TransactionTrinarySize) / 3) + Overhead) => 3072
-		bufLength, err := c.Read(buf)
-		if err != nil {
+		if idleTimeout > 0 {
+			c.SetReadDeadline(time.Now().Add(idleTimeout))
+		}
+
+		frame, err := reader.ReadFrame()
+		traceReceivedFrame(conn.owner.address, frame, err)
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() && (idleTimeout > 0) {
+			// Crashed clients leave dead connections, but quiet clients may wait for a long POW
+			if !conn.idle() {
+				continue
+			}
+			logs.Log.Warningf("Closed connection of %v after %v without a request", conn.address(), idleTimeout)
 			break
 		}
+		if frameErr, ok := err.(*ipc.FrameError); ok {
+			logs.Log.Debug(err.Error())
+			var reqID byte
+			if frameErr.Frame != nil {
+				reqID = frameErr.Frame.ReqID
+			}
+			conn.sendError(reqID, err, ipc.ErrorInvalidFrame)
 
-		bufferIdx := -1
-		for {
-			bufferIdx++
-
-			if bufLength > bufferIdx {
-
-				switch frameState {
-
-				case FrameStateSearchEnq:
-					if buf[bufferIdx] == 0x05 {
-						// Init variables for new message
-						frameLength = -1
-						frameData = nil
-						frameState = FrameStateSearchVersion
-					}
-
-				case FrameStateSearchVersion:
-					if buf[bufferIdx] == 0x01 {
-						frameState = FrameStateSearchLength
-					} else {
-						frameState = FrameStateSearchEnq
-					}
-
-				case FrameStateSearchLength:
-					if frameLength == -1 {
-						// Receive first byte
-						frameLength = int(buf[bufferIdx]) << 8
-					} else {
-						// Receive second byte and go on
-						frameLength |= int(buf[bufferIdx])
-						frameState = FrameStateSearchData
-					}
-
-				case FrameStateSearchData:
-					missingByteCount := frameLength - len(frameData)
-					if (bufLength - bufferIdx) >= missingByteCount {
-						// Frame completely received
-						frameData = append(frameData, buf[bufferIdx:(bufferIdx+missingByteCount)]...)
-						bufferIdx += missingByteCount - 1
-						frameState = FrameStateSearchCRC
-					} else {
-						// Frame not completed in this read => Copy the remaining bytes
-						frameData = append(frameData, buf[bufferIdx:bufLength]...)
-						bufferIdx = bufLength
-					}
-
-				case FrameStateSearchCRC:
-					frame, err := BytesToIpcFrameV1(frameData)
-					if err != nil {
-						logs.Log.Debug(err.Error())
-						responseMsg, _ := NewIpcMessageV1(0, IpcCmdError, []byte(err.Error()))
-						sendToClient(c, responseMsg)
-						frameState = FrameStateSearchEnq
-						break
-					}
-
-					crc := crc8.Checksum(frameData, crc8Table)
-					if buf[bufferIdx] != crc {
-						logs.Log.Debugf("Wrong Checksum! CRC: %X, Expected: %X", crc, buf[bufferIdx])
-						responseMsg, _ := NewIpcMessageV1(frame.ReqID, IpcCmdError, []byte(fmt.Sprintf("Wrong Checksum! CRC: %X, Expected: %X", crc, buf[bufferIdx])))
-						sendToClient(c, responseMsg)
-						frameState = FrameStateSearchEnq
-						break
-					}
-
-					switch frame.Command {
-
-					case IpcCmdGetServerVersion:
-						logs.Log.Debug("Received Command GetServerVersion")
-						responseMsg, _ := NewIpcMessageV1(frame.ReqID, IpcCmdResponse, []byte(powSrvVersion))
-						sendToClient(c, responseMsg)
-
-					case IpcCmdGetPowType:
-						logs.Log.Debug("Received Command GetPowType")
-						responseMsg, _ := NewIpcMessageV1(frame.ReqID, IpcCmdResponse, []byte(powType))
-						sendToClient(c, responseMsg)
-
-					case IpcCmdGetPowVersion:
-						logs.Log.Debug("Received Command GetPowVersion")
-						responseMsg, _ := NewIpcMessageV1(frame.ReqID, IpcCmdResponse, []byte(powVersion))
-						sendToClient(c, responseMsg)
-
-					case IpcCmdPowFunc:
-						logs.Log.Debug("Received Command PowFunc")
-						mwm := int(frame.Data[0])
-
-						if mwm > config.GetInt("pow.maxMinWeightMagnitude") {
-							logs.Log.Debugf("MinWeightMagnitude too high. MWM: %v Allowed: %v", mwm, config.GetInt("pow.maxMinWeightMagnitude"))
-							responseMsg, _ := NewIpcMessageV1(frame.ReqID, IpcCmdError, []byte(fmt.Sprintf("MinWeightMagnitude too high. MWM: %v Allowed: %v", mwm, config.GetInt("pow.maxMinWeightMagnitude"))))
-							sendToClient(c, responseMsg)
-							frameState = FrameStateSearchEnq
-							break
-						}
-
-						trytes, err := giota.ToTrytes(string(frame.Data[1:]))
-						if err != nil {
-							logs.Log.Debug(err.Error())
-							responseMsg, _ := NewIpcMessageV1(frame.ReqID, IpcCmdError, []byte(err.Error()))
-							sendToClient(c, responseMsg)
-							frameState = FrameStateSearchEnq
-							break
-						}
-
-						result, err := powFunc(trytes, mwm)
-						if err != nil {
-							logs.Log.Debug(err.Error())
-							responseMsg, _ := NewIpcMessageV1(frame.ReqID, IpcCmdError, []byte(err.Error()))
-							sendToClient(c, responseMsg)
-							frameState = FrameStateSearchEnq
-							break
-						} else {
-							responseMsg, err := NewIpcMessageV1(frame.ReqID, IpcCmdResponse, []byte(result))
-							if err != nil {
-								frameState = FrameStateSearchEnq
-								break
-							}
-							sendToClient(c, responseMsg)
-						}
-
-					default:
-						// IpcCmdNotification, IpcCmdResponse, IpcCmdError
-						logs.Log.Debugf("Unknown command! Cmd: %X", frame.Command)
-						responseMsg, _ := NewIpcMessageV1(frame.ReqID, IpcCmdError, []byte(fmt.Sprintf("Unknown command! Cmd: %X", frame.Command)))
-						sendToClient(c, responseMsg)
-					}
-
-					// Search for the next message
-					frameState = FrameStateSearchEnq
-				}
-			} else {
-				// Received Buffer completely handled, break the loop to receive the next message
+			// Clients that keep sending garbage are not talking to us
+			malformedFrames++
+			if (maxMalformedFrames > 0) && (malformedFrames >= maxMalformedFrames) {
+				logs.Log.Warningf("Dropped connection of %v after %d malformed frames", c.RemoteAddr(), malformedFrames)
 				break
 			}
+			continue
+		}
+		if err != nil {
+			break
+		}
+
+		conn.writer.SetVersion(frame.Version)
+		conn.handleFrame(frame, config, progressInterval)
+	}
+}
+
+// handleFrame executes the command of a received frame
+func (c *clientConnection) handleFrame(frame *ipc.Frame, config *viper.Viper, progressInterval time.Duration) {
+	if !c.commandAllowed(frame.Command) {
+		logs.Log.Debugf("Command without authentication! Cmd: %X", frame.Command)
+		c.sendError(frame.ReqID, &ServerError{Code: ipc.ErrorAuthRequired}, ipc.ErrorAuthRequired)
+		return
+	}
+
+	switch frame.Command {
+
+	case ipc.CmdGetServerVersion:
+		logs.Log.Debug("Received Command GetServerVersion")
+		c.send(frame.ReqID, ipc.CmdResponse, []byte(powSrvVersion))
+
+	case ipc.CmdGetPowType:
+		logs.Log.Debug("Received Command GetPowType")
+		c.send(frame.ReqID, ipc.CmdResponse, []byte(c.owner.powType()))
+
+	case ipc.CmdGetPowVersion:
+		logs.Log.Debug("Received Command GetPowVersion")
+		c.send(frame.ReqID, ipc.CmdResponse, []byte(c.owner.powVersion()))
+
+	case ipc.CmdPowFunc:
+		log := c.log.WithFields(logs.Fields{"corrID": logs.NewCorrelationID(), "reqID": frame.ReqID})
+		log.Debugf("Received Command PowFunc")
+		c.countRequest()
+		if !c.allowRequest(frame.ReqID) {
+			return
+		}
+
+		if len(frame.Data) == 0 {
+			log.Debugf("POW request without MinWeightMagnitude")
+			c.countError()
+			c.sendError(frame.ReqID, newServerError(ipc.ErrorInvalidRequest, "POW request without MinWeightMagnitude"), ipc.ErrorInvalidRequest)
+			return
+		}
+		mwm := int(frame.Data[0])
+
+		if !c.checkMwm(frame.ReqID, mwm, config) {
+			return
+		}
+
+		trytes, priority, algorithm, err := parsePowFunc(frame.Data[1:])
+		if err != nil {
+			log.Debugf("%v", err)
+			c.countError()
+			c.sendError(frame.ReqID, err, ipc.ErrorInvalidTrytes)
+			return
+		}
+
+		if !c.checkReqID(frame.ReqID) {
+			return
+		}
+
+		if !c.startRequest() {
+			log.Debugf("Server shutting down")
+			c.countError()
+			c.sendError(frame.ReqID, &ServerError{Code: ipc.ErrorShuttingDown}, ipc.ErrorShuttingDown)
+			return
+		}
+
+		if nonce, hit := resultCache.get(trytes, mwm); hit {
+			log.WithFields(logs.Fields{"mwm": mwm}).Infof("PoW result taken from the cache, MWM %d", mwm)
+			c.send(frame.ReqID, ipc.CmdResponse, []byte(nonce))
+			c.finishRequest()
+			return
+		}
+
+		// The POW is done by the workers of the dispatcher, so the connection is not blocked
+		reqID := frame.ReqID
+		c.startProgress(reqID, progressInterval)
+		err = c.submitJob(reqID, trytes, mwm, priority, algorithm, log, func(result giota.Trytes, err error) {
+			defer c.finishRequest()
+			if err == errRequestCancelled {
+				log.Debugf("PoW of the cancelled request finished, the result is dropped")
+				return
+			}
+			c.stopProgress(reqID)
+
+			if err != nil {
+				log.Debugf("%v", err)
+				c.countError()
+				c.sendError(reqID, err, ipc.ErrorPowFailed)
+				return
+			}
+
+			resultCache.add(trytes, mwm, result)
+			c.send(reqID, ipc.CmdResponse, []byte(result))
+		})
+		if err != nil {
+			c.stopProgress(reqID)
+			c.finishRequest()
+			log.Debugf("%v", err)
+			c.countError()
+			c.sendError(frame.ReqID, err, ipc.ErrorInternal)
+			return
+		}
+
+	case ipc.CmdPowFuncBatch:
+		log := c.log.WithFields(logs.Fields{"corrID": logs.NewCorrelationID(), "reqID": frame.ReqID})
+		log.Debugf("Received Command PowFuncBatch")
+		c.countRequest()
+		if !c.allowRequest(frame.ReqID) {
+			return
 		}
+
+		transactions, err := parsePowBatch(frame.Data)
+		if err != nil {
+			log.Debugf("%v", err)
+			c.countError()
+			c.sendError(frame.ReqID, err, ipc.ErrorInvalidRequest)
+			return
+		}
+
+		mwm := int(frame.Data[0])
+		reversed := (frame.Data[1] & ipc.PowBatchFlagReversed) != 0
+
+		if !c.checkMwm(frame.ReqID, mwm, config) {
+			return
+		}
+
+		if !c.checkReqID(frame.ReqID) {
+			return
+		}
+
+		if !c.startRequest() {
+			log.Debugf("Server shutting down")
+			c.countError()
+			c.sendError(frame.ReqID, &ServerError{Code: ipc.ErrorShuttingDown}, ipc.ErrorShuttingDown)
+			return
+		}
+
+		reqID := frame.ReqID
+		received := time.Now()
+		c.startProgress(reqID, progressInterval)
+		err = c.submitBatch(reqID, transactions, mwm, reversed, log, func(result []giota.Trytes, err error) {
+			defer c.finishRequest()
+			if err == errRequestCancelled {
+				log.Debugf("Batch request cancelled, the result is dropped")
+				return
+			}
+			c.stopProgress(reqID)
+
+			if err != nil {
+				log.Debugf("%v", err)
+				c.countError()
+				c.sendError(reqID, err, ipc.ErrorPowFailed)
+				return
+			}
+
+			log.Infof("Batch of %d transactions completed in %d ms", len(result), int64(time.Since(received)/time.Millisecond))
+			var data []byte
+			for _, trytes := range result {
+				data = append(data, []byte(trytes)...)
+			}
+			c.send(reqID, ipc.CmdResponse, data)
+		})
+		if err != nil {
+			c.stopProgress(reqID)
+			c.finishRequest()
+			log.Debugf("%v", err)
+			c.countError()
+			c.sendError(frame.ReqID, err, ipc.ErrorInternal)
+			return
+		}
+
+	case ipc.CmdGetFrameVersions:
+		logs.Log.Debug("Received Command GetFrameVersions")
+		c.send(frame.ReqID, ipc.CmdResponse, []byte{ipc.Version1, ipc.Version2})
+
+	case ipc.CmdAuth:
+		logs.Log.Debug("Received Command Auth")
+		c.handleAuth(frame.ReqID, frame.Data, config.GetString("server.authToken"))
+
+	case ipc.CmdSelectDevice:
+		logs.Log.Debug("Received Command SelectDevice")
+		c.handleSelectDevice(frame.ReqID, frame.Data)
+
+	case ipc.CmdPing:
+		logs.Log.Debug("Received Command Ping")
+		c.send(frame.ReqID, ipc.CmdResponse, nil)
+
+	case ipc.CmdCancel:
+		logs.Log.Debugf("Received Command Cancel for ReqID %X", frame.ReqID)
+		c.cancelJob(frame.ReqID)
+
+	case ipc.CmdGetServerStats:
+		logs.Log.Debug("Received Command GetServerStats")
+		stats, err := json.Marshal(GetServerStats())
+		if err != nil {
+			logs.Log.Debug(err.Error())
+			c.sendError(frame.ReqID, err, ipc.ErrorInternal)
+			return
+		}
+		c.send(frame.ReqID, ipc.CmdResponse, stats)
+
+	case ipc.CmdGetInfo:
+		logs.Log.Debug("Received Command GetInfo")
+		info, err := json.Marshal(currentPowInfo())
+		if err != nil {
+			logs.Log.Debug(err.Error())
+			c.sendError(frame.ReqID, err, ipc.ErrorInternal)
+			return
+		}
+		c.send(frame.ReqID, ipc.CmdResponse, info)
+
+	case ipc.CmdGetLimits:
+		logs.Log.Debug("Received Command GetLimits")
+		limits, err := json.Marshal(c.serverLimits(config, progressInterval))
+		if err != nil {
+			logs.Log.Debug(err.Error())
+			c.sendError(frame.ReqID, err, ipc.ErrorInternal)
+			return
+		}
+		c.send(frame.ReqID, ipc.CmdResponse, limits)
+
+	case ipc.CmdGetDeviceList:
+		logs.Log.Debug("Received Command GetDeviceList")
+		devices, err := json.Marshal(GetDeviceList())
+		if err != nil {
+			logs.Log.Debug(err.Error())
+			c.sendError(frame.ReqID, err, ipc.ErrorInternal)
+			return
+		}
+		c.send(frame.ReqID, ipc.CmdResponse, devices)
+
+	case ipc.CmdHealthCheck:
+		logs.Log.Debug("Received Command HealthCheck")
+		timeout := HealthCheckTimeout
+		if timeoutMs := config.GetInt("pow.healthCheckTimeoutMs"); timeoutMs > 0 {
+			timeout = time.Duration(timeoutMs) * time.Millisecond
+		}
+
+		// The check takes up to the timeout, so the connection is not blocked
+		go func(reqID byte) {
+			health, err := json.Marshal(CheckHealth(timeout))
+			if err != nil {
+				logs.Log.Debug(err.Error())
+				c.sendError(reqID, err, ipc.ErrorInternal)
+				return
+			}
+			c.send(reqID, ipc.CmdResponse, health)
+		}(frame.ReqID)
+
+	default:
+		// ipc.CmdNotification, ipc.CmdResponse, ipc.CmdError
+		logs.Log.Debugf("Unknown command! Cmd: %X", frame.Command)
+		c.sendError(frame.ReqID, newServerError(ipc.ErrorUnknownCommand, "Cmd: %X", frame.Command), ipc.ErrorUnknownCommand)
 	}
 }
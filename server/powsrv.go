@@ -1,26 +1,37 @@
 package main
 
 import (
+	"crypto/tls"
 	"encoding/json"
+	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"sort"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
-	"github.com/iotaledger/giota"
 	"github.com/muxxer/powsrv"
-	"github.com/shufps/pidiver/pidiver"
-	"github.com/shufps/pidiver/raspberry"
 	flag "github.com/spf13/pflag"
 	"github.com/spf13/viper"
 
-	"github.com/muxxer/ftdiver"
+	_ "github.com/muxxer/powsrv/drivers"
 	"github.com/muxxer/powsrv/logs"
+	"github.com/muxxer/powsrv/metrics"
 )
 
 var config *viper.Viper
 
+// listenerConfig is the configuration of a single listener
+type listenerConfig struct {
+	Name    string // Name of the listener in the logs, e.g. 'local'
+	Network string // 'unix' or 'tcp'
+	Address string // Socket path or TCP address, e.g. ':5000'
+}
+
 /*
 PRECEDENCE (Higher number overrides the others):
 1. default
@@ -39,25 +50,76 @@ func loadConfig() *viper.Viper {
 	flag.StringP("fpga.core", "f", "pidiver1.1.rbf", "Core/config file to upload to FPGA")
 	flag.StringP("usb.device", "d", "/dev/ttyACM0", "Device file for usb communication")
 
-	flag.StringP("pow.type", "t", "giota", "'pidiver', 'usbdiver', 'ftdiver', 'giota', 'giota-cl', 'giota-sse', 'giota-carm64', 'giota-c128', 'giota-c' or giota-go'")
+	flag.StringP("pow.type", "t", "giota", "Driver of the POW device: '"+strings.Join(powsrv.Drivers(), "', '")+"'")
+	flag.Bool("pow.forceFlash", false, "Write the core to the flash of all 'pidiver', 'usbdiver' and 'ftdiver' devices even if it is up to date")
+	flag.Bool("pow.forceConfigure", false, "Configure the FPGA of all 'pidiver', 'usbdiver' and 'ftdiver' devices with the core even if it is already configured")
+	flag.Int("pow.workers", 0, "Number of goroutines of a single PoW of the 'giota' types (0 = NumCPU-1)")
+	flag.Bool("pow.lowPriority", false, "Run powSrv with the lowest priority, so the PoW of the 'giota' types doesn't starve other processes (Linux only)")
 	flag.IntP("pow.maxMinWeightMagnitude", "m", 20, "Maximum Min-Weight-Magnitude (Difficulty for PoW)")
-
-	var logLevel = flag.StringP("log.level", "l", "INFO", "'DEBUG', 'INFO', 'NOTICE', 'WARNING', 'ERROR' or 'CRITICAL'")
-
-	flag.StringP("server.socketPath", "s", "/tmp/powSrv.sock", "Unix socket path of powSrv")
+	flag.Int("pow.minMinWeightMagnitude", 1, "Minimum Min-Weight-Magnitude, lower requests are answered with MWM_TOO_LOW")
+	flag.String("pow.network", "", "Preset of the Min-Weight-Magnitude limits if they are not set explicitly: 'mainnet' (14) or 'devnet' (9)")
+	flag.Int("pow.maxQueueDepth", 50, "Maximum number of PoW requests that wait for an idle device (0 = unlimited)")
+	flag.Int("pow.maxPendingPerClient", 2, "Maximum number of PoW requests of a single client that are in progress at the same time (0 = unlimited)")
+	flag.Int("pow.maxConsecutiveHighPriority", 4, "Maximum number of high priority PoW requests that are started in a row while normal requests wait (0 = unlimited)")
+	flag.Int("pow.maxRequestsPerMinute", 0, "Maximum number of PoW requests of a single client per minute (0 = unlimited)")
+	flag.String("pow.schedulerPolicy", powsrv.SchedulerFirstIdle, "Assignment of the PoW requests to the devices: 'first-idle', 'fastest-first' or 'round-robin'")
+	flag.Int("pow.spillThresholdMs", 100, "Time a PoW request waits for a faster device with the 'fastest-first' policy before a slower device is used")
+	flag.Int("pow.jobTimeoutSeconds", 0, "Abandon a PoW that a device didn't finish within this time and answer it with POW_TIMEOUT (0 = no timeout)")
+	flag.Float64("pow.jobTimeoutMwmMultiplier", 0, "Multiply pow.jobTimeoutSeconds by this factor for every MWM above 14, e.g. 3 (0 = same timeout for every MWM)")
+	flag.Int("pow.healthCheckTimeoutMs", 5000, "Deadline of the health check PoW of a single device")
+	flag.Bool("pow.coalesceIdenticalRequests", true, "Requests with the same trytes and MWM as a queued or running request get its result instead of doing the PoW again")
+	flag.Int("pow.cacheSize", 0, "Number of PoW results that are cached for identical requests with the same MWM (0 = disabled)")
+	flag.Int("pow.cacheTTLSeconds", 60, "Time the PoW results are cached")
+	flag.Int("pow.deviceRetryIntervalSeconds", 60, "Interval to retry the initialization of disabled devices (0 = disabled)")
+	flag.Bool("pow.failover", false, "Retry a PoW request that failed on a device on the other devices, the client only gets an error if all of them failed")
+	flag.Bool("pow.verifyResults", true, "Check the nonce of every PoW against the MWM before it is returned, a wrong nonce counts as an error of the device")
+	flag.Bool("pow.verifySoftwareResults", true, "Check the nonces of the CPU devices too (only with pow.verifyResults)")
+	flag.Int("pow.maxConsecutiveErrors", 0, "Disable a device after this number of failed PoW requests in a row, it is initialized again by the retry loop (0 = never)")
+
+	var logLevel = flag.StringP("log.level", "l", "INFO", "'TRACE', 'DEBUG', 'INFO', 'NOTICE', 'WARNING', 'ERROR' or 'CRITICAL'")
+	flag.Bool("trace", false, "Log every IPC frame that is sent or received (same as --log.level TRACE)")
+	flag.String("log.format", logs.FormatText, "'text' or 'json' (one JSON object per line with the fields of the request, e.g. corrID, remoteAddr, reqID and deviceIndex)")
+	flag.String("log.outputPath", "", "Log file (empty = stdout), WARNING and more severe entries are mirrored to stderr. SIGUSR1 reopens the file")
+	flag.Int("log.maxSizeMB", 100, "Size at which the log file is rotated (0 = no rotation, e.g. if logrotate is used)")
+	flag.Int("log.maxBackups", 3, "Number of rotated log files that are kept")
+
+	flag.StringP("server.socketPath", "s", powsrv.DefaultAddress, "Unix socket path (Windows: named pipe) of powSrv")
+	flag.String("server.network", "tcp", "Network of the additional listener: 'unix', 'tcp' or 'pipe' (Windows)")
+	flag.StringP("server.address", "a", "", "Address of the additional listener, e.g. ':5000' (empty = disabled)")
+	flag.Int("server.shutdownGraceSeconds", 10, "Time to wait for running PoW requests on shutdown")
+	flag.Int("server.progressIntervalMs", 10000, "Interval of the progress notifications to the clients during a PoW request (0 = disabled)")
+	flag.String("server.authToken", "", "Shared secret that clients have to authenticate with (empty = no authentication)")
+	flag.Bool("server.authRequiredForUnix", true, "Require the authentication for Unix socket connections too")
+	flag.String("server.tls.cert", "", "Certificate file of the TCP listeners (empty = no TLS)")
+	flag.String("server.tls.key", "", "Private key file of the TCP listeners")
+	flag.String("server.tls.clientCAs", "", "CA file to verify client certificates (empty = no client certificates required)")
+	flag.Int("server.maxMalformedFrames", 10, "Number of malformed frames after which a client connection is dropped (0 = unlimited)")
+	flag.Int("server.writeTimeoutMs", 5000, "Close client connections if writing a frame takes longer, e.g. because the client doesn't read (0 = no timeout)")
+	flag.Int("server.clientIdleTimeoutSeconds", 0, "Close client connections without any request for this time, unless a PoW request is in progress (0 = disabled)")
+	flag.String("server.statsFile", "", "File of the lifetime statistics, loaded at the start and written periodically and on shutdown (empty = disabled)")
+	flag.Int("server.statsSaveIntervalMinutes", 10, "Interval to write the lifetime statistics to \"server.statsFile\"")
+	flag.String("server.httpAddress", "", "Address of the HTTP listener for the IRI-compatible attachToTangle API, e.g. ':14265' (empty = disabled)")
+	flag.String("server.eventsAddress", "", "Address of the TCP listener that publishes an event for every finished POW as line-delimited JSON, e.g. ':5002' (empty = disabled)")
+	flag.Int("server.discoveryPort", 0, "UDP port to answer the discovery probes of the clients with the address of the first TCP listener (0 = disabled, default port of the clients: 5001)")
+	flag.String("server.metricsAddress", "", "Address of the HTTP listener for the Prometheus metrics and the health check, e.g. ':9311' (empty = disabled)")
+
+	flag.Bool("force", false, "Remove the Unix sockets even if another powSrv is still listening on them")
+
+	flag.Int("benchmark", 0, "Do N PoW operations per device on random transactions, print the durations and exit (--benchmark = 100)")
+	flag.Lookup("benchmark").NoOptDefVal = "100"
+	flag.Int("benchmark-mwm", 14, "Min-Weight-Magnitude of the benchmark")
+	flag.Bool("benchmark-json", false, "Print the benchmark results as JSON")
 
 	config.BindPFlags(flag.CommandLine)
 
 	var configPath = flag.StringP("config", "c", "powsrv.config.json", "Config file path")
+	flag.Bool("stats", false, "Print the statistics of the running powSrv and exit")
+	flag.Bool("healthcheck", false, "Check the devices of the running powSrv and exit with 0 if all are healthy, 1 otherwise")
 	flag.Parse()
 
 	logs.SetLogLevel(*logLevel)
 
-	// Bind environment vars
-	replacer := strings.NewReplacer(".", "_")
-	config.SetEnvPrefix("POWSRV")
-	config.SetEnvKeyReplacer(replacer)
-	config.AutomaticEnv()
+	bindEnv(config)
 
 	// Load config
 	if len(*configPath) > 0 {
@@ -79,183 +141,692 @@ func loadConfig() *viper.Viper {
 	return config
 }
 
+// bindEnv binds the environment vars, e.g. POWSRV_POW_MAXQUEUEDEPTH for "pow.maxQueueDepth"
+func bindEnv(v *viper.Viper) {
+	replacer := strings.NewReplacer(".", "_")
+	v.SetEnvPrefix("POWSRV")
+	v.SetEnvKeyReplacer(replacer)
+	v.AutomaticEnv()
+}
+
 func init() {
-	logs.Setup()
+	logs.Setup(logs.Output{})
 	config = loadConfig()
+	err := logs.Setup(logs.Output{
+		Path:       config.GetString("log.outputPath"),
+		MaxSizeMB:  config.GetInt("log.maxSizeMB"),
+		MaxBackups: config.GetInt("log.maxBackups"),
+	})
+	if err != nil {
+		logs.Log.Fatalf("Log file could not be opened: %v", err)
+	}
+	err = logs.SetFormat(config.GetString("log.format"))
+	if err != nil {
+		logs.Log.Warning(err)
+	}
 	logs.SetLogLevel(config.GetString("log.level"))
+	if config.GetBool("trace") {
+		logs.SetLogLevel(logs.LevelTrace)
+	}
+
+	err = powsrv.ApplyNetworkPreset(config)
+	if err != nil {
+		logs.Log.Fatal(err)
+	}
 
 	cfg, _ := json.MarshalIndent(config.AllSettings(), "", "  ")
 	logs.Log.Debugf("Following settings loaded: \n %+v", string(cfg))
 }
 
-func main() {
-	flag.Parse() // Scan the arguments list
+// initPowDevice initializes the POW hardware/software of a configured device with its registered driver
+func initPowDevice(index int, deviceConfig powsrv.PowConfigDevice) (*powsrv.PowDevice, error) {
+	return powsrv.NewPowDevice(index, deviceConfig)
+}
 
-	var powFunc giota.PowFunc
-	var powType string
-	var powVersion string
-	var err error
+// configuredDevice is a device of the running server together with its configuration
+type configuredDevice struct {
+	config powsrv.PowConfigDevice
+	device *powsrv.PowDevice
+}
 
-	switch strings.ToLower(config.GetString("pow.type")) {
+var devices []configuredDevice
+var devicesMutex = &sync.Mutex{}
 
-	case "giota":
-		powType, powFunc = giota.GetBestPoW()
-		powVersion = ""
+// newConfiguredDevice initializes a configured device
+// If the initialization fails, the device is disabled
+func newConfiguredDevice(index int, deviceConfig powsrv.PowConfigDevice) configuredDevice {
+	device, err := initPowDevice(index, deviceConfig)
+	if err != nil {
+		logs.Log.Errorf("POW device [%d] %v could not be initialized, it is disabled: %v", index, deviceConfig.Type, err)
+		device = &powsrv.PowDevice{Index: index, PowType: deviceConfig.Type}
+		powsrv.DisablePowDevice(device)
+	}
+	return configuredDevice{config: deviceConfig, device: device}
+}
 
-	case "giota-go":
-		powFunc = giota.PowGo
-		powType = "gIOTA-Go"
+// powDevices returns the POW devices of the configured devices
+// It returns false if all devices are disabled
+func powDevices(configured []configuredDevice) ([]*powsrv.PowDevice, bool) {
+	var result []*powsrv.PowDevice
+	enabled := false
 
-	case "giota-cl":
-		powFunc, err = giota.GetPowFunc("PowCL")
-		if err == nil {
-			powType = "gIOTA-PowCL"
-		} else {
-			powType, powFunc = giota.GetBestPoW()
-			logs.Log.Infof("POW type '%s' not available. Using '%s' instead", "PowCL", powType)
+	for _, c := range configured {
+		result = append(result, c.device)
+		if !c.device.Disabled() {
+			enabled = true
 		}
+	}
+	return result, enabled
+}
 
-	case "giota-sse":
-		powFunc, err = giota.GetPowFunc("PowSSE")
-		if err == nil {
-			powType = "gIOTA-PowSSE"
-		} else {
-			powType, powFunc = giota.GetBestPoW()
-			logs.Log.Infof("POW type '%s' not available. Using '%s' instead", "PowSSE", powType)
+// initPowDevices initializes all configured devices
+// Devices that fail to initialize are disabled, the server only refuses to start if no device is usable
+func initPowDevices(deviceConfigs []powsrv.PowConfigDevice) []*powsrv.PowDevice {
+	devicesMutex.Lock()
+	defer devicesMutex.Unlock()
+
+	for index, deviceConfig := range deviceConfigs {
+		devices = append(devices, newConfiguredDevice(index, deviceConfig))
+	}
+
+	result, enabled := powDevices(devices)
+	if !enabled {
+		logs.Log.Fatal("No POW device could be initialized")
+	}
+
+	return result
+}
+
+// retryDisabledDevices periodically initializes the disabled devices again
+// Devices that recover are added to the dispatch pool
+func retryDisabledDevices(interval time.Duration) {
+	for range time.Tick(interval) {
+		devicesMutex.Lock()
+		for i, c := range devices {
+			if !c.device.Disabled() {
+				continue
+			}
+
+			recovered, err := initPowDevice(c.device.Index, c.config)
+			if err != nil {
+				logs.Log.Debugf("POW device %v is still disabled: %v", c.device, err)
+				continue
+			}
+
+			logs.Log.Infof("POW device %v recovered", recovered)
+			devices[i].device = recovered
+			powsrv.ReplacePowDevice(recovered)
 		}
+		devicesMutex.Unlock()
+	}
+}
 
-	case "giota-carm64":
-		powFunc, err = giota.GetPowFunc("PowCARM64")
-		if err == nil {
-			powType = "gIOTA-PowCARM64"
-		} else {
-			powType, powFunc = giota.GetBestPoW()
-			logs.Log.Infof("POW type '%s' not available. Using '%s' instead", "PowCARM64", powType)
+// reloadDevices reads the pow section of the config file again and updates the running devices and settings
+// Unchanged devices keep running, new devices are initialized and removed devices are released after their POW in progress.
+// If the config can't be loaded, the old configuration is kept. Settings that need a restart are logged.
+func reloadDevices() {
+	configFile := config.ConfigFileUsed()
+	if configFile == "" {
+		logs.Log.Warning("No config file loaded, nothing to reload")
+		return
+	}
+
+	// Flags and environment vars override the config file like at the start, removed keys get the flag defaults
+	reloaded := viper.New()
+	reloaded.BindPFlags(flag.CommandLine)
+	bindEnv(reloaded)
+	reloaded.SetConfigFile(configFile)
+	err := reloaded.ReadInConfig()
+	if err != nil {
+		logs.Log.Errorf("Config could not be reloaded from %s, keeping the old configuration: %v", configFile, err)
+		return
+	}
+	err = powsrv.ApplyNetworkPreset(reloaded)
+	if err != nil {
+		logs.Log.Errorf("Config could not be reloaded from %s, keeping the old configuration: %v", configFile, err)
+		return
+	}
+
+	deviceConfigs, err := loadDeviceConfigs(reloaded)
+	if err != nil {
+		logs.Log.Errorf("Device config could not be reloaded from %s, keeping the old configuration: %v", configFile, err)
+		return
+	}
+
+	devicesMutex.Lock()
+	defer devicesMutex.Unlock()
+
+	nextIndex := 0
+	for _, c := range devices {
+		if c.device.Index >= nextIndex {
+			nextIndex = c.device.Index + 1
 		}
+	}
 
-	case "giota-c128":
-		powFunc, err = giota.GetPowFunc("PowC128")
-		if err == nil {
-			powType = "gIOTA-PowC128"
-		} else {
-			powType, powFunc = giota.GetBestPoW()
-			logs.Log.Infof("POW type '%s' not available. Using '%s' instead", "PowC128", powType)
+	var updated []configuredDevice
+	var added []*powsrv.PowDevice
+	kept := make(map[*powsrv.PowDevice]bool)
+	for _, deviceConfig := range deviceConfigs {
+		found := false
+		for _, c := range devices {
+			if (c.config == deviceConfig) && !kept[c.device] {
+				updated = append(updated, c)
+				kept[c.device] = true
+				found = true
+				break
+			}
 		}
 
-	case "giota-c":
-		powFunc, err = giota.GetPowFunc("PowC")
-		if err == nil {
-			powType = "gIOTA-PowC"
-		} else {
-			powType, powFunc = giota.GetBestPoW()
-			logs.Log.Infof("POW type '%s' not available. Using '%s' instead", "PowC", powType)
+		if !found {
+			c := newConfiguredDevice(nextIndex, deviceConfig)
+			nextIndex++
+			updated = append(updated, c)
+			added = append(added, c.device)
 		}
+	}
 
-	case "pidiver":
-		piconfig := pidiver.PiDiverConfig{
-			Device:         "",
-			ConfigFile:     config.GetString("fpga.core"),
-			ForceFlash:     false,
-			ForceConfigure: false}
+	result, enabled := powDevices(updated)
+	if !enabled {
+		logs.Log.Error("No POW device could be initialized after the reload, keeping the old configuration")
+		for _, device := range added {
+			if device.CloseFunc != nil {
+				device.CloseFunc()
+			}
+		}
+		return
+	}
 
-		// initialize pidiver
-		llStruct := raspberry.GetLowLevel()
-		err := pidiver.InitPiDiver(&llStruct, &piconfig)
+	for _, c := range devices {
+		if !kept[c.device] {
+			logs.Log.Infof("Removing POW device %v", c.device)
+		}
+	}
+	for _, device := range added {
+		logs.Log.Infof("Adding POW device %v", device)
+	}
+
+	powsrv.UpdatePowDevices(result)
+	devices = updated
+
+	err = powsrv.ApplyPowSettings(reloaded)
+	if err != nil {
+		logs.Log.Errorf("Scheduler policy could not be reloaded, keeping the old policy: %v", err)
+	}
+	if changed := powsrv.RestartRequiredSettings(config, reloaded); len(changed) > 0 {
+		logs.Log.Warningf("Changed settings are only used after a restart: %v", strings.Join(changed, ", "))
+	}
+
+	logs.Log.Info("Device configuration reloaded")
+}
+
+// loadDeviceConfigs returns the configured POW devices
+// If no "pow.devices" are configured, a single device is built from the "pow.type" setting.
+// "pow.forceFlash" and "pow.forceConfigure" apply to all devices.
+func loadDeviceConfigs(v *viper.Viper) ([]powsrv.PowConfigDevice, error) {
+	var deviceConfigs []powsrv.PowConfigDevice
+
+	err := v.UnmarshalKey("pow.devices", &deviceConfigs)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(deviceConfigs) == 0 {
+		deviceConfigs = append(deviceConfigs, powsrv.PowConfigDevice{
+			Type:        v.GetString("pow.type"),
+			Core:        v.GetString("fpga.core"),
+			Device:      v.GetString("usb.device"),
+			Workers:     v.GetInt("pow.workers"),
+			LowPriority: v.GetBool("pow.lowPriority")})
+	}
+
+	forceFlash, forceConfigure := v.GetBool("pow.forceFlash"), v.GetBool("pow.forceConfigure")
+	for i := range deviceConfigs {
+		deviceConfigs[i].ForceFlash = deviceConfigs[i].ForceFlash || forceFlash
+		deviceConfigs[i].ForceConfigure = deviceConfigs[i].ForceConfigure || forceConfigure
+	}
+
+	return deviceConfigs, nil
+}
+
+// printStats prints the statistics of the powSrv that is running on the configured socket
+func printStats() error {
+	powClient := &powsrv.PowClient{Network: powsrv.DefaultNetwork, Address: config.GetString("server.socketPath"), AuthToken: config.GetString("server.authToken"), WriteTimeOutMs: 500, ReadTimeOutMs: 5000}
+	err := powClient.Init()
+	if err != nil {
+		return err
+	}
+	defer powClient.Close()
+
+	stats, err := powClient.GetServerStats()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Uptime:         %v\n", time.Duration(stats.UptimeSeconds)*time.Second)
+	fmt.Printf("PoW requests:   %d\n", stats.TotalRequests)
+	fmt.Printf("Errors:         %d\n", stats.Errors)
+	fmt.Printf("Queue length:   %d\n", stats.QueueLength)
+	fmt.Printf("Cache:          %d hits, %d misses\n", stats.CacheHits, stats.CacheMisses)
+	printLifetimeStats("Since start:", stats.SinceStart)
+	printLifetimeStats("Lifetime:", stats.Lifetime)
+	for _, device := range stats.Devices {
+		state := "idle"
+		if device.Disabled {
+			state = "disabled"
+		} else if device.Busy {
+			state = "busy"
+		}
+		fmt.Printf("Device [%d] %s (%s): %d requests, %d errors, avg %.1f ms, median %.1f ms, max %.1f ms, estimated %.1f ms\n",
+			device.Index, device.PowType, state, device.Requests, device.Errors, device.AvgDurationMs, device.MedianDurationMs, device.MaxDurationMs, device.EstimatedMs)
+	}
+	for _, client := range stats.Clients {
+		fmt.Printf("Client %s: connected %v, %d requests, %d errors, %d rate limited, %d pending, %d running\n",
+			client.Address, time.Duration(client.ConnectedSeconds)*time.Second, client.Requests, client.Errors, client.RateLimited, client.Pending, client.Running)
+	}
+
+	return nil
+}
+
+// benchmark does the PoW operations on the configured devices and prints the results
+func benchmark(operations int) {
+	// The summary lines of the single PoW operations are not of interest
+	if !flag.CommandLine.Changed("log.level") {
+		logs.SetLogLevel("WARNING")
+	}
+
+	deviceConfigs, err := loadDeviceConfigs(config)
+	if err != nil {
+		logs.Log.Fatalf("Device config could not be loaded: %v", err)
+	}
+	powsrv.SetPowDevices(initPowDevices(deviceConfigs))
+	defer powsrv.Shutdown(0)
+
+	mwm := config.GetInt("benchmark-mwm")
+	if !config.GetBool("benchmark-json") {
+		fmt.Printf("Benchmark: %d PoW operations per device, MWM %d\n", operations, mwm)
+	}
+
+	report := powsrv.Benchmark(powsrv.GetPowDevices(), operations, mwm)
+
+	if config.GetBool("benchmark-json") {
+		result, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(result))
+		return
+	}
+
+	printResult := func(name string, result powsrv.BenchmarkResult) {
+		fmt.Printf("%s: %d PoW, %d errors, min %.1f ms, avg %.1f ms, p95 %.1f ms, max %.1f ms, %.2f PoW/s\n",
+			name, result.Operations, result.Errors, result.MinMs, result.AvgMs, result.P95Ms, result.MaxMs, result.PowPerSecond)
+	}
+	for _, result := range report.Devices {
+		printResult(fmt.Sprintf("Device [%d] %s", result.Index, result.PowType), result)
+	}
+	printResult("All devices in parallel", report.Aggregate)
+}
+
+// printLifetimeStats prints the counters and the counters of every POW type
+func printLifetimeStats(title string, stats *powsrv.LifetimeStats) {
+	if stats == nil {
+		return
+	}
+
+	fmt.Printf("%-15s %d PoW requests, %d errors, %.1f s PoW\n", title, stats.Requests, stats.Errors, stats.PowSeconds)
+	powTypes := make([]string, 0, len(stats.PowTypes))
+	for powType := range stats.PowTypes {
+		powTypes = append(powTypes, powType)
+	}
+	sort.Strings(powTypes)
+	for _, powType := range powTypes {
+		powTypeStats := stats.PowTypes[powType]
+		fmt.Printf("  %s: %d PoW requests, %d errors, %.1f s PoW\n", powType, powTypeStats.Requests, powTypeStats.Errors, powTypeStats.PowSeconds)
+	}
+}
+
+// saveLifetimeStats writes the lifetime statistics every interval
+func saveLifetimeStats(path string, interval time.Duration) {
+	for range time.Tick(interval) {
+		err := powsrv.SaveLifetimeStats(path)
 		if err != nil {
-			logs.Log.Fatal(err)
+			logs.Log.Warningf("Lifetime statistics could not be written to %v: %v", path, err)
 		}
-		powVersion = "not implemented yet"
-		/*
-			powVersion, err := pidiver.GetFPGAVersion()
-			if err != nil {
-				log.Fatal(err)
-			}
-		*/
-		powFunc = pidiver.PowPiDiver
-		powType = "PiDiver"
-
-	case "usbdiver":
-		piconfig := pidiver.PiDiverConfig{
-			Device:         config.GetString("usb.device"),
-			ConfigFile:     config.GetString("fpga.core"),
-			ForceFlash:     false,
-			ForceConfigure: false}
-
-		// initialize pidiver
-		err := pidiver.InitUSBDiver(&piconfig)
+	}
+}
+
+// healthCheck checks the devices of the powSrv that is running on the configured socket
+func healthCheck() bool {
+	powClient := &powsrv.PowClient{Network: powsrv.DefaultNetwork, Address: config.GetString("server.socketPath"), AuthToken: config.GetString("server.authToken"), WriteTimeOutMs: 500, ReadTimeOutMs: 2 * config.GetInt("pow.healthCheckTimeoutMs")}
+	err := powClient.Init()
+	if err != nil {
+		fmt.Printf("powSrv not reachable: %v\n", err)
+		return false
+	}
+	defer powClient.Close()
+
+	health, err := powClient.HealthCheck()
+	if err != nil {
+		fmt.Printf("Health check failed: %v\n", err)
+		return false
+	}
+
+	for _, device := range health {
+		state := "OK"
+		if !device.OK {
+			state = "FAIL (" + device.Error + ")"
+		}
+		fmt.Printf("Device [%d] %s: %s, %.1f ms\n", device.Index, device.PowType, state, device.LatencyMs)
+	}
+
+	return powsrv.Healthy(health)
+}
+
+func main() {
+	flag.Parse() // Scan the arguments list
+
+	if config.GetBool("healthcheck") {
+		if !healthCheck() {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if config.GetBool("stats") {
+		err := printStats()
 		if err != nil {
-			logs.Log.Fatal(err)
+			logs.Log.Fatalf("Statistics could not be loaded: %v", err)
 		}
-		powVersion = "not implemented yet"
-		/*
-			powVersion, err := pidiver.GetFPGAVersion()
+		return
+	}
+
+	if operations := config.GetInt("benchmark"); operations > 0 {
+		benchmark(operations)
+		return
+	}
+
+	// With systemd socket activation, the sockets already exist and the configured listeners are not used
+	activated, err := powsrv.ActivationListeners()
+	if err != nil {
+		logs.Log.Fatalf("Socket activation failed: %v", err)
+	}
+
+	var listenerConfigs []listenerConfig
+	if len(activated) == 0 {
+		listenerConfigs = loadListenerConfigs()
+
+		// Refuse to start before the devices are initialized if another powSrv is using a socket
+		for _, listenerConfig := range listenerConfigs {
+			if listenerConfig.Network != "unix" {
+				continue
+			}
+			err := powsrv.PrepareSocket(listenerConfig.Address, config.GetBool("force"))
 			if err != nil {
-				log.Fatal(err)
+				logs.Log.Fatalf("Listener \"%v\": %v (use --force to take over the socket)", listenerConfig.Name, err)
 			}
-		*/
-		powFunc = pidiver.PowUSBDiver
-		powType = "USBDiver"
-
-	case "ftdiver":
-		piconfig := pidiver.PiDiverConfig{
-			Device:         "",
-			ConfigFile:     "",
-			ForceFlash:     false,
-			ForceConfigure: false}
-
-		// initialize pidiver
-		llStruct := ftdiver.GetLowLevel()
-		err := pidiver.InitPiDiver(&llStruct, &piconfig)
+		}
+	}
+
+	statsFile := config.GetString("server.statsFile")
+	if statsFile != "" {
+		err := powsrv.LoadLifetimeStats(statsFile)
+		if err != nil {
+			logs.Log.Warningf("Lifetime statistics could not be loaded, starting fresh: %v", err)
+		}
+		if interval := config.GetInt("server.statsSaveIntervalMinutes"); interval > 0 {
+			go saveLifetimeStats(statsFile, time.Duration(interval)*time.Minute)
+		}
+	}
+
+	deviceConfigs, err := loadDeviceConfigs(config)
+	if err != nil {
+		logs.Log.Fatalf("Device config could not be loaded: %v", err)
+	}
+	powDevices := initPowDevices(deviceConfigs)
+
+	powsrv.SetPowDevices(powDevices)
+	err = powsrv.ApplyPowSettings(config)
+	if err != nil {
+		logs.Log.Fatal(err)
+	}
+
+	if retryInterval := config.GetInt("pow.deviceRetryIntervalSeconds"); retryInterval > 0 {
+		go retryDisabledDevices(time.Duration(retryInterval) * time.Second)
+	}
+
+	logs.Log.Info("Starting powSrv...")
+
+	var tlsConfig *tls.Config
+	if certFile := config.GetString("server.tls.cert"); certFile != "" {
+		var err error
+		tlsConfig, err = powsrv.NewServerTLSConfig(certFile, config.GetString("server.tls.key"), config.GetString("server.tls.clientCAs"))
 		if err != nil {
-			logs.Log.Fatal(err)
+			logs.Log.Fatalf("TLS config could not be loaded: %v", err)
+		}
+	}
+
+	var listeners []net.Listener
+	serve := func(name string, ln net.Listener, origin string) {
+		encryption := ""
+		if (tlsConfig != nil) && (ln.Addr().Network() == "tcp") {
+			ln = tls.NewListener(ln, tlsConfig)
+			encryption = ", TLS"
 		}
-		powVersion = "not implemented yet"
-		/*
-			powVersion, err := pidiver.GetFPGAVersion()
+
+		logs.Log.Infof("Listener \"%v\": listening for connections on \"%v\" (%v%v, %v)", name, ln.Addr(), ln.Addr().Network(), encryption, origin)
+		listeners = append(listeners, ln)
+		go acceptConnections(name, ln)
+	}
+
+	for _, ln := range activated {
+		serve(ln.Name, ln.Listener, "activated by systemd")
+	}
+
+	if len(activated) == 0 {
+		for _, listenerConfig := range listenerConfigs {
+			ln, err := listen(listenerConfig.Network, listenerConfig.Address)
 			if err != nil {
-				logs.Log.Fatal(err)
+				logs.Log.Errorf("Listener \"%v\" could not be started: %v", listenerConfig.Name, err)
+				continue
 			}
-		*/
-		powFunc = pidiver.PowPiDiver
-		powType = "ftdiver"
+			serve(listenerConfig.Name, ln, "configured")
+		}
+	}
+	if len(listeners) == 0 {
+		logs.Log.Fatal("No listener could be started")
+	}
 
-	default:
-		logs.Log.Fatal("Unknown POW type")
+	if discoveryPort := config.GetInt("server.discoveryPort"); discoveryPort > 0 {
+		startDiscovery(discoveryPort, listeners, tlsConfig != nil)
 	}
 
-	powsrv.SetPowFunc(powFunc)
+	if metricsAddress := config.GetString("server.metricsAddress"); metricsAddress != "" {
+		err := metrics.Start(metricsAddress)
+		if err != nil {
+			logs.Log.Warningf("Metrics could not be started on \"%v\": %v", metricsAddress, err)
+		} else {
+			logs.Log.Infof("Serving metrics on \"http://%v/metrics\"", metricsAddress)
+		}
+	}
 
-	// Servers should unlink the socket pathname prior to binding it.
-	// https://troydhanson.github.io/network/Unix_domain_sockets.html
-	syscall.Unlink(config.GetString("server.socketPath"))
+	if httpAddress := config.GetString("server.httpAddress"); httpAddress != "" {
+		ln, err := net.Listen("tcp", httpAddress)
+		if err != nil {
+			logs.Log.Warningf("HTTP API could not be started on \"%v\": %v", httpAddress, err)
+		} else {
+			scheme := "http"
+			if tlsConfig != nil {
+				ln = tls.NewListener(ln, tlsConfig)
+				scheme = "https"
+			}
+			logs.Log.Infof("Serving the HTTP API on \"%v://%v/\"", scheme, ln.Addr())
+			go http.Serve(ln, powsrv.HTTPHandler(config))
+		}
+	}
 
-	logs.Log.Info("Starting powSrv...")
-	ln, err := net.Listen("unix", config.GetString("server.socketPath"))
-	if err != nil {
-		logs.Log.Fatal("Listen error:", err)
+	if eventsAddress := config.GetString("server.eventsAddress"); eventsAddress != "" {
+		ln, err := net.Listen("tcp", eventsAddress)
+		if err != nil {
+			logs.Log.Warningf("POW events could not be started on \"%v\": %v", eventsAddress, err)
+		} else {
+			if tlsConfig != nil {
+				ln = tls.NewListener(ln, tlsConfig)
+			}
+			logs.Log.Infof("Publishing the POW events on \"%v\"", ln.Addr())
+			go powsrv.ServeEvents(ln)
+		}
+	}
+
+	if network := config.GetString("pow.network"); network != "" {
+		logs.Log.Infof("Min-Weight-Magnitude: %d - %d (network \"%v\")", config.GetInt("pow.minMinWeightMagnitude"), config.GetInt("pow.maxMinWeightMagnitude"), network)
+	} else {
+		logs.Log.Infof("Min-Weight-Magnitude: %d - %d", config.GetInt("pow.minMinWeightMagnitude"), config.GetInt("pow.maxMinWeightMagnitude"))
+	}
+
+	logs.Log.Info("powSrv started. Waiting for connections...")
+	for _, device := range powDevices {
+		if device.Disabled() {
+			logs.Log.Warningf("POW device disabled: %v", device)
+		} else {
+			logs.Log.Infof("Using POW device: %v", device)
+		}
 	}
 
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	go func() {
+		for range sighup {
+			logs.Log.Info("Caught signal SIGHUP: reloading the device configuration.")
+			reloadDevices()
+		}
+	}()
+
+	notifyReopenLogs()
+
 	sigc := make(chan os.Signal, 1)
 	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
-	go func(ln net.Listener, c chan os.Signal) {
-		sig := <-c
-		logs.Log.Infof("Caught signal %s: powSrv shutting down.", sig)
+	sig := <-sigc
+
+	logs.Log.Infof("Caught signal %s: powSrv shutting down.", sig)
+	for _, ln := range listeners {
 		ln.Close()
-		os.Exit(0)
-	}(ln, sigc)
+	}
 
-	logs.Log.Info("powSrv started. Waiting for connections...")
-	logs.Log.Infof("Listening for connections on \"%v\"", config.GetString("server.socketPath"))
-	logs.Log.Infof("Using POW type: %v", powType)
+	go func() {
+		sig := <-sigc
+		logs.Log.Warningf("Caught signal %s again: powSrv exits immediately.", sig)
+		os.Exit(1)
+	}()
+
+	powsrv.Shutdown(time.Duration(config.GetInt("server.shutdownGraceSeconds")) * time.Second)
+
+	if statsFile != "" {
+		err := powsrv.SaveLifetimeStats(statsFile)
+		if err != nil {
+			logs.Log.Warningf("Lifetime statistics could not be written to %v: %v", statsFile, err)
+		}
+	}
+
+	for _, device := range powsrv.GetPowDevices() {
+		logs.Log.Infof("Device %v: %d PoW requests", device, device.Requests())
+	}
+}
+
+// startDiscovery answers the discovery probes on the UDP port with the address of the first TCP listener
+func startDiscovery(port int, listeners []net.Listener, useTLS bool) {
+	var endpoint *powsrv.Endpoint
+	for _, ln := range listeners {
+		tcpAddr, ok := ln.Addr().(*net.TCPAddr)
+		if !ok {
+			continue
+		}
+
+		// The clients complete the address with the source address of the response
+		address := tcpAddr.String()
+		if tcpAddr.IP.IsUnspecified() {
+			address = fmt.Sprintf(":%d", tcpAddr.Port)
+		}
+		endpoint = &powsrv.Endpoint{Network: "tcp", Address: address, TLS: useTLS}
+		break
+	}
+	if endpoint == nil {
+		logs.Log.Warning("Discovery not started, there is no TCP listener")
+		return
+	}
+
+	conn, err := net.ListenPacket("udp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		logs.Log.Warningf("Discovery could not be started on UDP port %d: %v", port, err)
+		return
+	}
+
+	logs.Log.Infof("Answering discovery probes on UDP port %d with \"%v\"", port, endpoint.Address)
+	go powsrv.ServeDiscovery(conn, *endpoint, config.GetString("server.authToken"))
+}
+
+// loadListenerConfigs returns the configured listeners
+// If no "server.listeners" are configured, the listeners are built from "server.socketPath" and "server.address"
+func loadListenerConfigs() []listenerConfig {
+	var listenerConfigs []listenerConfig
+
+	err := config.UnmarshalKey("server.listeners", &listenerConfigs)
+	if err != nil {
+		logs.Log.Fatalf("Listener config could not be loaded: %v", err)
+	}
+
+	if len(listenerConfigs) == 0 {
+		if socketPath := config.GetString("server.socketPath"); socketPath != "" {
+			listenerConfigs = append(listenerConfigs, listenerConfig{Name: powsrv.DefaultNetwork, Network: powsrv.DefaultNetwork, Address: socketPath})
+		}
+		if address := config.GetString("server.address"); address != "" {
+			network := config.GetString("server.network")
+			listenerConfigs = append(listenerConfigs, listenerConfig{Name: network, Network: network, Address: address})
+		}
+	}
+
+	for i := range listenerConfigs {
+		if listenerConfigs[i].Name == "" {
+			listenerConfigs[i].Name = fmt.Sprintf("%v %v", listenerConfigs[i].Network, listenerConfigs[i].Address)
+		}
+	}
+
+	return listenerConfigs
+}
+
+// listen creates a listener for the given network ("unix", "tcp" or "pipe")
+func listen(network string, address string) (net.Listener, error) {
+	// Stale Unix sockets were already removed by powsrv.PrepareSocket
+	if network == "pipe" {
+		return powsrv.ListenPipe(address)
+	}
+
+	return net.Listen(network, address)
+}
+
+// acceptConnections handles the incoming connections of a listener until it is closed
+func acceptConnections(name string, ln net.Listener) {
 	for {
 		fd, err := ln.Accept()
 		if err != nil {
-			logs.Log.Info("Accept error: ", err)
-			continue
-		} else {
-			logs.Log.Debugf("New connection accepted from \"%v\"", fd.RemoteAddr)
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				logs.Log.Infof("Listener \"%v\": accept error: %v", name, err)
+				continue
+			}
+			// Listener closed
+			return
 		}
 
-		go powsrv.HandleClientConnection(fd, config, powType, powVersion)
+		go func(c net.Conn) {
+			addr := remoteAddr(c)
+			logs.Log.Debugf("Listener \"%v\": new connection accepted from \"%v\"", name, addr)
+			powsrv.HandleClientConnection(c, config)
+			logs.Log.Debugf("Listener \"%v\": connection from \"%v\" closed", name, addr)
+		}(fd)
+	}
+}
+
+// remoteAddr returns a printable address of the client
+// Unix socket clients are usually unnamed, so the socket path is used instead
+func remoteAddr(c net.Conn) string {
+	if addr := c.RemoteAddr(); (addr != nil) && (addr.String() != "") && (addr.String() != "@") {
+		return fmt.Sprintf("%v (%v)", addr, addr.Network())
 	}
+	return fmt.Sprintf("%v (%v)", c.LocalAddr(), c.LocalAddr().Network())
 }
@@ -0,0 +1,26 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/muxxer/powsrv/logs"
+)
+
+// notifyReopenLogs reopens the log file on SIGUSR1, e.g. after logrotate moved it
+func notifyReopenLogs() {
+	sigusr1 := make(chan os.Signal, 1)
+	signal.Notify(sigusr1, syscall.SIGUSR1)
+	go func() {
+		for range sigusr1 {
+			if err := logs.Reopen(); err != nil {
+				logs.Log.Errorf("Log file could not be reopened: %v", err)
+				continue
+			}
+			logs.Log.Info("Caught signal SIGUSR1: log file reopened.")
+		}
+	}()
+}
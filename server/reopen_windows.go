@@ -0,0 +1,4 @@
+package main
+
+// notifyReopenLogs does nothing, there is no SIGUSR1 on Windows
+func notifyReopenLogs() {}
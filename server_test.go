@@ -0,0 +1,946 @@
+package powsrv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iotaledger/giota"
+	"github.com/spf13/viper"
+
+	"github.com/muxxer/powsrv/ipc"
+)
+
+// resetShutdown allows the following tests to use the server again
+func resetShutdown() {
+	inFlightMutex.Lock()
+	shuttingDown = false
+	inFlightFinished = nil
+	inFlightMutex.Unlock()
+}
+
+// testShutdown starts a POW request on a device that needs powDuration and shuts the server down during the POW
+func testShutdown(t *testing.T, powDuration time.Duration, grace time.Duration) error {
+	path, cleanup := startTestServer(t, false)
+	defer cleanup()
+	defer resetShutdown()
+
+	powDone := make(chan struct{})
+	defer close(powDone)
+
+	SetPowDevices([]*PowDevice{{PowType: "test", PowFunc: func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		select {
+		case <-time.After(powDuration):
+		case <-powDone:
+		}
+		return trytes, nil
+	}}})
+
+	powClient := &PowClient{PowSrvPath: path, WriteTimeOutMs: 500, ReadTimeOutMs: 10000}
+	err := powClient.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer powClient.Close()
+
+	data, err := giota.ToTrytes(transaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := powClient.PowFunc(data, MWM)
+		result <- err
+	}()
+
+	// Wait until the request is in progress
+	time.Sleep(50 * time.Millisecond)
+	Shutdown(grace)
+
+	err = <-result
+
+	_, errAfterShutdown := powClient.PowFunc(data, MWM)
+	if errAfterShutdown == nil {
+		t.Error("Request after shutdown succeeded")
+	}
+
+	return err
+}
+
+func TestShutdownDrainsRequests(t *testing.T) {
+	err := testShutdown(t, 200*time.Millisecond, 5*time.Second)
+	if err != nil {
+		t.Errorf("Request in progress failed: %v", err)
+	}
+}
+
+func TestShutdownGracePeriodExpired(t *testing.T) {
+	err := testShutdown(t, time.Hour, 100*time.Millisecond)
+	if err != ErrConnectionLost {
+		t.Errorf("Expected ErrConnectionLost, got: %v", err)
+	}
+}
+
+func TestGetPowInfoDuringPow(t *testing.T) {
+	path, cleanup := startTestServer(t, false)
+	defer cleanup()
+
+	powDone := make(chan struct{})
+	SetPowDevices([]*PowDevice{{PowType: "test", PowFunc: func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		<-powDone
+		return trytes, nil
+	}}})
+
+	powClient := &PowClient{PowSrvPath: path, WriteTimeOutMs: 500, ReadTimeOutMs: 5000}
+	err := powClient.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer powClient.Close()
+
+	data, err := giota.ToTrytes(transaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := powClient.PowFunc(data, MWM)
+		result <- err
+	}()
+
+	// The POW is still in progress, but the info is answered immediately
+	_, powType, _, err := powClient.GetPowInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if powType != "[0] test" {
+		t.Errorf("Wrong PowType: %v", powType)
+	}
+
+	close(powDone)
+	err = <-result
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGetPowInfoCached(t *testing.T) {
+	path, cleanup := startTestServer(t, false)
+	defer cleanup()
+
+	SetPowDevices([]*PowDevice{classDevice(0, DeviceClassFPGA, fakeNonce)})
+
+	powClient := &PowClient{PowSrvPath: path, WriteTimeOutMs: 500, ReadTimeOutMs: 5000}
+	err := powClient.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer powClient.Close()
+
+	data, err := giota.ToTrytes(transaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Concurrent calls don't interfere with each other or with POW requests
+	errs := make(chan error, 20)
+	for i := 0; i < 10; i++ {
+		go func() {
+			serverVersion, powType, _, err := powClient.GetPowInfo()
+			if (err == nil) && ((serverVersion != powSrvVersion) || (powType != "[0] test")) {
+				err = fmt.Errorf("Wrong info: %q, %q", serverVersion, powType)
+			}
+			errs <- err
+		}()
+		go func() {
+			_, err := powClient.PowFunc(data, MWM)
+			errs <- err
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+
+	// The cached info is returned until it is refreshed
+	SetPowDevices([]*PowDevice{classDevice(0, DeviceClassCPU, fakeNonce), classDevice(1, DeviceClassCPU, fakeNonce)})
+	_, powType, _, err := powClient.GetPowInfo()
+	if (err != nil) || (powType != "[0] test") {
+		t.Errorf("Expected the cached info, got %q, %v", powType, err)
+	}
+	_, powType, _, err = powClient.RefreshPowInfo()
+	if (err != nil) || (powType != "[0] test, [1] test") {
+		t.Errorf("Expected the current info, got %q, %v", powType, err)
+	}
+	_, powType, _, err = powClient.GetPowInfo()
+	if (err != nil) || (powType != "[0] test, [1] test") {
+		t.Errorf("Expected the refreshed info, got %q, %v", powType, err)
+	}
+}
+
+func TestGetPowTypeOfServingDevice(t *testing.T) {
+	path, cleanup := startTestServer(t, false)
+	defer cleanup()
+
+	fpga := classDevice(0, DeviceClassFPGA, fakeNonce)
+	fpga.PowType, fpga.PowVersion = "PiDiver", "core=3 driver=pidiver"
+	cpu := classDevice(1, DeviceClassCPU, fakeNonce)
+	cpu.PowType, cpu.PowVersion = "gIOTA-PowC", "1.0"
+	SetPowDevices([]*PowDevice{fpga, cpu})
+
+	powClient := &PowClient{PowSrvPath: path, WriteTimeOutMs: 500, ReadTimeOutMs: 5000, PreferredDevice: DeviceClassCPU}
+	err := powClient.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer powClient.Close()
+
+	// All devices are reported before the first POW
+	powType, err := powClient.sendIpcFrameToServer(context.Background(), ipc.CmdGetPowType, nil)
+	if (err != nil) || (string(powType) != "[0] PiDiver, [1] gIOTA-PowC") {
+		t.Errorf("Unexpected PowType before the POW: %q, %v", powType, err)
+	}
+
+	data, err := giota.ToTrytes(transaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = powClient.PowFunc(data, MWM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Afterwards the device that did the POW of the connection
+	powType, err = powClient.sendIpcFrameToServer(context.Background(), ipc.CmdGetPowType, nil)
+	if (err != nil) || (string(powType) != "gIOTA-PowC") {
+		t.Errorf("Unexpected PowType after the POW: %q, %v", powType, err)
+	}
+	powVersion, err := powClient.sendIpcFrameToServer(context.Background(), ipc.CmdGetPowVersion, nil)
+	if (err != nil) || (string(powVersion) != "1.0") {
+		t.Errorf("Unexpected PowVersion after the POW: %q, %v", powVersion, err)
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	path, cleanup := startTestServer(t, false)
+	defer cleanup()
+
+	SetMaxQueueDepth(1)
+	defer SetMaxQueueDepth(0)
+
+	powStarted := make(chan struct{}, 2)
+	powDone := make(chan struct{})
+	SetPowDevices([]*PowDevice{{PowType: "test", PowFunc: func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		powStarted <- struct{}{}
+		<-powDone
+		return trytes, nil
+	}}})
+
+	powClient := &PowClient{PowSrvPath: path, WriteTimeOutMs: 500, ReadTimeOutMs: 5000}
+	err := powClient.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer powClient.Close()
+
+	data, err := giota.ToTrytes(transaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := make(chan error, 2)
+	powFunc := func() {
+		_, err := powClient.PowFunc(data, MWM)
+		results <- err
+	}
+
+	// First request is in progress, the second one is queued
+	go powFunc()
+	<-powStarted
+	go powFunc()
+
+	for {
+		stats, err := powClient.GetServerStats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.QueueLength == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	_, err = powClient.PowFunc(data, MWM)
+	if !errors.Is(err, ErrServerBusy) {
+		t.Errorf("Expected ErrServerBusy, got: %v", err)
+	}
+
+	close(powDone)
+	for i := 0; i < 2; i++ {
+		if err := <-results; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	path, cleanup := startTestServer(t, false)
+	defer cleanup()
+
+	SetPowDevices([]*PowDevice{
+		{Index: 0, PowType: "ok", PowFunc: func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+			return trytes, nil
+		}},
+		{Index: 1, PowType: "wedged", PowFunc: func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+			time.Sleep(time.Second)
+			return trytes, nil
+		}},
+	})
+
+	powClient := &PowClient{PowSrvPath: path, WriteTimeOutMs: 500, ReadTimeOutMs: 10000}
+	err := powClient.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer powClient.Close()
+
+	health, err := powClient.HealthCheck()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if (len(health) != 2) || !health[0].OK || health[1].OK {
+		t.Errorf("Wrong health check result: %+v", health)
+	}
+	if Healthy(health) {
+		t.Error("Server with a wedged device is healthy")
+	}
+}
+
+func TestDisabledDevice(t *testing.T) {
+	path, cleanup := startTestServer(t, false)
+	defer cleanup()
+
+	echoPow := func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return trytes, nil
+	}
+
+	disabled := &PowDevice{Index: 1, PowType: "usbdiver"}
+	DisablePowDevice(disabled)
+	SetPowDevices([]*PowDevice{{Index: 0, PowType: "test", PowFunc: echoPow}, disabled})
+
+	powClient := &PowClient{PowSrvPath: path, WriteTimeOutMs: 500, ReadTimeOutMs: 5000}
+	err := powClient.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer powClient.Close()
+
+	_, powType, _, err := powClient.GetPowInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if powType != "[0] test, [1] usbdiver (disabled)" {
+		t.Errorf("Wrong PowType: %v", powType)
+	}
+
+	data, err := giota.ToTrytes(transaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The remaining device does the POW
+	_, err = powClient.PowFunc(data, MWM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The recovered device is used again
+	ReplacePowDevice(&PowDevice{Index: 1, PowType: "USBDiver", PowFunc: echoPow})
+	DisablePowDevice(GetPowDevices()[0])
+
+	_, powType, _, err = powClient.RefreshPowInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if powType != "[0] test (disabled), [1] USBDiver" {
+		t.Errorf("Wrong PowType: %v", powType)
+	}
+
+	_, err = powClient.PowFunc(data, MWM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if GetPowDevices()[1].Requests() != 1 {
+		t.Errorf("Recovered device was not used")
+	}
+
+	// No device left
+	DisablePowDevice(GetPowDevices()[1])
+	_, err = powClient.PowFunc(data, MWM)
+	if err == nil {
+		t.Error("POW without an enabled device succeeded")
+	}
+}
+
+// notificationRecorder collects the notifications of a PowClient
+type notificationRecorder struct {
+	mutex    sync.Mutex
+	messages []string
+}
+
+func (r *notificationRecorder) add(message string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.messages = append(r.messages, message)
+}
+
+func (r *notificationRecorder) String() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return fmt.Sprint(r.messages)
+}
+
+func TestDeviceLost(t *testing.T) {
+	path, cleanup := startTestServer(t, false)
+	defer cleanup()
+
+	usbStarted := make(chan struct{}, 2)
+	usbRelease := make(chan struct{})
+	usb := &PowDevice{Index: 0, PowType: "USBDiver", PowClass: DeviceClassFPGA, PowFunc: func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		usbStarted <- struct{}{}
+		<-usbRelease
+		return "", errors.New("device unplugged")
+	}}
+	cpuStarted := make(chan struct{}, 2)
+	cpuRelease := make(chan struct{})
+	cpu := &PowDevice{Index: 1, PowType: "cpu", PowClass: DeviceClassCPU, PowFunc: func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		cpuStarted <- struct{}{}
+		<-cpuRelease
+		return fakeNonce, nil
+	}}
+	SetPowDevices([]*PowDevice{usb, cpu})
+
+	newClient := func(device string) (*PowClient, *notificationRecorder) {
+		recorder := &notificationRecorder{}
+		powClient := &PowClient{PowSrvPath: path, WriteTimeOutMs: 500, ReadTimeOutMs: 5000, PreferredDevice: device, OnServerNotification: recorder.add}
+		err := powClient.Init()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return powClient, recorder
+	}
+	pow := func(powClient *PowClient, mwm int) chan error {
+		result := make(chan error, 1)
+		go func() {
+			_, err := powClient.PowFunc(giota.Trytes(transaction), mwm)
+			result <- err
+		}()
+		return result
+	}
+	waitQueued := func(length int) {
+		for getDispatcher().queueLength() != length {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	cpuClient, cpuNotifications := newClient("cpu")
+	defer cpuClient.Close()
+	fpgaClient, fpgaNotifications := newClient("fpga")
+	defer fpgaClient.Close()
+	anyClient, anyNotifications := newClient("any")
+	defer anyClient.Close()
+
+	// Both devices are busy, a request for the FPGA and a request for any device are queued
+	cpuResult := pow(cpuClient, 10)
+	<-cpuStarted
+	fpgaRunning := pow(fpgaClient, 11)
+	<-usbStarted
+	fpgaQueued := pow(fpgaClient, 12)
+	waitQueued(1)
+	anyResult := pow(anyClient, 13)
+	waitQueued(2)
+
+	// The queued request that only the lost device could do fails immediately
+	DisablePowDevice(usb)
+	select {
+	case err := <-fpgaQueued:
+		if !errors.Is(err, ErrNoDevice) {
+			t.Errorf("Expected ErrNoDevice, got: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Queued request of the lost device didn't fail")
+	}
+
+	// The running POW fails, the request of any device is done by the remaining device
+	close(usbRelease)
+	if err := <-fpgaRunning; err == nil {
+		t.Error("POW of the unplugged device succeeded")
+	}
+	close(cpuRelease)
+	for _, result := range []chan error{cpuResult, anyResult} {
+		if err := <-result; err != nil {
+			t.Error(err)
+		}
+	}
+
+	// Only the clients with requests of the lost device are notified
+	for _, test := range []struct {
+		name     string
+		recorder *notificationRecorder
+		expected string
+	}{
+		{"cpu", cpuNotifications, "[]"},
+		{"fpga", fpgaNotifications, "[DEVICE_LOST USBDiver]"},
+		{"any", anyNotifications, "[DEVICE_LOST USBDiver]"},
+	} {
+		if notifications := test.recorder.String(); notifications != test.expected {
+			t.Errorf("Client %v: expected the notifications %v, got %v", test.name, test.expected, notifications)
+		}
+	}
+}
+
+func TestUpdatePowDevices(t *testing.T) {
+	data, err := giota.ToTrytes(transaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	powStarted := make(chan struct{}, 2)
+	powDone := make(chan struct{})
+	blockingPow := func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		powStarted <- struct{}{}
+		<-powDone
+		return trytes, nil
+	}
+
+	released := make(chan struct{})
+	kept := &PowDevice{Index: 0, PowType: "kept", PowFunc: blockingPow}
+	removed := &PowDevice{Index: 1, PowType: "removed", PowFunc: blockingPow, CloseFunc: func() error {
+		close(released)
+		return nil
+	}}
+	SetPowDevices([]*PowDevice{kept, removed})
+
+	// Both devices are busy during the update
+	results := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := PowFunc(data, MWM)
+			results <- err
+		}()
+		<-powStarted
+	}
+
+	added := &PowDevice{Index: 2, PowType: "added", PowFunc: func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return trytes, nil
+	}}
+	UpdatePowDevices([]*PowDevice{kept, added})
+
+	if powType := getDispatcher().powTypes(); powType != "[0] kept, [2] added" {
+		t.Errorf("Wrong PowType: %v", powType)
+	}
+
+	// The added device is used immediately
+	_, err = PowFunc(data, MWM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-released:
+		t.Fatal("Removed device was released during the POW")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(powDone)
+	for i := 0; i < 2; i++ {
+		if err := <-results; err != nil {
+			t.Error(err)
+		}
+	}
+
+	select {
+	case <-released:
+	case <-time.After(time.Second):
+		t.Fatal("Removed device was not released")
+	}
+}
+
+func TestMalformedFrames(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	config := viper.New()
+	config.Set("server.maxMalformedFrames", 3)
+	go HandleClientConnection(server, config)
+
+	go func() {
+		writer := ipc.NewFrameWriter(client, ipc.Version1)
+		// POW request without data is an error, but not a malformed frame
+		writer.WriteFrame(0x01, ipc.CmdPowFunc, nil)
+		for i := 0; i < 3; i++ {
+			client.Write([]byte{ipc.StartByte, ipc.Version1, 0x00, 0x04, byte(0x02 + i), 0xFF, 0x00, 0x00, 0x00})
+		}
+	}()
+
+	reader := ipc.NewFrameReader(client)
+	for reqID := byte(0x01); reqID <= 0x04; reqID++ {
+		frame, err := reader.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (frame.Command != ipc.CmdError) || (frame.ReqID != reqID) {
+			t.Errorf("Expected error for ReqID %X, got: %+v", reqID, frame)
+		}
+	}
+
+	// Connection is dropped after the third malformed frame
+	_, err := reader.ReadFrame()
+	if err != io.EOF {
+		t.Errorf("Connection not dropped: %v", err)
+	}
+}
+
+func TestFairness(t *testing.T) {
+	path, cleanup := startTestServer(t, false)
+	defer cleanup()
+
+	SetMaxPendingPerClient(1)
+	defer SetMaxPendingPerClient(0)
+
+	started := make(chan byte, 5)
+	powDone := make(chan struct{})
+	SetPowDevices([]*PowDevice{{PowType: "test", PowFunc: func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		started <- trytes[0]
+		<-powDone
+		return trytes, nil
+	}}})
+
+	results := make(chan error, 5)
+	var clients []*PowClient
+	pow := func(powClient *PowClient, client byte, count int) {
+		data, err := giota.ToTrytes(string(client) + transaction[1:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < count; i++ {
+			go func() {
+				_, err := powClient.PowFunc(data, MWM)
+				results <- err
+			}()
+		}
+	}
+	for i := 0; i < 2; i++ {
+		powClient := &PowClient{PowSrvPath: path, WriteTimeOutMs: 500, ReadTimeOutMs: 5000}
+		err := powClient.Init()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer powClient.Close()
+		clients = append(clients, powClient)
+	}
+
+	// Client A pipelines 4 requests before client B sends its request
+	pow(clients[0], 'A', 4)
+	if first := <-started; first != 'A' {
+		t.Fatalf("Wrong first request: %c", first)
+	}
+	waitForQueueLength(t, clients[0], 3)
+	pow(clients[1], 'B', 1)
+	waitForQueueLength(t, clients[0], 4)
+
+	order := "A"
+	for i := 0; i < 4; i++ {
+		powDone <- struct{}{}
+		order += string(<-started)
+	}
+	powDone <- struct{}{}
+
+	if order != "ABAAA" {
+		t.Errorf("Requests not scheduled round-robin: %s", order)
+	}
+	for i := 0; i < 5; i++ {
+		if err := <-results; err != nil {
+			t.Error(err)
+		}
+	}
+
+	// Connections of other tests may not be closed yet
+	requests := make(map[uint64]bool)
+	for _, client := range GetServerStats().Clients {
+		requests[client.Requests] = true
+	}
+	if !requests[4] || !requests[1] {
+		t.Errorf("Wrong client statistics: %+v", GetServerStats().Clients)
+	}
+}
+
+// waitForQueueLength waits until the given number of requests is queued on the server
+func waitForQueueLength(t *testing.T, powClient *PowClient, length int) {
+	for {
+		stats, err := powClient.GetServerStats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.QueueLength == length {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// newPipeClient returns a client that is connected to a server with the given config via net.Pipe
+func newPipeClient(config *viper.Viper) *PowClient {
+	client, server := net.Pipe()
+	go HandleClientConnection(server, config)
+
+	SetPowDevices([]*PowDevice{{PowType: "test", PowFunc: func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return trytes, nil
+	}}})
+
+	c := newServerConnection(client)
+	powClient := &PowClient{ReadTimeOutMs: 5000}
+	powClient.pending = make(map[byte]*pendingRequest)
+	powClient.pendingSlots = make(chan struct{}, 256)
+	powClient.connection = c
+	go powClient.receive(c)
+	return powClient
+}
+
+func TestRateLimit(t *testing.T) {
+	config := viper.New()
+	config.Set("pow.maxMinWeightMagnitude", 243)
+	config.Set("pow.maxRequestsPerMinute", 2)
+	powClient := newPipeClient(config)
+	defer powClient.Close()
+
+	data, err := giota.ToTrytes(transaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err = powClient.PowFunc(data, MWM)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = powClient.PowFunc(data, MWM)
+	rateLimitErr, ok := err.(*RateLimitError)
+	if !ok {
+		t.Fatalf("Expected RateLimitError, got: %v", err)
+	}
+	if (rateLimitErr.RetryAfter <= 0) || (rateLimitErr.RetryAfter > 30*time.Second) {
+		t.Errorf("Wrong retry-after hint: %v", rateLimitErr.RetryAfter)
+	}
+}
+
+func TestPowValidation(t *testing.T) {
+	config := viper.New()
+	config.Set("pow.maxMinWeightMagnitude", 14)
+	config.Set("pow.minMinWeightMagnitude", 9)
+	powClient := newPipeClient(config)
+	defer powClient.Close()
+
+	data, err := giota.ToTrytes(transaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = powClient.PowFunc(data, 15)
+	if !errors.Is(err, ErrMWMTooHigh) {
+		t.Errorf("Expected ErrMWMTooHigh, got: %v", err)
+	}
+
+	_, err = powClient.PowFunc(data, 8)
+	var serverErr *ServerError
+	if !errors.Is(err, ErrMWMTooLow) || !errors.As(err, &serverErr) || (serverErr.Message != "9") {
+		t.Errorf("Expected ErrMWMTooLow with the minimum, got: %v", err)
+	}
+
+	_, err = powClient.PowFunc(data[:100], 14)
+	if !errors.Is(err, ErrInvalidTrytes) {
+		t.Errorf("Expected ErrInvalidTrytes for a short transaction, got: %v", err)
+	}
+
+	_, err = powClient.sendIpcFrameToServer(context.Background(), ipc.CmdPowFunc, append([]byte{14}, []byte(strings.Replace(transaction, "9", "a", 1))...))
+	if !errors.Is(err, ErrInvalidTrytes) {
+		t.Errorf("Expected ErrInvalidTrytes for invalid characters, got: %v", err)
+	}
+
+	_, err = powClient.sendIpcFrameToServer(context.Background(), ipc.CmdPowFuncBatch, append([]byte{14, 0}, []byte(transaction[1:])...))
+	if !errors.Is(err, ErrInvalidTrytes) {
+		t.Errorf("Expected ErrInvalidTrytes for a batch, got: %v", err)
+	}
+
+	_, err = powClient.PowFunc(data, 14)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPowFuncPriority(t *testing.T) {
+	config := viper.New()
+	config.Set("pow.maxMinWeightMagnitude", 14)
+	powClient := newPipeClient(config)
+	defer powClient.Close()
+
+	before := GetServerStats().Priorities
+	_, err := powClient.PowFuncPriority(giota.Trytes(transaction), 14, ipc.PriorityHigh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = powClient.PowFunc(giota.Trytes(transaction), 14)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after := GetServerStats().Priorities
+	if (len(after) != 2) || (after[ipc.PriorityHigh].Priority != ipc.PriorityHigh) {
+		t.Fatalf("Wrong priority statistics: %+v", after)
+	}
+	for _, priority := range []int{ipc.PriorityNormal, ipc.PriorityHigh} {
+		if after[priority].Requests != before[priority].Requests+1 {
+			t.Errorf("Expected 1 started request with priority %d, got %d", priority, after[priority].Requests-before[priority].Requests)
+		}
+	}
+
+	_, err = powClient.sendIpcFrameToServer(context.Background(), ipc.CmdPowFunc, append(append([]byte{14}, []byte(transaction)...), 2))
+	var serverErr *ServerError
+	if !errors.As(err, &serverErr) || (serverErr.Code != ipc.ErrorInvalidRequest) {
+		t.Errorf("Expected INVALID_REQUEST for an unknown priority, got: %v", err)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "powSrv.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	config := viper.New()
+	config.Set("pow.maxMinWeightMagnitude", 243)
+	config.Set("server.clientIdleTimeoutSeconds", 1)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go HandleClientConnection(c, config)
+		}
+	}()
+
+	SetPowDevices([]*PowDevice{{PowType: "test", PowFunc: func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		time.Sleep(1500 * time.Millisecond)
+		return trytes, nil
+	}}})
+
+	// A client without requests is dropped
+	idle, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+	idle.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = idle.Read(make([]byte, 1))
+	if err != io.EOF {
+		t.Errorf("Idle connection not closed: %v", err)
+	}
+
+	// A quiet client with a POW in progress is kept
+	powClient := &PowClient{Network: "unix", Address: path, WriteTimeOutMs: 500, ReadTimeOutMs: 5000}
+	err = powClient.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer powClient.Close()
+	_, err = powClient.PowFunc(giota.Trytes(transaction), MWM)
+	if err != nil {
+		t.Errorf("POW in progress failed: %v", err)
+	}
+
+	// The pings keep a quiet client alive
+	pingClient := &PowClient{Network: "unix", Address: path, WriteTimeOutMs: 500, ReadTimeOutMs: 5000, KeepAliveIntervalMs: 300}
+	err = pingClient.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pingClient.Close()
+	time.Sleep(2 * time.Second)
+	if !pingClient.connected() {
+		t.Error("Connection with pings was closed")
+	}
+	err = pingClient.Ping()
+	if err != nil {
+		t.Error(err)
+	}
+
+	// Pings during a POW must not interfere with the response
+	_, err = pingClient.PowFunc(giota.Trytes(transaction), MWM)
+	if err != nil {
+		t.Errorf("POW with pings failed: %v", err)
+	}
+}
+
+func TestSlowClient(t *testing.T) {
+	path, cleanup := startTestServer(t, false)
+	defer cleanup()
+
+	client, server := net.Pipe()
+	defer client.Close()
+
+	config := viper.New()
+	config.Set("pow.maxMinWeightMagnitude", 243)
+	config.Set("server.writeTimeoutMs", 200)
+	handled := make(chan struct{})
+	go func() {
+		HandleClientConnection(server, config)
+		close(handled)
+	}()
+
+	// The client sends POW requests, but never reads the responses
+	go func() {
+		writer := ipc.NewFrameWriter(client, ipc.Version1)
+		for reqID := 0; reqID < 2*outboxSize; reqID++ {
+			if writer.WriteFrame(byte(reqID), ipc.CmdPowFunc, append([]byte{byte(MWM)}, transaction...)) != nil {
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Connection of the slow client was not closed")
+	}
+
+	// The device workers are not blocked by the slow client
+	powClient := &PowClient{PowSrvPath: path, WriteTimeOutMs: 500, ReadTimeOutMs: 2000}
+	err := powClient.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer powClient.Close()
+
+	_, err = powClient.PowFunc(giota.Trytes(transaction), MWM)
+	if err != nil {
+		t.Errorf("POW after the slow client failed: %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+package powsrv
+
+import (
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// restartSettings are read when the server or a connection starts, changes are only used after a restart
+var restartSettings = []string{
+	"pow.network",
+	"pow.minMinWeightMagnitude",
+	"pow.maxMinWeightMagnitude",
+	"pow.maxRequestsPerMinute",
+	"pow.deviceRetryIntervalSeconds",
+}
+
+// ApplyPowSettings applies the runtime settings of the pow section, e.g. "pow.maxQueueDepth"
+// It is used at the start and on a reload, missing keys get the value of config, e.g. the flag defaults.
+// If "pow.schedulerPolicy" is invalid, the other settings are applied and the old policy is kept.
+func ApplyPowSettings(config *viper.Viper) error {
+	SetMaxQueueDepth(config.GetInt("pow.maxQueueDepth"))
+	SetMaxPendingPerClient(config.GetInt("pow.maxPendingPerClient"))
+	SetMaxConsecutiveHighPriority(config.GetInt("pow.maxConsecutiveHighPriority"))
+	SetSpillThreshold(time.Duration(config.GetInt("pow.spillThresholdMs")) * time.Millisecond)
+	SetCoalesceRequests(config.GetBool("pow.coalesceIdenticalRequests"))
+	SetPowFailover(config.GetBool("pow.failover"))
+	SetMaxConsecutiveErrors(config.GetInt("pow.maxConsecutiveErrors"))
+	SetVerifyResults(config.GetBool("pow.verifyResults"))
+	SetVerifySoftwareResults(config.GetBool("pow.verifySoftwareResults"))
+	SetJobTimeout(time.Duration(config.GetInt("pow.jobTimeoutSeconds"))*time.Second, config.GetFloat64("pow.jobTimeoutMwmMultiplier"))
+
+	// Keep the cached results if the cache settings didn't change
+	cacheSize, cacheTTL := config.GetInt("pow.cacheSize"), time.Duration(config.GetInt("pow.cacheTTLSeconds"))*time.Second
+	if size, ttl := powCacheSettings(); size != cacheSize || ttl != cacheTTL {
+		SetPowCache(cacheSize, cacheTTL)
+	}
+
+	return SetSchedulerPolicy(config.GetString("pow.schedulerPolicy"))
+}
+
+// RestartRequiredSettings returns the settings of the pow section that differ between the running and the reloaded
+// config, but are only used after a restart
+func RestartRequiredSettings(running *viper.Viper, reloaded *viper.Viper) []string {
+	var changed []string
+	for _, key := range restartSettings {
+		if running.GetString(key) != reloaded.GetString(key) {
+			changed = append(changed, key)
+		}
+	}
+	return changed
+}
@@ -0,0 +1,112 @@
+package powsrv
+
+import (
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"github.com/iotaledger/giota"
+	"github.com/spf13/viper"
+)
+
+// defaultPowSettings returns the pow section with the defaults of the package
+func defaultPowSettings() *viper.Viper {
+	config := viper.New()
+	config.Set("pow.maxMinWeightMagnitude", 14)
+	config.Set("pow.maxConsecutiveHighPriority", 4)
+	config.Set("pow.spillThresholdMs", 100)
+	config.Set("pow.schedulerPolicy", SchedulerFirstIdle)
+	return config
+}
+
+func TestApplyPowSettings(t *testing.T) {
+	defer ApplyPowSettings(defaultPowSettings())
+
+	config := defaultPowSettings()
+	config.Set("pow.maxQueueDepth", 5)
+	config.Set("pow.maxPendingPerClient", 3)
+	config.Set("pow.coalesceIdenticalRequests", true)
+	config.Set("pow.cacheSize", 10)
+	config.Set("pow.cacheTTLSeconds", 60)
+	err := ApplyPowSettings(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resultCache.add(giota.Trytes(transaction), 14, fakeNonce)
+
+	client := newPipeClient(config)
+	defer client.Close()
+	limits, err := client.GetLimits()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limits.MaxQueueDepth != 5 || limits.MaxPendingPerClient != 3 {
+		t.Errorf("Expected MaxQueueDepth 5 and MaxPendingPerClient 3, got: %+v", limits)
+	}
+	if atomic.LoadInt32(&coalesceRequests) != 1 {
+		t.Error("Expected coalesced requests")
+	}
+
+	// Reload with a changed queue depth, removed keys get the defaults and the unchanged cache is kept
+	reloaded := defaultPowSettings()
+	reloaded.Set("pow.maxQueueDepth", 7)
+	reloaded.Set("pow.cacheSize", 10)
+	reloaded.Set("pow.cacheTTLSeconds", 60)
+	reloaded.Set("pow.schedulerPolicy", SchedulerRoundRobin)
+	err = ApplyPowSettings(reloaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client = newPipeClient(reloaded)
+	defer client.Close()
+	limits, err = client.GetLimits()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limits.MaxQueueDepth != 7 || limits.MaxPendingPerClient != 0 {
+		t.Errorf("Expected MaxQueueDepth 7 and MaxPendingPerClient 0, got: %+v", limits)
+	}
+	if atomic.LoadInt32(&coalesceRequests) != 0 {
+		t.Error("Expected no coalesced requests after the key was removed")
+	}
+	if atomic.LoadInt32(&schedulerPolicy) != schedulerRoundRobin {
+		t.Error("Expected the reloaded scheduler policy")
+	}
+	if _, hit := resultCache.get(giota.Trytes(transaction), 14); !hit {
+		t.Error("Expected the cached result to be kept")
+	}
+
+	// An invalid scheduler policy keeps the old policy, the other settings are applied
+	reloaded.Set("pow.schedulerPolicy", "random")
+	reloaded.Set("pow.maxQueueDepth", 9)
+	err = ApplyPowSettings(reloaded)
+	if err == nil {
+		t.Error("Expected an error for the invalid scheduler policy")
+	}
+	if atomic.LoadInt32(&schedulerPolicy) != schedulerRoundRobin || atomic.LoadInt32(&maxQueueDepth) != 9 {
+		t.Error("Expected the old scheduler policy and the reloaded queue depth")
+	}
+}
+
+func TestRestartRequiredSettings(t *testing.T) {
+	running := viper.New()
+	running.Set("pow.network", NetworkMainnet)
+	running.Set("pow.maxQueueDepth", 5)
+	ApplyNetworkPreset(running)
+
+	reloaded := viper.New()
+	reloaded.Set("pow.network", NetworkMainnet)
+	reloaded.Set("pow.maxQueueDepth", 7)
+	ApplyNetworkPreset(reloaded)
+	if changed := RestartRequiredSettings(running, reloaded); len(changed) != 0 {
+		t.Errorf("Expected no restart, got: %v", changed)
+	}
+
+	reloaded.Set("pow.maxMinWeightMagnitude", 20)
+	reloaded.Set("pow.maxRequestsPerMinute", 10)
+	expected := []string{"pow.maxMinWeightMagnitude", "pow.maxRequestsPerMinute"}
+	if changed := RestartRequiredSettings(running, reloaded); !reflect.DeepEqual(changed, expected) {
+		t.Errorf("Expected %v, got: %v", expected, changed)
+	}
+}
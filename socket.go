@@ -0,0 +1,79 @@
+package powsrv
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/muxxer/powsrv/ipc"
+	"github.com/muxxer/powsrv/logs"
+)
+
+// socketCheckTimeout is the time a process on an existing socket has to answer the version request
+const socketCheckTimeout = 2 * time.Second
+
+// SocketInUseError is returned by PrepareSocket if another process is listening on the socket
+type SocketInUseError struct {
+	Path          string
+	ServerVersion string // Version of the running powSrv, empty if the process didn't answer like a powSrv
+}
+
+func (e *SocketInUseError) Error() string {
+	if e.ServerVersion == "" {
+		return fmt.Sprintf("another process is already listening at %v", e.Path)
+	}
+	return fmt.Sprintf("another powSrv is already running at %v (version %v)", e.Path, e.ServerVersion)
+}
+
+// PrepareSocket removes a stale Unix socket file before the server binds it
+// The socket is only removed if nobody is listening on it, otherwise a SocketInUseError is returned.
+// With force, the socket is removed without the check and a running powSrv loses its socket.
+func PrepareSocket(path string, force bool) error {
+	if !force {
+		c, err := net.DialTimeout("unix", path, socketCheckTimeout)
+		if err == nil {
+			version, _ := querySocketVersion(c)
+			c.Close()
+			return &SocketInUseError{Path: path, ServerVersion: version}
+		}
+
+		if errors.Is(err, syscall.ENOENT) {
+			return nil
+		}
+		if !errors.Is(err, syscall.ECONNREFUSED) {
+			return fmt.Errorf("Socket %v could not be checked: %w", path, err)
+		}
+		logs.Log.Infof("Removing stale socket %v", path)
+	}
+
+	err := os.Remove(path)
+	if (err != nil) && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// querySocketVersion sends ipc.CmdGetServerVersion on the connection and returns the answer
+func querySocketVersion(c net.Conn) (string, error) {
+	err := c.SetDeadline(time.Now().Add(socketCheckTimeout))
+	if err != nil {
+		return "", err
+	}
+
+	err = ipc.NewFrameWriter(c, ipc.Version1).WriteFrame(1, ipc.CmdGetServerVersion, nil)
+	if err != nil {
+		return "", err
+	}
+
+	frame, err := ipc.NewFrameReader(c).ReadFrame()
+	if err != nil {
+		return "", err
+	}
+	if frame.Command != ipc.CmdResponse {
+		return "", fmt.Errorf("Unexpected answer: command %d", frame.Command)
+	}
+	return string(frame.Data), nil
+}
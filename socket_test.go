@@ -0,0 +1,59 @@
+package powsrv
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/muxxer/powsrv/testsrv"
+)
+
+func TestPrepareStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "powSrv.sock")
+
+	// No socket yet
+	err := PrepareSocket(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The socket file of a crashed server is left behind
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+
+	err = PrepareSocket(path, false)
+	if err != nil {
+		t.Fatalf("Stale socket not removed: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Stale socket still exists: %v", err)
+	}
+}
+
+func TestPrepareSocketInUse(t *testing.T) {
+	server := testsrv.StartMockServer(t, testsrv.Options{ServerVersion: "1.2.3"})
+
+	err := PrepareSocket(server.Address(), false)
+	var inUseErr *SocketInUseError
+	if !errors.As(err, &inUseErr) || (inUseErr.ServerVersion != "1.2.3") {
+		t.Fatalf("Expected SocketInUseError with the version of the running server, got: %v", err)
+	}
+	if _, err := os.Stat(server.Address()); err != nil {
+		t.Errorf("Socket of the running server was removed: %v", err)
+	}
+
+	// The socket is taken over with force
+	err = PrepareSocket(server.Address(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(server.Address()); !os.IsNotExist(err) {
+		t.Errorf("Socket still exists: %v", err)
+	}
+}
@@ -0,0 +1,220 @@
+package powsrv
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+	Statistics of the server
+	========================
+
+	The ipc.CmdGetServerStats command returns the ServerStats as JSON:
+
+	{
+		"uptimeSeconds": 3600,        // Seconds since the server was started
+		"totalRequests": 1234,        // Number of received POW requests
+		"errors": 5,                  // Number of POW requests that were answered with an error
+		"verificationFailures": 1,    // Number of nonces of the devices that didn't reach the MWM ("pow.verifyResults")
+		"queueLength": 2,             // Number of POW requests that wait for an idle device
+		"cacheHits": 3,               // Number of POW requests that were answered from the result cache ("pow.cacheSize")
+		"cacheMisses": 1231,          // Number of POW requests that were not found in the result cache
+		"sinceStart": {               // Counters since the start of the powSrv
+			"requests": 1234,           // Number of received POW requests
+			"errors": 5,                // Number of POW requests that were answered with an error
+			"powSeconds": 148.2,        // Time the devices were doing POW
+			"powTypes": {               // Counters of the devices by their POW type
+				"PiDiver": {"requests": 1000, "errors": 1, "powSeconds": 120.5}
+			}
+		},
+		"lifetime": {...},            // Counters of all runs of the powSrv with the same "server.statsFile", like "sinceStart"
+		"priorities": [
+			{
+				"priority": 1,              // ipc.PriorityNormal or ipc.PriorityHigh
+				"requests": 12,             // Number of started POW requests with this priority
+				"queued": 0,                // Number of POW requests with this priority that wait for an idle device
+				"avgWaitMs": 35.2,          // Average time in the queue of the last 100 started POW requests
+				"medianWaitMs": 20,         // Median time in the queue of the last 100 started POW requests
+				"maxWaitMs": 140            // Maximum time in the queue of the last 100 started POW requests
+			}
+		],
+		"devices": [
+			{
+				"index": 0,                 // Index of the device in the configuration
+				"powType": "PiDiver",       // Name of the used POW implementation
+				"busy": true,               // Device is currently doing POW
+				"disabled": false,          // Device is not used for POW, e.g. because the initialization failed
+				"requests": 1000,           // Number of POW requests done by this device
+				"errors": 1,                // Number of POW requests that failed on this device
+				"verificationFailures": 1,  // Number of nonces of this device that didn't reach the MWM, included in "errors"
+				"avgDurationMs": 120.5,     // Average POW duration of the last 100 POW requests
+				"medianDurationMs": 110,    // Median POW duration of the last 100 POW requests
+				"maxDurationMs": 450,       // Maximum POW duration of the last 100 POW requests
+				"estimatedMs": 115.2        // Moving average of the POW durations that is used by the scheduler
+			}
+		],
+		"clients": [
+			{
+				"address": "192.168.1.20:51234 (tcp)", // Address of the client
+				"connectedSeconds": 600,               // Seconds since the client connected
+				"requests": 100,                       // Number of POW requests of the client
+				"errors": 3,                           // Number of POW requests of the client that were answered with an error
+				"rateLimited": 2,                      // Number of POW requests that exceeded "pow.maxRequestsPerMinute"
+				"pending": 4,                          // Number of POW requests of the client that are queued or in progress
+				"running": 2                           // Number of POW requests of the client that are in progress
+			}
+		]
+	}
+*/
+
+// durationBufferSize is the number of POW durations that are used for the statistics of a device
+const durationBufferSize = 100
+
+var startTime = time.Now()
+var totalRequests uint64
+var totalErrors uint64
+var priorityRequests [2]uint64      // Started POW requests by their priority
+var priorityWaits [2]durationBuffer // Time in the queue of the started POW requests by their priority
+
+// ServerStats contains the statistics of the powSrv
+type ServerStats struct {
+	UptimeSeconds        int64           `json:"uptimeSeconds"`
+	TotalRequests        uint64          `json:"totalRequests"`
+	Errors               uint64          `json:"errors"`
+	VerificationFailures uint64          `json:"verificationFailures"`
+	QueueLength          int             `json:"queueLength"`
+	CacheHits            uint64          `json:"cacheHits"`
+	CacheMisses          uint64          `json:"cacheMisses"`
+	SinceStart           *LifetimeStats  `json:"sinceStart"`
+	Lifetime             *LifetimeStats  `json:"lifetime"`
+	Priorities           []PriorityStats `json:"priorities"`
+	Devices              []DeviceStats   `json:"devices"`
+	Clients              []ClientStats   `json:"clients"`
+}
+
+// DeviceStats contains the statistics of a single POW device
+type DeviceStats struct {
+	Index                int     `json:"index"`
+	PowType              string  `json:"powType"`
+	Busy                 bool    `json:"busy"`
+	Disabled             bool    `json:"disabled"`
+	Requests             uint64  `json:"requests"`
+	Errors               uint64  `json:"errors"`
+	VerificationFailures uint64  `json:"verificationFailures"`
+	AvgDurationMs        float64 `json:"avgDurationMs"`
+	MedianDurationMs     float64 `json:"medianDurationMs"`
+	MaxDurationMs        float64 `json:"maxDurationMs"`
+	EstimatedMs          float64 `json:"estimatedMs"`
+}
+
+// PriorityStats contains the statistics of the POW requests with the same priority
+type PriorityStats struct {
+	Priority     int     `json:"priority"`
+	Requests     uint64  `json:"requests"`
+	Queued       int     `json:"queued"`
+	AvgWaitMs    float64 `json:"avgWaitMs"`
+	MedianWaitMs float64 `json:"medianWaitMs"`
+	MaxWaitMs    float64 `json:"maxWaitMs"`
+}
+
+// ClientStats contains the statistics of a connected client
+type ClientStats struct {
+	Address          string `json:"address"`
+	ConnectedSeconds int64  `json:"connectedSeconds"`
+	Requests         uint64 `json:"requests"`
+	Errors           uint64 `json:"errors"`
+	RateLimited      uint64 `json:"rateLimited"`
+	Pending          int    `json:"pending"`
+	Running          int    `json:"running"`
+}
+
+// durationBuffer is a ring buffer of the last POW durations
+type durationBuffer struct {
+	mutex     sync.Mutex
+	durations [durationBufferSize]time.Duration
+	next      int
+	count     int
+}
+
+// add stores a duration and overwrites the oldest one if the buffer is full
+func (b *durationBuffer) add(duration time.Duration) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.durations[b.next] = duration
+	b.next = (b.next + 1) % durationBufferSize
+	if b.count < durationBufferSize {
+		b.count++
+	}
+}
+
+// stats returns the average, median and maximum of the stored durations
+func (b *durationBuffer) stats() (avg time.Duration, median time.Duration, max time.Duration) {
+	b.mutex.Lock()
+	durations := make([]time.Duration, b.count)
+	copy(durations, b.durations[:b.count])
+	b.mutex.Unlock()
+
+	if len(durations) == 0 {
+		return 0, 0, 0
+	}
+
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+
+	var sum time.Duration
+	for _, duration := range durations {
+		sum += duration
+	}
+
+	middle := len(durations) / 2
+	median = durations[middle]
+	if len(durations)%2 == 0 {
+		median = (durations[middle-1] + durations[middle]) / 2
+	}
+
+	return sum / time.Duration(len(durations)), median, durations[len(durations)-1]
+}
+
+// durationToMs converts a duration to milliseconds
+func durationToMs(duration time.Duration) float64 {
+	return float64(duration) / float64(time.Millisecond)
+}
+
+// countRequest counts a received POW request
+func countRequest() {
+	atomic.AddUint64(&totalRequests, 1)
+}
+
+// countError counts a POW request that was answered with an error
+func countError() {
+	atomic.AddUint64(&totalErrors, 1)
+}
+
+// countPriorityStart counts a started POW request and the time it waited in the queue
+func countPriorityStart(priority byte, wait time.Duration) {
+	atomic.AddUint64(&priorityRequests[priority], 1)
+	priorityWaits[priority].add(wait)
+}
+
+// GetServerStats collects the current statistics of the powSrv
+func GetServerStats() *ServerStats {
+	d := getDispatcher()
+
+	cacheHits, cacheMisses := resultCache.counters()
+	return &ServerStats{
+		UptimeSeconds:        int64(time.Since(startTime) / time.Second),
+		TotalRequests:        atomic.LoadUint64(&totalRequests),
+		Errors:               atomic.LoadUint64(&totalErrors),
+		VerificationFailures: atomic.LoadUint64(&totalVerificationFailures),
+		QueueLength:          d.queueLength(),
+		CacheHits:            cacheHits,
+		CacheMisses:          cacheMisses,
+		SinceStart:           sinceStartStats(),
+		Lifetime:             lifetimeStats(),
+		Priorities:           d.priorityStats(),
+		Devices:              d.deviceStats(),
+		Clients:              clientStats(),
+	}
+}
@@ -0,0 +1,34 @@
+package powsrv
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDurationBufferStats(t *testing.T) {
+	var buffer durationBuffer
+
+	avg, median, max := buffer.stats()
+	if (avg != 0) || (median != 0) || (max != 0) {
+		t.Errorf("Empty buffer: avg %v, median %v, max %v", avg, median, max)
+	}
+
+	// The first 10 durations are overwritten by the following ones
+	for i := 0; i < 10; i++ {
+		buffer.add(time.Hour)
+	}
+	for i := 1; i <= durationBufferSize; i++ {
+		buffer.add(time.Duration(i) * time.Millisecond)
+	}
+
+	avg, median, max = buffer.stats()
+	if avg != 50500*time.Microsecond {
+		t.Errorf("Wrong avg: %v", avg)
+	}
+	if median != 50500*time.Microsecond {
+		t.Errorf("Wrong median: %v", median)
+	}
+	if max != durationBufferSize*time.Millisecond {
+		t.Errorf("Wrong max: %v", max)
+	}
+}
@@ -0,0 +1,309 @@
+/*
+Package testsrv implements an in-process mock powSrv for the tests of PowClient integrations.
+
+The mock server speaks the IPC protocol of the ipc package on a temporary Unix socket or TCP port,
+so the tests don't need a powSrv with POW hardware:
+
+	server := testsrv.StartMockServer(t, testsrv.Options{})
+	powClient := &powsrv.PowClient{Network: server.Network(), Address: server.Address(), ReadTimeOutMs: 5000}
+
+By default the POW requests are answered with a canned nonce. Set Options.PowFunc to giota.PowGo for real POW.
+Faults can be injected with the Delay, CorruptCRCEvery, DropAfter and PowError options.
+*/
+package testsrv
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/iotaledger/giota"
+
+	"github.com/muxxer/powsrv/ipc"
+)
+
+// DefaultNonce is the nonce of the POW requests if no PowFunc is set
+const DefaultNonce = "MOCKNONCE999999999999999999"
+
+// Options configures the mock server
+type Options struct {
+	Network string // Network of the listener: 'unix' or 'tcp' (default: 'unix')
+
+	ServerVersion string        // Answer of CmdGetServerVersion (default: "mock")
+	PowType       string        // Answer of CmdGetPowType (default: "mock")
+	PowVersion    string        // Answer of CmdGetPowVersion (default: "1.0")
+	PowFunc       giota.PowFunc // Does the POW, e.g. giota.PowGo (default: DefaultNonce is returned)
+
+	Delay           time.Duration // Delay of every response
+	CorruptCRCEvery int           // The CRC of every n-th response is corrupted (0 = never)
+	DropAfter       int           // The connection is closed as soon as it received n requests (0 = never)
+	PowError        string        // If set, every POW request is answered with an error frame with this payload, e.g. ipc.ErrorQueueFull
+}
+
+// Server is a running mock powSrv
+type Server struct {
+	opts    Options
+	ln      net.Listener
+	dir     string
+	wg      sync.WaitGroup
+	mutex   sync.Mutex
+	conns   map[net.Conn]struct{}
+	closed  bool
+	frames  uint64 // Number of the received frames of all connections
+	written uint64 // Number of the written responses of all connections
+}
+
+// StartMockServer starts a mock powSrv that is closed at the end of the test
+func StartMockServer(t testing.TB, opts Options) *Server {
+	s, err := Start(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Start starts a mock powSrv, it has to be closed with Close
+func Start(opts Options) (*Server, error) {
+	if opts.Network == "" {
+		opts.Network = "unix"
+	}
+	if opts.ServerVersion == "" {
+		opts.ServerVersion = "mock"
+	}
+	if opts.PowType == "" {
+		opts.PowType = "mock"
+	}
+	if opts.PowVersion == "" {
+		opts.PowVersion = "1.0"
+	}
+
+	s := &Server{opts: opts, conns: make(map[net.Conn]struct{})}
+
+	address := "127.0.0.1:0"
+	if opts.Network == "unix" {
+		dir, err := ioutil.TempDir("", "powsrv-mock")
+		if err != nil {
+			return nil, err
+		}
+		s.dir = dir
+		address = filepath.Join(dir, "powSrv.sock")
+	}
+
+	ln, err := net.Listen(opts.Network, address)
+	if err != nil {
+		if s.dir != "" {
+			os.RemoveAll(s.dir)
+		}
+		return nil, err
+	}
+	s.ln = ln
+
+	s.wg.Add(1)
+	go s.accept()
+	return s, nil
+}
+
+// Network returns the network of the listener
+func (s *Server) Network() string {
+	return s.opts.Network
+}
+
+// Address returns the address of the listener
+func (s *Server) Address() string {
+	return s.ln.Addr().String()
+}
+
+// Frames returns the number of frames the server received
+func (s *Server) Frames() uint64 {
+	return atomic.LoadUint64(&s.frames)
+}
+
+// DropConnections closes all client connections, new connections are still accepted
+func (s *Server) DropConnections() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for c := range s.conns {
+		c.Close()
+	}
+}
+
+// Close stops the server and closes all client connections
+func (s *Server) Close() {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return
+	}
+	s.closed = true
+	s.mutex.Unlock()
+
+	s.ln.Close()
+	s.DropConnections()
+	s.wg.Wait()
+
+	if s.dir != "" {
+		os.RemoveAll(s.dir)
+	}
+}
+
+// accept handles the connections until the listener is closed
+func (s *Server) accept() {
+	defer s.wg.Done()
+
+	for {
+		c, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mutex.Lock()
+		if s.closed {
+			s.mutex.Unlock()
+			c.Close()
+			return
+		}
+		s.conns[c] = struct{}{}
+		s.wg.Add(1)
+		s.mutex.Unlock()
+
+		go s.handle(c)
+	}
+}
+
+// mockConnection is a client connection of the mock server
+type mockConnection struct {
+	net.Conn
+	server *Server
+	mutex  sync.Mutex // Frames of concurrent responses must not interleave
+}
+
+// handle answers the requests of a connection until it is closed
+func (s *Server) handle(c net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.conns, c)
+		s.mutex.Unlock()
+		c.Close()
+	}()
+
+	conn := &mockConnection{Conn: c, server: s}
+	reader := ipc.NewFrameReader(c)
+
+	var received int
+	var responses sync.WaitGroup
+	defer responses.Wait()
+
+	for {
+		frame, err := reader.ReadFrame()
+		if frameErr, ok := err.(*ipc.FrameError); ok {
+			if frameErr.Frame != nil {
+				conn.send(frameErr.Frame.Version, frameErr.Frame.ReqID, ipc.CmdError, []byte(ipc.ErrorInvalidFrame))
+			}
+			continue
+		}
+		if err != nil {
+			return
+		}
+
+		atomic.AddUint64(&s.frames, 1)
+		received++
+		if (s.opts.DropAfter > 0) && (received >= s.opts.DropAfter) {
+			return
+		}
+
+		// The requests are answered concurrently, so delayed responses can overtake each other
+		responses.Add(1)
+		go func(frame *ipc.Frame) {
+			defer responses.Done()
+			conn.answer(frame)
+		}(frame)
+	}
+}
+
+// answer handles a single request
+func (c *mockConnection) answer(frame *ipc.Frame) {
+	opts := c.server.opts
+
+	if frame.Command == ipc.CmdCancel {
+		// The responses are not cancelled, the client drops them
+		return
+	}
+
+	if opts.Delay > 0 {
+		time.Sleep(opts.Delay)
+	}
+
+	switch frame.Command {
+	case ipc.CmdGetServerVersion:
+		c.send(frame.Version, frame.ReqID, ipc.CmdResponse, []byte(opts.ServerVersion))
+
+	case ipc.CmdGetPowType:
+		c.send(frame.Version, frame.ReqID, ipc.CmdResponse, []byte(opts.PowType))
+
+	case ipc.CmdGetPowVersion:
+		c.send(frame.Version, frame.ReqID, ipc.CmdResponse, []byte(opts.PowVersion))
+
+	case ipc.CmdGetFrameVersions:
+		c.send(frame.Version, frame.ReqID, ipc.CmdResponse, []byte{ipc.Version1, ipc.Version2})
+
+	case ipc.CmdPing:
+		c.send(frame.Version, frame.ReqID, ipc.CmdResponse, nil)
+
+	case ipc.CmdPowFunc:
+		if opts.PowError != "" {
+			c.send(frame.Version, frame.ReqID, ipc.CmdError, []byte(opts.PowError))
+			return
+		}
+		if len(frame.Data) == 0 {
+			c.send(frame.Version, frame.ReqID, ipc.CmdError, []byte(ipc.ErrorInvalidRequest))
+			return
+		}
+
+		nonce, err := c.pow(giota.Trytes(frame.Data[1:]), int(frame.Data[0]))
+		if err != nil {
+			c.send(frame.Version, frame.ReqID, ipc.CmdError, []byte(ipc.ErrorPowFailed+" "+err.Error()))
+			return
+		}
+		c.send(frame.Version, frame.ReqID, ipc.CmdResponse, []byte(nonce))
+
+	default:
+		c.send(frame.Version, frame.ReqID, ipc.CmdError, []byte(ipc.ErrorUnknownCommand))
+	}
+}
+
+// pow returns the nonce of the PowFunc, or DefaultNonce
+func (c *mockConnection) pow(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+	if c.server.opts.PowFunc == nil {
+		return DefaultNonce, nil
+	}
+	return c.server.opts.PowFunc(trytes, mwm)
+}
+
+// send writes a frame, the CRC is corrupted if requested by CorruptCRCEvery
+func (c *mockConnection) send(version byte, reqID byte, command byte, data []byte) {
+	msg, err := ipc.NewMessage(version, reqID, command, data)
+	if err != nil {
+		return
+	}
+	encoded, err := msg.ToBytes()
+	if err != nil {
+		return
+	}
+
+	written := atomic.AddUint64(&c.server.written, 1)
+	if every := c.server.opts.CorruptCRCEvery; (every > 0) && (written%uint64(every) == 0) {
+		encoded[len(encoded)-1] ^= 0xFF
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.Write(encoded)
+}
@@ -0,0 +1,81 @@
+package powsrv
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/iotaledger/giota"
+
+	"github.com/muxxer/powsrv/ipc"
+	"github.com/muxxer/powsrv/logs"
+)
+
+// jobTimeoutReferenceMWM is the MWM of the unscaled job timeout, the MWM of the IOTA mainnet
+const jobTimeoutReferenceMWM = 14
+
+var jobTimeout int64               // Deadline of a single POW in ns (0 = no deadline)
+var jobTimeoutMwmMultiplier uint64 // Bits of the float64 factor of the deadline per MWM above jobTimeoutReferenceMWM (0 = not scaled)
+
+// AbortableDriver is implemented by drivers that can stop a running POW, e.g. after the job timeout
+type AbortableDriver interface {
+	Abort() error // Stops the running POW, its Pow call returns as soon as possible
+}
+
+// SetJobTimeout sets the deadline of a single POW on a device (0 = no deadline)
+// With an mwmMultiplier > 0, the deadline is multiplied by it for every MWM above 14 (and divided below),
+// e.g. 3 scales it like the expected work of the POW.
+func SetJobTimeout(timeout time.Duration, mwmMultiplier float64) {
+	atomic.StoreInt64(&jobTimeout, int64(timeout))
+	atomic.StoreUint64(&jobTimeoutMwmMultiplier, math.Float64bits(mwmMultiplier))
+}
+
+// jobDeadline returns the deadline of a POW with the MWM, 0 if there is none
+func jobDeadline(mwm int) time.Duration {
+	timeout := time.Duration(atomic.LoadInt64(&jobTimeout))
+	if timeout <= 0 {
+		return 0
+	}
+
+	if multiplier := math.Float64frombits(atomic.LoadUint64(&jobTimeoutMwmMultiplier)); multiplier > 0 {
+		timeout = time.Duration(float64(timeout) * math.Pow(multiplier, float64(mwm-jobTimeoutReferenceMWM)))
+	}
+	return timeout
+}
+
+// runPow does the POW of the job on the device within the deadline of the job
+// If the deadline expires, the device is aborted if it supports it and an ipc.ErrorPowTimeout error is returned.
+// The POW keeps running in its goroutine until the PowFunc returns, the returned channel is closed at that time.
+// The device must not start another POW before, so it is nil if the POW is finished.
+func runPow(device *PowDevice, job *powJob, log *logs.Entry) (giota.Trytes, <-chan struct{}, error) {
+	timeout := jobDeadline(job.mwm)
+	if timeout == 0 {
+		result, err := device.PowFunc(job.trytes, job.mwm)
+		return result, nil, err
+	}
+
+	var result giota.Trytes
+	var err error
+	finished := make(chan struct{})
+	go func() {
+		result, err = device.PowFunc(job.trytes, job.mwm)
+		close(finished)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-finished:
+		return result, nil, err
+	case <-timer.C:
+	}
+
+	log.Warningf("PoW on device %v not finished within %d ms, the result is abandoned", device, int64(timeout/time.Millisecond))
+	if device.AbortFunc != nil {
+		if abortErr := device.AbortFunc(); abortErr != nil {
+			log.Warningf("PoW on device %v could not be aborted: %v", device, abortErr)
+		}
+	}
+	return "", finished, newServerError(ipc.ErrorPowTimeout, "%d ms", int64(timeout/time.Millisecond))
+}
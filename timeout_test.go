@@ -0,0 +1,75 @@
+package powsrv
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/iotaledger/giota"
+)
+
+func TestJobDeadline(t *testing.T) {
+	defer SetJobTimeout(0, 0)
+
+	for _, test := range []struct {
+		timeout    time.Duration
+		multiplier float64
+		mwm        int
+		expected   time.Duration
+	}{
+		{0, 3, 14, 0},
+		{10 * time.Second, 0, 20, 10 * time.Second},
+		{10 * time.Second, 3, 14, 10 * time.Second},
+		{10 * time.Second, 3, 16, 90 * time.Second},
+		{9 * time.Second, 3, 12, time.Second},
+	} {
+		SetJobTimeout(test.timeout, test.multiplier)
+		deadline := jobDeadline(test.mwm)
+		if (deadline < test.expected-time.Millisecond) || (deadline > test.expected+time.Millisecond) {
+			t.Errorf("Timeout %v, multiplier %v, MWM %d: expected %v, got %v", test.timeout, test.multiplier, test.mwm, test.expected, deadline)
+		}
+	}
+}
+
+func TestJobTimeout(t *testing.T) {
+	SetJobTimeout(100*time.Millisecond, 0)
+	defer SetJobTimeout(0, 0)
+
+	// The first POW hangs until it is aborted
+	abort := make(chan struct{})
+	var calls, aborts int32
+	device := &PowDevice{PowType: "test", PowFunc: func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			<-abort
+			return "", errors.New("aborted")
+		}
+		return fakeNonce, nil
+	}, AbortFunc: func() error {
+		atomic.AddInt32(&aborts, 1)
+		close(abort)
+		return nil
+	}}
+	SetPowDevices([]*PowDevice{device})
+
+	ts := time.Now()
+	_, err := PowFunc(giota.Trytes(transaction), MWM)
+	if !errors.Is(err, ErrPowTimeout) {
+		t.Fatalf("Expected ErrPowTimeout, got: %v", err)
+	}
+	if time.Since(ts) > 2*time.Second {
+		t.Errorf("Timeout took %v", time.Since(ts))
+	}
+	if atomic.LoadInt32(&aborts) != 1 {
+		t.Error("Device was not aborted")
+	}
+
+	// The device is still usable and not counted as failing
+	result, err := PowFunc(giota.Trytes(transaction), MWM)
+	if (err != nil) || (result != fakeNonce) {
+		t.Errorf("POW after the timeout failed: %v, %v", result, err)
+	}
+	if atomic.LoadInt32(&device.consecutiveErrors) != 0 {
+		t.Errorf("Timeout counted as consecutive error")
+	}
+}
@@ -0,0 +1,92 @@
+package powsrv
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"time"
+
+	"github.com/muxxer/powsrv/logs"
+)
+
+const tlsHandshakeTimeout = 10 * time.Second
+
+// NewServerTLSConfig loads the certificate of the server for TCP listeners
+// If clientCAsFile is set, clients need a certificate that is signed by one of the CAs in the file.
+func NewServerTLSConfig(certFile string, keyFile string, clientCAsFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+
+	if clientCAsFile != "" {
+		clientCAs, err := loadCertPool(clientCAsFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = clientCAs
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
+// NewClientTLSConfig creates the TLS config of a PowClient
+// caFile is needed for self-signed server certificates, certFile and keyFile for servers that require client certificates.
+func NewClientTLSConfig(caFile string, certFile string, keyFile string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caFile != "" {
+		rootCAs, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// loadCertPool loads the PEM encoded certificates of a file
+func loadCertPool(file string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("No certificates found in \"%v\"", file)
+	}
+	return pool, nil
+}
+
+// tlsHandshake finishes the TLS handshake of a new connection before any frame is read
+// A failed handshake is logged with the address of the client instead of showing up as a broken frame.
+func tlsHandshake(c net.Conn) bool {
+	tlsConn, ok := c.(*tls.Conn)
+	if !ok {
+		return true
+	}
+
+	tlsConn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	err := tlsConn.Handshake()
+	tlsConn.SetDeadline(time.Time{})
+	if err != nil {
+		logs.Log.Warningf("TLS handshake with %v failed: %v", c.RemoteAddr(), err)
+		return false
+	}
+	return true
+}
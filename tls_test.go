@@ -0,0 +1,187 @@
+package powsrv
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/iotaledger/giota"
+	"github.com/spf13/viper"
+)
+
+// certDir contains the self-signed certificates of the TLS tests
+var certDir string
+
+func TestMain(m *testing.M) {
+	var err error
+	certDir, err = ioutil.TempDir("", "powsrv-certs")
+	if err != nil {
+		panic(err)
+	}
+
+	err = generateCerts(certDir)
+	if err != nil {
+		os.RemoveAll(certDir)
+		panic(err)
+	}
+
+	code := m.Run()
+	os.RemoveAll(certDir)
+	os.Exit(code)
+}
+
+// generateCerts creates a CA, a server certificate for 127.0.0.1 and a client certificate in dir
+func generateCerts(dir string) error {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "powSrv test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return err
+	}
+	err = writePEM(filepath.Join(dir, "ca.pem"), "CERTIFICATE", caDER)
+	if err != nil {
+		return err
+	}
+
+	certs := []struct {
+		name  string
+		usage x509.ExtKeyUsage
+	}{
+		{"server", x509.ExtKeyUsageServerAuth},
+		{"client", x509.ExtKeyUsageClientAuth},
+	}
+
+	for i, cert := range certs {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return err
+		}
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(int64(i + 2)),
+			Subject:      pkix.Name{CommonName: "powSrv test " + cert.name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{cert.usage},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, caTemplate, &key.PublicKey, caKey)
+		if err != nil {
+			return err
+		}
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return err
+		}
+
+		err = writePEM(filepath.Join(dir, cert.name+".pem"), "CERTIFICATE", der)
+		if err != nil {
+			return err
+		}
+		err = writePEM(filepath.Join(dir, cert.name+".key"), "EC PRIVATE KEY", keyDER)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writePEM(file string, blockType string, der []byte) error {
+	return ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600)
+}
+
+func TestTLS(t *testing.T) {
+	serverTLSConfig, err := NewServerTLSConfig(filepath.Join(certDir, "server.pem"), filepath.Join(certDir, "server.key"), filepath.Join(certDir, "ca.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln = tls.NewListener(ln, serverTLSConfig)
+	defer ln.Close()
+
+	config := viper.New()
+	config.Set("pow.maxMinWeightMagnitude", 243)
+	SetPowDevices([]*PowDevice{{PowType: "test", PowFunc: func(trytes giota.Trytes, mwm int) (giota.Trytes, error) {
+		return trytes, nil
+	}}})
+
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go HandleClientConnection(c, config)
+		}
+	}()
+
+	data, err := giota.ToTrytes(transaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withoutClientCert, err := NewClientTLSConfig(filepath.Join(certDir, "ca.pem"), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	withClientCert, err := NewClientTLSConfig(filepath.Join(certDir, "ca.pem"), filepath.Join(certDir, "client.pem"), filepath.Join(certDir, "client.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		tlsConfig *tls.Config
+		success   bool
+	}{
+		{"No TLS", nil, false},
+		{"Unknown server CA", &tls.Config{}, false},
+		{"No client certificate", withoutClientCert, false},
+		// The failed handshakes before must not stop the listener
+		{"Client certificate", withClientCert, true},
+	}
+
+	for _, test := range tests {
+		powClient := &PowClient{Network: "tcp", Address: ln.Addr().String(), TLSConfig: test.tlsConfig, WriteTimeOutMs: 500, ReadTimeOutMs: 1000}
+		err := powClient.Init()
+		if err == nil {
+			var response giota.Trytes
+			response, err = powClient.PowFunc(data, MWM)
+			if (err == nil) && (response != data) {
+				t.Errorf("%s: wrong response", test.name)
+			}
+			powClient.Close()
+		}
+
+		if (err == nil) != test.success {
+			t.Errorf("%s: unexpected result: %v", test.name, err)
+		}
+	}
+}
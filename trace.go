@@ -0,0 +1,69 @@
+package powsrv
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+
+	"github.com/muxxer/powsrv/ipc"
+	"github.com/muxxer/powsrv/logs"
+)
+
+// Directions of the frame dumps
+const (
+	frameSent     = "send"
+	frameReceived = "recv"
+)
+
+// frameDumpBytes is the number of bytes at the start and the end of the data that are dumped with every frame
+const frameDumpBytes = 16
+
+// traceFrame logs a sent or received frame with logs.LevelTrace
+// crc is "ok" or "failed" for received frames and empty for sent frames. The fields are only formatted if TRACE is
+// enabled, so the frames don't cost any allocation during normal operation.
+func traceFrame(direction string, remoteAddr string, reqID byte, command byte, data []byte, crc string) {
+	if !logs.TraceEnabled() {
+		return
+	}
+
+	dumpSize := frameDumpBytes
+	if len(data) < dumpSize {
+		dumpSize = len(data)
+	}
+	fields := logs.Fields{
+		"direction":  direction,
+		"remoteAddr": remoteAddr,
+		"reqID":      reqID,
+		"cmd":        ipc.CommandName(command),
+		"length":     len(data),
+		"head":       hex.EncodeToString(data[:dumpSize]),
+		"tail":       hex.EncodeToString(data[len(data)-dumpSize:]),
+	}
+	if crc != "" {
+		fields["crc"] = crc
+	}
+	logs.WithFields(fields).Tracef("Frame %s %s", direction, ipc.CommandName(command))
+}
+
+// traceReceivedFrame logs the result of ipc.FrameReader.ReadFrame with logs.LevelTrace
+// The complete bytes of a frame with a wrong checksum are dumped with DEBUG.
+func traceReceivedFrame(remoteAddr string, frame *ipc.Frame, err error) {
+	if frameErr, ok := err.(*ipc.FrameError); ok && (frameErr.Raw != nil) {
+		frame = frameErr.Frame
+		logs.WithFields(logs.Fields{"remoteAddr": remoteAddr, "reqID": frame.ReqID}).Debugf("Frame with a wrong checksum: %x", frameErr.Raw)
+		traceFrame(frameReceived, remoteAddr, frame.ReqID, frame.Command, frame.Data, "failed")
+		return
+	}
+	if err == nil {
+		traceFrame(frameReceived, remoteAddr, frame.ReqID, frame.Command, frame.Data, "ok")
+	}
+}
+
+// remoteAddress returns the remote address of the connection for the logs, e.g. "192.168.1.10:5000 (tcp)"
+func remoteAddress(c net.Conn) string {
+	addr := c.RemoteAddr()
+	if addr == nil {
+		return ""
+	}
+	return fmt.Sprintf("%v (%v)", addr, addr.Network())
+}
@@ -0,0 +1,41 @@
+package powsrv
+
+import (
+	"testing"
+
+	"github.com/iotaledger/giota"
+	"github.com/spf13/viper"
+
+	"github.com/muxxer/powsrv/ipc"
+	"github.com/muxxer/powsrv/logs"
+)
+
+func TestTraceFrames(t *testing.T) {
+	// Without TRACE, the frames are not formatted
+	data := []byte(transaction)
+	allocs := testing.AllocsPerRun(100, func() {
+		traceFrame(frameSent, "127.0.0.1:5000 (tcp)", 1, ipc.CmdPowFunc, data, "")
+		traceReceivedFrame("127.0.0.1:5000 (tcp)", &ipc.Frame{ReqID: 1, Command: ipc.CmdResponse, Data: data}, nil)
+	})
+	if allocs != 0 {
+		t.Errorf("Frame dumps without TRACE allocated %v times", allocs)
+	}
+
+	// Both sides dump their frames, including empty ones
+	logs.SetLogLevel(logs.LevelTrace)
+	defer logs.SetLogLevel("DEBUG")
+
+	config := viper.New()
+	config.Set("pow.maxMinWeightMagnitude", 14)
+	powClient := newPipeClient(config)
+	defer powClient.Close()
+
+	_, err := powClient.PowFunc(giota.Trytes(transaction), MWM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = powClient.Ping()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,101 @@
+package powsrv
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/iotaledger/giota"
+)
+
+var verifyResults int32         // Nonces of the devices are checked before they are returned (1) or not (0)
+var verifySoftwareResults int32 // Nonces of DeviceClassCPU devices are checked too (1) or not (0)
+var totalVerificationFailures uint64
+
+// SetVerifyResults enables or disables the check of the nonces returned by the devices
+// A nonce whose transaction hash doesn't reach the MWM is treated like an error of the device.
+func SetVerifyResults(enabled bool) {
+	var value int32
+	if enabled {
+		value = 1
+	}
+	atomic.StoreInt32(&verifyResults, value)
+}
+
+// SetVerifySoftwareResults enables or disables the check of the nonces of the software (DeviceClassCPU) devices
+// It has no effect if SetVerifyResults is disabled.
+func SetVerifySoftwareResults(enabled bool) {
+	var value int32
+	if enabled {
+		value = 1
+	}
+	atomic.StoreInt32(&verifySoftwareResults, value)
+}
+
+// verifiesResults returns true if the nonces of the device are checked
+func (d *PowDevice) verifiesResults() bool {
+	if atomic.LoadInt32(&verifyResults) == 0 {
+		return false
+	}
+	return (d.PowClass != DeviceClassCPU) || (atomic.LoadInt32(&verifySoftwareResults) == 1)
+}
+
+// VerifyPow returns true if the hash of the transaction reaches the MinWeightMagnitude
+// The trytes must contain the nonce, e.g. a result of PowBatch or the transaction with the nonce of PowFunc.
+func VerifyPow(trytes giota.Trytes, mwm int) (bool, error) {
+	weight, err := transactionWeight(trytes)
+	if err != nil {
+		return false, err
+	}
+	return weight >= mwm, nil
+}
+
+// transactionWeight returns the number of trailing zero trits of the transaction hash
+func transactionWeight(trytes giota.Trytes) (int, error) {
+	if len(trytes) != transactionTrytesSize {
+		return 0, fmt.Errorf("%w: length is not %d trytes: %d", ErrInvalidTrytes, transactionTrytesSize, len(trytes))
+	}
+	if _, err := giota.ToTrytes(string(trytes)); err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidTrytes, err)
+	}
+
+	tx, err := giota.NewTransaction(trytes)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidTrytes, err)
+	}
+
+	return hashWeight(tx.Hash()), nil
+}
+
+// hashWeight returns the number of trailing zero trits of the hash
+func hashWeight(hash giota.Trytes) int {
+	trits := hash.Trits()
+	weight := 0
+	for i := len(trits) - 1; (i >= 0) && (trits[i] == 0); i-- {
+		weight++
+	}
+	return weight
+}
+
+// verifyNonce checks that the transaction with the nonce reaches the MWM
+// It returns an *InvalidNonceError with the achieved weight if it doesn't.
+func verifyNonce(trytes giota.Trytes, nonce giota.Trytes, mwm int) error {
+	if (len(trytes) != transactionTrytesSize) || (len(nonce) != nonceTrytesSize) {
+		return fmt.Errorf("%w: size is not %d trytes: %d", ErrInvalidNonce, nonceTrytesSize, len(nonce))
+	}
+
+	// The trytes are hashed without parsing the transaction, the raw POW (ipc.AlgorithmCurlRaw) has no transaction fields
+	trytes = trytes[:transactionTrytesSize-nonceTrytesSize] + nonce
+	if _, err := giota.ToTrytes(string(trytes)); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidNonce, err)
+	}
+	if weight := hashWeight(trytes.Hash()); weight < mwm {
+		return &InvalidNonceError{Weight: weight, MWM: mwm}
+	}
+	return nil
+}
+
+// countVerificationFailure counts a nonce of the device that didn't reach the MWM
+func countVerificationFailure(device *PowDevice) {
+	atomic.AddUint64(&device.verificationFailures, 1)
+	atomic.AddUint64(&totalVerificationFailures, 1)
+}
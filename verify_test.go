@@ -0,0 +1,160 @@
+package powsrv
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/iotaledger/giota"
+)
+
+// verifyMWM is low enough to find a valid nonce by trying
+const verifyMWM = 3
+
+// findNonce returns a nonce for the transaction (or the trytes of a raw POW) whose hash reaches the MWM
+func findNonce(t *testing.T, trytes giota.Trytes, mwm int) giota.Trytes {
+	for i := 0; i < 10000; i++ {
+		nonce := giota.Trytes(string([]byte{giota.TryteAlphabet[i%27], giota.TryteAlphabet[(i/27)%27], giota.TryteAlphabet[i/729]}) + fakeNonce[3:])
+		if verifyNonce(trytes, nonce, mwm) == nil {
+			return nonce
+		}
+	}
+	t.Fatal("No valid nonce found")
+	return ""
+}
+
+// findInvalidNonce returns a nonce for the transaction whose hash doesn't reach the MWM
+func findInvalidNonce(t *testing.T, trytes giota.Trytes, mwm int) giota.Trytes {
+	for i := 0; i < 27; i++ {
+		nonce := giota.Trytes(string(giota.TryteAlphabet[i]) + fakeNonce[1:])
+		if verifyNonce(trytes, nonce, mwm) != nil {
+			return nonce
+		}
+	}
+	t.Fatal("No invalid nonce found")
+	return ""
+}
+
+func TestVerifyResults(t *testing.T) {
+	SetVerifyResults(true)
+	SetVerifySoftwareResults(true)
+	defer SetVerifyResults(false)
+	defer SetVerifySoftwareResults(false)
+
+	trytes := giota.Trytes(transaction)
+	valid := findNonce(t, trytes, verifyMWM)
+	invalid := findInvalidNonce(t, trytes, verifyMWM)
+
+	device := classDevice(0, DeviceClassFPGA, valid)
+	SetPowDevices([]*PowDevice{device})
+	result, err := PowFunc(trytes, verifyMWM)
+	if (err != nil) || (result != valid) {
+		t.Fatalf("Valid nonce was rejected: %v, %v", result, err)
+	}
+
+	failures := atomic.LoadUint64(&totalVerificationFailures)
+	device = classDevice(0, DeviceClassFPGA, invalid)
+	SetPowDevices([]*PowDevice{device})
+	_, err = PowFunc(trytes, verifyMWM)
+	if err == nil {
+		t.Fatal("Invalid nonce was returned")
+	}
+	if (atomic.LoadUint64(&device.verificationFailures) != 1) || (atomic.LoadUint64(&device.errors) != 1) {
+		t.Errorf("Expected a verification failure and an error of the device, got %d and %d", device.verificationFailures, device.errors)
+	}
+	if atomic.LoadUint64(&totalVerificationFailures) != failures+1 {
+		t.Error("Verification failure was not counted in the server stats")
+	}
+
+	// A wrong nonce is retried on the other devices
+	SetPowFailover(true)
+	defer SetPowFailover(false)
+	SetPowDevices([]*PowDevice{classDevice(0, DeviceClassFPGA, invalid), classDevice(1, DeviceClassCPU, valid)})
+	result, err = PowFunc(trytes, verifyMWM)
+	if (err != nil) || (result != valid) {
+		t.Errorf("Request was not retried on the valid device: %v, %v", result, err)
+	}
+
+	// The software devices may be skipped
+	SetVerifySoftwareResults(false)
+	SetPowDevices([]*PowDevice{classDevice(0, DeviceClassCPU, invalid)})
+	result, err = PowFunc(trytes, verifyMWM)
+	if (err != nil) || (result != invalid) {
+		t.Errorf("Nonce of the CPU device was verified: %v, %v", result, err)
+	}
+}
+
+func TestVerifyPow(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		nonce  string
+		mwm    int
+		valid  bool
+		weight int // Trailing zero trits of the hash
+	}{
+		{"weight 8", "KGL99ONCE999999999999999999", 8, true, 8},
+		{"weight 8, lower MWM", "KGL99ONCE999999999999999999", 5, true, 8},
+		{"weight 8, off by one trit", "KGL99ONCE999999999999999999", 9, false, 8},
+		{"weight 5", "N9999ONCE999999999999999999", 5, true, 5},
+		{"weight 5, MWM 14", "N9999ONCE999999999999999999", 14, false, 5},
+		{"corrupted first tryte", "LGL99ONCE999999999999999999", 8, false, -1},
+		{"corrupted last tryte", "KGL99ONCE99999999999999999A", 8, false, -1},
+	} {
+		trytes := giota.Trytes(transaction[:transactionTrytesSize-nonceTrytesSize] + test.nonce)
+		valid, err := VerifyPow(trytes, test.mwm)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if valid != test.valid {
+			t.Errorf("%s: expected %v, got %v", test.name, test.valid, valid)
+		}
+
+		err = verifyNonce(giota.Trytes(transaction), giota.Trytes(test.nonce), test.mwm)
+		var nonceErr *InvalidNonceError
+		switch {
+		case test.valid && (err != nil):
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		case !test.valid && !errors.As(err, &nonceErr):
+			t.Errorf("%s: expected InvalidNonceError, got %v", test.name, err)
+		case !test.valid && (test.weight != -1) && (nonceErr.Weight != test.weight):
+			t.Errorf("%s: expected weight %d, got %d", test.name, test.weight, nonceErr.Weight)
+		}
+	}
+
+	for _, trytes := range []string{transaction[1:], transaction[1:] + "a"} {
+		_, err := VerifyPow(giota.Trytes(trytes), MWM)
+		if !errors.Is(err, ErrInvalidTrytes) {
+			t.Errorf("Expected ErrInvalidTrytes, got %v", err)
+		}
+	}
+}
+
+func TestClientVerifyResults(t *testing.T) {
+	path, cleanup := startTestServer(t, false)
+	defer cleanup()
+
+	const nonce = "KGL99ONCE999999999999999999"
+	SetPowDevices([]*PowDevice{classDevice(0, DeviceClassFPGA, nonce)})
+
+	powClient := &PowClient{PowSrvPath: path, WriteTimeOutMs: 500, ReadTimeOutMs: 5000, VerifyResults: true}
+	err := powClient.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer powClient.Close()
+
+	result, err := powClient.PowFunc(giota.Trytes(transaction), 8)
+	if (err != nil) || (result != nonce) {
+		t.Fatalf("Valid nonce was rejected: %v, %v", result, err)
+	}
+
+	_, err = powClient.PowFunc(giota.Trytes(transaction), 9)
+	var nonceErr *InvalidNonceError
+	if !errors.Is(err, ErrInvalidNonce) || !errors.As(err, &nonceErr) {
+		t.Fatalf("Expected ErrInvalidNonce, got %v", err)
+	}
+	if (nonceErr.Weight != 8) || (nonceErr.MWM != 9) {
+		t.Errorf("Expected weight 8 of MWM 9, got %d of %d", nonceErr.Weight, nonceErr.MWM)
+	}
+}